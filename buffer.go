@@ -0,0 +1,218 @@
+package message
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"strings"
+
+	"github.com/emersion/go-message/textproto"
+)
+
+const defaultMaxMemoryBytes = 1 << 20 // 1 MB
+
+// BufferOptions are options for ReadBuffered.
+type BufferOptions struct {
+	ReadOptions
+
+	// MaxMemoryBytes limits the total size of the part bodies kept in
+	// memory. Once the limit is reached, bodies are stored in temporary files.
+	//
+	// Set to -1 for no limit, set to 0 for the default value (1MB).
+	MaxMemoryBytes int64
+
+	// TempDir is the directory where temporary files are created. If empty,
+	// the default directory for temporary files is used.
+	TempDir string
+}
+
+// withDefaults returns a sanitised version of the options with defaults/special
+// values accounted for.
+func (o *BufferOptions) withDefaults() *BufferOptions {
+	var out BufferOptions
+	if o != nil {
+		out = *o
+	}
+	out.ReadOptions = *out.ReadOptions.withDefaults()
+	if out.MaxMemoryBytes == 0 {
+		out.MaxMemoryBytes = defaultMaxMemoryBytes
+	} else if out.MaxMemoryBytes < 0 {
+		out.MaxMemoryBytes = math.MaxInt64
+	}
+	return &out
+}
+
+// spillBuffer stores data in memory, as long as the memory budget shared by
+// all buffers of an entity isn't exhausted. Beyond it, data is stored in a
+// temporary file.
+type spillBuffer struct {
+	opts   *BufferOptions
+	budget *int64 // remaining memory budget
+	mem    bytes.Buffer
+	f      *os.File
+	size   int64
+}
+
+func (b *spillBuffer) Write(p []byte) (int, error) {
+	if b.f == nil && int64(len(p)) > *b.budget {
+		f, err := ioutil.TempFile(b.opts.TempDir, "go-message-")
+		if err != nil {
+			return 0, err
+		}
+		b.f = f
+		// The data moved to the file no longer counts towards the budget
+		*b.budget += int64(b.mem.Len())
+		if _, err := b.mem.WriteTo(f); err != nil {
+			return 0, err
+		}
+	}
+
+	var n int
+	var err error
+	if b.f != nil {
+		n, err = b.f.Write(p)
+	} else {
+		n, err = b.mem.Write(p)
+		*b.budget -= int64(n)
+	}
+	b.size += int64(n)
+	return n, err
+}
+
+func (b *spillBuffer) open() io.Reader {
+	if b.f != nil {
+		return io.NewSectionReader(b.f, 0, b.size)
+	}
+	return bytes.NewReader(b.mem.Bytes())
+}
+
+func (b *spillBuffer) Close() error {
+	if b.f == nil {
+		return nil
+	}
+	err := b.f.Close()
+	if removeErr := os.Remove(b.f.Name()); err == nil {
+		err = removeErr
+	}
+	return err
+}
+
+// A BufferedEntity is an entity whose body has been buffered, so that it can
+// be opened multiple times. It must be closed once no longer used, to remove
+// temporary files.
+type BufferedEntity struct {
+	// The entity's header.
+	Header Header
+	// The parts of a multipart entity.
+	Parts []*BufferedEntity
+
+	body *spillBuffer
+}
+
+func readBuffered(h textproto.Header, r io.Reader, opts *BufferOptions, budget *int64) (*BufferedEntity, error) {
+	be := &BufferedEntity{Header: Header{h}}
+
+	mediaType, params, _ := be.Header.ContentType()
+	if !strings.HasPrefix(mediaType, "multipart/") || params["boundary"] == "" {
+		be.body = &spillBuffer{opts: opts, budget: budget}
+		if _, err := io.Copy(be.body, r); err != nil {
+			be.Close()
+			return nil, err
+		}
+		return be, nil
+	}
+
+	mr := textproto.NewMultipartReader(r, params["boundary"])
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			be.Close()
+			return nil, err
+		}
+
+		part, err := readBuffered(p.Header, p, opts, budget)
+		if err != nil {
+			be.Close()
+			return nil, err
+		}
+		be.Parts = append(be.Parts, part)
+	}
+	return be, nil
+}
+
+// ReadBuffered reads a message from r, and buffers the raw body of each part.
+// Part bodies are kept in memory until their total size exceeds a limit: then
+// they are stored in temporary files.
+//
+// The preamble and the epilogue of multipart entities, i.e. the text before
+// the first part and after the last part, aren't kept.
+func ReadBuffered(r io.Reader, opts *BufferOptions) (*BufferedEntity, error) {
+	opts = opts.withDefaults()
+
+	lr := &limitedReader{R: r, N: opts.MaxHeaderBytes}
+	br := bufio.NewReader(lr)
+
+	h, err := textproto.ReadHeader(br)
+	if err != nil {
+		return nil, err
+	}
+
+	lr.N = math.MaxInt64
+
+	budget := opts.MaxMemoryBytes
+	return readBuffered(h, br, opts, &budget)
+}
+
+// Open returns a new Entity reading from the buffered data. Each returned
+// Entity can be read independently. The BufferedEntity must not be closed
+// while an Entity is being read.
+//
+// Multipart entities are re-assembled from their parts: the returned Entity
+// has no preamble nor epilogue, and its delimiter lines may differ from the
+// original message, e.g. in their line endings.
+//
+// If the entity or one of its parts uses an unknown transfer encoding or
+// charset, Open returns an error that verifies IsUnknownCharset or
+// IsUnknownEncoding, but also returns an Entity that can be read.
+func (be *BufferedEntity) Open() (*Entity, error) {
+	if be.body != nil {
+		return New(be.Header, be.body.open())
+	}
+
+	var firstErr error
+	parts := make([]*Entity, len(be.Parts))
+	for i, p := range be.Parts {
+		part, err := p.Open()
+		if part == nil {
+			return nil, err
+		} else if err != nil && firstErr == nil {
+			firstErr = err
+		}
+		parts[i] = part
+	}
+
+	e, err := NewMultipart(be.Header, parts)
+	if err == nil {
+		err = firstErr
+	}
+	return e, err
+}
+
+// Close removes the temporary files used to buffer the entity's data.
+func (be *BufferedEntity) Close() error {
+	var err error
+	if be.body != nil {
+		err = be.body.Close()
+	}
+	for _, p := range be.Parts {
+		if closeErr := p.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
@@ -0,0 +1,123 @@
+package message
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func testReadBuffered(t *testing.T, opts *BufferOptions) {
+	e, err := Read(strings.NewReader(testIndexMessage))
+	if err != nil {
+		t.Fatalf("Read() = %v", err)
+	}
+	want := walkBodies(t, e)
+
+	be, err := ReadBuffered(strings.NewReader(testIndexMessage), opts)
+	if err != nil {
+		t.Fatalf("ReadBuffered() = %v", err)
+	}
+	defer be.Close()
+
+	if len(be.Parts) != 3 || len(be.Parts[0].Parts) != 2 {
+		t.Fatalf("ReadBuffered() = %+v, want 3 parts with 2 parts in the first one", be)
+	}
+
+	for i := 0; i < 2; i++ {
+		e, err := be.Open()
+		if err != nil {
+			t.Fatalf("BufferedEntity.Open() = %v", err)
+		}
+		if got := walkBodies(t, e); !reflect.DeepEqual(got, want) {
+			t.Errorf("BufferedEntity.Open() #%v = %q, want %q", i, got, want)
+		}
+	}
+
+	// Parts can be opened on their own
+	e, err = be.Parts[1].Open()
+	if err != nil {
+		t.Fatalf("BufferedEntity.Open() = %v", err)
+	}
+	if b, err := ioutil.ReadAll(e.Body); err != nil || string(b) != "I'm Taki." {
+		t.Errorf("attachment body = %q (%v), want %q", string(b), err, "I'm Taki.")
+	}
+}
+
+func TestReadBuffered(t *testing.T) {
+	testReadBuffered(t, nil)
+}
+
+func TestReadBuffered_spill(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-message-")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir() = %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	opts := &BufferOptions{MaxMemoryBytes: 8, TempDir: dir}
+	testReadBuffered(t, opts)
+
+	be, err := ReadBuffered(strings.NewReader(testIndexMessage), opts)
+	if err != nil {
+		t.Fatalf("ReadBuffered() = %v", err)
+	}
+
+	// The text/plain, text/html and attachment parts exceed the limit
+	if files, err := ioutil.ReadDir(dir); err != nil || len(files) != 3 {
+		t.Errorf("got %v temporary files (%v), want 3", len(files), err)
+	}
+	if err := be.Close(); err != nil {
+		t.Fatalf("BufferedEntity.Close() = %v", err)
+	}
+	if files, err := ioutil.ReadDir(dir); err != nil || len(files) != 0 {
+		t.Errorf("got %v temporary files (%v) after Close(), want none", len(files), err)
+	}
+}
+
+func TestReadBuffered_sharedLimit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-message-")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir() = %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	be, err := ReadBuffered(strings.NewReader(testIndexMessage), nil)
+	if err != nil {
+		t.Fatalf("ReadBuffered() = %v", err)
+	}
+	var max, total int64
+	var walk func(be *BufferedEntity)
+	walk = func(be *BufferedEntity) {
+		if be.body != nil {
+			total += be.body.size
+			if be.body.size > max {
+				max = be.body.size
+			}
+		}
+		for _, p := range be.Parts {
+			walk(p)
+		}
+	}
+	walk(be)
+	be.Close()
+
+	if max == total {
+		t.Fatalf("test message has a single non-empty part")
+	}
+
+	// Each part fits in the limit, but not all of them
+	be, err = ReadBuffered(strings.NewReader(testIndexMessage), &BufferOptions{
+		MaxMemoryBytes: max,
+		TempDir:        dir,
+	})
+	if err != nil {
+		t.Fatalf("ReadBuffered() = %v", err)
+	}
+	defer be.Close()
+
+	if files, err := ioutil.ReadDir(dir); err != nil || len(files) == 0 {
+		t.Errorf("got %v temporary files (%v), want at least one", len(files), err)
+	}
+}
@@ -1,11 +1,14 @@
 package message
 
 import (
+	"bytes"
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"io"
 	"mime"
 	"strings"
+	"unicode/utf8"
 )
 
 type UnknownCharsetError struct {
@@ -50,6 +53,31 @@ func charsetReader(charset string, input io.Reader) (io.Reader, error) {
 	return input, UnknownCharsetError{fmt.Errorf("message: unhandled charset %q", charset)}
 }
 
+// CharsetWriter, if non-nil, defines a function to generate charset-conversion
+// writers, converting from UTF-8 into the provided charset. Charsets are always
+// lower-case. utf-8 and us-ascii charsets are handled by default. Closing the
+// returned io.WriteCloser flushes any pending data but doesn't close output.
+//
+// Importing github.com/emersion/go-message/charset will set CharsetWriter to
+// a function that handles most common charsets.
+var CharsetWriter func(charset string, output io.Writer) (io.WriteCloser, error)
+
+// charsetWriter calls CharsetWriter if non-nil.
+func charsetWriter(charset string, output io.Writer) (io.WriteCloser, error) {
+	charset = strings.ToLower(charset)
+	if charset == "utf-8" || charset == "us-ascii" {
+		return nopCloser{output}, nil
+	}
+	if CharsetWriter != nil {
+		w, err := CharsetWriter(charset, output)
+		if err != nil {
+			return nil, UnknownCharsetError{err}
+		}
+		return w, nil
+	}
+	return nil, UnknownCharsetError{fmt.Errorf("message: unhandled charset %q", charset)}
+}
+
 // decodeHeader decodes an internationalized header field. If it fails, it
 // returns the input string and the error.
 func decodeHeader(s string) (string, error) {
@@ -64,3 +92,75 @@ func decodeHeader(s string) (string, error) {
 func encodeHeader(s string) string {
 	return mime.QEncoding.Encode("utf-8", s)
 }
+
+// maxEncodedWordLen is the maximum length of an encoded-word, as defined in
+// RFC 2047 section 2.
+const maxEncodedWordLen = 75
+
+// encodeHeaderCharset encodes an internationalized header field using the
+// provided charset.
+//
+// Unlike mime.WordEncoder, the value is converted from UTF-8 and each
+// encoded-word contains whole characters, as required by RFC 2047 section 5.
+// Each chunk is converted separately so that stateful charsets such as
+// ISO-2022-JP return to their initial state at the end of each encoded-word.
+func encodeHeaderCharset(charset, s string) (string, error) {
+	switch strings.ToLower(charset) {
+	case "utf-8", "us-ascii":
+		return encodeHeader(s), nil
+	}
+	if !needsEncoding(s) {
+		return s, nil
+	}
+
+	var words []string
+	for len(s) > 0 {
+		// Grow the chunk one character at a time until the encoded-word gets
+		// too long
+		var word string
+		i := 0
+		for i < len(s) {
+			_, size := utf8.DecodeRuneInString(s[i:])
+			b, err := convertCharset(charset, s[:i+size])
+			if err != nil {
+				return "", err
+			}
+			w := "=?" + charset + "?b?" + base64.StdEncoding.EncodeToString(b) + "?="
+			if i > 0 && len(w) > maxEncodedWordLen {
+				break
+			}
+			word = w
+			i += size
+		}
+		words = append(words, word)
+		s = s[i:]
+	}
+	return strings.Join(words, " "), nil
+}
+
+// convertCharset converts s from UTF-8 to the provided charset.
+func convertCharset(charset, s string) ([]byte, error) {
+	var b bytes.Buffer
+	w, err := charsetWriter(charset, &b)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.WriteString(w, s); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// needsEncoding reports whether s contains characters which can't be written
+// as-is in a header field.
+func needsEncoding(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if (s[i] < ' ' || s[i] > '~') && s[i] != '\t' {
+			return true
+		}
+	}
+	return false
+}
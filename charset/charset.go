@@ -1,7 +1,8 @@
 // Package charset provides functions to decode and encode charsets.
 //
 // It imports all supported charsets, which adds about 1MiB to binaries size.
-// Importing the package automatically sets message.CharsetReader.
+// Importing the package automatically sets message.CharsetReader and
+// message.CharsetWriter.
 package charset
 
 import (
@@ -15,6 +16,7 @@ import (
 	"golang.org/x/text/encoding/htmlindex"
 	"golang.org/x/text/encoding/ianaindex"
 	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
 )
 
 // Quirks table for charsets not handled by ianaindex
@@ -30,10 +32,31 @@ var charsets = map[string]encoding.Encoding{
 
 func init() {
 	message.CharsetReader = Reader
+	message.CharsetWriter = Writer
 }
 
 // Reader returns an io.Reader that converts the provided charset to UTF-8.
 func Reader(charset string, input io.Reader) (io.Reader, error) {
+	enc, err := lookup(charset)
+	if err != nil {
+		return nil, err
+	}
+	return enc.NewDecoder().Reader(input), nil
+}
+
+// Writer returns an io.WriteCloser that converts UTF-8 to the provided
+// charset. Characters which can't be represented in the charset result in an
+// error. Close must be called to flush the pending data, it doesn't close
+// output.
+func Writer(charset string, output io.Writer) (io.WriteCloser, error) {
+	enc, err := lookup(charset)
+	if err != nil {
+		return nil, err
+	}
+	return transform.NewWriter(output, enc.NewEncoder()), nil
+}
+
+func lookup(charset string) (encoding.Encoding, error) {
 	var err error
 	enc, ok := charsets[strings.ToLower(charset)]
 	if ok && enc == nil {
@@ -54,7 +77,7 @@ func Reader(charset string, input io.Reader) (io.Reader, error) {
 	if enc == nil {
 		return nil, fmt.Errorf("charset %q: unsupported charset", charset)
 	}
-	return enc.NewDecoder().Reader(input), nil
+	return enc, nil
 }
 
 // RegisterEncoding registers an encoding. This is intended to be called from
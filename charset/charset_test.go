@@ -2,9 +2,12 @@ package charset
 
 import (
 	"bytes"
+	"io"
 	"io/ioutil"
 	"strings"
 	"testing"
+
+	"github.com/emersion/go-message"
 )
 
 var testCharsets = []struct {
@@ -86,3 +89,93 @@ func TestDisabledCharsetReader(t *testing.T) {
 		t.Errorf("Reader(): expected disabled charset to return an error")
 	}
 }
+
+func TestCharsetWriter(t *testing.T) {
+	for _, test := range testCharsets {
+		var b bytes.Buffer
+		w, err := Writer(test.charset, &b)
+		if test.decoded == "" {
+			if err == nil {
+				t.Errorf("Expected an error when creating writer for charset %q", test.charset)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Expected no error when creating writer for charset %q, but got: %v", test.charset, err)
+		} else if _, err := io.WriteString(w, test.decoded); err != nil {
+			t.Errorf("Expected no error when writing charset %q, but got: %v", test.charset, err)
+		} else if err := w.Close(); err != nil {
+			t.Errorf("Expected no error when closing writer for charset %q, but got: %v", test.charset, err)
+		} else if !bytes.Equal(b.Bytes(), test.encoded) {
+			t.Errorf("Expected encoded text to be %v but got %v", test.encoded, b.Bytes())
+		}
+	}
+}
+
+func TestCharsetWriter_unsupportedCharacter(t *testing.T) {
+	w, err := Writer("iso-8859-1", ioutil.Discard)
+	if err != nil {
+		t.Fatalf("Writer() = %v", err)
+	}
+	if _, err := io.WriteString(w, "测试"); err == nil {
+		if err := w.Close(); err == nil {
+			t.Errorf("Expected an error when writing characters not supported by the charset")
+		}
+	}
+}
+
+func TestMessageWriter_charset(t *testing.T) {
+	var h message.Header
+	h.SetContentType("text/plain", map[string]string{"charset": "iso-2022-jp"})
+	if err := h.SetTextWithCharset("Subject", "こんにちは", "iso-2022-jp"); err != nil {
+		t.Fatalf("SetTextWithCharset() = %v", err)
+	}
+
+	var b bytes.Buffer
+	w, err := message.CreateWriter(&b, h)
+	if err != nil {
+		t.Fatalf("CreateWriter() = %v", err)
+	}
+	io.WriteString(w, "日本語のテキスト")
+	if err := w.Close(); err != nil {
+		t.Fatalf("Writer.Close() = %v", err)
+	}
+
+	if strings.Contains(b.String(), "日本語") {
+		t.Errorf("Expected body to be converted, got:\n%v", b.String())
+	}
+
+	e, err := message.Read(&b)
+	if err != nil {
+		t.Fatalf("Read() = %v", err)
+	}
+	if subject, err := e.Header.Text("Subject"); err != nil {
+		t.Errorf("Text(Subject) = %v", err)
+	} else if subject != "こんにちは" {
+		t.Errorf("Expected subject to be %q but got %q", "こんにちは", subject)
+	}
+	if body, err := ioutil.ReadAll(e.Body); err != nil {
+		t.Errorf("ReadAll() = %v", err)
+	} else if s := string(body); s != "日本語のテキスト" {
+		t.Errorf("Expected body to be %q but got %q", "日本語のテキスト", s)
+	}
+}
+
+func TestHeader_SetTextWithCharset_long(t *testing.T) {
+	subject := strings.Repeat("café crème ", 20)
+
+	var h message.Header
+	if err := h.SetTextWithCharset("Subject", subject, "windows-1252"); err != nil {
+		t.Fatalf("SetTextWithCharset() = %v", err)
+	}
+	for _, word := range strings.Fields(h.Get("Subject")) {
+		if len(word) > 75 {
+			t.Errorf("Encoded-word %q is longer than 75 characters", word)
+		}
+	}
+	if s, err := h.Text("Subject"); err != nil {
+		t.Errorf("Text(Subject) = %v", err)
+	} else if s != subject {
+		t.Errorf("Expected subject to be %q but got %q", subject, s)
+	}
+}
@@ -0,0 +1,522 @@
+package dkim
+
+import (
+	"bufio"
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/emersion/go-message/internal/canonical"
+	"github.com/emersion/go-message/textproto"
+)
+
+const (
+	arcAuthResultsHeaderFieldName      = "ARC-Authentication-Results"
+	arcMessageSignatureHeaderFieldName = "ARC-Message-Signature"
+	arcSealHeaderFieldName             = "ARC-Seal"
+)
+
+// maxARCInstance is the maximum number of ARC sets in a message, as defined
+// in RFC 8617 section 4.2.1.
+const maxARCInstance = 50
+
+// ChainValidation is the status of an Authenticated Received Chain (ARC), as
+// defined in RFC 8617 section 4.4.
+type ChainValidation string
+
+const (
+	// ChainValidationNone indicates that the message has no ARC set.
+	ChainValidationNone ChainValidation = "none"
+	// ChainValidationPass indicates that the ARC chain is valid.
+	ChainValidationPass ChainValidation = "pass"
+	// ChainValidationFail indicates that the ARC chain is invalid.
+	ChainValidationFail ChainValidation = "fail"
+)
+
+func parseARCInstance(s string) (int, error) {
+	i, err := strconv.Atoi(removeFWS(s))
+	if err != nil || i < 1 || i > maxARCInstance {
+		return 0, permFailError("invalid ARC instance")
+	}
+	return i, nil
+}
+
+// arcSet contains the raw header fields of an ARC set.
+type arcSet struct {
+	authResults, messageSignature, seal []byte
+}
+
+// parseARCSets collects the ARC sets of a header. The set with instance i is
+// at index i-1.
+func parseARCSets(h *textproto.Header) ([]arcSet, error) {
+	sets := make(map[int]*arcSet)
+	for fs := h.Fields(); fs.Next(); {
+		var params string
+		var field func(set *arcSet) *[]byte
+		switch k := fs.Key(); {
+		case strings.EqualFold(k, arcAuthResultsHeaderFieldName):
+			// The value is the instance tag followed by the authentication
+			// results, which aren't a tag list
+			params = fs.Value()
+			if i := strings.IndexByte(params, ';'); i >= 0 {
+				params = params[:i]
+			}
+			field = func(set *arcSet) *[]byte { return &set.authResults }
+		case strings.EqualFold(k, arcMessageSignatureHeaderFieldName):
+			params = fs.Value()
+			field = func(set *arcSet) *[]byte { return &set.messageSignature }
+		case strings.EqualFold(k, arcSealHeaderFieldName):
+			params = fs.Value()
+			field = func(set *arcSet) *[]byte { return &set.seal }
+		default:
+			continue
+		}
+
+		tags, err := parseTagList(params)
+		if err != nil {
+			return nil, permFailError("malformed " + fs.Key() + " header field: " + err.Error())
+		}
+		instance, err := parseARCInstance(tags["i"])
+		if err != nil {
+			return nil, err
+		}
+		raw, err := fs.Raw()
+		if err != nil {
+			return nil, permFailError("malformed " + fs.Key() + " header field: " + err.Error())
+		}
+
+		set, ok := sets[instance]
+		if !ok {
+			set = new(arcSet)
+			sets[instance] = set
+		}
+		if *field(set) != nil {
+			return nil, permFailError("duplicate " + fs.Key() + " header field")
+		}
+		*field(set) = raw
+	}
+
+	l := make([]arcSet, len(sets))
+	for i := range l {
+		set, ok := sets[i+1]
+		if !ok {
+			return nil, permFailError("missing ARC set")
+		}
+		if set.authResults == nil || set.messageSignature == nil || set.seal == nil {
+			return nil, permFailError("incomplete ARC set")
+		}
+		l[i] = *set
+	}
+	return l, nil
+}
+
+// hashARCSeal computes the hash of ARC sets, as defined in RFC 8617 section
+// 5.1.1. The b= tag of the last ARC-Seal header field is ignored.
+func hashARCSeal(sets []arcSet) []byte {
+	hasher := sha256.New()
+	for i, set := range sets {
+		hasher.Write(canonicalizeHeader(CanonicalizationRelaxed, set.authResults))
+		hasher.Write(canonicalizeHeader(CanonicalizationRelaxed, set.messageSignature))
+		if i < len(sets)-1 {
+			hasher.Write(canonicalizeHeader(CanonicalizationRelaxed, set.seal))
+		} else {
+			b := canonicalizeHeader(CanonicalizationRelaxed, removeSignature(set.seal))
+			hasher.Write(bytes.TrimSuffix(b, crlf))
+		}
+	}
+	return hasher.Sum(nil)
+}
+
+// arcSeal is a parsed ARC-Seal header field.
+type arcSeal struct {
+	instance int
+	algo     string
+	domain   string
+	selector string
+	cv       ChainValidation
+	sig      []byte
+}
+
+func parseARCSeal(raw []byte) (*arcSeal, error) {
+	i := bytes.IndexByte(raw, ':')
+	if i < 0 {
+		return nil, permFailError("malformed ARC-Seal header field")
+	}
+	params, err := parseTagList(string(raw[i+1:]))
+	if err != nil {
+		return nil, permFailError("malformed ARC-Seal header field: " + err.Error())
+	}
+
+	for _, k := range []string{"i", "a", "b", "cv", "d", "s"} {
+		if _, ok := params[k]; !ok {
+			return nil, permFailError("ARC-Seal header field is missing required tag " + k + "=")
+		}
+	}
+	// RFC 8617 section 4.1.3: the h= tag isn't allowed
+	if _, ok := params["h"]; ok {
+		return nil, permFailError("ARC-Seal header field has a h= tag")
+	}
+
+	seal := &arcSeal{
+		algo:     removeFWS(params["a"]),
+		domain:   removeFWS(params["d"]),
+		selector: removeFWS(params["s"]),
+		cv:       ChainValidation(strings.ToLower(removeFWS(params["cv"]))),
+	}
+	if seal.instance, err = parseARCInstance(params["i"]); err != nil {
+		return nil, err
+	}
+	if !isSupportedAlgorithm(seal.algo) {
+		return nil, permFailError("unsupported signing algorithm")
+	}
+	switch seal.cv {
+	case ChainValidationNone, ChainValidationPass, ChainValidationFail:
+	default:
+		return nil, permFailError("invalid chain validation status")
+	}
+	if seal.sig, err = decodeBase64(params["b"]); err != nil {
+		return nil, permFailError("malformed signature data: " + err.Error())
+	}
+	return seal, nil
+}
+
+// ARCSealOptions are options for NewARCSealer.
+type ARCSealOptions struct {
+	// Domain is the sealing domain, used in the d= tags. It's required.
+	Domain string
+	// Selector is the name of the public key record in the sealing domain.
+	// It's required.
+	Selector string
+	// Signer is the private key used to sign the ARC-Message-Signature and
+	// ARC-Seal header fields. It's required. *rsa.PrivateKey and
+	// ed25519.PrivateKey are supported.
+	Signer crypto.Signer
+
+	// HeaderCanonicalization and BodyCanonicalization are the
+	// canonicalization algorithms for the ARC-Message-Signature header field.
+	// The default is CanonicalizationRelaxed. ARC-Seal header fields always
+	// use the relaxed algorithm.
+	HeaderCanonicalization Canonicalization
+	BodyCanonicalization   Canonicalization
+
+	// HeaderKeys is the list of header fields signed by the
+	// ARC-Message-Signature header field. By default, the header fields
+	// recommended by RFC 6376 section 5.4.1 and DKIM-Signature header fields
+	// present in the message are signed. It must not contain ARC-Seal.
+	HeaderKeys []string
+
+	// AuthenticationResults is the value of the ARC-Authentication-Results
+	// header field, without the instance: the authentication service
+	// identifier followed by the authentication results, as defined in
+	// RFC 8601. For instance: "example.org; dkim=pass header.d=example.com".
+	// It's required.
+	AuthenticationResults string
+
+	// ChainValidation is the result of the validation of the ARC chain of the
+	// message, performed before the message was modified, for instance with
+	// VerifyARC. It's required if the message already has ARC sets, and must
+	// be either ChainValidationPass or ChainValidationFail.
+	ChainValidation ChainValidation
+}
+
+// withDefaults returns a sanitised version of the options with defaults/special
+// values accounted for.
+func (o *ARCSealOptions) withDefaults() (*ARCSealOptions, error) {
+	if o == nil {
+		return nil, errors.New("dkim: no options specified")
+	}
+	opts := *o
+	if opts.Domain == "" {
+		return nil, errors.New("dkim: no sealing domain specified")
+	}
+	if opts.Selector == "" {
+		return nil, errors.New("dkim: no selector specified")
+	}
+	if opts.Signer == nil {
+		return nil, errors.New("dkim: no signer specified")
+	}
+	if opts.AuthenticationResults == "" {
+		return nil, errors.New("dkim: no authentication results specified")
+	}
+	if strings.ContainsAny(opts.AuthenticationResults, "\r\n") {
+		return nil, errors.New("dkim: authentication results contain a line break")
+	}
+	if err := defaultCanonicalization(&opts.HeaderCanonicalization); err != nil {
+		return nil, err
+	}
+	if err := defaultCanonicalization(&opts.BodyCanonicalization); err != nil {
+		return nil, err
+	}
+	if hasHeaderKey(opts.HeaderKeys, arcSealHeaderFieldName) {
+		return nil, errors.New("dkim: the ARC-Seal header field must not be signed")
+	}
+	return &opts, nil
+}
+
+// ARCSealer adds an ARC set to a message, as defined in RFC 8617 section 5.1.
+//
+// The message body must be written to the ARCSealer. Line endings are
+// canonicalized to CRLF. When the ARCSealer is closed, the
+// ARC-Authentication-Results, ARC-Message-Signature and ARC-Seal header
+// fields are added to the header.
+type ARCSealer struct {
+	h       *textproto.Header
+	options *ARCSealOptions
+	algo    string
+	opts    crypto.SignerOpts
+	sets    []arcSet
+	cv      ChainValidation
+
+	in       *canonical.Writer
+	bodyHash hash.Hash
+	body     *bodyCanonicalizer
+	closed   bool
+}
+
+// NewARCSealer creates a new ARCSealer for a message with the header h. h is
+// modified when the ARCSealer is closed: it must not be written before.
+//
+// An error is returned if the ARC chain of the message is malformed or has
+// already failed: such a chain can't be extended.
+func NewARCSealer(h *textproto.Header, options *ARCSealOptions) (*ARCSealer, error) {
+	options, err := options.withDefaults()
+	if err != nil {
+		return nil, err
+	}
+
+	algo, opts, err := signatureAlgorithm(options.Signer)
+	if err != nil {
+		return nil, err
+	}
+
+	sets, err := parseARCSets(h)
+	if err != nil {
+		return nil, err
+	}
+	if len(sets) >= maxARCInstance {
+		return nil, errors.New("dkim: too many ARC sets")
+	}
+
+	cv := ChainValidationNone
+	if len(sets) > 0 {
+		seal, err := parseARCSeal(sets[len(sets)-1].seal)
+		if err != nil {
+			return nil, err
+		}
+		// RFC 8617 section 5.1.2: a failed chain isn't extended
+		if seal.cv == ChainValidationFail {
+			return nil, errors.New("dkim: ARC chain has already failed")
+		}
+
+		switch options.ChainValidation {
+		case ChainValidationPass, ChainValidationFail:
+			cv = options.ChainValidation
+		default:
+			return nil, errors.New("dkim: missing chain validation status")
+		}
+	}
+
+	s := &ARCSealer{
+		h:        h,
+		options:  options,
+		algo:     algo,
+		opts:     opts,
+		sets:     sets,
+		cv:       cv,
+		bodyHash: sha256.New(),
+	}
+	s.body = newBodyCanonicalizer(s.bodyHash, options.BodyCanonicalization)
+	s.in = canonical.NewWriter(s.body)
+	return s, nil
+}
+
+// Write implements io.WriteCloser.
+func (s *ARCSealer) Write(b []byte) (int, error) {
+	if s.closed {
+		return 0, errors.New("dkim: sealer already closed")
+	}
+	return s.in.Write(b)
+}
+
+// Close implements io.WriteCloser. It adds the ARC set to the header.
+func (s *ARCSealer) Close() error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+
+	if err := s.body.Close(); err != nil {
+		return err
+	}
+
+	instance := "i=" + strconv.Itoa(len(s.sets)+1)
+	t := "t=" + strconv.FormatInt(now().Unix(), 10)
+
+	authResults := []byte(arcAuthResultsHeaderFieldName + ": " + instance + "; " + s.options.AuthenticationResults + "\r\n")
+
+	keys := s.options.HeaderKeys
+	if keys == nil {
+		candidates := append(defaultHeaderKeys[:len(defaultHeaderKeys):len(defaultHeaderKeys)], headerFieldName)
+		for _, k := range candidates {
+			if s.h.Has(k) {
+				keys = append(keys, k)
+			}
+		}
+	}
+	tags := []string{
+		instance,
+		"a=" + s.algo,
+		"c=" + string(s.options.HeaderCanonicalization) + "/" + string(s.options.BodyCanonicalization),
+		"d=" + s.options.Domain,
+		"s=" + s.options.Selector,
+		t,
+		"h=" + strings.Join(keys, ":"),
+		"bh=" + base64.StdEncoding.EncodeToString(s.bodyHash.Sum(nil)),
+	}
+	raw := foldHeaderField(arcMessageSignatureHeaderFieldName, append(tags[:len(tags):len(tags)], "b="))
+	hashed, err := hashHeader(s.h, keys, s.options.HeaderCanonicalization, []byte(raw))
+	if err != nil {
+		return err
+	}
+	sig, err := s.options.Signer.Sign(rand.Reader, hashed, s.opts)
+	if err != nil {
+		return err
+	}
+	tags = append(tags, "b="+base64.StdEncoding.EncodeToString(sig))
+	messageSignature := []byte(foldHeaderField(arcMessageSignatureHeaderFieldName, tags))
+
+	tags = []string{
+		instance,
+		"a=" + s.algo,
+		t,
+		"cv=" + string(s.cv),
+		"d=" + s.options.Domain,
+		"s=" + s.options.Selector,
+	}
+	raw = foldHeaderField(arcSealHeaderFieldName, append(tags[:len(tags):len(tags)], "b="))
+	sets := append(s.sets, arcSet{
+		authResults:      authResults,
+		messageSignature: messageSignature,
+		seal:             []byte(raw),
+	})
+	sig, err = s.options.Signer.Sign(rand.Reader, hashARCSeal(sets), s.opts)
+	if err != nil {
+		return err
+	}
+	tags = append(tags, "b="+base64.StdEncoding.EncodeToString(sig))
+	seal := []byte(foldHeaderField(arcSealHeaderFieldName, tags))
+
+	s.h.AddRaw(authResults)
+	s.h.AddRaw(messageSignature)
+	s.h.AddRaw(seal)
+	return nil
+}
+
+// An ARCVerification is the result of the validation of an ARC chain.
+type ARCVerification struct {
+	// Result is the status of the chain.
+	Result ChainValidation
+	// Instance is the instance of the most recent ARC set, or zero if the
+	// message has no ARC set.
+	Instance int
+	// Err describes why the chain failed. It's nil unless Result is
+	// ChainValidationFail. IsTempFail can be used to check whether the
+	// validation may succeed later.
+	Err error
+}
+
+// VerifyARC validates the Authenticated Received Chain (ARC) of a message read
+// from r, as defined in RFC 8617 section 5.2. An error is only returned if
+// the message can't be read.
+func VerifyARC(r io.Reader) (*ARCVerification, error) {
+	return VerifyARCWithOptions(r, nil)
+}
+
+// VerifyARCWithOptions see VerifyARC, but allows overriding some parameters
+// with VerifyOptions.
+func VerifyARCWithOptions(r io.Reader, opts *VerifyOptions) (*ARCVerification, error) {
+	opts = opts.withDefaults()
+
+	br := bufio.NewReader(r)
+	h, err := textproto.ReadHeader(br)
+	if err != nil {
+		return nil, err
+	}
+
+	sets, err := parseARCSets(&h)
+	if err != nil {
+		return &ARCVerification{Result: ChainValidationFail, Err: err}, nil
+	}
+	if len(sets) == 0 {
+		return &ARCVerification{Result: ChainValidationNone}, nil
+	}
+
+	v := &ARCVerification{Result: ChainValidationFail, Instance: len(sets)}
+
+	seals := make([]*arcSeal, len(sets))
+	for i, set := range sets {
+		seal, err := parseARCSeal(set.seal)
+		if err != nil {
+			v.Err = err
+			return v, nil
+		}
+		if seal.instance != i+1 {
+			v.Err = permFailError("ARC-Seal instance mismatch")
+			return v, nil
+		}
+		seals[i] = seal
+	}
+
+	if seals[len(seals)-1].cv == ChainValidationFail {
+		v.Err = errors.New("dkim: ARC chain has already failed")
+		return v, nil
+	}
+	for i, seal := range seals {
+		want := ChainValidationPass
+		if i == 0 {
+			want = ChainValidationNone
+		}
+		if seal.cv != want {
+			v.Err = fmt.Errorf("dkim: invalid chain validation status %q in ARC set %v", seal.cv, i+1)
+			return v, nil
+		}
+	}
+
+	// Only the most recent ARC-Message-Signature needs to be valid: previous
+	// ones are expected to be broken by modifications of the message
+	sig, err := parseSignature(sets[len(sets)-1].messageSignature, true)
+	if err != nil {
+		v.Err = err
+		return v, nil
+	}
+	if _, err := io.Copy(canonical.NewWriter(sig.body), br); err != nil {
+		return nil, err
+	}
+	if err := sig.verify(&h, opts.Resolver); err != nil {
+		v.Err = err
+		return v, nil
+	}
+
+	for i := len(seals) - 1; i >= 0; i-- {
+		seal := seals[i]
+		pk, err := lookupPublicKey(opts.Resolver, seal.domain, seal.selector, seal.algo)
+		if err == nil {
+			err = verifySignature(pk.key, hashARCSeal(sets[:i+1]), seal.sig)
+		}
+		if err != nil {
+			v.Err = fmt.Errorf("dkim: failed to verify ARC-Seal %v: %w", i+1, err)
+			return v, nil
+		}
+	}
+
+	v.Result = ChainValidationPass
+	return v, nil
+}
@@ -0,0 +1,172 @@
+package dkim
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/emersion/go-message/textproto"
+)
+
+func sealMessage(t *testing.T, msg string, options *ARCSealOptions) string {
+	br := bufio.NewReader(strings.NewReader(msg))
+	h, err := textproto.ReadHeader(br)
+	if err != nil {
+		t.Fatalf("textproto.ReadHeader() = %v", err)
+	}
+
+	s, err := NewARCSealer(&h, options)
+	if err != nil {
+		t.Fatalf("NewARCSealer() = %v", err)
+	}
+	var body bytes.Buffer
+	if _, err := io.Copy(io.MultiWriter(&body, s), br); err != nil {
+		t.Fatalf("io.Copy() = %v", err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("ARCSealer.Close() = %v", err)
+	}
+
+	var b bytes.Buffer
+	if err := textproto.WriteHeader(&b, h); err != nil {
+		t.Fatalf("textproto.WriteHeader() = %v", err)
+	}
+	body.WriteTo(&b)
+	return b.String()
+}
+
+func verifyARC(t *testing.T, msg string, resolver Resolver) *ARCVerification {
+	v, err := VerifyARCWithOptions(strings.NewReader(msg), &VerifyOptions{Resolver: resolver})
+	if err != nil {
+		t.Fatalf("VerifyARC() = %v", err)
+	}
+	return v
+}
+
+func TestARC(t *testing.T) {
+	rsaKey, edKey, resolver := newTestKeys(t)
+
+	// The first forwarder seals the original message
+	sealed := sealMessage(t, testMessage, &ARCSealOptions{
+		Domain:                "football.example.com",
+		Selector:              "rsa",
+		Signer:                rsaKey,
+		AuthenticationResults: "football.example.com; spf=pass smtp.mailfrom=football.example.com",
+	})
+	if !strings.Contains(sealed, "ARC-Authentication-Results: i=1; football.example.com; spf=pass") {
+		t.Errorf("sealed message doesn't contain the ARC-Authentication-Results header field:\n%v", sealed)
+	}
+
+	v := verifyARC(t, sealed, resolver)
+	if v.Result != ChainValidationPass || v.Instance != 1 {
+		t.Fatalf("VerifyARC() = %v (instance %v, %v), want pass (instance 1)", v.Result, v.Instance, v.Err)
+	}
+
+	// The second forwarder modifies the message, and seals it again
+	modified := strings.Replace(sealed, "Joe.\r\n", "Joe.\r\n\r\n-- \r\nfootball mailing list\r\n", 1)
+	sealed = sealMessage(t, modified, &ARCSealOptions{
+		Domain:                "football.example.com",
+		Selector:              "ed25519",
+		Signer:                edKey,
+		AuthenticationResults: "football.example.com; arc=pass",
+		ChainValidation:       v.Result,
+	})
+
+	v = verifyARC(t, sealed, resolver)
+	if v.Result != ChainValidationPass || v.Instance != 2 {
+		t.Fatalf("VerifyARC() = %v (instance %v, %v), want pass (instance 2)", v.Result, v.Instance, v.Err)
+	}
+
+	tests := map[string]string{
+		"body":         strings.Replace(sealed, "hungry", "thirsty", 1),
+		"auth results": strings.Replace(sealed, "spf=pass", "spf=fail", 1),
+	}
+	for name, msg := range tests {
+		t.Run(name, func(t *testing.T) {
+			v := verifyARC(t, msg, resolver)
+			if v.Result != ChainValidationFail || v.Err == nil {
+				t.Errorf("VerifyARC() = %v (%v), want fail", v.Result, v.Err)
+			}
+		})
+	}
+}
+
+func TestARC_fail(t *testing.T) {
+	key, _, resolver := newTestKeys(t)
+	options := &ARCSealOptions{
+		Domain:                "football.example.com",
+		Selector:              "rsa",
+		Signer:                key,
+		AuthenticationResults: "football.example.com; arc=none",
+	}
+	sealed := sealMessage(t, testMessage, options)
+
+	h, err := textproto.ReadHeader(bufio.NewReader(strings.NewReader(sealed)))
+	if err != nil {
+		t.Fatalf("textproto.ReadHeader() = %v", err)
+	}
+	if _, err := NewARCSealer(&h, options); err == nil {
+		t.Error("NewARCSealer() without chain validation status = nil, want an error")
+	}
+
+	options.ChainValidation = ChainValidationFail
+	options.AuthenticationResults = "football.example.com; arc=fail"
+	sealed = sealMessage(t, sealed, options)
+	v := verifyARC(t, sealed, resolver)
+	if v.Result != ChainValidationFail {
+		t.Errorf("VerifyARC() = %v, want fail", v.Result)
+	}
+
+	h, err = textproto.ReadHeader(bufio.NewReader(strings.NewReader(sealed)))
+	if err != nil {
+		t.Fatalf("textproto.ReadHeader() = %v", err)
+	}
+	options.ChainValidation = ChainValidationPass
+	if _, err := NewARCSealer(&h, options); err == nil {
+		t.Error("NewARCSealer() on failed chain = nil, want an error")
+	}
+}
+
+func TestVerifyARC_none(t *testing.T) {
+	v := verifyARC(t, testMessage, testResolver{})
+	if v.Result != ChainValidationNone || v.Err != nil {
+		t.Errorf("VerifyARC() = %v (%v), want none", v.Result, v.Err)
+	}
+}
+
+func TestVerifyARC_missingKey(t *testing.T) {
+	key, _, _ := newTestKeys(t)
+	sealed := sealMessage(t, testMessage, &ARCSealOptions{
+		Domain:                "football.example.com",
+		Selector:              "rsa",
+		Signer:                key,
+		AuthenticationResults: "football.example.com; arc=none",
+	})
+
+	v := verifyARC(t, sealed, testResolver{})
+	if v.Result != ChainValidationFail || !IsPermFail(v.Err) {
+		t.Errorf("VerifyARC() = %v (%v), want a permanent failure", v.Result, v.Err)
+	}
+}
+
+func TestVerifyARC_malformed(t *testing.T) {
+	tests := map[string]string{
+		"incomplete set": "ARC-Seal: i=1; a=rsa-sha256; cv=none; d=example.org; s=s; b=\r\n",
+		"missing set": "ARC-Authentication-Results: i=2; example.org; arc=none\r\n" +
+			"ARC-Message-Signature: i=2; a=rsa-sha256; d=example.org; s=s; h=From; bh=; b=\r\n" +
+			"ARC-Seal: i=2; a=rsa-sha256; cv=pass; d=example.org; s=s; b=\r\n",
+		"invalid cv": "ARC-Authentication-Results: i=1; example.org; arc=none\r\n" +
+			"ARC-Message-Signature: i=1; a=rsa-sha256; d=example.org; s=s; h=From; bh=; b=\r\n" +
+			"ARC-Seal: i=1; a=rsa-sha256; cv=pass; d=example.org; s=s; b=\r\n",
+	}
+	for name, fields := range tests {
+		t.Run(name, func(t *testing.T) {
+			v := verifyARC(t, fields+testMessage, testResolver{})
+			if v.Result != ChainValidationFail || v.Err == nil {
+				t.Errorf("VerifyARC() = %v (%v), want fail", v.Result, v.Err)
+			}
+		})
+	}
+}
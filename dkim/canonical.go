@@ -0,0 +1,196 @@
+package dkim
+
+import (
+	"bytes"
+	"io"
+	"strings"
+)
+
+var crlf = []byte("\r\n")
+
+// canonicalizeHeader canonicalizes a raw header field, as defined in RFC 6376
+// section 3.4.1 and 3.4.2. The result ends with CRLF.
+func canonicalizeHeader(c Canonicalization, raw []byte) []byte {
+	if c != CanonicalizationRelaxed {
+		if !bytes.HasSuffix(raw, crlf) {
+			return append(append([]byte(nil), raw...), crlf...)
+		}
+		return raw
+	}
+
+	i := bytes.IndexByte(raw, ':')
+	if i < 0 {
+		return append(append([]byte(nil), raw...), crlf...)
+	}
+	k := strings.ToLower(strings.TrimRight(string(raw[:i]), " \t"))
+
+	// Unfold lines, and reduce whitespace sequences to a single space
+	var v []byte
+	space := false
+	for _, c := range raw[i+1:] {
+		switch {
+		case c == '\r' || c == '\n':
+			continue
+		case isWSP(c):
+			space = true
+		default:
+			if space && len(v) > 0 {
+				v = append(v, ' ')
+			}
+			space = false
+			v = append(v, c)
+		}
+	}
+
+	b := make([]byte, 0, len(k)+1+len(v)+2)
+	b = append(b, k...)
+	b = append(b, ':')
+	b = append(b, v...)
+	return append(b, crlf...)
+}
+
+// removeSignature removes the value of the b= tag of a signature header
+// field, as required to hash it. The trailing CRLF is removed too.
+func removeSignature(raw []byte) []byte {
+	raw = bytes.TrimSuffix(raw, crlf)
+
+	colon := bytes.IndexByte(raw, ':')
+	if colon < 0 {
+		return raw
+	}
+
+	start := colon + 1
+	for start <= len(raw) {
+		end := bytes.IndexByte(raw[start:], ';')
+		if end < 0 {
+			end = len(raw)
+		} else {
+			end += start
+		}
+
+		spec := raw[start:end]
+		if eq := bytes.IndexByte(spec, '='); eq >= 0 && string(bytes.TrimFunc(spec[:eq], func(r rune) bool {
+			return r < 128 && isFWS(byte(r))
+		})) == "b" {
+			b := make([]byte, 0, len(raw))
+			b = append(b, raw[:start+eq+1]...)
+			return append(b, raw[end:]...)
+		}
+
+		start = end + 1
+	}
+	return raw
+}
+
+// bodyCanonicalizer canonicalizes a message body, as defined in RFC 6376
+// section 3.4.3 and 3.4.4. Line endings are expected to be CRLF.
+type bodyCanonicalizer struct {
+	w       io.Writer
+	relaxed bool
+	line    []byte
+	crlfs   int
+	written bool
+}
+
+func newBodyCanonicalizer(w io.Writer, c Canonicalization) *bodyCanonicalizer {
+	return &bodyCanonicalizer{w: w, relaxed: c == CanonicalizationRelaxed}
+}
+
+// relaxLine ignores whitespace at the end of a line, and reduces whitespace
+// sequences to a single space.
+func relaxLine(l []byte) []byte {
+	var b []byte
+	space := false
+	for _, c := range l {
+		if isWSP(c) {
+			space = true
+			continue
+		}
+		if space {
+			b = append(b, ' ')
+			space = false
+		}
+		b = append(b, c)
+	}
+	return b
+}
+
+func (c *bodyCanonicalizer) writeLine(l []byte) error {
+	if c.relaxed {
+		l = relaxLine(l)
+	}
+	if len(l) == 0 {
+		// Empty lines at the end of the body are ignored: only write them
+		// once a non-empty line follows
+		c.crlfs++
+		return nil
+	}
+
+	for ; c.crlfs > 0; c.crlfs-- {
+		if _, err := c.w.Write(crlf); err != nil {
+			return err
+		}
+	}
+	if _, err := c.w.Write(l); err != nil {
+		return err
+	}
+	if _, err := c.w.Write(crlf); err != nil {
+		return err
+	}
+	c.written = true
+	return nil
+}
+
+func (c *bodyCanonicalizer) Write(b []byte) (int, error) {
+	c.line = append(c.line, b...)
+
+	start := 0
+	for {
+		i := bytes.Index(c.line[start:], crlf)
+		if i < 0 {
+			break
+		}
+		if err := c.writeLine(c.line[start : start+i]); err != nil {
+			return 0, err
+		}
+		start += i + len(crlf)
+	}
+	c.line = append(c.line[:0], c.line[start:]...)
+
+	return len(b), nil
+}
+
+func (c *bodyCanonicalizer) Close() error {
+	// A missing CRLF at the end of the body is added
+	if len(c.line) > 0 {
+		if err := c.writeLine(c.line); err != nil {
+			return err
+		}
+		c.line = nil
+	}
+
+	// The simple algorithm converts an empty body to a single CRLF
+	if !c.written && !c.relaxed {
+		_, err := c.w.Write(crlf)
+		return err
+	}
+	return nil
+}
+
+// limitedWriter writes at most n bytes to w, and discards the rest.
+type limitedWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (lw *limitedWriter) Write(b []byte) (int, error) {
+	n := len(b)
+	if int64(len(b)) > lw.n {
+		b = b[:lw.n]
+	}
+	if _, err := lw.w.Write(b); err != nil {
+		return 0, err
+	}
+	lw.n -= int64(len(b))
+	return n, nil
+}
@@ -0,0 +1,188 @@
+// Package dkim implements DomainKeys Identified Mail (DKIM) signatures.
+//
+// DKIM is defined in RFC 6376. The rsa-sha256 and ed25519-sha256 (RFC 8463)
+// signing algorithms are supported. rsa-sha1 signatures are considered
+// invalid, as required by RFC 8301.
+//
+// Messages are signed with a Signer while they are written, for instance by a
+// message.Writer. The resulting DKIM-Signature header field must then be
+// prepended to the message. Signatures are checked with Verify.
+//
+// The Authenticated Received Chain (ARC) protocol, defined in RFC 8617, is
+// also supported. It allows intermediaries which modify messages, such as
+// mailing lists, to record the authentication results they observed. ARC sets
+// are added with an ARCSealer, and chains are validated with VerifyARC.
+package dkim
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Canonicalization is a canonicalization algorithm, as defined in RFC 6376
+// section 3.4.
+type Canonicalization string
+
+const (
+	// CanonicalizationSimple tolerates almost no modification.
+	CanonicalizationSimple Canonicalization = "simple"
+	// CanonicalizationRelaxed tolerates common modifications such as
+	// whitespace replacement and header field line rewrapping.
+	CanonicalizationRelaxed Canonicalization = "relaxed"
+)
+
+type failError struct {
+	msg  string
+	err  error
+	temp bool
+}
+
+func (err *failError) Error() string {
+	if err.err != nil {
+		return "dkim: " + err.msg + ": " + err.err.Error()
+	}
+	return "dkim: " + err.msg
+}
+
+func (err *failError) Unwrap() error {
+	return err.err
+}
+
+func permFailError(msg string) error {
+	return &failError{msg: msg}
+}
+
+func tempFailError(msg string, err error) error {
+	return &failError{msg: msg, err: err, temp: true}
+}
+
+// IsTempFail returns true if the error returned by Verify is a temporary
+// failure, for instance a DNS timeout. Verification may succeed later.
+func IsTempFail(err error) bool {
+	var failErr *failError
+	return errors.As(err, &failErr) && failErr.temp
+}
+
+// IsPermFail returns true if the error returned by Verify is a permanent
+// failure, for instance a malformed signature or a missing key.
+//
+// Errors which are neither temporary nor permanent failures indicate that the
+// signature doesn't match the message.
+func IsPermFail(err error) bool {
+	var failErr *failError
+	return errors.As(err, &failErr) && !failErr.temp
+}
+
+func isWSP(c byte) bool {
+	return c == ' ' || c == '\t'
+}
+
+func isFWS(c byte) bool {
+	return isWSP(c) || c == '\r' || c == '\n'
+}
+
+// removeFWS removes all folding whitespace from s.
+func removeFWS(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r < 128 && isFWS(byte(r)) {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// parseTagList parses a tag=value list, as defined in RFC 6376 section 3.2.
+func parseTagList(s string) (map[string]string, error) {
+	params := make(map[string]string)
+	for _, spec := range strings.Split(s, ";") {
+		spec = strings.TrimFunc(spec, func(r rune) bool {
+			return r < 128 && isFWS(byte(r))
+		})
+		if spec == "" {
+			// Trailing semicolon
+			continue
+		}
+
+		i := strings.IndexByte(spec, '=')
+		if i < 0 {
+			return nil, fmt.Errorf("malformed tag-spec %q", spec)
+		}
+		k := strings.TrimRight(spec[:i], " \t\r\n")
+		v := strings.TrimLeft(spec[i+1:], " \t\r\n")
+		if k == "" {
+			return nil, fmt.Errorf("malformed tag-spec %q", spec)
+		}
+		if _, dup := params[k]; dup {
+			return nil, fmt.Errorf("duplicate tag %q", k)
+		}
+		params[k] = v
+	}
+	return params, nil
+}
+
+// parseTagValueList parses a colon-separated list of values, such as the h=
+// tag of DKIM-Signature header fields.
+func parseTagValueList(s string) []string {
+	l := strings.Split(s, ":")
+	for i, v := range l {
+		l[i] = strings.TrimSpace(removeFWS(v))
+	}
+	return l
+}
+
+func decodeBase64(s string) ([]byte, error) {
+	return base64.StdEncoding.DecodeString(removeFWS(s))
+}
+
+// foldHeaderField formats a header field whose value is a tag list. Folding
+// whitespace is inserted between tags and inside base64 values, so that lines
+// don't exceed a reasonable length.
+//
+// The output up to the last tag's value doesn't depend on this value, so that
+// the header field can be hashed with an empty b= tag before being signed.
+func foldHeaderField(k string, tags []string) string {
+	const maxLen = 75
+
+	var b strings.Builder
+	b.WriteString(k)
+	b.WriteString(":")
+	n := len(k) + 1
+	for i, tag := range tags {
+		if i < len(tags)-1 {
+			tag += ";"
+		}
+
+		name, value := tag, ""
+		if i := strings.IndexByte(tag, '='); i >= 0 {
+			name, value = tag[:i+1], tag[i+1:]
+		}
+		// Base64 values can be folded anywhere
+		foldable := name == "b=" || name == "bh="
+
+		l := len(tag)
+		if foldable {
+			l = len(name)
+		}
+		if n+1+l > maxLen {
+			b.WriteString("\r\n")
+			n = 0
+		}
+		b.WriteString(" ")
+		b.WriteString(name)
+		n += 1 + len(name)
+
+		for foldable && n+len(value) > maxLen {
+			l := maxLen - n
+			b.WriteString(value[:l])
+			b.WriteString("\r\n ")
+			value = value[l:]
+			n = 1
+		}
+		b.WriteString(value)
+		n += len(value)
+	}
+	b.WriteString("\r\n")
+	return b.String()
+}
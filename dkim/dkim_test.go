@@ -0,0 +1,150 @@
+package dkim
+
+import (
+	"bytes"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type testResolver map[string]string
+
+func (r testResolver) LookupTXT(domain string) ([]string, error) {
+	txt, ok := r[domain]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: domain, IsNotFound: true}
+	}
+	return []string{txt}, nil
+}
+
+func TestParseTagList(t *testing.T) {
+	s := " v=1; a=rsa-sha256;\r\n\td = example.org ; h=From : To;\r\n b=abc\r\n def;"
+	want := map[string]string{
+		"v": "1",
+		"a": "rsa-sha256",
+		"d": "example.org",
+		"h": "From : To",
+		"b": "abc\r\n def",
+	}
+	params, err := parseTagList(s)
+	if err != nil {
+		t.Fatalf("parseTagList() = %v", err)
+	}
+	if !reflect.DeepEqual(params, want) {
+		t.Errorf("parseTagList() = %#v, want %#v", params, want)
+	}
+
+	if _, err := parseTagList("v=1; v=2"); err == nil {
+		t.Error("parseTagList() with duplicate tag = nil, want an error")
+	}
+	if _, err := parseTagList("v=1; a"); err == nil {
+		t.Error("parseTagList() with malformed tag = nil, want an error")
+	}
+}
+
+// Example from RFC 6376 section 3.4.5
+var canonicalizationTests = []struct {
+	c      Canonicalization
+	header []string
+	body   string
+}{
+	{
+		c:      CanonicalizationSimple,
+		header: []string{"A: X\r\n", "B : Y\t\r\n\tZ  \r\n"},
+		body:   " C \r\nD \t E\r\n",
+	},
+	{
+		c:      CanonicalizationRelaxed,
+		header: []string{"a:X\r\n", "b:Y Z\r\n"},
+		body:   " C\r\nD E\r\n",
+	},
+}
+
+func TestCanonicalization(t *testing.T) {
+	header := []string{"A: X\r\n", "B : Y\t\r\n\tZ  \r\n"}
+	body := " C \r\nD \t E\r\n\r\n\r\n"
+
+	for _, tc := range canonicalizationTests {
+		t.Run(string(tc.c), func(t *testing.T) {
+			for i, raw := range header {
+				if s := string(canonicalizeHeader(tc.c, []byte(raw))); s != tc.header[i] {
+					t.Errorf("canonicalizeHeader(%q) = %q, want %q", raw, s, tc.header[i])
+				}
+			}
+
+			// Write the body in small chunks, to check that lines split
+			// across writes are handled
+			var b bytes.Buffer
+			w := newBodyCanonicalizer(&b, tc.c)
+			for i := 0; i < len(body); i += 3 {
+				end := i + 3
+				if end > len(body) {
+					end = len(body)
+				}
+				if _, err := w.Write([]byte(body[i:end])); err != nil {
+					t.Fatalf("Write() = %v", err)
+				}
+			}
+			if err := w.Close(); err != nil {
+				t.Fatalf("Close() = %v", err)
+			}
+			if s := b.String(); s != tc.body {
+				t.Errorf("canonicalized body = %q, want %q", s, tc.body)
+			}
+		})
+	}
+}
+
+var bodyCanonicalizationTests = []struct {
+	c    Canonicalization
+	in   string
+	want string
+}{
+	{CanonicalizationSimple, "", "\r\n"},
+	{CanonicalizationRelaxed, "", ""},
+	{CanonicalizationSimple, "\r\n\r\n", "\r\n"},
+	{CanonicalizationRelaxed, " \r\n\t\r\n", ""},
+	{CanonicalizationSimple, "Hi", "Hi\r\n"},
+	{CanonicalizationRelaxed, "Hi \t", "Hi\r\n"},
+	{CanonicalizationSimple, "Hi\r\n\r\nBye\r\n\r\n", "Hi\r\n\r\nBye\r\n"},
+}
+
+func TestBodyCanonicalizer(t *testing.T) {
+	for _, tc := range bodyCanonicalizationTests {
+		var b bytes.Buffer
+		w := newBodyCanonicalizer(&b, tc.c)
+		if _, err := w.Write([]byte(tc.in)); err != nil {
+			t.Fatalf("Write() = %v", err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("Close() = %v", err)
+		}
+		if s := b.String(); s != tc.want {
+			t.Errorf("%v canonicalization of %q = %q, want %q", tc.c, tc.in, s, tc.want)
+		}
+	}
+}
+
+func TestRemoveSignature(t *testing.T) {
+	raw := "DKIM-Signature: v=1; b=abc\r\n def; bh=ghi;\r\n d=example.org\r\n"
+	want := "DKIM-Signature: v=1; b=; bh=ghi;\r\n d=example.org"
+	if s := string(removeSignature([]byte(raw))); s != want {
+		t.Errorf("removeSignature() = %q, want %q", s, want)
+	}
+}
+
+func TestFoldHeaderField(t *testing.T) {
+	tags := []string{"v=1", "a=rsa-sha256", "d=example.org", "h=From:To:Subject:Date", "b=" + strings.Repeat("A", 200)}
+	s := foldHeaderField(headerFieldName, tags)
+	for _, l := range strings.SplitAfter(s, "\r\n") {
+		if len(l) > 78 {
+			t.Errorf("line too long: %q", l)
+		}
+	}
+
+	unsigned := foldHeaderField(headerFieldName, append(tags[:len(tags)-1:len(tags)-1], "b="))
+	if got, want := string(removeSignature([]byte(s))), strings.TrimSuffix(unsigned, "\r\n"); got != want {
+		t.Errorf("removeSignature(foldHeaderField()) = %q, want %q", got, want)
+	}
+}
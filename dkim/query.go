@@ -0,0 +1,155 @@
+package dkim
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"errors"
+	"net"
+	"strings"
+)
+
+// A Resolver looks up DNS TXT records.
+type Resolver interface {
+	// LookupTXT returns the TXT records for the given domain name. If a record
+	// is made of multiple character strings, they are concatenated.
+	LookupTXT(domain string) ([]string, error)
+}
+
+type netResolver struct{}
+
+func (netResolver) LookupTXT(domain string) ([]string, error) {
+	return net.LookupTXT(domain)
+}
+
+// publicKey is a DKIM key record, as defined in RFC 6376 section 3.6.1.
+type publicKey struct {
+	key       crypto.PublicKey
+	hashAlgos []string
+	flags     []string
+}
+
+func (pk *publicKey) hasFlag(flag string) bool {
+	for _, f := range pk.flags {
+		if f == flag {
+			return true
+		}
+	}
+	return false
+}
+
+func (pk *publicKey) acceptsHash(hashAlgo string) bool {
+	if pk.hashAlgos == nil {
+		return true
+	}
+	for _, h := range pk.hashAlgos {
+		if h == hashAlgo {
+			return true
+		}
+	}
+	return false
+}
+
+func parsePublicKey(s string) (*publicKey, error) {
+	params, err := parseTagList(s)
+	if err != nil {
+		return nil, permFailError("malformed key record: " + err.Error())
+	}
+
+	if v, ok := params["v"]; ok && v != "DKIM1" {
+		return nil, permFailError("incompatible key record version")
+	}
+
+	var pk publicKey
+	if h, ok := params["h"]; ok {
+		pk.hashAlgos = parseTagValueList(h)
+	}
+	if t, ok := params["t"]; ok {
+		pk.flags = parseTagValueList(t)
+	}
+	if s, ok := params["s"]; ok {
+		ok = false
+		for _, service := range parseTagValueList(s) {
+			if service == "*" || service == "email" {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return nil, permFailError("key record isn't suitable for email")
+		}
+	}
+
+	p, ok := params["p"]
+	if !ok {
+		return nil, permFailError("key record is missing public key")
+	}
+	if removeFWS(p) == "" {
+		return nil, permFailError("key revoked")
+	}
+	b, err := decodeBase64(p)
+	if err != nil {
+		return nil, permFailError("malformed public key: " + err.Error())
+	}
+
+	keyType := "rsa"
+	if k, ok := params["k"]; ok {
+		keyType = k
+	}
+	switch keyType {
+	case "rsa":
+		pub, err := x509.ParsePKIXPublicKey(b)
+		if err != nil {
+			// Some records contain a PKCS #1 key
+			pub, err = x509.ParsePKCS1PublicKey(b)
+		}
+		if err != nil {
+			return nil, permFailError("malformed RSA public key: " + err.Error())
+		}
+		rsaPub, ok := pub.(*rsa.PublicKey)
+		if !ok {
+			return nil, permFailError("key record public key isn't an RSA key")
+		}
+		// RFC 8301 section 3.2
+		if rsaPub.N.BitLen() < 1024 {
+			return nil, permFailError("RSA key is too short")
+		}
+		pk.key = rsaPub
+	case "ed25519":
+		if len(b) != ed25519.PublicKeySize {
+			return nil, permFailError("malformed Ed25519 public key")
+		}
+		pk.key = ed25519.PublicKey(b)
+	default:
+		return nil, permFailError("unsupported key type")
+	}
+
+	return &pk, nil
+}
+
+// queryPublicKey fetches the public key of selector for domain, as defined
+// in RFC 6376 section 3.6.2.
+func queryPublicKey(resolver Resolver, domain, selector string) (*publicKey, error) {
+	txts, err := resolver.LookupTXT(selector + "._domainkey." + domain)
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		return nil, permFailError("no key for signature")
+	} else if err != nil {
+		return nil, tempFailError("failed to query public key", err)
+	}
+	if len(txts) == 0 {
+		return nil, permFailError("no key for signature")
+	}
+
+	// RFC 6376 section 3.6.2.2: the result of multiple records is undefined,
+	// use the first valid one
+	var pk *publicKey
+	for _, txt := range txts {
+		pk, err = parsePublicKey(strings.TrimSpace(txt))
+		if err == nil {
+			break
+		}
+	}
+	return pk, err
+}
@@ -0,0 +1,414 @@
+package dkim
+
+import (
+	"bufio"
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-message/internal/canonical"
+	"github.com/emersion/go-message/textproto"
+)
+
+const headerFieldName = "DKIM-Signature"
+
+var now = time.Now
+
+// defaultHeaderKeys is the list of header fields signed by default, taken
+// from RFC 6376 section 5.4.1.
+var defaultHeaderKeys = []string{
+	"From",
+	"Reply-To",
+	"Subject",
+	"Date",
+	"To",
+	"Cc",
+	"Resent-Date",
+	"Resent-From",
+	"Resent-To",
+	"Resent-Cc",
+	"In-Reply-To",
+	"References",
+	"List-Id",
+	"List-Help",
+	"List-Unsubscribe",
+	"List-Subscribe",
+	"List-Post",
+	"List-Owner",
+	"List-Archive",
+}
+
+// SignOptions are options for NewSigner.
+type SignOptions struct {
+	// Domain is the signing domain, used in the d= tag. It's required.
+	Domain string
+	// Selector is the name of the public key record in the signing domain.
+	// It's required.
+	Selector string
+	// Identifier is the agent or user on behalf of which the message is
+	// signed, used in the i= tag. It must be in Domain or one of its
+	// subdomains. It's optional.
+	Identifier string
+
+	// Signer is the private key used to sign the message. It's required.
+	// *rsa.PrivateKey and ed25519.PrivateKey are supported. The public key
+	// must be published in the DNS record of Selector.
+	Signer crypto.Signer
+
+	// HeaderCanonicalization and BodyCanonicalization are the
+	// canonicalization algorithms for the message header and body. The
+	// default is CanonicalizationRelaxed.
+	HeaderCanonicalization Canonicalization
+	BodyCanonicalization   Canonicalization
+
+	// HeaderKeys is the list of signed header fields. It must contain From.
+	// By default, the header fields recommended by RFC 6376 section 5.4.1
+	// which are present in the message are signed.
+	//
+	// A header field name can be repeated to sign multiple instances. A
+	// header field name can be specified even if the message doesn't contain
+	// it: such a header field can't be added without breaking the signature.
+	HeaderKeys []string
+
+	// Expiration is the time after which the signature is considered
+	// invalid. It's optional.
+	Expiration time.Time
+}
+
+// withDefaults returns a sanitised version of the options with defaults/special
+// values accounted for.
+func (o *SignOptions) withDefaults() (*SignOptions, error) {
+	if o == nil {
+		return nil, errors.New("dkim: no options specified")
+	}
+	opts := *o
+	if opts.Domain == "" {
+		return nil, errors.New("dkim: no signing domain specified")
+	}
+	if opts.Selector == "" {
+		return nil, errors.New("dkim: no selector specified")
+	}
+	if opts.Signer == nil {
+		return nil, errors.New("dkim: no signer specified")
+	}
+	if opts.Identifier != "" && !isSubdomainIdentifier(opts.Identifier, opts.Domain) {
+		return nil, errors.New("dkim: identifier isn't in the signing domain")
+	}
+
+	if err := defaultCanonicalization(&opts.HeaderCanonicalization); err != nil {
+		return nil, err
+	}
+	if err := defaultCanonicalization(&opts.BodyCanonicalization); err != nil {
+		return nil, err
+	}
+
+	if opts.HeaderKeys != nil && !hasHeaderKey(opts.HeaderKeys, "From") {
+		return nil, errors.New("dkim: the From header field must be signed")
+	}
+
+	return &opts, nil
+}
+
+// defaultCanonicalization sets c to CanonicalizationRelaxed if empty, and
+// checks that it's a known algorithm.
+func defaultCanonicalization(c *Canonicalization) error {
+	if *c == "" {
+		*c = CanonicalizationRelaxed
+	}
+	if *c != CanonicalizationSimple && *c != CanonicalizationRelaxed {
+		return fmt.Errorf("dkim: unknown canonicalization %q", *c)
+	}
+	return nil
+}
+
+func hasHeaderKey(keys []string, k string) bool {
+	for _, key := range keys {
+		if strings.EqualFold(key, k) {
+			return true
+		}
+	}
+	return false
+}
+
+// isSubdomainIdentifier checks that the domain of identifier is domain or one
+// of its subdomains.
+func isSubdomainIdentifier(identifier, domain string) bool {
+	i := strings.LastIndexByte(identifier, '@')
+	if i < 0 {
+		return false
+	}
+	d := strings.ToLower(identifier[i+1:])
+	domain = strings.ToLower(domain)
+	return d == domain || strings.HasSuffix(d, "."+domain)
+}
+
+// signatureAlgorithm returns the name of the DKIM signing algorithm for a
+// private key.
+func signatureAlgorithm(signer crypto.Signer) (string, crypto.SignerOpts, error) {
+	switch signer.Public().(type) {
+	case *rsa.PublicKey:
+		return "rsa-sha256", crypto.SHA256, nil
+	case ed25519.PublicKey:
+		// RFC 8463 section 3: the SHA-256 hash is signed with PureEdDSA
+		return "ed25519-sha256", crypto.Hash(0), nil
+	default:
+		return "", nil, fmt.Errorf("dkim: unsupported key type %T", signer.Public())
+	}
+}
+
+// writeSignedHeader writes the canonicalized header fields listed in keys to
+// w. Header fields with the same name are selected from the bottom of the
+// header, as defined in RFC 6376 section 5.4.2.
+func writeSignedHeader(w io.Writer, h *textproto.Header, keys []string, c Canonicalization) error {
+	fields := make(map[string][][]byte)
+	for fs := h.Fields(); fs.Next(); {
+		raw, err := fs.Raw()
+		if err != nil {
+			return err
+		}
+		k := strings.ToLower(fs.Key())
+		fields[k] = append(fields[k], raw)
+	}
+
+	for _, k := range keys {
+		k = strings.ToLower(k)
+		l := fields[k]
+		if len(l) == 0 {
+			// Non-existent header fields are ignored
+			continue
+		}
+		raw := l[len(l)-1]
+		fields[k] = l[:len(l)-1]
+
+		if _, err := w.Write(canonicalizeHeader(c, raw)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Signer generates a DKIM signature.
+//
+// The whole message, header and body, must be written to the Signer. Line
+// endings are canonicalized to CRLF. Once the Signer is closed, Signature
+// returns the DKIM-Signature header field, which must be prepended to the
+// message.
+type Signer struct {
+	options *SignOptions
+	algo    string
+	opts    crypto.SignerOpts
+
+	in     *canonical.Writer
+	header []byte
+	h      textproto.Header
+
+	bodyHash hash.Hash
+	body     *bodyCanonicalizer
+
+	sig string
+}
+
+// NewSigner creates a new Signer.
+func NewSigner(options *SignOptions) (*Signer, error) {
+	options, err := options.withDefaults()
+	if err != nil {
+		return nil, err
+	}
+
+	algo, opts, err := signatureAlgorithm(options.Signer)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Signer{
+		options:  options,
+		algo:     algo,
+		opts:     opts,
+		bodyHash: sha256.New(),
+	}
+	s.in = canonical.NewWriter((*signerInput)(s))
+	return s, nil
+}
+
+type signerInput Signer
+
+func (in *signerInput) Write(b []byte) (int, error) {
+	s := (*Signer)(in)
+	if s.body != nil {
+		return s.body.Write(b)
+	}
+
+	// Buffer the header until the blank line which separates it from the
+	// body
+	start := len(s.header) - 3
+	if start < 0 {
+		start = 0
+	}
+	s.header = append(s.header, b...)
+
+	end := -1
+	if bytes.HasPrefix(s.header, crlf) {
+		end = len(crlf)
+	} else if i := bytes.Index(s.header[start:], []byte("\r\n\r\n")); i >= 0 {
+		end = start + i + 4
+	}
+	if end < 0 {
+		return len(b), nil
+	}
+
+	body := s.header[end:]
+	s.header = s.header[:end]
+	if err := s.startBody(); err != nil {
+		return 0, err
+	}
+	if _, err := s.body.Write(body); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (s *Signer) startBody() error {
+	h, err := textproto.ReadHeader(bufio.NewReader(bytes.NewReader(s.header)))
+	if err != nil {
+		return fmt.Errorf("dkim: failed to parse header: %v", err)
+	}
+	s.h = h
+	s.header = nil
+	s.body = newBodyCanonicalizer(s.bodyHash, s.options.BodyCanonicalization)
+	return nil
+}
+
+// Write implements io.WriteCloser.
+func (s *Signer) Write(b []byte) (int, error) {
+	if s.sig != "" {
+		return 0, errors.New("dkim: signer already closed")
+	}
+	return s.in.Write(b)
+}
+
+// Close implements io.WriteCloser. It computes the signature.
+func (s *Signer) Close() error {
+	if s.sig != "" {
+		return nil
+	}
+
+	if s.body == nil {
+		// The message has no body
+		if len(s.header) > 0 && !bytes.HasSuffix(s.header, crlf) {
+			s.header = append(s.header, crlf...)
+		}
+		s.header = append(s.header, crlf...)
+		if err := s.startBody(); err != nil {
+			return err
+		}
+	}
+	if err := s.body.Close(); err != nil {
+		return err
+	}
+
+	keys := s.options.HeaderKeys
+	if keys == nil {
+		for _, k := range defaultHeaderKeys {
+			if s.h.Has(k) {
+				keys = append(keys, k)
+			}
+		}
+	}
+	if !s.h.Has("From") {
+		return errors.New("dkim: message has no From header field")
+	}
+
+	tags := []string{
+		"v=1",
+		"a=" + s.algo,
+		"c=" + string(s.options.HeaderCanonicalization) + "/" + string(s.options.BodyCanonicalization),
+		"d=" + s.options.Domain,
+		"s=" + s.options.Selector,
+	}
+	if s.options.Identifier != "" {
+		tags = append(tags, "i="+s.options.Identifier)
+	}
+	tags = append(tags, "t="+strconv.FormatInt(now().Unix(), 10))
+	if !s.options.Expiration.IsZero() {
+		tags = append(tags, "x="+strconv.FormatInt(s.options.Expiration.Unix(), 10))
+	}
+	tags = append(tags,
+		"h="+strings.Join(keys, ":"),
+		"bh="+base64.StdEncoding.EncodeToString(s.bodyHash.Sum(nil)),
+	)
+
+	// The signature header field is hashed with an empty b= tag
+	raw := foldHeaderField(headerFieldName, append(tags[:len(tags):len(tags)], "b="))
+	hashed, err := hashHeader(&s.h, keys, s.options.HeaderCanonicalization, []byte(raw))
+	if err != nil {
+		return err
+	}
+
+	sig, err := s.options.Signer.Sign(rand.Reader, hashed, s.opts)
+	if err != nil {
+		return err
+	}
+
+	tags = append(tags, "b="+base64.StdEncoding.EncodeToString(sig))
+	s.sig = foldHeaderField(headerFieldName, tags)
+	return nil
+}
+
+// Signature returns the DKIM-Signature header field, including the trailing
+// CRLF. It can be prepended to the message, or added to a header with
+// textproto.Header.AddRaw. Close must be called before.
+func (s *Signer) Signature() string {
+	if s.sig == "" {
+		panic("dkim: Signer.Signature called before Close")
+	}
+	return s.sig
+}
+
+// hashHeader computes the hash of the signed header fields and of the raw
+// signature header field, as defined in RFC 6376 section 3.7.
+func hashHeader(h *textproto.Header, keys []string, c Canonicalization, sigField []byte) ([]byte, error) {
+	hasher := sha256.New()
+	if err := writeSignedHeader(hasher, h, keys, c); err != nil {
+		return nil, err
+	}
+
+	b := canonicalizeHeader(c, removeSignature(sigField))
+	hasher.Write(bytes.TrimSuffix(b, crlf))
+
+	return hasher.Sum(nil), nil
+}
+
+// Sign signs a message read from r, and writes it to w with a DKIM-Signature
+// header field prepended. The message is buffered in memory: use a Signer to
+// avoid this.
+func Sign(w io.Writer, r io.Reader, options *SignOptions) error {
+	s, err := NewSigner(options)
+	if err != nil {
+		return err
+	}
+
+	var b bytes.Buffer
+	if _, err := io.Copy(io.MultiWriter(&b, s), r); err != nil {
+		return err
+	}
+	if err := s.Close(); err != nil {
+		return err
+	}
+
+	if _, err := io.WriteString(w, s.Signature()); err != nil {
+		return err
+	}
+	_, err = b.WriteTo(w)
+	return err
+}
@@ -0,0 +1,178 @@
+package dkim
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-message"
+)
+
+const testMessage = "From: Joe SixPack <joe@football.example.com>\r\n" +
+	"To: Suzie Q <suzie@shopping.example.net>\r\n" +
+	"Subject: Is dinner ready?\r\n" +
+	"Date: Fri, 11 Jul 2003 21:00:37 -0700 (PDT)\r\n" +
+	"Message-ID: <20030712040037.46341.5F8J@football.example.com>\r\n" +
+	"\r\n" +
+	"Hi.\r\n" +
+	"\r\n" +
+	"We lost the game.  Are you hungry yet?\r\n" +
+	"\r\n" +
+	"Joe.\r\n"
+
+func newTestKeys(t *testing.T) (*rsa.PrivateKey, ed25519.PrivateKey, testResolver) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("rsa.GenerateKey() = %v", err)
+	}
+	rsaPub, err := x509.MarshalPKIXPublicKey(rsaKey.Public())
+	if err != nil {
+		t.Fatalf("x509.MarshalPKIXPublicKey() = %v", err)
+	}
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("ed25519.GenerateKey() = %v", err)
+	}
+	edPub := edKey.Public().(ed25519.PublicKey)
+
+	resolver := testResolver{
+		"rsa._domainkey.football.example.com":     "v=DKIM1; k=rsa; p=" + base64.StdEncoding.EncodeToString(rsaPub),
+		"ed25519._domainkey.football.example.com": "v=DKIM1; k=ed25519; p=" + base64.StdEncoding.EncodeToString(edPub),
+	}
+	return rsaKey, edKey, resolver
+}
+
+func signMessage(t *testing.T, msg string, options *SignOptions) string {
+	var b bytes.Buffer
+	if err := Sign(&b, strings.NewReader(msg), options); err != nil {
+		t.Fatalf("Sign() = %v", err)
+	}
+	return b.String()
+}
+
+func verifyMessage(t *testing.T, msg string, resolver Resolver) *Verification {
+	verifs, err := VerifyWithOptions(strings.NewReader(msg), &VerifyOptions{Resolver: resolver})
+	if err != nil {
+		t.Fatalf("Verify() = %v", err)
+	}
+	if len(verifs) != 1 {
+		t.Fatalf("Verify() returned %v verifications, want 1", len(verifs))
+	}
+	return verifs[0]
+}
+
+func TestSign(t *testing.T) {
+	rsaKey, edKey, resolver := newTestKeys(t)
+
+	keys := map[string]crypto.Signer{"rsa": rsaKey, "ed25519": edKey}
+	cans := []Canonicalization{CanonicalizationSimple, CanonicalizationRelaxed}
+	for selector, key := range keys {
+		for _, headerCan := range cans {
+			for _, bodyCan := range cans {
+				name := selector + "/" + string(headerCan) + "/" + string(bodyCan)
+				t.Run(name, func(t *testing.T) {
+					options := &SignOptions{
+						Domain:                 "football.example.com",
+						Selector:               selector,
+						Signer:                 key,
+						HeaderCanonicalization: headerCan,
+						BodyCanonicalization:   bodyCan,
+					}
+					signed := signMessage(t, testMessage, options)
+					if !strings.HasSuffix(signed, testMessage) {
+						t.Errorf("signed message doesn't end with the original message:\n%v", signed)
+					}
+
+					v := verifyMessage(t, signed, resolver)
+					if v.Err != nil {
+						t.Fatalf("Verification.Err = %v", v.Err)
+					}
+					if v.Domain != "football.example.com" {
+						t.Errorf("Verification.Domain = %q, want %q", v.Domain, "football.example.com")
+					}
+					if v.Identifier != "@football.example.com" {
+						t.Errorf("Verification.Identifier = %q, want %q", v.Identifier, "@football.example.com")
+					}
+					wantKeys := []string{"From", "Subject", "Date", "To"}
+					if strings.Join(v.HeaderKeys, ":") != strings.Join(wantKeys, ":") {
+						t.Errorf("Verification.HeaderKeys = %v, want %v", v.HeaderKeys, wantKeys)
+					}
+				})
+			}
+		}
+	}
+}
+
+func TestSigner_messageWriter(t *testing.T) {
+	key, _, resolver := newTestKeys(t)
+
+	s, err := NewSigner(&SignOptions{
+		Domain:     "football.example.com",
+		Selector:   "rsa",
+		Identifier: "joe@football.example.com",
+		Signer:     key,
+		Expiration: time.Now().Add(time.Hour),
+	})
+	if err != nil {
+		t.Fatalf("NewSigner() = %v", err)
+	}
+
+	var h message.Header
+	h.Set("From", "Joe SixPack <joe@football.example.com>")
+	h.Set("Subject", "Is dinner ready?")
+	h.SetContentType("text/plain", map[string]string{"charset": "utf-8"})
+	h.Set("Content-Transfer-Encoding", "quoted-printable")
+
+	var b bytes.Buffer
+	w, err := message.CreateWriter(io.MultiWriter(&b, s), h)
+	if err != nil {
+		t.Fatalf("message.CreateWriter() = %v", err)
+	}
+	io.WriteString(w, "Ça va ?\n")
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close() = %v", err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("Signer.Close() = %v", err)
+	}
+
+	signed := s.Signature() + b.String()
+	v := verifyMessage(t, signed, resolver)
+	if v.Err != nil {
+		t.Fatalf("Verification.Err = %v", v.Err)
+	}
+	if v.Identifier != "joe@football.example.com" {
+		t.Errorf("Verification.Identifier = %q, want %q", v.Identifier, "joe@football.example.com")
+	}
+	if v.Expiration.IsZero() {
+		t.Error("Verification.Expiration is zero")
+	}
+}
+
+func TestSign_invalidOptions(t *testing.T) {
+	key, _, _ := newTestKeys(t)
+
+	tests := []*SignOptions{
+		nil,
+		{Selector: "rsa", Signer: key},
+		{Domain: "football.example.com", Signer: key},
+		{Domain: "football.example.com", Selector: "rsa"},
+		{Domain: "football.example.com", Selector: "rsa", Signer: key, Identifier: "joe@example.org"},
+		{Domain: "football.example.com", Selector: "rsa", Signer: key, HeaderKeys: []string{"To"}},
+		{Domain: "football.example.com", Selector: "rsa", Signer: key, BodyCanonicalization: "nofws"},
+	}
+	for _, options := range tests {
+		if _, err := NewSigner(options); err == nil {
+			t.Errorf("NewSigner(%+v) = nil, want an error", options)
+		}
+	}
+}
@@ -0,0 +1,339 @@
+package dkim
+
+import (
+	"bufio"
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"hash"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-message/internal/canonical"
+	"github.com/emersion/go-message/textproto"
+)
+
+// VerifyOptions are options for VerifyWithOptions.
+type VerifyOptions struct {
+	// Resolver is used to fetch public keys. By default, DNS queries are
+	// performed with the net package.
+	Resolver Resolver
+}
+
+// withDefaults returns a sanitised version of the options with defaults/special
+// values accounted for.
+func (o *VerifyOptions) withDefaults() *VerifyOptions {
+	var opts VerifyOptions
+	if o != nil {
+		opts = *o
+	}
+	if opts.Resolver == nil {
+		opts.Resolver = netResolver{}
+	}
+	return &opts
+}
+
+// A Verification is the result of the verification of a DKIM signature.
+type Verification struct {
+	// Domain is the signing domain (SDID).
+	Domain string
+	// Selector is the name of the public key record in the signing domain.
+	Selector string
+	// Identifier is the agent or user identifier (AUID). It defaults to the
+	// signing domain, prefixed with "@".
+	Identifier string
+	// HeaderKeys is the list of signed header fields.
+	HeaderKeys []string
+
+	// Time is the signature timestamp. It's zero if unknown.
+	Time time.Time
+	// Expiration is the time after which the signature is invalid. It's zero
+	// if the signature doesn't expire.
+	Expiration time.Time
+
+	// Err is nil if the signature is valid. Otherwise, IsTempFail and
+	// IsPermFail can be used to check the kind of failure.
+	Err error
+}
+
+// signature is a parsed DKIM-Signature header field.
+type signature struct {
+	v *Verification
+
+	raw       []byte
+	algo      string
+	headerCan Canonicalization
+	bodyCan   Canonicalization
+	sig       []byte
+	bodyHash  []byte
+	// bodyLength is -1 if the whole body is signed
+	bodyLength int64
+	// instance is the ARC instance, zero for DKIM signatures
+	instance int
+
+	hasher hash.Hash
+	body   *bodyCanonicalizer
+	limit  *limitedWriter
+}
+
+// parseSignature parses a DKIM-Signature header field. If arc is true, an
+// ARC-Message-Signature header field is parsed instead: it has no v= tag and
+// its i= tag contains the ARC instance.
+func parseSignature(raw []byte, arc bool) (*signature, error) {
+	i := bytes.IndexByte(raw, ':')
+	if i < 0 {
+		return nil, permFailError("malformed signature")
+	}
+	params, err := parseTagList(string(raw[i+1:]))
+	if err != nil {
+		return nil, permFailError("malformed signature: " + err.Error())
+	}
+
+	required := []string{"v", "a", "b", "bh", "d", "h", "s"}
+	if arc {
+		required[0] = "i"
+	}
+	for _, k := range required {
+		if _, ok := params[k]; !ok {
+			return nil, permFailError("signature is missing required tag " + k + "=")
+		}
+	}
+	if !arc && params["v"] != "1" {
+		return nil, permFailError("incompatible signature version")
+	}
+
+	sig := &signature{
+		v: &Verification{
+			Domain:     removeFWS(params["d"]),
+			Selector:   removeFWS(params["s"]),
+			HeaderKeys: parseTagValueList(params["h"]),
+		},
+		raw:        raw,
+		algo:       removeFWS(params["a"]),
+		headerCan:  CanonicalizationSimple,
+		bodyCan:    CanonicalizationSimple,
+		bodyLength: -1,
+	}
+
+	if !isSupportedAlgorithm(sig.algo) {
+		return nil, permFailError("unsupported signing algorithm")
+	}
+
+	if sig.sig, err = decodeBase64(params["b"]); err != nil {
+		return nil, permFailError("malformed signature data: " + err.Error())
+	}
+	if sig.bodyHash, err = decodeBase64(params["bh"]); err != nil {
+		return nil, permFailError("malformed body hash: " + err.Error())
+	}
+
+	if !arc && !hasHeaderKey(sig.v.HeaderKeys, "From") {
+		return nil, permFailError("From header field isn't signed")
+	}
+	if arc && hasHeaderKey(sig.v.HeaderKeys, arcSealHeaderFieldName) {
+		return nil, permFailError("ARC-Seal header field is signed")
+	}
+
+	if c, ok := params["c"]; ok {
+		l := strings.SplitN(removeFWS(c), "/", 2)
+		sig.headerCan = Canonicalization(l[0])
+		if len(l) == 2 {
+			sig.bodyCan = Canonicalization(l[1])
+		}
+		for _, c := range []Canonicalization{sig.headerCan, sig.bodyCan} {
+			if c != CanonicalizationSimple && c != CanonicalizationRelaxed {
+				return nil, permFailError("unsupported canonicalization")
+			}
+		}
+	}
+
+	if arc {
+		if sig.instance, err = parseARCInstance(params["i"]); err != nil {
+			return nil, err
+		}
+		sig.v.Identifier = "@" + sig.v.Domain
+	} else if i, ok := params["i"]; ok {
+		sig.v.Identifier = removeFWS(i)
+		if !isSubdomainIdentifier(sig.v.Identifier, sig.v.Domain) {
+			return nil, permFailError("identifier isn't in the signing domain")
+		}
+	} else {
+		sig.v.Identifier = "@" + sig.v.Domain
+	}
+
+	if l, ok := params["l"]; ok {
+		sig.bodyLength, err = strconv.ParseInt(removeFWS(l), 10, 64)
+		if err != nil || sig.bodyLength < 0 {
+			return nil, permFailError("malformed body length")
+		}
+	}
+
+	if q, ok := params["q"]; ok {
+		ok = false
+		for _, method := range parseTagValueList(q) {
+			if method == "dns/txt" {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return nil, permFailError("unsupported public key query method")
+		}
+	}
+
+	if t, ok := params["t"]; ok {
+		sec, err := strconv.ParseInt(removeFWS(t), 10, 64)
+		if err != nil {
+			return nil, permFailError("malformed timestamp")
+		}
+		sig.v.Time = time.Unix(sec, 0)
+	}
+	if x, ok := params["x"]; ok {
+		sec, err := strconv.ParseInt(removeFWS(x), 10, 64)
+		if err != nil {
+			return nil, permFailError("malformed expiration")
+		}
+		sig.v.Expiration = time.Unix(sec, 0)
+		if !sig.v.Time.IsZero() && sig.v.Expiration.Before(sig.v.Time) {
+			return nil, permFailError("signature expires before its timestamp")
+		}
+	}
+
+	sig.hasher = sha256.New()
+	var w io.Writer = sig.hasher
+	if sig.bodyLength >= 0 {
+		sig.limit = &limitedWriter{w: w, n: sig.bodyLength}
+		w = sig.limit
+	}
+	sig.body = newBodyCanonicalizer(w, sig.bodyCan)
+	return sig, nil
+}
+
+func isSupportedAlgorithm(algo string) bool {
+	return algo == "rsa-sha256" || algo == "ed25519-sha256"
+}
+
+// lookupPublicKey fetches the public key of selector for domain, and checks
+// that it can be used with the signing algorithm algo.
+func lookupPublicKey(resolver Resolver, domain, selector, algo string) (*publicKey, error) {
+	pk, err := queryPublicKey(resolver, domain, selector)
+	if err != nil {
+		return nil, err
+	}
+	if !pk.acceptsHash("sha256") {
+		return nil, permFailError("key record doesn't allow the signature hash algorithm")
+	}
+	_, isEd25519 := pk.key.(ed25519.PublicKey)
+	if isEd25519 != (algo == "ed25519-sha256") {
+		return nil, permFailError("key type doesn't match the signing algorithm")
+	}
+	return pk, nil
+}
+
+// verifySignature checks the signature of a hash with a public key.
+func verifySignature(pub crypto.PublicKey, hashed, sig []byte) error {
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, hashed, sig); err != nil {
+			return errors.New("dkim: signature doesn't match")
+		}
+		return nil
+	case ed25519.PublicKey:
+		if !ed25519.Verify(pub, hashed, sig) {
+			return errors.New("dkim: signature doesn't match")
+		}
+		return nil
+	default:
+		return permFailError("unsupported public key type")
+	}
+}
+
+func (sig *signature) verify(h *textproto.Header, resolver Resolver) error {
+	if !sig.v.Expiration.IsZero() && now().After(sig.v.Expiration) {
+		return permFailError("signature has expired")
+	}
+
+	if err := sig.body.Close(); err != nil {
+		return err
+	}
+	if sig.limit != nil && sig.limit.n > 0 {
+		return permFailError("message body is shorter than the signed body length")
+	}
+	if !bytes.Equal(sig.hasher.Sum(nil), sig.bodyHash) {
+		return errors.New("dkim: body hash doesn't match")
+	}
+
+	pk, err := lookupPublicKey(resolver, sig.v.Domain, sig.v.Selector, sig.algo)
+	if err != nil {
+		return err
+	}
+	if pk.hasFlag("s") {
+		if i := strings.LastIndexByte(sig.v.Identifier, '@'); !strings.EqualFold(sig.v.Identifier[i+1:], sig.v.Domain) {
+			return permFailError("key record doesn't allow identifiers in subdomains")
+		}
+	}
+
+	hashed, err := hashHeader(h, sig.v.HeaderKeys, sig.headerCan, sig.raw)
+	if err != nil {
+		return permFailError("malformed header: " + err.Error())
+	}
+	return verifySignature(pk.key, hashed, sig.sig)
+}
+
+// Verify checks the DKIM signatures of a message read from r. It returns one
+// Verification per DKIM-Signature header field, in the order of the header.
+// An error is only returned if the message can't be read.
+//
+// If the message isn't signed, an empty list is returned.
+func Verify(r io.Reader) ([]*Verification, error) {
+	return VerifyWithOptions(r, nil)
+}
+
+// VerifyWithOptions see Verify, but allows overriding some parameters with
+// VerifyOptions.
+func VerifyWithOptions(r io.Reader, opts *VerifyOptions) ([]*Verification, error) {
+	opts = opts.withDefaults()
+
+	br := bufio.NewReader(r)
+	h, err := textproto.ReadHeader(br)
+	if err != nil {
+		return nil, err
+	}
+
+	var verifs []*Verification
+	var sigs []*signature
+	var bodyWriters []io.Writer
+	for fs := h.FieldsByKey(headerFieldName); fs.Next(); {
+		raw, err := fs.Raw()
+		if err != nil {
+			verifs = append(verifs, &Verification{Err: permFailError("malformed signature")})
+			continue
+		}
+		sig, err := parseSignature(raw, false)
+		if err != nil {
+			verifs = append(verifs, &Verification{Err: err})
+			continue
+		}
+		verifs = append(verifs, sig.v)
+		sigs = append(sigs, sig)
+		bodyWriters = append(bodyWriters, sig.body)
+	}
+
+	if len(sigs) == 0 {
+		return verifs, nil
+	}
+
+	// Line endings of messages stored with bare LF are canonicalized
+	if _, err := io.Copy(canonical.NewWriter(io.MultiWriter(bodyWriters...)), br); err != nil {
+		return nil, err
+	}
+
+	for _, sig := range sigs {
+		sig.v.Err = sig.verify(&h, opts.Resolver)
+	}
+	return verifs, nil
+}
@@ -0,0 +1,153 @@
+package dkim
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Example from RFC 8463 appendix A
+const testEd25519SignedMessage = "DKIM-Signature: v=1; a=ed25519-sha256; c=relaxed/relaxed;\r\n" +
+	" d=football.example.com; i=@football.example.com;\r\n" +
+	" q=dns/txt; s=brisbane; t=1528637909; h=from : to :\r\n" +
+	" subject : date : message-id : from : subject : date;\r\n" +
+	" bh=2jUSOH9NhtVGCQWNr9BrIAPreKQjO6Sn7XIkfJVOzv8=;\r\n" +
+	" b=/gCrinpcQOoIfuHNQIbq4pgh9kyIK3AQUdt9OdqQehSwhEIug4D11Bus\r\n" +
+	" Fa3bT3FY5OsU7ZbnKELq+eXdp1Q1Dw==\r\n" +
+	testMessage
+
+var testEd25519Resolver = testResolver{
+	"brisbane._domainkey.football.example.com": "v=DKIM1; k=ed25519; p=11qYAYKxCrfVS/7TyWQHOg7hcvPapiMlrwIaaPcHURo=",
+}
+
+func TestVerify_ed25519(t *testing.T) {
+	v := verifyMessage(t, testEd25519SignedMessage, testEd25519Resolver)
+	if v.Err != nil {
+		t.Fatalf("Verification.Err = %v", v.Err)
+	}
+	if v.Domain != "football.example.com" {
+		t.Errorf("Verification.Domain = %q, want %q", v.Domain, "football.example.com")
+	}
+	if v.Selector != "brisbane" {
+		t.Errorf("Verification.Selector = %q, want %q", v.Selector, "brisbane")
+	}
+	if want := time.Unix(1528637909, 0); !v.Time.Equal(want) {
+		t.Errorf("Verification.Time = %v, want %v", v.Time, want)
+	}
+	wantKeys := "from:to:subject:date:message-id:from:subject:date"
+	if s := strings.Join(v.HeaderKeys, ":"); s != wantKeys {
+		t.Errorf("Verification.HeaderKeys = %v, want %v", s, wantKeys)
+	}
+}
+
+func TestVerify_lf(t *testing.T) {
+	msg := strings.Replace(testEd25519SignedMessage, "\r\n", "\n", -1)
+	v := verifyMessage(t, msg, testEd25519Resolver)
+	if v.Err != nil {
+		t.Fatalf("Verification.Err = %v", v.Err)
+	}
+}
+
+func TestVerify_tampered(t *testing.T) {
+	tests := map[string]string{
+		"body":   strings.Replace(testEd25519SignedMessage, "hungry", "thirsty", 1),
+		"header": strings.Replace(testEd25519SignedMessage, "Subject: Is dinner ready?", "Subject: Is lunch ready?", 1),
+		// From is signed twice, so adding a From header field breaks the
+		// signature
+		"added header": "From: Mallory <mallory@example.org>\r\n" + testEd25519SignedMessage,
+	}
+	for name, msg := range tests {
+		t.Run(name, func(t *testing.T) {
+			v := verifyMessage(t, msg, testEd25519Resolver)
+			if v.Err == nil {
+				t.Fatal("Verification.Err = nil, want an error")
+			}
+			if IsPermFail(v.Err) || IsTempFail(v.Err) {
+				t.Errorf("Verification.Err = %v, want a signature mismatch", v.Err)
+			}
+		})
+	}
+}
+
+type failingResolver struct{}
+
+func (failingResolver) LookupTXT(domain string) ([]string, error) {
+	return nil, errors.New("DNS server unreachable")
+}
+
+func TestVerify_keyErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		resolver Resolver
+		temp     bool
+	}{
+		{"missing", testResolver{}, false},
+		{"revoked", testResolver{"brisbane._domainkey.football.example.com": "v=DKIM1; k=ed25519; p="}, false},
+		{"wrong type", testResolver{"brisbane._domainkey.football.example.com": "v=DKIM1; p=11qYAYKxCrfVS/7TyWQHOg7hcvPapiMlrwIaaPcHURo="}, false},
+		{"wrong hash", testResolver{"brisbane._domainkey.football.example.com": "v=DKIM1; k=ed25519; h=sha1; p=11qYAYKxCrfVS/7TyWQHOg7hcvPapiMlrwIaaPcHURo="}, false},
+		{"unreachable", failingResolver{}, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			v := verifyMessage(t, testEd25519SignedMessage, tc.resolver)
+			if tc.temp && !IsTempFail(v.Err) {
+				t.Errorf("Verification.Err = %v, want a temporary failure", v.Err)
+			} else if !tc.temp && !IsPermFail(v.Err) {
+				t.Errorf("Verification.Err = %v, want a permanent failure", v.Err)
+			}
+		})
+	}
+}
+
+func TestVerify_expired(t *testing.T) {
+	key, _, resolver := newTestKeys(t)
+	signed := signMessage(t, testMessage, &SignOptions{
+		Domain:     "football.example.com",
+		Selector:   "rsa",
+		Signer:     key,
+		Expiration: time.Now().Add(time.Hour),
+	})
+
+	defer func() {
+		now = time.Now
+	}()
+	now = func() time.Time {
+		return time.Now().Add(2 * time.Hour)
+	}
+
+	v := verifyMessage(t, signed, resolver)
+	if !IsPermFail(v.Err) {
+		t.Errorf("Verification.Err = %v, want a permanent failure", v.Err)
+	}
+}
+
+func TestVerify_malformed(t *testing.T) {
+	tests := []string{
+		"v=2; a=rsa-sha256; d=example.org; s=s; h=From; bh=; b=",
+		"v=1; a=rsa-sha1; d=example.org; s=s; h=From; bh=; b=",
+		"v=1; a=rsa-sha256; d=example.org; s=s; h=Subject; bh=; b=",
+		"v=1; a=rsa-sha256; d=example.org; s=s; h=From; bh=; b=; i=joe@example.com",
+		"v=1; a=rsa-sha256; d=example.org; s=s; h=From; bh=; b=; c=nowsp",
+		"v=1; a=rsa-sha256; d=example.org; s=s; h=From; bh=; b=; t=1000; x=10",
+		"v=1; a=rsa-sha256; d=example.org; s=s; h=From; bh=; b=; q=https",
+		"v=1; a=rsa-sha256; d=example.org; s=s; h=From; b=",
+	}
+	for _, tags := range tests {
+		msg := "DKIM-Signature: " + tags + "\r\n" + testMessage
+		v := verifyMessage(t, msg, testResolver{})
+		if !IsPermFail(v.Err) {
+			t.Errorf("Verify(%q): Verification.Err = %v, want a permanent failure", tags, v.Err)
+		}
+	}
+}
+
+func TestVerify_notSigned(t *testing.T) {
+	verifs, err := Verify(strings.NewReader(testMessage))
+	if err != nil {
+		t.Fatalf("Verify() = %v", err)
+	}
+	if len(verifs) != 0 {
+		t.Errorf("Verify() returned %v verifications, want none", len(verifs))
+	}
+}
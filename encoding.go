@@ -153,3 +153,164 @@ func cutLine(b []byte, max int) ([]byte, []byte) {
 	}
 	return b, nil
 }
+
+// autoEncodingWriter buffers the beginning of an entity's body to pick a
+// Content-Transfer-Encoding. The header is written once the encoding has been
+// picked.
+type autoEncodingWriter struct {
+	w           io.Writer
+	header      *Header
+	writeHeader func() error
+	max         int64
+
+	buf bytes.Buffer
+	wc  io.WriteCloser // non-nil once the encoding has been picked
+}
+
+func (w *autoEncodingWriter) Write(b []byte) (int, error) {
+	if w.wc != nil {
+		return w.wc.Write(b)
+	}
+	if int64(w.buf.Len())+int64(len(b)) <= w.max {
+		return w.buf.Write(b)
+	}
+
+	// The body is too large to be buffered, pick an encoding from what we have
+	// and stream the rest
+	if err := w.start(false); err != nil {
+		return 0, err
+	}
+	return w.wc.Write(b)
+}
+
+func (w *autoEncodingWriter) Close() error {
+	if w.wc == nil {
+		if err := w.start(true); err != nil {
+			return err
+		}
+	}
+	return w.wc.Close()
+}
+
+// start picks the encoding, writes the header and the buffered data. complete
+// indicates whether the whole body has been buffered.
+func (w *autoEncodingWriter) start(complete bool) error {
+	mediaType, mediaParams, _ := w.header.ContentType()
+	isText := strings.HasPrefix(mediaType, "text/")
+
+	stats := scanBody(w.buf.Bytes())
+	enc := stats.encoding(isText, complete)
+	w.header.Set("Content-Transfer-Encoding", enc)
+
+	// If the body isn't complete, we can't know whether it contains non-ASCII
+	// characters. Since UTF-8 is a superset of US-ASCII, it's always safe.
+	if isText && (stats.nonASCII > 0 || !complete) && mediaParams["charset"] == "" {
+		if mediaParams == nil {
+			mediaParams = make(map[string]string)
+		}
+		mediaParams["charset"] = "utf-8"
+		w.header.SetContentType(mediaType, mediaParams)
+	}
+
+	if err := w.writeHeader(); err != nil {
+		return err
+	}
+
+	wc, err := encodingWriter(enc, w.w)
+	if err != nil {
+		return err
+	}
+	if !complete && enc == "quoted-printable" {
+		// The rest of the body hasn't been checked
+		wc = &bareCRGuardWriter{WriteCloser: wc}
+	}
+	w.wc = wc
+
+	_, err = w.wc.Write(w.buf.Bytes())
+	w.buf = bytes.Buffer{}
+	return err
+}
+
+var errBareCR = errors.New("message: bare CR in quoted-printable text body")
+
+// bareCRGuardWriter rejects bare CR characters, which would be converted to
+// line breaks by a quoted-printable text encoder.
+type bareCRGuardWriter struct {
+	io.WriteCloser
+	cr bool // the last byte written is a CR
+}
+
+func (w *bareCRGuardWriter) Write(b []byte) (int, error) {
+	cr := w.cr
+	for _, c := range b {
+		if cr && c != '\n' {
+			return 0, errBareCR
+		}
+		cr = c == '\r'
+	}
+	w.cr = cr
+	return w.WriteCloser.Write(b)
+}
+
+func (w *bareCRGuardWriter) Close() error {
+	if w.cr {
+		return errBareCR
+	}
+	return w.WriteCloser.Close()
+}
+
+// bodyStats holds the properties of a body relevant to the choice of a
+// Content-Transfer-Encoding.
+type bodyStats struct {
+	len        int
+	nonASCII   int
+	nul        bool
+	bareCR     bool
+	maxLineLen int
+}
+
+func scanBody(b []byte) bodyStats {
+	stats := bodyStats{len: len(b)}
+	lineLen := 0
+	for i, c := range b {
+		switch {
+		case c == '\n':
+			lineLen = 0
+			continue
+		case c == '\r':
+			// A CR at the end of the buffer is considered bare
+			if i+1 >= len(b) || b[i+1] != '\n' {
+				stats.bareCR = true
+			}
+			continue
+		case c == 0:
+			stats.nul = true
+		case c >= 0x80:
+			stats.nonASCII++
+		}
+		lineLen++
+		if lineLen > stats.maxLineLen {
+			stats.maxLineLen = lineLen
+		}
+	}
+	return stats
+}
+
+// encoding returns the Content-Transfer-Encoding to use for the body. complete
+// indicates whether the stats describe the whole body or just the beginning
+// of it.
+func (stats *bodyStats) encoding(isText, complete bool) string {
+	// NUL and bare CR characters can't be represented in quoted-printable
+	// text without being altered
+	binary := stats.nul || stats.bareCR
+
+	if complete && !binary && stats.nonASCII == 0 && stats.maxLineLen <= 998 {
+		return "7bit"
+	}
+	// Quoted-printable triples the size of each non-ASCII byte, base64 is
+	// more compact for mostly non-ASCII text
+	if isText && !binary && stats.nonASCII*3 <= stats.len {
+		return "quoted-printable"
+	}
+	return "base64"
+}
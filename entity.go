@@ -14,7 +14,7 @@ import (
 // multipart entity.
 //
 // An Entity can only be consumed once: after its body is read, it can't be
-// used anymore.
+// used anymore. See ReadBuffered to read an entity multiple times.
 type Entity struct {
 	Header Header    // The entity's header.
 	Body   io.Reader // The decoded entity's body.
@@ -213,12 +213,83 @@ func (e *Entity) WriteTo(w io.Writer) error {
 // If an error is returned, processing stops.
 type WalkFunc func(path []int, entity *Entity, err error) error
 
+const defaultMaxMessageDepth = 10
+
+// WalkOptions are options for WalkWithOptions.
+type WalkOptions struct {
+	// ReadOptions are used to parse encapsulated messages.
+	ReadOptions
+
+	// DescendMessages enables parsing the body of message/rfc822 and
+	// message/global parts as encapsulated messages, and walking them. An
+	// encapsulated message is visited as the single child of the part
+	// containing it, so its path is the part's path followed by 0.
+	DescendMessages bool
+
+	// MaxMessageDepth limits the nesting level of encapsulated messages. If
+	// exceeded, message parts are visited without descending into them.
+	//
+	// Set to -1 for no limit, set to 0 for the default value (10).
+	MaxMessageDepth int
+}
+
+// withDefaults returns a sanitised version of the options with defaults/special
+// values accounted for.
+func (o *WalkOptions) withDefaults() *WalkOptions {
+	var out WalkOptions
+	if o != nil {
+		out = *o
+	}
+	out.ReadOptions = *out.ReadOptions.withDefaults()
+	if out.MaxMessageDepth == 0 {
+		out.MaxMessageDepth = defaultMaxMessageDepth
+	} else if out.MaxMessageDepth < 0 {
+		out.MaxMessageDepth = math.MaxInt32
+	}
+	return &out
+}
+
+// messagePartReader is a MultipartReader returning a single encapsulated
+// message.
+type messagePartReader struct {
+	e    *Entity
+	err  error
+	done bool
+}
+
+func (r *messagePartReader) NextPart() (*Entity, error) {
+	if r.done {
+		return nil, io.EOF
+	}
+	r.done = true
+	return r.e, r.err
+}
+
+func (r *messagePartReader) Close() error {
+	return nil
+}
+
 // Walk walks the entity's multipart tree, calling walkFunc for each part in
 // the tree, including the root entity.
 //
 // Walk consumes the entity.
 func (e *Entity) Walk(walkFunc WalkFunc) error {
+	return e.WalkWithOptions(walkFunc, nil)
+}
+
+// WalkWithOptions see Walk, but allows overriding some parameters with
+// WalkOptions.
+//
+// If DescendMessages is set, the body of message parts is read by
+// WalkWithOptions, so walkFunc must not read it. If an encapsulated message
+// can't be parsed, the error is passed to walkFunc for its message part, and
+// the walk continues with the next part.
+func (e *Entity) WalkWithOptions(walkFunc WalkFunc, opts *WalkOptions) error {
+	opts = opts.withDefaults()
+
 	var multipartReaders []MultipartReader
+	// number of encapsulated messages containing each multipart reader
+	var messageDepths []int
 	var path []int
 	part := e
 	for {
@@ -233,6 +304,7 @@ func (e *Entity) Walk(walkFunc WalkFunc) error {
 			part, err = mr.NextPart()
 			if err == io.EOF {
 				multipartReaders = multipartReaders[:len(multipartReaders)-1]
+				messageDepths = messageDepths[:len(messageDepths)-1]
 				path = path[:len(path)-1]
 				continue
 			} else if IsUnknownEncoding(err) || IsUnknownCharset(err) {
@@ -244,6 +316,22 @@ func (e *Entity) Walk(walkFunc WalkFunc) error {
 			path[len(path)-1]++
 		}
 
+		depth := 0
+		if len(messageDepths) > 0 {
+			depth = messageDepths[len(messageDepths)-1]
+		}
+
+		// Parse encapsulated messages before visiting their part, so that
+		// parsing errors are reported for that part
+		var me *Entity
+		var meErr error
+		if opts.DescendMessages && isMessageType(part.mediaType) && depth < opts.MaxMessageDepth {
+			me, meErr = part.EncapsulatedMessageWithOptions(&opts.ReadOptions)
+			if me == nil && err == nil {
+				err = meErr
+			}
+		}
+
 		// Copy the path since we'll mutate it on the next iteration
 		var pathCopy []int
 		if len(path) > 0 {
@@ -257,6 +345,11 @@ func (e *Entity) Walk(walkFunc WalkFunc) error {
 
 		if mr := part.MultipartReader(); mr != nil {
 			multipartReaders = append(multipartReaders, mr)
+			messageDepths = append(messageDepths, depth)
+			path = append(path, -1)
+		} else if me != nil {
+			multipartReaders = append(multipartReaders, &messagePartReader{e: me, err: meErr})
+			messageDepths = append(messageDepths, depth+1)
 			path = append(path, -1)
 		}
 
@@ -265,3 +358,28 @@ func (e *Entity) Walk(walkFunc WalkFunc) error {
 
 	return nil
 }
+
+func isMessageType(mediaType string) bool {
+	return mediaType == "message/rfc822" || mediaType == "message/global"
+}
+
+// EncapsulatedMessage parses the body of a message/rfc822 or message/global
+// entity as a message. If this entity is not a message, it returns nil.
+//
+// EncapsulatedMessage consumes the entity's body.
+//
+// If the encapsulated message uses an unknown transfer encoding or charset,
+// EncapsulatedMessage returns an error that verifies IsUnknownCharset or
+// IsUnknownEncoding, but also returns an Entity that can be read.
+func (e *Entity) EncapsulatedMessage() (*Entity, error) {
+	return e.EncapsulatedMessageWithOptions(nil)
+}
+
+// EncapsulatedMessageWithOptions see EncapsulatedMessage, but allows
+// overriding some parameters with ReadOptions.
+func (e *Entity) EncapsulatedMessageWithOptions(opts *ReadOptions) (*Entity, error) {
+	if !isMessageType(e.mediaType) {
+		return nil, nil
+	}
+	return ReadWithOptions(e.Body, opts)
+}
@@ -472,3 +472,163 @@ func TestWalk_multipart(t *testing.T) {
 		t.Errorf("Entity.Walk() =\n%#v\nbut want:\n%#v", got, want)
 	}
 }
+
+const testEncapsulatedMessage = "Content-Type: multipart/mixed; boundary=outer\r\n" +
+	"\r\n" +
+	"--outer\r\n" +
+	"Content-Type: text/plain\r\n" +
+	"\r\n" +
+	"Forwarded message below\r\n" +
+	"--outer\r\n" +
+	"Content-Type: message/rfc822\r\n" +
+	"\r\n" +
+	"Subject: Your Name\r\n" +
+	"Content-Type: multipart/alternative; boundary=inner\r\n" +
+	"\r\n" +
+	"--inner\r\n" +
+	"Content-Type: text/plain\r\n" +
+	"\r\n" +
+	"Who are you?\r\n" +
+	"--inner\r\n" +
+	"Content-Type: message/global\r\n" +
+	"\r\n" +
+	"Content-Type: text/plain\r\n" +
+	"\r\n" +
+	"I'm Mitsuha.\r\n" +
+	"--inner--\r\n" +
+	"--outer--\r\n"
+
+func walkCollectWithOptions(t *testing.T, opts *WalkOptions) []testWalkPart {
+	e, err := Read(strings.NewReader(testEncapsulatedMessage))
+	if err != nil {
+		t.Fatalf("Read() = %v", err)
+	}
+
+	var l []testWalkPart
+	err = e.WalkWithOptions(func(path []int, part *Entity, err error) error {
+		mediaType, _, _ := part.Header.ContentType()
+		var body string
+		if part.MultipartReader() == nil && !strings.HasPrefix(mediaType, "message/") {
+			b, err := ioutil.ReadAll(part.Body)
+			if err != nil {
+				return err
+			}
+			body = string(b)
+		}
+		l = append(l, testWalkPart{
+			path:      path,
+			mediaType: mediaType,
+			body:      body,
+			err:       err,
+		})
+		return nil
+	}, opts)
+	if err != nil {
+		t.Fatalf("Entity.WalkWithOptions() = %v", err)
+	}
+	return l
+}
+
+func TestWalkWithOptions_descendMessages(t *testing.T) {
+	want := []testWalkPart{
+		{path: nil, mediaType: "multipart/mixed"},
+		{path: []int{0}, mediaType: "text/plain", body: "Forwarded message below"},
+		{path: []int{1}, mediaType: "message/rfc822"},
+		{path: []int{1, 0}, mediaType: "multipart/alternative"},
+		{path: []int{1, 0, 0}, mediaType: "text/plain", body: "Who are you?"},
+		{path: []int{1, 0, 1}, mediaType: "message/global"},
+		{path: []int{1, 0, 1, 0}, mediaType: "text/plain", body: "I'm Mitsuha."},
+	}
+
+	got := walkCollectWithOptions(t, &WalkOptions{DescendMessages: true})
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Entity.WalkWithOptions() =\n%#v\nbut want:\n%#v", got, want)
+	}
+
+	got = walkCollectWithOptions(t, &WalkOptions{DescendMessages: true, MaxMessageDepth: 1})
+	if !reflect.DeepEqual(got, want[:6]) {
+		t.Errorf("Entity.WalkWithOptions() with MaxMessageDepth =\n%#v\nbut want:\n%#v", got, want[:6])
+	}
+
+	got = walkCollectWithOptions(t, nil)
+	if !reflect.DeepEqual(got, want[:3]) {
+		t.Errorf("Entity.WalkWithOptions() without DescendMessages =\n%#v\nbut want:\n%#v", got, want[:3])
+	}
+}
+
+func TestWalkWithOptions_invalidMessage(t *testing.T) {
+	s := "Content-Type: multipart/mixed; boundary=outer\r\n" +
+		"\r\n" +
+		"--outer\r\n" +
+		"Content-Type: message/rfc822\r\n" +
+		"\r\n" +
+		"Subject: " + strings.Repeat("Your Name ", 16) + "\r\n" +
+		"\r\n" +
+		"Who are you?\r\n" +
+		"--outer\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n" +
+		"I'm Mitsuha.\r\n" +
+		"--outer--\r\n"
+
+	e, err := Read(strings.NewReader(s))
+	if err != nil {
+		t.Fatalf("Read() = %v", err)
+	}
+
+	var paths [][]int
+	var errs []error
+	err = e.WalkWithOptions(func(path []int, part *Entity, err error) error {
+		paths = append(paths, path)
+		errs = append(errs, err)
+		return nil
+	}, &WalkOptions{
+		ReadOptions:     ReadOptions{MaxHeaderBytes: 64},
+		DescendMessages: true,
+	})
+	if err != nil {
+		t.Fatalf("Entity.WalkWithOptions() = %v", err)
+	}
+
+	// The encapsulated message header exceeds MaxHeaderBytes: the error is
+	// reported for the message part, and the next part is visited
+	wantPaths := [][]int{nil, {0}, {1}}
+	if !reflect.DeepEqual(paths, wantPaths) {
+		t.Fatalf("Entity.WalkWithOptions() visited %v, want %v", paths, wantPaths)
+	}
+	if errs[1] != errHeaderTooBig {
+		t.Errorf("Entity.WalkWithOptions() error for the message part = %v, want %v", errs[1], errHeaderTooBig)
+	}
+	if errs[0] != nil || errs[2] != nil {
+		t.Errorf("Entity.WalkWithOptions() errors = %v, want only one for the message part", errs)
+	}
+}
+
+func TestEntity_EncapsulatedMessage(t *testing.T) {
+	e, err := Read(strings.NewReader(testSingleText))
+	if err != nil {
+		t.Fatalf("Read() = %v", err)
+	}
+	if me, err := e.EncapsulatedMessage(); me != nil || err != nil {
+		t.Errorf("Entity.EncapsulatedMessage() = %v, %v, want nil", me, err)
+	}
+
+	s := "Content-Type: message/rfc822\r\n" +
+		"Content-Transfer-Encoding: base64\r\n" +
+		"\r\n" +
+		"U3ViamVjdDogWW91ciBOYW1lDQoNCldobyBhcmUgeW91Pw==\r\n"
+	e, err = Read(strings.NewReader(s))
+	if err != nil {
+		t.Fatalf("Read() = %v", err)
+	}
+	me, err := e.EncapsulatedMessage()
+	if err != nil {
+		t.Fatalf("Entity.EncapsulatedMessage() = %v", err)
+	}
+	if subject := me.Header.Get("Subject"); subject != "Your Name" {
+		t.Errorf("Subject = %q, want %q", subject, "Your Name")
+	}
+	if b, err := ioutil.ReadAll(me.Body); err != nil || string(b) != "Who are you?" {
+		t.Errorf("body = %q (%v), want %q", string(b), err, "Who are you?")
+	}
+}
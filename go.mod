@@ -1,5 +1,8 @@
 module github.com/emersion/go-message
 
-go 1.14
+go 1.20
 
-require golang.org/x/text v0.14.0
+require (
+	golang.org/x/net v0.17.0
+	golang.org/x/text v0.14.0
+)
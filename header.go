@@ -1,7 +1,13 @@
 package message
 
 import (
+	"fmt"
 	"mime"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
 
 	"github.com/emersion/go-message/textproto"
 )
@@ -17,12 +23,149 @@ func parseHeaderWithParams(s string) (f string, params map[string]string, err er
 	return
 }
 
-func formatHeaderWithParams(f string, params map[string]string) string {
-	encParams := make(map[string]string)
-	for k, v := range params {
-		encParams[k] = encodeHeader(v)
+// maxParamLen is the maximum length of an RFC 2231 encoded parameter section,
+// so that each section fits on its own header line once folded.
+const maxParamLen = 70
+
+// maxFallbackParamLen is the maximum length of an RFC 2047 parameter fallback,
+// so that it fits on a header line of at most 998 characters once folded.
+const maxFallbackParamLen = 998 - len(" ;")
+
+// formatHeaderWithParams formats a header field with parameters. Parameter
+// values which can't be represented as a token or a quoted-string are encoded
+// as defined in RFC 2231, and split into multiple sections if too long. If
+// fallback is true, these values are additionally written as RFC 2047
+// encoded-words for readers which don't support RFC 2231, unless they are too
+// long to fit on a header line.
+func formatHeaderWithParams(f string, params map[string]string, fallback bool) string {
+	s := mime.FormatMediaType(f, nil)
+	if s == "" {
+		return ""
+	}
+
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString(s)
+	for _, k := range keys {
+		v := params[k]
+		if !isToken(k) {
+			return ""
+		}
+		k = strings.ToLower(k)
+
+		if isToken(v) {
+			b.WriteString("; " + k + "=" + v)
+			continue
+		} else if isQuotable(v) {
+			b.WriteString("; " + k + "=" + quoteString(v))
+			continue
+		}
+
+		if fallback {
+			if p := k + "=" + quoteString(encodeHeader(v)); len(p) <= maxFallbackParamLen {
+				b.WriteString("; " + p)
+			}
+		}
+		for _, section := range encodeParam(k, v) {
+			b.WriteString("; " + section)
+		}
+	}
+	return b.String()
+}
+
+// encodeParam encodes a parameter as defined in RFC 2231, and returns one or
+// more parameter sections.
+func encodeParam(k, v string) []string {
+	const prefix = "utf-8''"
+
+	enc := percentEncode(v)
+	if s := k + "*=" + prefix + enc; len(s) <= maxParamLen {
+		return []string{s}
+	}
+
+	// Split the value into multiple sections, without splitting characters
+	var sections []string
+	for i := 0; len(v) > 0; i++ {
+		name := k + "*" + strconv.Itoa(i) + "*="
+		if i == 0 {
+			name += prefix
+		}
+
+		n := 0
+		l := len(name)
+		for n < len(v) {
+			_, size := utf8.DecodeRuneInString(v[n:])
+			runeLen := len(percentEncode(v[n : n+size]))
+			if n > 0 && l+runeLen > maxParamLen {
+				break
+			}
+			n += size
+			l += runeLen
+		}
+
+		sections = append(sections, name+percentEncode(v[:n]))
+		v = v[n:]
+	}
+	return sections
+}
+
+func percentEncode(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if isTokenChar(c) && c != '*' && c != '\'' && c != '%' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func isTokenChar(c byte) bool {
+	// RFC 2045 section 5.1 tspecials
+	return c > ' ' && c < 0x7F && !strings.ContainsRune(`()<>@,;:\"/[]?=`, rune(c))
+}
+
+func isToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if !isTokenChar(s[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// isQuotable reports whether s can be represented as a quoted-string without
+// any encoding.
+func isQuotable(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if (s[i] < ' ' || s[i] >= 0x7F) && s[i] != '\t' {
+			return false
+		}
 	}
-	return mime.FormatMediaType(f, encParams)
+	return true
+}
+
+func quoteString(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		if s[i] == '"' || s[i] == '\\' {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(s[i])
+	}
+	b.WriteByte('"')
+	return b.String()
 }
 
 // HeaderFields iterates over header fields.
@@ -73,9 +216,17 @@ func (h *Header) ContentType() (t string, params map[string]string, err error) {
 	return parseHeaderWithParams(v)
 }
 
-// SetContentType formats the Content-Type header field.
+// SetContentType formats the Content-Type header field. Parameter values are
+// encoded as defined in RFC 2231 if necessary.
 func (h *Header) SetContentType(t string, params map[string]string) {
-	h.Set("Content-Type", formatHeaderWithParams(t, params))
+	h.Set("Content-Type", formatHeaderWithParams(t, params, false))
+}
+
+// SetContentTypeWithFallback formats the Content-Type header field like
+// SetContentType. Parameter values encoded as defined in RFC 2231 are also
+// written as RFC 2047 encoded-words, for readers which don't support RFC 2231.
+func (h *Header) SetContentTypeWithFallback(t string, params map[string]string) {
+	h.Set("Content-Type", formatHeaderWithParams(t, params, true))
 }
 
 // ContentDisposition parses the Content-Disposition header field, as defined in
@@ -85,9 +236,18 @@ func (h *Header) ContentDisposition() (disp string, params map[string]string, er
 }
 
 // SetContentDisposition formats the Content-Disposition header field, as
-// defined in RFC 2183.
+// defined in RFC 2183. Parameter values are encoded as defined in RFC 2231 if
+// necessary.
 func (h *Header) SetContentDisposition(disp string, params map[string]string) {
-	h.Set("Content-Disposition", formatHeaderWithParams(disp, params))
+	h.Set("Content-Disposition", formatHeaderWithParams(disp, params, false))
+}
+
+// SetContentDispositionWithFallback formats the Content-Disposition header
+// field like SetContentDisposition. Parameter values encoded as defined in
+// RFC 2231 are also written as RFC 2047 encoded-words, for readers which don't
+// support RFC 2231.
+func (h *Header) SetContentDispositionWithFallback(disp string, params map[string]string) {
+	h.Set("Content-Disposition", formatHeaderWithParams(disp, params, true))
 }
 
 // Text parses a plaintext header field. The field charset is automatically
@@ -102,6 +262,19 @@ func (h *Header) SetText(k, v string) {
 	h.Set(k, encodeHeader(v))
 }
 
+// SetTextWithCharset sets a plaintext header field, encoding it with the
+// provided charset if it contains non-ASCII characters. If the charset is
+// unknown, the header field is left unchanged and the error verifies
+// IsUnknownCharset.
+func (h *Header) SetTextWithCharset(k, v, charset string) error {
+	enc, err := encodeHeaderCharset(charset, v)
+	if err != nil {
+		return err
+	}
+	h.Set(k, enc)
+	return nil
+}
+
 // Copy creates a stand-alone copy of the header.
 func (h *Header) Copy() Header {
 	return Header{h.Header.Copy()}
@@ -120,3 +293,135 @@ func (h *Header) Fields() HeaderFields {
 func (h *Header) FieldsByKey(k string) HeaderFields {
 	return &headerFields{h.Header.FieldsByKey(k)}
 }
+
+// phraseHeaderFields are header fields containing phrases, as defined in
+// RFC 5322 section 3.2.5. Decoding encoded-words in these fields may require
+// quoting.
+var phraseHeaderFields = map[string]bool{
+	"From":                        true,
+	"Sender":                      true,
+	"Reply-To":                    true,
+	"To":                          true,
+	"Cc":                          true,
+	"Bcc":                         true,
+	"Resent-From":                 true,
+	"Resent-Sender":               true,
+	"Resent-To":                   true,
+	"Resent-Cc":                   true,
+	"Resent-Bcc":                  true,
+	"Mail-Followup-To":            true,
+	"Mail-Reply-To":               true,
+	"Disposition-Notification-To": true,
+	"Keywords":                    true,
+	"List-Id":                     true,
+}
+
+// decodeHeaderUTF8 replaces RFC 2047 encoded-words with raw UTF-8 in the
+// header fields, as allowed by RFC 6532. The order of the header fields is
+// preserved.
+func decodeHeaderUTF8(h *Header) {
+	type field struct {
+		k, v string
+		raw  []byte
+	}
+	var l []field
+	changed := false
+	fields := h.Header.Fields()
+	for fields.Next() {
+		k, v := fields.Key(), fields.Value()
+		raw, _ := fields.Raw()
+		f := field{k: k, v: v, raw: raw}
+
+		switch {
+		case !strings.Contains(v, "=?"):
+			// Nothing to decode
+		case k == "Content-Type" || k == "Content-Disposition":
+			// Parameters may contain encoded-words for legacy readers
+		case phraseHeaderFields[k]:
+			f.v, f.raw = decodePhrases(v), nil
+		default:
+			if dec, err := decodeHeader(v); err == nil {
+				f.v, f.raw = dec, nil
+			}
+		}
+		if f.raw == nil {
+			// Encoded-words could contain line breaks
+			f.v = strings.NewReplacer("\r", " ", "\n", " ").Replace(f.v)
+			changed = true
+		}
+
+		l = append(l, f)
+	}
+	if !changed {
+		return
+	}
+
+	var hh textproto.Header
+	for i := len(l) - 1; i >= 0; i-- {
+		if l[i].raw != nil {
+			hh.AddRaw(l[i].raw)
+		} else {
+			hh.Add(l[i].k, l[i].v)
+		}
+	}
+	h.Header = hh
+}
+
+var encodedWordsRegexp = regexp.MustCompile(`^=\?[^?\s]+\?[bBqQ]\?[^?\s]*\?=(?:\s+=\?[^?\s]+\?[bBqQ]\?[^?\s]*\?=)*`)
+
+// decodePhrases replaces RFC 2047 encoded-words in phrases with raw UTF-8,
+// quoting the result if necessary. Quoted strings, comments and angle
+// brackets are left as-is.
+func decodePhrases(s string) string {
+	var b strings.Builder
+	for len(s) > 0 {
+		var n int
+		switch s[0] {
+		case '"':
+			n = skipDelimited(s, '"', '"')
+		case '(':
+			n = skipDelimited(s, '(', ')')
+		case '<':
+			n = skipDelimited(s, '<', '>')
+		default:
+			if loc := encodedWordsRegexp.FindStringIndex(s); loc != nil {
+				n = loc[1]
+				dec, err := decodeHeader(s[:n])
+				if err != nil {
+					b.WriteString(s[:n])
+				} else if strings.ContainsAny(dec, "()<>[]:;@\\,.\"") {
+					b.WriteString(quoteString(dec))
+				} else {
+					b.WriteString(dec)
+				}
+				s = s[n:]
+				continue
+			}
+			n = 1
+		}
+		b.WriteString(s[:n])
+		s = s[n:]
+	}
+	return b.String()
+}
+
+// skipDelimited returns the length of the delimited token at the start of s.
+// Backslash escapes and nested delimiters are taken into account.
+func skipDelimited(s string, open, close byte) int {
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\\' && open != '<':
+			i++
+		case c == open && (open != close || depth == 0):
+			depth++
+		case c == close:
+			depth--
+			if depth == 0 {
+				return i + 1
+			}
+		}
+	}
+	return len(s)
+}
@@ -1,8 +1,12 @@
 package message
 
 import (
+	"bytes"
 	"reflect"
+	"strings"
 	"testing"
+
+	"github.com/emersion/go-message/textproto"
 )
 
 func TestHeader(t *testing.T) {
@@ -85,3 +89,81 @@ func TestUnknownCharset(t *testing.T) {
 		t.Error("Expected error to verify IsUnknownCharset")
 	}
 }
+
+var formatHeaderWithParamsTests = []struct {
+	params    map[string]string
+	formatted string
+	fallback  string
+}{
+	{
+		params:    map[string]string{"filename": "report.pdf"},
+		formatted: "attachment; filename=report.pdf",
+	},
+	{
+		params:    map[string]string{"filename": "annual report.pdf"},
+		formatted: `attachment; filename="annual report.pdf"`,
+	},
+	{
+		params:    map[string]string{"filename": "complémentarité.txt"},
+		formatted: "attachment; filename*=utf-8''compl%C3%A9mentarit%C3%A9.txt",
+		fallback:  `attachment; filename="=?utf-8?q?compl=C3=A9mentarit=C3=A9.txt?="; filename*=utf-8''compl%C3%A9mentarit%C3%A9.txt`,
+	},
+	{
+		params: map[string]string{"filename": "Plan de complémentarité de l'Homme (version définitive).pdf"},
+		formatted: "attachment; filename*0*=utf-8''Plan%20de%20compl%C3%A9mentarit%C3%A9%20de%20l%27Ho; " +
+			"filename*1*=mme%20%28version%20d%C3%A9finitive%29.pdf",
+	},
+}
+
+func TestFormatHeaderWithParams(t *testing.T) {
+	for _, test := range formatHeaderWithParamsTests {
+		var h Header
+		h.SetContentDisposition("attachment", test.params)
+		if s := h.Get("Content-Disposition"); s != test.formatted {
+			t.Errorf("Expected Content-Disposition to be \n%v\n but got \n%v", test.formatted, s)
+		}
+		if _, params, err := h.ContentDisposition(); err != nil {
+			t.Errorf("ContentDisposition() = %v", err)
+		} else if !reflect.DeepEqual(params, test.params) {
+			t.Errorf("Expected params %v but got %v", test.params, params)
+		}
+
+		if test.fallback == "" {
+			continue
+		}
+		h.SetContentDispositionWithFallback("attachment", test.params)
+		if s := h.Get("Content-Disposition"); s != test.fallback {
+			t.Errorf("Expected Content-Disposition with fallback to be \n%v\n but got \n%v", test.fallback, s)
+		}
+		if _, params, err := h.ContentDisposition(); err != nil {
+			t.Errorf("ContentDisposition() = %v", err)
+		} else if !reflect.DeepEqual(params, test.params) {
+			t.Errorf("Expected params %v but got %v", test.params, params)
+		}
+	}
+}
+
+func TestFormatHeaderWithParams_longFallback(t *testing.T) {
+	params := map[string]string{"filename": strings.Repeat("日本語ファイル名", 20) + ".txt"}
+
+	var h Header
+	h.SetContentDispositionWithFallback("attachment", params)
+	if s := h.Get("Content-Disposition"); strings.Contains(s, "filename=") {
+		t.Errorf("Expected no RFC 2047 fallback for a long parameter, got \n%v", s)
+	}
+	if _, got, err := h.ContentDisposition(); err != nil {
+		t.Errorf("ContentDisposition() = %v", err)
+	} else if !reflect.DeepEqual(got, params) {
+		t.Errorf("Expected params %v but got %v", params, got)
+	}
+
+	var b bytes.Buffer
+	if err := textproto.WriteHeader(&b, h.Header); err != nil {
+		t.Fatalf("WriteHeader() = %v", err)
+	}
+	for _, l := range strings.Split(b.String(), "\r\n") {
+		if len(l) > 76 {
+			t.Errorf("Expected header lines to be folded at 76 characters, got %v characters: %q", len(l), l)
+		}
+	}
+}
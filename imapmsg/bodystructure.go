@@ -0,0 +1,257 @@
+package imapmsg
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/emersion/go-message"
+	"github.com/emersion/go-message/textproto"
+)
+
+// maxDepth is the maximum nesting level of multipart and message/rfc822
+// parts. Deeper parts are handled as opaque single parts.
+const maxDepth = 100
+
+// A BodyStructure is the structure of a message part, as defined in RFC 3501
+// section 7.4.2. It contains both the basic fields and the extension data.
+type BodyStructure struct {
+	// The lowercase MIME type and subtype, e.g. "text" and "plain"
+	MIMEType    string
+	MIMESubType string
+	// The Content-Type parameters, with lowercase keys
+	Params map[string]string
+	// The Content-ID, Content-Description and lowercase
+	// Content-Transfer-Encoding header fields
+	ID          string
+	Description string
+	Encoding    string
+	// The size of the body in octets, in its transfer encoding
+	Size int64
+
+	// The number of lines of the body, in its transfer encoding. Only set for
+	// text and message/rfc822 parts.
+	Lines int64
+
+	// The child parts of a multipart part
+	Children []*BodyStructure
+
+	// The envelope and the body structure of the encapsulated message of a
+	// message/rfc822 part. They are nil if the encapsulated message can't be
+	// parsed: the part is then handled as an opaque single part.
+	Envelope      *Envelope
+	BodyStructure *BodyStructure
+
+	// The Content-MD5 header field
+	MD5 string
+	// The lowercase Content-Disposition value and its parameters
+	Disposition       string
+	DispositionParams map[string]string
+	// The Content-Language tags
+	Language []string
+	// The Content-Location URI
+	Location string
+}
+
+// IsMultipart checks whether the part is a multipart part.
+func (bs *BodyStructure) IsMultipart() bool {
+	return bs.MIMEType == "multipart"
+}
+
+// isMessage checks whether the part is an encapsulated message.
+func (bs *BodyStructure) isMessage() bool {
+	return bs.MIMEType == "message" && (bs.MIMESubType == "rfc822" || bs.MIMESubType == "global")
+}
+
+// countReader counts the octets and lines read.
+type countReader struct {
+	r     io.Reader
+	size  int64
+	lines int64
+	last  byte
+}
+
+func (cr *countReader) Read(b []byte) (int, error) {
+	n, err := cr.r.Read(b)
+	if n > 0 {
+		cr.size += int64(n)
+		cr.lines += int64(bytes.Count(b[:n], []byte{'\n'}))
+		cr.last = b[n-1]
+	}
+	return n, err
+}
+
+// lineCount returns the number of lines read. A final line without a line
+// ending is counted.
+func (cr *countReader) lineCount() int64 {
+	if cr.size > 0 && cr.last != '\n' {
+		return cr.lines + 1
+	}
+	return cr.lines
+}
+
+// eofReader records whether the end of its input has been reached.
+type eofReader struct {
+	r   io.Reader
+	eof bool
+}
+
+func (er *eofReader) Read(b []byte) (int, error) {
+	n, err := er.r.Read(b)
+	if err == io.EOF {
+		er.eof = true
+	}
+	return n, err
+}
+
+// multipartReader reads the parts of a multipart body. Unlike
+// textproto.MultipartReader, a missing close delimiter isn't an error: the
+// body ends at EOF.
+type multipartReader struct {
+	mr *textproto.MultipartReader
+	er *eofReader
+}
+
+func newMultipartReader(r io.Reader, boundary string) *multipartReader {
+	er := &eofReader{r: r}
+	return &multipartReader{mr: textproto.NewMultipartReader(er, boundary), er: er}
+}
+
+// NextPart returns the header and the raw body of the next part. When there
+// are no more parts, io.EOF is returned.
+func (mr *multipartReader) NextPart() (textproto.Header, io.Reader, error) {
+	p, err := mr.mr.NextPart()
+	if err != nil && mr.er.eof {
+		err = io.EOF
+	}
+	if err != nil {
+		return textproto.Header{}, nil, err
+	}
+	return p.Header, &partReader{p: p, er: mr.er}, nil
+}
+
+// partReader reads the body of a part, which ends at EOF if the multipart
+// body is missing its close delimiter.
+type partReader struct {
+	p  *textproto.Part
+	er *eofReader
+}
+
+func (pr *partReader) Read(b []byte) (int, error) {
+	n, err := pr.p.Read(b)
+	if err == io.ErrUnexpectedEOF && pr.er.eof {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func newBodyStructure(h textproto.Header, inDigest bool) *BodyStructure {
+	mh := message.Header{Header: h}
+
+	var bs BodyStructure
+	t, params, err := mh.ContentType()
+	if h.Get("Content-Type") == "" {
+		// The default is text/plain, or message/rfc822 in a multipart/digest
+		// part
+		if inDigest {
+			t = "message/rfc822"
+		} else {
+			params = map[string]string{"charset": "us-ascii"}
+		}
+	} else if err != nil {
+		t, params = "text/plain", map[string]string{"charset": "us-ascii"}
+	}
+	t = strings.ToLower(t)
+	if i := strings.IndexByte(t, '/'); i >= 0 {
+		bs.MIMEType, bs.MIMESubType = t[:i], t[i+1:]
+	} else {
+		bs.MIMEType, bs.MIMESubType = "text", "plain"
+	}
+	bs.Params = params
+
+	if bs.IsMultipart() && params["boundary"] == "" {
+		// A multipart part without boundary can't be parsed
+		bs.MIMEType, bs.MIMESubType = "text", "plain"
+		bs.Params = map[string]string{"charset": "us-ascii"}
+	}
+
+	bs.ID = strings.TrimSpace(h.Get("Content-Id"))
+	bs.Description = h.Get("Content-Description")
+	bs.Encoding = strings.ToLower(strings.TrimSpace(h.Get("Content-Transfer-Encoding")))
+	if bs.Encoding == "" {
+		bs.Encoding = "7bit"
+	}
+
+	bs.MD5 = strings.TrimSpace(h.Get("Content-Md5"))
+	if disp, params, err := mh.ContentDisposition(); err == nil {
+		bs.Disposition = strings.ToLower(disp)
+		bs.DispositionParams = params
+	}
+	for _, tag := range strings.Split(h.Get("Content-Language"), ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			bs.Language = append(bs.Language, tag)
+		}
+	}
+	bs.Location = strings.TrimSpace(h.Get("Content-Location"))
+
+	return &bs
+}
+
+func isIdentityEncoding(enc string) bool {
+	switch enc {
+	case "7bit", "8bit", "binary":
+		return true
+	default:
+		return false
+	}
+}
+
+// readBodyStructure computes the body structure of a part, reading its raw
+// body from r.
+func readBodyStructure(h textproto.Header, r io.Reader, inDigest bool, depth int) (*BodyStructure, error) {
+	bs := newBodyStructure(h, inDigest)
+	cr := &countReader{r: r}
+
+	switch {
+	case depth >= maxDepth:
+		// Don't parse the body
+	case bs.IsMultipart():
+		mr := newMultipartReader(cr, bs.Params["boundary"])
+		for {
+			ph, p, err := mr.NextPart()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, err
+			}
+
+			child, err := readBodyStructure(ph, p, bs.MIMESubType == "digest", depth+1)
+			if err != nil {
+				return nil, err
+			}
+			bs.Children = append(bs.Children, child)
+		}
+	case bs.isMessage() && isIdentityEncoding(bs.Encoding):
+		// If the encapsulated message can't be parsed, handle the part as an
+		// opaque single part
+		br := bufio.NewReader(cr)
+		if mh, err := textproto.ReadHeader(br); err == nil {
+			if msgBS, err := readBodyStructure(mh, br, false, depth+1); err == nil {
+				bs.Envelope = NewEnvelope(mailHeader(mh))
+				bs.BodyStructure = msgBS
+			}
+		}
+	}
+
+	if _, err := io.Copy(ioutil.Discard, cr); err != nil {
+		return nil, err
+	}
+
+	bs.Size = cr.size
+	if bs.MIMEType == "text" || bs.isMessage() {
+		bs.Lines = cr.lineCount()
+	}
+	return bs, nil
+}
@@ -0,0 +1,106 @@
+package imapmsg
+
+import (
+	"strings"
+	"time"
+
+	"github.com/emersion/go-message/mail"
+)
+
+// An Address is an address in an envelope, as defined in RFC 3501 section
+// 7.4.2.
+//
+// Groups are represented by a start marker, whose Mailbox is the group name
+// and whose Host is empty, followed by the group's addresses and by an end
+// marker, whose fields are all empty.
+type Address struct {
+	Name    string
+	Mailbox string
+	Host    string
+}
+
+// IsGroupStart checks whether the address is a group start marker.
+func (addr *Address) IsGroupStart() bool {
+	return addr.Host == "" && addr.Mailbox != ""
+}
+
+// IsGroupEnd checks whether the address is a group end marker.
+func (addr *Address) IsGroupEnd() bool {
+	return addr.Host == "" && addr.Mailbox == ""
+}
+
+func newAddress(addr *mail.Address) *Address {
+	mailbox, host := addr.Address, ""
+	if i := strings.LastIndexByte(addr.Address, '@'); i >= 0 {
+		mailbox, host = addr.Address[:i], addr.Address[i+1:]
+	}
+	return &Address{Name: addr.Name, Mailbox: mailbox, Host: host}
+}
+
+// An Envelope is the envelope of a message, as defined in RFC 3501 section
+// 7.4.2.
+//
+// Strings are the raw header field values: RFC 2047 encoded-words are not
+// decoded. Header fields which can't be parsed are left empty.
+type Envelope struct {
+	Date    time.Time
+	Subject string
+	From    []*Address
+	Sender  []*Address
+	ReplyTo []*Address
+	To      []*Address
+	Cc      []*Address
+	Bcc     []*Address
+	// Message identifiers, without angle brackets
+	InReplyTo []string
+	MessageID string
+}
+
+func addressList(h *mail.Header, k string) []*Address {
+	// RFC 3501 section 7.4.2: display names are not decoded
+	groups, err := mail.ParseAddressGroupsWithOptions(h.Get(k), &mail.ParseAddressOptions{Raw: true})
+	if err != nil {
+		return nil
+	}
+
+	var l []*Address
+	for _, group := range groups {
+		if group.Name != "" {
+			l = append(l, &Address{Mailbox: group.Name})
+		}
+		for _, addr := range group.Addresses {
+			l = append(l, newAddress(addr))
+		}
+		if group.Name != "" {
+			l = append(l, &Address{})
+		}
+	}
+	return l
+}
+
+// NewEnvelope computes the envelope of a message from its header.
+//
+// As required by RFC 3501, if the Sender or Reply-To header fields are missing
+// or empty, they default to the From header field.
+func NewEnvelope(h mail.Header) *Envelope {
+	var env Envelope
+	env.Date, _ = h.Date()
+	env.Subject = h.Get("Subject")
+	env.From = addressList(&h, "From")
+	env.Sender = addressList(&h, "Sender")
+	env.ReplyTo = addressList(&h, "Reply-To")
+	env.To = addressList(&h, "To")
+	env.Cc = addressList(&h, "Cc")
+	env.Bcc = addressList(&h, "Bcc")
+	env.InReplyTo, _ = h.MsgIDList("In-Reply-To")
+	env.MessageID, _ = h.MessageID()
+
+	if len(env.Sender) == 0 {
+		env.Sender = env.From
+	}
+	if len(env.ReplyTo) == 0 {
+		env.ReplyTo = env.From
+	}
+
+	return &env
+}
@@ -0,0 +1,34 @@
+// Package imapmsg computes message data returned by IMAP servers: envelopes,
+// body structures and body sections.
+//
+// IMAP is defined in RFC 3501.
+package imapmsg
+
+import (
+	"bufio"
+	"io"
+
+	"github.com/emersion/go-message"
+	"github.com/emersion/go-message/mail"
+	"github.com/emersion/go-message/textproto"
+)
+
+func mailHeader(h textproto.Header) mail.Header {
+	return mail.Header{Header: message.Header{Header: h}}
+}
+
+// Read reads a raw message from r, and computes its envelope and its body
+// structure. Bodies are read as a stream, without being buffered.
+func Read(r io.Reader) (*Envelope, *BodyStructure, error) {
+	br := bufio.NewReader(r)
+	h, err := textproto.ReadHeader(br)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	bs, err := readBodyStructure(h, br, false, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	return NewEnvelope(mailHeader(h)), bs, nil
+}
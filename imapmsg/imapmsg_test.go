@@ -0,0 +1,302 @@
+package imapmsg
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testTextBody = "Who are you?\r\n" +
+	"I'm Taki.=\r\n" +
+	"\r\n"
+
+const testEncapsulatedBody = "Hello"
+
+const testEncapsulated = "From: Taki Tachibana <taki@example.org>\r\n" +
+	"Subject: Forwarded\r\n" +
+	"\r\n" +
+	testEncapsulatedBody
+
+const testImageBody = "iVBORw0KGgo="
+
+const testMessage = "From: Mitsuha Miyamizu <mitsuha@example.org>\r\n" +
+	"To: Taki Tachibana <taki@example.org>, Friends: tessie@example.org, sayaka@example.org;\r\n" +
+	"Subject: =?utf-8?q?Your_Name.?=\r\n" +
+	"Date: Wed, 23 Nov 2016 09:41:02 +0900\r\n" +
+	"Message-Id: <42@example.org>\r\n" +
+	"In-Reply-To: <41@example.org>\r\n" +
+	"Content-Type: multipart/mixed; boundary=outer\r\n" +
+	"Content-Language: en, ja\r\n" +
+	"\r\n" +
+	"This is a multi-part message in MIME format.\r\n" +
+	"--outer\r\n" +
+	"Content-Type: text/plain; charset=utf-8\r\n" +
+	"Content-Transfer-Encoding: Quoted-Printable\r\n" +
+	"\r\n" +
+	testTextBody +
+	"\r\n--outer\r\n" +
+	"Content-Type: message/rfc822\r\n" +
+	"Content-Disposition: attachment; filename=forwarded.eml\r\n" +
+	"Content-Description: =?utf-8?q?Forwarded_message?=\r\n" +
+	"\r\n" +
+	testEncapsulated +
+	"\r\n--outer\r\n" +
+	"Content-Type: image/png\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"Content-Id: <image@example.org>\r\n" +
+	"Content-Md5: Q2hlY2sgSW50ZWdyaXR5IQ==\r\n" +
+	"Content-Location: https://example.org/image.png\r\n" +
+	"\r\n" +
+	testImageBody +
+	"\r\n--outer--\r\n"
+
+func TestRead(t *testing.T) {
+	env, bs, err := Read(strings.NewReader(testMessage))
+	if err != nil {
+		t.Fatalf("Read() = %v", err)
+	}
+
+	mitsuha := []*Address{{Name: "Mitsuha Miyamizu", Mailbox: "mitsuha", Host: "example.org"}}
+	wantEnv := &Envelope{
+		Date:    time.Date(2016, time.November, 23, 9, 41, 2, 0, time.FixedZone("", 9*60*60)),
+		Subject: "=?utf-8?q?Your_Name.?=",
+		From:    mitsuha,
+		Sender:  mitsuha,
+		ReplyTo: mitsuha,
+		To: []*Address{
+			{Name: "Taki Tachibana", Mailbox: "taki", Host: "example.org"},
+			{Mailbox: "Friends"},
+			{Mailbox: "tessie", Host: "example.org"},
+			{Mailbox: "sayaka", Host: "example.org"},
+			{},
+		},
+		InReplyTo: []string{"41@example.org"},
+		MessageID: "42@example.org",
+	}
+	if !env.Date.Equal(wantEnv.Date) {
+		t.Errorf("Envelope.Date = %v, want %v", env.Date, wantEnv.Date)
+	}
+	env.Date = wantEnv.Date
+	if !reflect.DeepEqual(env, wantEnv) {
+		t.Errorf("Read() = envelope %+v, want %+v", env, wantEnv)
+	}
+	if !env.To[1].IsGroupStart() || !env.To[4].IsGroupEnd() || env.To[2].IsGroupStart() || env.To[2].IsGroupEnd() {
+		t.Errorf("Envelope.To doesn't contain the expected group markers")
+	}
+
+	wantBS := &BodyStructure{
+		MIMEType:    "multipart",
+		MIMESubType: "mixed",
+		Params:      map[string]string{"boundary": "outer"},
+		Encoding:    "7bit",
+		Size:        int64(len(testMessage) - strings.Index(testMessage, "\r\n\r\n") - 4),
+		Language:    []string{"en", "ja"},
+		Children: []*BodyStructure{
+			{
+				MIMEType:    "text",
+				MIMESubType: "plain",
+				Params:      map[string]string{"charset": "utf-8"},
+				Encoding:    "quoted-printable",
+				Size:        int64(len(testTextBody)),
+				Lines:       3,
+			},
+			{
+				MIMEType:    "message",
+				MIMESubType: "rfc822",
+				Params:      map[string]string{},
+				Description: "=?utf-8?q?Forwarded_message?=",
+				Encoding:    "7bit",
+				Size:        int64(len(testEncapsulated)),
+				Lines:       4,
+				Envelope: &Envelope{
+					Subject: "Forwarded",
+					From:    []*Address{{Name: "Taki Tachibana", Mailbox: "taki", Host: "example.org"}},
+					Sender:  []*Address{{Name: "Taki Tachibana", Mailbox: "taki", Host: "example.org"}},
+					ReplyTo: []*Address{{Name: "Taki Tachibana", Mailbox: "taki", Host: "example.org"}},
+				},
+				BodyStructure: &BodyStructure{
+					MIMEType:    "text",
+					MIMESubType: "plain",
+					Params:      map[string]string{"charset": "us-ascii"},
+					Encoding:    "7bit",
+					Size:        int64(len(testEncapsulatedBody)),
+					Lines:       1,
+				},
+				Disposition:       "attachment",
+				DispositionParams: map[string]string{"filename": "forwarded.eml"},
+			},
+			{
+				MIMEType:    "image",
+				MIMESubType: "png",
+				Params:      map[string]string{},
+				ID:          "<image@example.org>",
+				Encoding:    "base64",
+				Size:        int64(len(testImageBody)),
+				MD5:         "Q2hlY2sgSW50ZWdyaXR5IQ==",
+				Location:    "https://example.org/image.png",
+			},
+		},
+	}
+	if !reflect.DeepEqual(bs, wantBS) {
+		for i, child := range bs.Children {
+			if i < len(wantBS.Children) && !reflect.DeepEqual(child, wantBS.Children[i]) {
+				t.Errorf("Read(): part %v = %+v, want %+v", i+1, child, wantBS.Children[i])
+			}
+		}
+		t.Errorf("Read() = body structure %+v, want %+v", bs, wantBS)
+	}
+}
+
+func TestRead_digest(t *testing.T) {
+	const msg = "Content-Type: multipart/digest; boundary=digest\r\n" +
+		"\r\n" +
+		"--digest\r\n" +
+		"\r\n" +
+		"Subject: First\r\n" +
+		"\r\n" +
+		"One\r\n" +
+		"--digest\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n" +
+		"Two\r\n" +
+		"--digest--\r\n"
+
+	_, bs, err := Read(strings.NewReader(msg))
+	if err != nil {
+		t.Fatalf("Read() = %v", err)
+	}
+	if len(bs.Children) != 2 {
+		t.Fatalf("Read(): got %v parts, want 2", len(bs.Children))
+	}
+
+	first := bs.Children[0]
+	if first.MIMEType != "message" || first.MIMESubType != "rfc822" {
+		t.Errorf("Read(): part 1 has type %v/%v, want message/rfc822", first.MIMEType, first.MIMESubType)
+	}
+	if first.Envelope == nil || first.Envelope.Subject != "First" {
+		t.Errorf("Read(): part 1 has envelope %+v, want subject %q", first.Envelope, "First")
+	}
+	if second := bs.Children[1]; second.MIMEType != "text" || second.Lines != 1 {
+		t.Errorf("Read(): part 2 has type %v with %v lines, want text with 1 line", second.MIMEType, second.Lines)
+	}
+}
+
+func TestRead_singlePart(t *testing.T) {
+	const body = "Who are you?\nI'm Mitsuha.\n"
+	msg := "From: mitsuha@example.org\n" +
+		"Sender: taki@example.org\n" +
+		"Reply-To: invalid\n" +
+		"Content-Type: text/plain; charset=\n" +
+		"\n" +
+		body
+
+	env, bs, err := Read(strings.NewReader(msg))
+	if err != nil {
+		t.Fatalf("Read() = %v", err)
+	}
+
+	if len(env.Sender) != 1 || env.Sender[0].Mailbox != "taki" {
+		t.Errorf("Envelope.Sender = %+v, want taki@example.org", env.Sender)
+	}
+	if !reflect.DeepEqual(env.ReplyTo, env.From) {
+		t.Errorf("Envelope.ReplyTo = %+v, want %+v", env.ReplyTo, env.From)
+	}
+
+	if bs.MIMEType != "text" || bs.MIMESubType != "plain" {
+		t.Errorf("BodyStructure has type %v/%v, want text/plain", bs.MIMEType, bs.MIMESubType)
+	}
+	if bs.Size != int64(len(body)) || bs.Lines != 2 {
+		t.Errorf("BodyStructure has size %v and %v lines, want %v and 2", bs.Size, bs.Lines, len(body))
+	}
+}
+
+func TestRead_encodedWords(t *testing.T) {
+	msg := "From: =?utf-8?q?Mitsuha_Miyamizu?= <mitsuha@example.org>\r\n" +
+		"To: =?utf-8?q?Friends?=: taki@example.org (=?utf-8?q?Taki?=);\r\n" +
+		"\r\n"
+
+	env, _, err := Read(strings.NewReader(msg))
+	if err != nil {
+		t.Fatalf("Read() = %v", err)
+	}
+
+	wantFrom := []*Address{{Name: "=?utf-8?q?Mitsuha_Miyamizu?=", Mailbox: "mitsuha", Host: "example.org"}}
+	if !reflect.DeepEqual(env.From, wantFrom) {
+		t.Errorf("Envelope.From = %+v, want %+v", env.From, wantFrom)
+	}
+	wantTo := []*Address{
+		{Mailbox: "=?utf-8?q?Friends?="},
+		{Name: "=?utf-8?q?Taki?=", Mailbox: "taki", Host: "example.org"},
+		{},
+	}
+	if !reflect.DeepEqual(env.To, wantTo) {
+		t.Errorf("Envelope.To = %+v, want %+v", env.To, wantTo)
+	}
+}
+
+func TestRead_missingCloseDelimiter(t *testing.T) {
+	const msg = "Content-Type: multipart/mixed; boundary=outer\r\n" +
+		"\r\n" +
+		"--outer\r\n" +
+		"\r\n" +
+		"One\r\n" +
+		"--outer\r\n" +
+		"Content-Type: multipart/alternative; boundary=inner\r\n" +
+		"\r\n" +
+		"--inner\r\n" +
+		"\r\n" +
+		"Two\r\n"
+
+	_, bs, err := Read(strings.NewReader(msg))
+	if err != nil {
+		t.Fatalf("Read() = %v", err)
+	}
+	if len(bs.Children) != 2 {
+		t.Fatalf("Read(): got %v parts, want 2", len(bs.Children))
+	}
+	if first := bs.Children[0]; first.Size != int64(len("One")) {
+		t.Errorf("Read(): part 1 has size %v, want %v", first.Size, len("One"))
+	}
+	second := bs.Children[1]
+	if len(second.Children) != 1 {
+		t.Fatalf("Read(): part 2 has %v parts, want 1", len(second.Children))
+	}
+	// The final line ending may be the beginning of a delimiter, it isn't
+	// part of the body
+	if inner := second.Children[0]; inner.Size != int64(len("Two")) || inner.Lines != 1 {
+		t.Errorf("Read(): part 2.1 has size %v and %v lines, want %v and 1", inner.Size, inner.Lines, len("Two"))
+	}
+}
+
+func TestRead_invalidEncapsulated(t *testing.T) {
+	const encapsulated = "This isn't a header field\r\n" +
+		"\r\n" +
+		"Hello\r\n"
+	const msg = "Content-Type: multipart/mixed; boundary=outer\r\n" +
+		"\r\n" +
+		"--outer\r\n" +
+		"Content-Type: message/rfc822\r\n" +
+		"\r\n" +
+		encapsulated +
+		"\r\n--outer--\r\n"
+
+	_, bs, err := Read(strings.NewReader(msg))
+	if err != nil {
+		t.Fatalf("Read() = %v", err)
+	}
+	if len(bs.Children) != 1 {
+		t.Fatalf("Read(): got %v parts, want 1", len(bs.Children))
+	}
+
+	part := bs.Children[0]
+	if part.MIMEType != "message" || part.MIMESubType != "rfc822" {
+		t.Errorf("Read(): part 1 has type %v/%v, want message/rfc822", part.MIMEType, part.MIMESubType)
+	}
+	if part.Envelope != nil || part.BodyStructure != nil {
+		t.Errorf("Read(): part 1 has an encapsulated message, want an opaque part")
+	}
+	if part.Size != int64(len(encapsulated)) || part.Lines != 3 {
+		t.Errorf("Read(): part 1 has size %v and %v lines, want %v and 3", part.Size, part.Lines, len(encapsulated))
+	}
+}
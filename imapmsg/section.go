@@ -0,0 +1,226 @@
+package imapmsg
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/emersion/go-message/textproto"
+)
+
+// ErrNoSuchPart is returned by WriteSection if the requested part doesn't
+// exist in the message.
+var ErrNoSuchPart = errors.New("imapmsg: no such part")
+
+// PartSpecifier is the part specifier of a section, as defined in RFC 3501
+// section 6.4.5.
+type PartSpecifier string
+
+const (
+	// PartSpecifierNone selects the whole message, or the body of a part
+	PartSpecifierNone PartSpecifier = ""
+	// PartSpecifierHeader selects the header of a message, or of an
+	// encapsulated message/rfc822 part
+	PartSpecifierHeader PartSpecifier = "HEADER"
+	// PartSpecifierMIME selects the MIME header of a part
+	PartSpecifierMIME PartSpecifier = "MIME"
+	// PartSpecifierText selects the body of a message, or of an encapsulated
+	// message/rfc822 part
+	PartSpecifierText PartSpecifier = "TEXT"
+)
+
+// SectionPartial is a byte range of a section.
+type SectionPartial struct {
+	Offset int64
+	Size   int64
+}
+
+// Section is a message section, as requested by an IMAP FETCH BODY[] item.
+type Section struct {
+	// Part is the part path, with 1-based part numbers. It's empty for the
+	// whole message.
+	Part []int
+	// Specifier is the part specifier.
+	Specifier PartSpecifier
+	// HeaderFields restricts PartSpecifierHeader to the specified header
+	// fields (HEADER.FIELDS). If NotHeaderFields is true, the specified
+	// header fields are excluded instead (HEADER.FIELDS.NOT).
+	HeaderFields    []string
+	NotHeaderFields bool
+	// Partial restricts the section to a byte range, if not nil.
+	Partial *SectionPartial
+}
+
+func (section *Section) selectsMessage() bool {
+	return section.Specifier == PartSpecifierHeader || section.Specifier == PartSpecifierText
+}
+
+// errPartialDone is returned by partialWriter once the requested range has
+// been written.
+var errPartialDone = errors.New("imapmsg: partial section written")
+
+// partialWriter only writes a byte range of its input.
+type partialWriter struct {
+	w      io.Writer
+	offset int64
+	size   int64
+}
+
+func (pw *partialWriter) Write(b []byte) (int, error) {
+	n := len(b)
+	if pw.offset >= int64(len(b)) {
+		pw.offset -= int64(len(b))
+		return n, nil
+	}
+	b = b[pw.offset:]
+	pw.offset = 0
+
+	done := false
+	if int64(len(b)) >= pw.size {
+		b = b[:pw.size]
+		done = true
+	}
+	if _, err := pw.w.Write(b); err != nil {
+		return 0, err
+	}
+	pw.size -= int64(len(b))
+	if done {
+		return n, errPartialDone
+	}
+	return n, nil
+}
+
+// writeHeaderFields writes a subset of a header, including the final blank
+// line. Raw header fields read by textproto.ReadHeader always end with CRLF.
+func writeHeaderFields(w io.Writer, h textproto.Header, keys []string, not bool) error {
+	m := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		m[strings.ToLower(k)] = true
+	}
+
+	fields := h.Fields()
+	for fields.Next() {
+		if m[strings.ToLower(fields.Key())] == not {
+			continue
+		}
+		raw, err := fields.Raw()
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(raw); err != nil {
+			return err
+		}
+	}
+
+	_, err := io.WriteString(w, "\r\n")
+	return err
+}
+
+// WriteSection reads a raw message from r, and writes the requested section
+// to w, as an IMAP server would return it in a FETCH BODY[] response. The
+// message is read as a stream, without being buffered, and reading stops once
+// the section has been written.
+//
+// Part numbers follow RFC 3501 section 6.4.5: a non-multipart message only
+// has a part 1, and the parts of a message/rfc822 part are numbered like the
+// parts of its encapsulated message. The whole message and body sections are
+// written as is. Header sections are written with CRLF line endings, since
+// textproto.ReadHeader normalizes the line endings of the fields it reads.
+//
+// If the requested part doesn't exist, ErrNoSuchPart is returned.
+func WriteSection(w io.Writer, r io.Reader, section *Section) error {
+	if section.Partial != nil {
+		if section.Partial.Size <= 0 {
+			return nil
+		}
+		w = &partialWriter{w: w, offset: section.Partial.Offset, size: section.Partial.Size}
+	}
+
+	err := writeSection(w, r, section)
+	if err == errPartialDone {
+		err = nil
+	}
+	return err
+}
+
+func writeSection(w io.Writer, r io.Reader, section *Section) error {
+	if len(section.Part) == 0 {
+		switch section.Specifier {
+		case PartSpecifierNone:
+			// The whole message is returned as is
+			_, err := io.Copy(w, r)
+			return err
+		case PartSpecifierMIME:
+			return ErrNoSuchPart
+		}
+	}
+
+	br := bufio.NewReader(r)
+	h, err := textproto.ReadHeader(br)
+	if err != nil {
+		return err
+	}
+	var body io.Reader = br
+
+	// h and body are the current part, inDigest is true if its parent is a
+	// multipart/digest part
+	inDigest := false
+	for i, n := range section.Part {
+		bs := newBodyStructure(h, inDigest)
+		if bs.IsMultipart() {
+			mr := newMultipartReader(body, bs.Params["boundary"])
+			var p io.Reader
+			for j := 0; j < n; j++ {
+				h, p, err = mr.NextPart()
+				if err == io.EOF {
+					return ErrNoSuchPart
+				} else if err != nil {
+					return err
+				}
+			}
+			if p == nil {
+				return ErrNoSuchPart
+			}
+			body = p
+			inDigest = bs.MIMESubType == "digest"
+		} else if n != 1 {
+			return ErrNoSuchPart
+		}
+
+		// Descend into encapsulated messages, if more part numbers follow or
+		// if the message header or body is requested
+		if i < len(section.Part)-1 || section.selectsMessage() {
+			bs = newBodyStructure(h, inDigest)
+			if !bs.isMessage() || !isIdentityEncoding(bs.Encoding) {
+				return ErrNoSuchPart
+			}
+			// Encapsulated messages which can't be parsed are opaque parts
+			br := bufio.NewReader(body)
+			h, err = textproto.ReadHeader(br)
+			if err != nil {
+				return ErrNoSuchPart
+			}
+			body = br
+			inDigest = false
+		}
+	}
+
+	switch section.Specifier {
+	case PartSpecifierNone:
+		_, err = io.Copy(w, body)
+	case PartSpecifierHeader:
+		if len(section.HeaderFields) > 0 || section.NotHeaderFields {
+			err = writeHeaderFields(w, h, section.HeaderFields, section.NotHeaderFields)
+		} else {
+			err = textproto.WriteHeader(w, h)
+		}
+	case PartSpecifierMIME:
+		err = textproto.WriteHeader(w, h)
+	case PartSpecifierText:
+		_, err = io.Copy(w, body)
+	default:
+		return errors.New("imapmsg: unknown part specifier")
+	}
+	return err
+}
@@ -0,0 +1,248 @@
+package imapmsg
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+const testMessageHeader = "From: Mitsuha Miyamizu <mitsuha@example.org>\r\n" +
+	"To: Taki Tachibana <taki@example.org>, Friends: tessie@example.org, sayaka@example.org;\r\n" +
+	"Subject: =?utf-8?q?Your_Name.?=\r\n" +
+	"Date: Wed, 23 Nov 2016 09:41:02 +0900\r\n" +
+	"Message-Id: <42@example.org>\r\n" +
+	"In-Reply-To: <41@example.org>\r\n" +
+	"Content-Type: multipart/mixed; boundary=outer\r\n" +
+	"Content-Language: en, ja\r\n" +
+	"\r\n"
+
+const testEncapsulatedHeader = "From: Taki Tachibana <taki@example.org>\r\n" +
+	"Subject: Forwarded\r\n" +
+	"\r\n"
+
+var sectionTests = []struct {
+	name    string
+	section Section
+	want    string
+}{
+	{
+		name:    "whole message",
+		section: Section{},
+		want:    testMessage,
+	},
+	{
+		name:    "header",
+		section: Section{Specifier: PartSpecifierHeader},
+		want:    testMessageHeader,
+	},
+	{
+		name:    "text",
+		section: Section{Specifier: PartSpecifierText},
+		want:    strings.TrimPrefix(testMessage, testMessageHeader),
+	},
+	{
+		name: "header fields",
+		section: Section{
+			Specifier:    PartSpecifierHeader,
+			HeaderFields: []string{"SUBJECT", "from", "X-Missing"},
+		},
+		want: "From: Mitsuha Miyamizu <mitsuha@example.org>\r\n" +
+			"Subject: =?utf-8?q?Your_Name.?=\r\n" +
+			"\r\n",
+	},
+	{
+		name: "header fields not",
+		section: Section{
+			Specifier:       PartSpecifierHeader,
+			HeaderFields:    []string{"To", "Message-Id", "In-Reply-To", "Content-Type", "Content-Language"},
+			NotHeaderFields: true,
+		},
+		want: "From: Mitsuha Miyamizu <mitsuha@example.org>\r\n" +
+			"Subject: =?utf-8?q?Your_Name.?=\r\n" +
+			"Date: Wed, 23 Nov 2016 09:41:02 +0900\r\n" +
+			"\r\n",
+	},
+	{
+		name:    "text part",
+		section: Section{Part: []int{1}},
+		want:    testTextBody,
+	},
+	{
+		name:    "text part MIME header",
+		section: Section{Part: []int{1}, Specifier: PartSpecifierMIME},
+		want: "Content-Type: text/plain; charset=utf-8\r\n" +
+			"Content-Transfer-Encoding: Quoted-Printable\r\n" +
+			"\r\n",
+	},
+	{
+		name:    "message part",
+		section: Section{Part: []int{2}},
+		want:    testEncapsulated,
+	},
+	{
+		name:    "message part header",
+		section: Section{Part: []int{2}, Specifier: PartSpecifierHeader},
+		want:    testEncapsulatedHeader,
+	},
+	{
+		name:    "message part text",
+		section: Section{Part: []int{2}, Specifier: PartSpecifierText},
+		want:    testEncapsulatedBody,
+	},
+	{
+		name:    "encapsulated part",
+		section: Section{Part: []int{2, 1}},
+		want:    testEncapsulatedBody,
+	},
+	{
+		name:    "encapsulated part MIME header",
+		section: Section{Part: []int{2, 1}, Specifier: PartSpecifierMIME},
+		want:    testEncapsulatedHeader,
+	},
+	{
+		name:    "image part",
+		section: Section{Part: []int{3}},
+		want:    testImageBody,
+	},
+	{
+		name:    "partial",
+		section: Section{Partial: &SectionPartial{Offset: 0, Size: 4}},
+		want:    "From",
+	},
+	{
+		name:    "partial part",
+		section: Section{Part: []int{3}, Partial: &SectionPartial{Offset: 4, Size: 1024}},
+		want:    testImageBody[4:],
+	},
+	{
+		name:    "partial out of range",
+		section: Section{Part: []int{3}, Partial: &SectionPartial{Offset: 1024, Size: 1024}},
+		want:    "",
+	},
+}
+
+func TestWriteSection(t *testing.T) {
+	for _, tc := range sectionTests {
+		t.Run(tc.name, func(t *testing.T) {
+			var b bytes.Buffer
+			if err := WriteSection(&b, strings.NewReader(testMessage), &tc.section); err != nil {
+				t.Fatalf("WriteSection() = %v", err)
+			}
+			if s := b.String(); s != tc.want {
+				t.Errorf("WriteSection() = %q, want %q", s, tc.want)
+			}
+		})
+	}
+}
+
+func TestWriteSection_singlePart(t *testing.T) {
+	const msg = "Subject: Your Name.\r\n" +
+		"\r\n" +
+		"Who are you?\r\n"
+
+	var b bytes.Buffer
+	if err := WriteSection(&b, strings.NewReader(msg), &Section{Part: []int{1}}); err != nil {
+		t.Fatalf("WriteSection() = %v", err)
+	}
+	if s := b.String(); s != "Who are you?\r\n" {
+		t.Errorf("WriteSection() = %q, want %q", s, "Who are you?\r\n")
+	}
+}
+
+func TestWriteSection_noSuchPart(t *testing.T) {
+	sections := map[string]*Section{
+		"out of range":         {Part: []int{4}},
+		"zero":                 {Part: []int{0}},
+		"leaf child":           {Part: []int{1, 1}},
+		"leaf header":          {Part: []int{3}, Specifier: PartSpecifierHeader},
+		"message MIME header":  {Specifier: PartSpecifierMIME},
+		"encapsulated missing": {Part: []int{2, 2}},
+	}
+	for name, section := range sections {
+		t.Run(name, func(t *testing.T) {
+			var b bytes.Buffer
+			if err := WriteSection(&b, strings.NewReader(testMessage), section); err != ErrNoSuchPart {
+				t.Errorf("WriteSection() = %v, want ErrNoSuchPart", err)
+			}
+		})
+	}
+}
+
+func TestWriteSection_missingCloseDelimiter(t *testing.T) {
+	const msg = "Content-Type: multipart/mixed; boundary=outer\r\n" +
+		"\r\n" +
+		"--outer\r\n" +
+		"\r\n" +
+		"One\r\n" +
+		"--outer\r\n" +
+		"\r\n" +
+		"Two"
+
+	var b bytes.Buffer
+	if err := WriteSection(&b, strings.NewReader(msg), &Section{Part: []int{2}}); err != nil {
+		t.Fatalf("WriteSection() = %v", err)
+	}
+	if s := b.String(); s != "Two" {
+		t.Errorf("WriteSection() = %q, want %q", s, "Two")
+	}
+
+	if err := WriteSection(&b, strings.NewReader(msg), &Section{Part: []int{3}}); err != ErrNoSuchPart {
+		t.Errorf("WriteSection() = %v, want ErrNoSuchPart", err)
+	}
+}
+
+func TestWriteSection_wholeMessageLF(t *testing.T) {
+	const msg = "Subject: Your Name.\n" +
+		"To: Taki Tachibana\n" +
+		" <taki@example.org>\n" +
+		"\n" +
+		"Who are you?\n"
+
+	var b bytes.Buffer
+	if err := WriteSection(&b, strings.NewReader(msg), &Section{}); err != nil {
+		t.Fatalf("WriteSection() = %v", err)
+	}
+	if s := b.String(); s != msg {
+		t.Errorf("WriteSection() = %q, want %q", s, msg)
+	}
+}
+
+func TestWriteSection_headerLF(t *testing.T) {
+	const msg = "Subject: Your Name.\n" +
+		"To: Taki Tachibana\n" +
+		" <taki@example.org>\n" +
+		"\n" +
+		"Who are you?\n"
+
+	for _, tc := range []struct {
+		name    string
+		section Section
+		want    string
+	}{
+		{
+			name:    "header",
+			section: Section{Specifier: PartSpecifierHeader},
+			want: "Subject: Your Name.\r\n" +
+				"To: Taki Tachibana\r\n" +
+				" <taki@example.org>\r\n" +
+				"\r\n",
+		},
+		{
+			name:    "header fields",
+			section: Section{Specifier: PartSpecifierHeader, HeaderFields: []string{"To"}},
+			want: "To: Taki Tachibana\r\n" +
+				" <taki@example.org>\r\n" +
+				"\r\n",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var b bytes.Buffer
+			if err := WriteSection(&b, strings.NewReader(msg), &tc.section); err != nil {
+				t.Fatalf("WriteSection() = %v", err)
+			}
+			if s := b.String(); s != tc.want {
+				t.Errorf("WriteSection() = %q, want %q", s, tc.want)
+			}
+		})
+	}
+}
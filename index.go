@@ -0,0 +1,210 @@
+package message
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+
+	"github.com/emersion/go-message/textproto"
+)
+
+// An Index records the location of an entity in a message, and of its parts if
+// it's a multipart entity. It allows opening any part of a message without
+// reading the rest of the message.
+//
+// Offsets are relative to the beginning of the message. An Index only
+// contains exported fields, so it can be serialized, e.g. with encoding/json,
+// and stored alongside the message.
+type Index struct {
+	// The offset of the entity's header.
+	HeaderOffset int64
+	// The offset of the entity's body, after the blank line ending the
+	// header.
+	BodyOffset int64
+	// The end offset of the entity's body.
+	End int64
+	// The indexes of the parts of a multipart entity.
+	Parts []*Index
+}
+
+// indexFrame is an entity being indexed.
+type indexFrame struct {
+	idx      *Index
+	inHeader bool
+	header   []byte
+	// boundary is set while the parts of a multipart entity are being read
+	boundary string
+}
+
+func (f *indexFrame) readHeaderLine(l []byte, offset int64, blank bool) error {
+	f.header = append(f.header, l...)
+	if len(f.header) > defaultMaxHeaderBytes {
+		return errHeaderTooBig
+	}
+	if !blank {
+		return nil
+	}
+
+	f.inHeader = false
+	f.idx.BodyOffset = offset + int64(len(l))
+
+	h, err := textproto.ReadHeader(bufio.NewReader(bytes.NewReader(f.header)))
+	f.header = nil
+	if err != nil {
+		return err
+	}
+
+	mh := Header{h}
+	mediaType, params, _ := mh.ContentType()
+	if strings.HasPrefix(mediaType, "multipart/") {
+		f.boundary = params["boundary"]
+	}
+	return nil
+}
+
+func (f *indexFrame) close(end int64) {
+	if f.inHeader {
+		f.inHeader = false
+		f.idx.BodyOffset = f.idx.HeaderOffset
+		if end > f.idx.BodyOffset {
+			f.idx.BodyOffset = end
+		}
+	}
+	f.idx.End = f.idx.BodyOffset
+	if end > f.idx.End {
+		f.idx.End = end
+	}
+}
+
+// matchBoundary checks whether a line is a boundary delimiter line. close is
+// true for the close delimiter line.
+func matchBoundary(l []byte, boundary string) (ok, final bool) {
+	l = bytes.TrimRight(l, " \t\r\n")
+	if !bytes.HasPrefix(l, []byte("--")) || !bytes.HasPrefix(l[2:], []byte(boundary)) {
+		return false, false
+	}
+	switch string(l[2+len(boundary):]) {
+	case "":
+		return true, false
+	case "--":
+		return true, true
+	default:
+		return false, false
+	}
+}
+
+func lineEndingLen(l []byte) int64 {
+	switch {
+	case bytes.HasSuffix(l, []byte("\r\n")):
+		return 2
+	case bytes.HasSuffix(l, []byte("\n")):
+		return 1
+	default:
+		return 0
+	}
+}
+
+// NewIndex reads a message from r and builds its index. The message is read
+// once, from the beginning to the end.
+func NewIndex(r io.ReaderAt, size int64) (*Index, error) {
+	br := bufio.NewReader(io.NewSectionReader(r, 0, size))
+
+	root := &Index{}
+	stack := []*indexFrame{{idx: root, inHeader: true}}
+
+	var offset, prevLineEndingLen int64
+	bol := true // at the beginning of a line
+	for {
+		l, err := br.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			err = nil
+		} else if err == io.EOF && len(l) > 0 {
+			err = nil
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		// Delimiter lines, including the line ending preceding them, end the
+		// current part of a multipart entity
+		handled := false
+		if bol && (l[len(l)-1] == '\n' || offset+int64(len(l)) == size) {
+			for i := len(stack) - 1; i >= 0; i-- {
+				f := stack[i]
+				if f.boundary == "" {
+					continue
+				}
+				ok, final := matchBoundary(l, f.boundary)
+				if !ok {
+					continue
+				}
+
+				end := offset - prevLineEndingLen
+				for _, child := range stack[i+1:] {
+					child.close(end)
+				}
+				stack = stack[:i+1]
+
+				if final {
+					f.boundary = ""
+				} else {
+					part := &Index{HeaderOffset: offset + int64(len(l))}
+					f.idx.Parts = append(f.idx.Parts, part)
+					stack = append(stack, &indexFrame{idx: part, inHeader: true})
+				}
+				handled = true
+				break
+			}
+		}
+
+		if f := stack[len(stack)-1]; !handled && f.inHeader {
+			blank := bol && (string(l) == "\n" || string(l) == "\r\n")
+			if err := f.readHeaderLine(l, offset, blank); err != nil {
+				return nil, err
+			}
+		}
+
+		offset += int64(len(l))
+		prevLineEndingLen = lineEndingLen(l)
+		bol = l[len(l)-1] == '\n'
+	}
+
+	for _, f := range stack {
+		f.close(offset)
+	}
+	return root, nil
+}
+
+// Part returns the index of a part of the entity. The path has the same
+// format as the one passed to WalkFunc. It returns nil if the part doesn't
+// exist.
+func (idx *Index) Part(path []int) *Index {
+	for _, i := range path {
+		if i < 0 || i >= len(idx.Parts) {
+			return nil
+		}
+		idx = idx.Parts[i]
+	}
+	return idx
+}
+
+// Open opens the entity. Only the entity's header is read: its body is read
+// from r when the returned Entity's body is read. r must contain the message
+// passed to NewIndex.
+//
+// If the entity uses an unknown transfer encoding or charset, Open returns an
+// error that verifies IsUnknownCharset or IsUnknownEncoding, but also returns
+// an Entity that can be read.
+func (idx *Index) Open(r io.ReaderAt) (*Entity, error) {
+	hr := io.NewSectionReader(r, idx.HeaderOffset, idx.BodyOffset-idx.HeaderOffset)
+	h, err := textproto.ReadHeader(bufio.NewReader(hr))
+	if err != nil {
+		return nil, err
+	}
+
+	body := io.NewSectionReader(r, idx.BodyOffset, idx.End-idx.BodyOffset)
+	return New(Header{h}, body)
+}
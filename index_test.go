@@ -0,0 +1,171 @@
+package message
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testIndexMessage = "Subject: Your Name.\r\n" +
+	"Content-Type: multipart/mixed; boundary=outer\r\n" +
+	"\r\n" +
+	"This is a multi-part message in MIME format.\r\n" +
+	"--outer\r\n" +
+	"Content-Type: multipart/alternative; boundary=\"inner\"\r\n" +
+	"\r\n" +
+	"--inner\r\n" +
+	"Content-Type: text/plain\r\n" +
+	"\r\n" +
+	"Who are you?\r\n" +
+	"--inner  \r\n" +
+	"Content-Type: text/html\r\n" +
+	"\r\n" +
+	"<p>Who are you?</p>\r\n" +
+	"--inner--\r\n" +
+	"\r\n" +
+	"--outer\r\n" +
+	"Content-Type: text/plain; charset=utf-8\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"Content-Disposition: attachment; filename=note.txt\r\n" +
+	"\r\n" +
+	"SSdtIFRha2ku\r\n" +
+	"--outer\r\n" +
+	"\r\n" +
+	"--outer--\r\n" +
+	"Epilogue\r\n"
+
+// walkBodies returns the headers and bodies of the leaf parts of a message,
+// indexed by their path.
+func walkBodies(t *testing.T, e *Entity) map[string]string {
+	m := make(map[string]string)
+	err := e.Walk(func(path []int, part *Entity, err error) error {
+		if err != nil {
+			return err
+		}
+		if part.MultipartReader() != nil {
+			return nil
+		}
+		b, err := ioutil.ReadAll(part.Body)
+		if err != nil {
+			return err
+		}
+		mediaType, _, _ := part.Header.ContentType()
+		m[fmt.Sprint(path)] = mediaType + "\n" + string(b)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Walk() = %v", err)
+	}
+	return m
+}
+
+func TestIndex(t *testing.T) {
+	for name, msg := range map[string]string{
+		"crlf": testIndexMessage,
+		"lf":   strings.Replace(testIndexMessage, "\r\n", "\n", -1),
+	} {
+		t.Run(name, func(t *testing.T) {
+			r := strings.NewReader(msg)
+			idx, err := NewIndex(r, r.Size())
+			if err != nil {
+				t.Fatalf("NewIndex() = %v", err)
+			}
+
+			if len(idx.Parts) != 3 || len(idx.Parts[0].Parts) != 2 {
+				t.Fatalf("NewIndex() = %+v, want 3 parts with 2 parts in the first one", idx)
+			}
+			if idx.End != r.Size() {
+				t.Errorf("Index.End = %v, want %v", idx.End, r.Size())
+			}
+
+			e, err := Read(strings.NewReader(msg))
+			if err != nil {
+				t.Fatalf("Read() = %v", err)
+			}
+			want := walkBodies(t, e)
+			if len(want) != 4 {
+				t.Fatalf("Walk() visited %v leaf parts, want 4", len(want))
+			}
+
+			got := make(map[string]string)
+			for _, path := range [][]int{{0, 0}, {0, 1}, {1}, {2}} {
+				part := idx.Part(path)
+				if part == nil {
+					t.Fatalf("Index.Part(%v) = nil", path)
+				}
+				e, err := part.Open(r)
+				if err != nil {
+					t.Fatalf("Index.Open() = %v", err)
+				}
+				for k, v := range walkBodies(t, e) {
+					if k != "[]" {
+						t.Errorf("part %v isn't a leaf", path)
+					}
+					got[fmt.Sprint(path)] = v
+				}
+			}
+
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("Index parts = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestIndex_Part(t *testing.T) {
+	r := strings.NewReader(testIndexMessage)
+	idx, err := NewIndex(r, r.Size())
+	if err != nil {
+		t.Fatalf("NewIndex() = %v", err)
+	}
+
+	if part := idx.Part(nil); part != idx {
+		t.Errorf("Index.Part(nil) = %p, want the root index %p", part, idx)
+	}
+	for _, path := range [][]int{{3}, {-1}, {1, 0}, {0, 2}} {
+		if part := idx.Part(path); part != nil {
+			t.Errorf("Index.Part(%v) = %+v, want nil", path, part)
+		}
+	}
+}
+
+func TestIndex_singlePart(t *testing.T) {
+	r := strings.NewReader(testSingleText)
+	idx, err := NewIndex(r, r.Size())
+	if err != nil {
+		t.Fatalf("NewIndex() = %v", err)
+	}
+
+	want := &Index{BodyOffset: int64(strings.Index(testSingleText, "Message body")), End: r.Size()}
+	if !reflect.DeepEqual(idx, want) {
+		t.Errorf("NewIndex() = %+v, want %+v", idx, want)
+	}
+}
+
+func TestIndex_json(t *testing.T) {
+	r := strings.NewReader(testIndexMessage)
+	idx, err := NewIndex(r, r.Size())
+	if err != nil {
+		t.Fatalf("NewIndex() = %v", err)
+	}
+
+	b, err := json.Marshal(idx)
+	if err != nil {
+		t.Fatalf("json.Marshal() = %v", err)
+	}
+	var decoded Index
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		t.Fatalf("json.Unmarshal() = %v", err)
+	}
+
+	e, err := decoded.Part([]int{1}).Open(r)
+	if err != nil {
+		t.Fatalf("Index.Open() = %v", err)
+	}
+	if b, err := ioutil.ReadAll(e.Body); err != nil || string(b) != "I'm Taki." {
+		t.Errorf("attachment body = %q (%v), want %q", string(b), err, "I'm Taki.")
+	}
+}
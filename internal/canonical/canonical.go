@@ -0,0 +1,115 @@
+// Package canonical implements helpers to handle the exact representation of
+// MIME entities on the wire, as needed to sign and verify them.
+package canonical
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Writer converts bare LF line endings to CRLF, as required for canonical
+// MIME entities (RFC 2046 section 4.1.1).
+type Writer struct {
+	w  io.Writer
+	cr bool
+}
+
+// NewWriter creates a Writer writing to w.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+// Write implements io.Writer.
+func (w *Writer) Write(b []byte) (int, error) {
+	n := len(b)
+	for len(b) > 0 {
+		i := bytes.IndexByte(b, '\n')
+		if i < 0 {
+			if _, err := w.w.Write(b); err != nil {
+				return 0, err
+			}
+			w.cr = b[len(b)-1] == '\r'
+			break
+		}
+
+		cr := w.cr
+		if i > 0 {
+			cr = b[i-1] == '\r'
+		}
+		chunk := b[:i+1]
+		if !cr {
+			chunk = append(append(make([]byte, 0, i+2), b[:i]...), '\r', '\n')
+		}
+		if _, err := w.w.Write(chunk); err != nil {
+			return 0, err
+		}
+		w.cr = false
+		b = b[i+1:]
+	}
+	return n, nil
+}
+
+// Bytes converts bare LF line endings in b to CRLF.
+func Bytes(b []byte) []byte {
+	var buf bytes.Buffer
+	buf.Grow(len(b))
+	NewWriter(&buf).Write(b)
+	return buf.Bytes()
+}
+
+// RandomBoundary generates a random multipart boundary.
+func RandomBoundary() (string, error) {
+	var buf [30]byte
+	if _, err := io.ReadFull(rand.Reader, buf[:]); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", buf[:]), nil
+}
+
+// SplitMultipart splits the raw body of a multipart entity with CRLF line
+// endings into its parts, as defined in RFC 2046 section 5.1.1. The CRLF
+// preceding a delimiter line isn't part of the parts. The preamble and the
+// epilogue are discarded.
+func SplitMultipart(b []byte, boundary string) ([][]byte, error) {
+	delim := []byte("--" + boundary)
+
+	var parts [][]byte
+	start := -1
+	for off := 0; off < len(b); {
+		line := b[off:]
+		next := len(b)
+		if i := bytes.Index(line, []byte("\r\n")); i >= 0 {
+			line = line[:i]
+			next = off + i + 2
+		}
+
+		if bytes.HasPrefix(line, delim) {
+			rest := line[len(delim):]
+			closing := bytes.HasPrefix(rest, []byte("--"))
+			if closing {
+				rest = rest[2:]
+			}
+			// Transport padding is allowed after the boundary
+			if len(bytes.TrimRight(rest, " \t")) == 0 {
+				if start >= 0 {
+					end := off - 2
+					if end < start {
+						end = start
+					}
+					parts = append(parts, b[start:end])
+				}
+				if closing {
+					return parts, nil
+				}
+				start = next
+			}
+		}
+
+		off = next
+	}
+
+	return nil, errors.New("missing multipart close delimiter")
+}
@@ -0,0 +1,53 @@
+package canonical
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWriter(t *testing.T) {
+	chunks := []string{"a\nb\r", "\nc\r\n", "\n", "d"}
+	want := "a\r\nb\r\nc\r\n\r\nd"
+
+	var b bytes.Buffer
+	w := NewWriter(&b)
+	for _, chunk := range chunks {
+		if _, err := w.Write([]byte(chunk)); err != nil {
+			t.Fatalf("Write() = %v", err)
+		}
+	}
+	if b.String() != want {
+		t.Errorf("Writer wrote %q, want %q", b.String(), want)
+	}
+}
+
+func TestSplitMultipart(t *testing.T) {
+	body := "preamble\r\n" +
+		"--b\r\n" +
+		"first\r\n" +
+		"--b  \r\n" +
+		"\r\n" +
+		"second\r\n" +
+		"\r\n" +
+		"--b--\r\n" +
+		"epilogue\r\n"
+	want := []string{"first", "\r\nsecond\r\n"}
+
+	parts, err := SplitMultipart([]byte(body), "b")
+	if err != nil {
+		t.Fatalf("SplitMultipart() = %v", err)
+	}
+	if len(parts) != len(want) {
+		t.Fatalf("SplitMultipart() returned %v parts, want %v", len(parts), len(want))
+	}
+	for i, part := range parts {
+		if string(part) != want[i] {
+			t.Errorf("part %v = %q, want %q", i, string(part), want[i])
+		}
+	}
+
+	if _, err := SplitMultipart([]byte(strings.Replace(body, "--b--", "--c--", 1)), "b"); err == nil {
+		t.Errorf("SplitMultipart() = nil, want an error for a missing close delimiter")
+	}
+}
@@ -1,6 +1,7 @@
 package mail
 
 import (
+	"errors"
 	"mime"
 	"net/mail"
 	"strings"
@@ -21,22 +22,488 @@ func formatAddressList(l []*Address) string {
 	return strings.Join(formatted, ", ")
 }
 
+// A Group is a named list of addresses, as defined in RFC 5322 section 3.4.
+// The list can be empty, e.g. "undisclosed-recipients:;".
+//
+// In a list of groups, addresses which don't belong to a group are represented
+// by a Group with an empty Name.
+type Group struct {
+	Name      string
+	Addresses []*Address
+}
+
+// String formats the group as a valid RFC 5322 group. If the group has no
+// name, its addresses are formatted as a plain address list.
+func (g *Group) String() string {
+	if g.Name == "" {
+		return formatAddressList(g.Addresses)
+	}
+	if len(g.Addresses) == 0 {
+		return formatPhrase(g.Name) + ":;"
+	}
+	return formatPhrase(g.Name) + ": " + formatAddressList(g.Addresses) + ";"
+}
+
+func formatGroupList(l []*Group) string {
+	var formatted []string
+	for _, g := range l {
+		if s := g.String(); s != "" {
+			formatted = append(formatted, s)
+		}
+	}
+	return strings.Join(formatted, ", ")
+}
+
+// isAtomPhrase reports whether s is a phrase made of ASCII atoms separated by
+// single spaces, which can be written without quoting.
+func isAtomPhrase(s string) bool {
+	if s == "" || strings.Contains(s, "=?") {
+		return false
+	}
+	for _, word := range strings.Split(s, " ") {
+		if word == "" {
+			return false
+		}
+		for _, r := range word {
+			if isMultibyte(r) || !isAtext(r, false) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// formatPhrase formats a display name. Unlike net/mail.Address.String, the
+// name is only quoted if it isn't a valid phrase.
+func formatPhrase(name string) string {
+	if isAtomPhrase(name) {
+		return name
+	}
+
+	allPrintable := true
+	for _, r := range name {
+		if !isVchar(r) && r != ' ' && r != '\t' || isMultibyte(r) {
+			allPrintable = false
+			break
+		}
+	}
+	if allPrintable {
+		var b strings.Builder
+		b.WriteByte('"')
+		for _, r := range name {
+			if r == '"' || r == '\\' {
+				b.WriteByte('\\')
+			}
+			b.WriteRune(r)
+		}
+		b.WriteByte('"')
+		return b.String()
+	}
+
+	// RFC 2047 section 5.3: some characters can't be Q-encoded in phrases
+	if strings.ContainsAny(name, "\"#$%&'(),.:;<>@[]^`{|}~") {
+		return mime.BEncoding.Encode("utf-8", name)
+	}
+	return mime.QEncoding.Encode("utf-8", name)
+}
+
 // ParseAddress parses a single RFC 5322 address, e.g. "Barry Gibbs <bg@example.com>"
 // Use this function only if you parse from a string, if you have a Header use
 // Header.AddressList instead
+//
+// Internationalized addresses are supported, as defined in RFC 6532: the local
+// part, the domain and the display name can contain UTF-8 characters.
 func ParseAddress(address string) (*Address, error) {
-	parser := mail.AddressParser{
-		&mime.WordDecoder{message.CharsetReader},
+	p := headerParser{s: address}
+	addr, _, err := p.parseAddress(false)
+	if err != nil {
+		return nil, err
+	}
+	if !p.skipCFWS() {
+		return nil, errors.New("mail: malformed parenthetical comment")
+	}
+	if !p.empty() {
+		return nil, errors.New("mail: expected single address")
 	}
-	return parser.Parse(address)
+	return addr, nil
 }
 
 // ParseAddressList parses the given string as a list of addresses.
 // Use this function only if you parse from a string, if you have a Header use
 // Header.AddressList instead
+//
+// Internationalized addresses are supported, as defined in RFC 6532. Members
+// of groups are included in the list, use ParseAddressGroups to retain the
+// groups.
 func ParseAddressList(list string) ([]*Address, error) {
-	parser := mail.AddressParser{
-		&mime.WordDecoder{message.CharsetReader},
+	groups, err := ParseAddressGroups(list)
+	if err != nil {
+		return nil, err
+	}
+
+	var l []*Address
+	for _, g := range groups {
+		l = append(l, g.Addresses...)
+	}
+	return l, nil
+}
+
+// ParseAddressGroups parses the given string as a list of addresses and
+// groups. Consecutive addresses which don't belong to a group are returned in
+// a Group with an empty Name.
+// Use this function only if you parse from a string, if you have a Header use
+// Header.AddressGroups instead
+func ParseAddressGroups(list string) ([]*Group, error) {
+	return ParseAddressGroupsWithOptions(list, nil)
+}
+
+// ParseAddressOptions contains options for ParseAddressGroupsWithOptions.
+type ParseAddressOptions struct {
+	// Raw disables RFC 2047 decoding: display names and group names are
+	// returned as they appear in the header field. This is useful to build
+	// an IMAP envelope, as defined in RFC 3501 section 7.4.2.
+	Raw bool
+}
+
+// ParseAddressGroupsWithOptions is like ParseAddressGroups, but with options.
+// A nil opts is equivalent to a zero ParseAddressOptions.
+func ParseAddressGroupsWithOptions(list string, opts *ParseAddressOptions) ([]*Group, error) {
+	p := headerParser{s: list}
+	if opts != nil {
+		p.raw = opts.Raw
+	}
+	return p.parseAddressGroups()
+}
+
+func newWordDecoder() *mime.WordDecoder {
+	return &mime.WordDecoder{CharsetReader: message.CharsetReader}
+}
+
+// parseAddressGroups parses an address-list, as defined in RFC 5322 section
+// 3.4.
+func (p *headerParser) parseAddressGroups() ([]*Group, error) {
+	var l []*Group
+	for {
+		if !p.skipCFWS() {
+			return nil, errors.New("mail: malformed parenthetical comment")
+		}
+		if p.empty() {
+			break
+		}
+		// RFC 5322 section 4.4: obs-addr-list allows empty elements
+		if p.consume(',') {
+			continue
+		}
+
+		addr, group, err := p.parseAddress(true)
+		if err != nil {
+			return nil, err
+		}
+		if group != nil {
+			l = append(l, group)
+		} else if len(l) > 0 && l[len(l)-1].Name == "" {
+			last := l[len(l)-1]
+			last.Addresses = append(last.Addresses, addr)
+		} else {
+			l = append(l, &Group{Addresses: []*Address{addr}})
+		}
+
+		if !p.skipCFWS() {
+			return nil, errors.New("mail: malformed parenthetical comment")
+		}
+		if p.empty() {
+			break
+		}
+		if !p.consume(',') {
+			return nil, errors.New("mail: expected comma")
+		}
+	}
+	if len(l) == 0 {
+		return nil, errors.New("mail: no address")
+	}
+	return l, nil
+}
+
+// parseAddress parses an address, as defined in RFC 5322 section 3.4. If
+// handleGroup is true, a group is accepted. Either a mailbox or a group is
+// returned.
+func (p *headerParser) parseAddress(handleGroup bool) (*Address, *Group, error) {
+	if !p.skipCFWS() {
+		return nil, nil, errors.New("mail: malformed parenthetical comment")
+	}
+	if p.empty() {
+		return nil, nil, errors.New("mail: no address")
+	}
+
+	// addr-spec, optionally followed by a comment used as the display name
+	orig := p.s
+	if addr, err := p.parseAddrSpec(); err == nil {
+		comments, ok := p.parseCFWS()
+		if !ok {
+			return nil, nil, errors.New("mail: malformed parenthetical comment")
+		}
+		if p.empty() || p.peek() == ',' || p.peek() == ';' {
+			a := &Address{Address: addr}
+			if len(comments) > 0 {
+				a.Name = strings.TrimSpace(comments[0])
+				if !p.raw {
+					a.Name = decodeWords(a.Name)
+				}
+			}
+			return a, nil, nil
+		}
+	}
+	p.s = orig
+
+	// name-addr or group
+	var name string
+	if p.peek() != '<' {
+		var err error
+		name, err = p.parsePhrase()
+		if err != nil {
+			return nil, nil, err
+		}
+		if !p.skipCFWS() {
+			return nil, nil, errors.New("mail: malformed parenthetical comment")
+		}
+	}
+
+	if handleGroup && p.consume(':') {
+		addrs, err := p.parseGroupList()
+		if err != nil {
+			return nil, nil, err
+		}
+		return nil, &Group{Name: name, Addresses: addrs}, nil
+	}
+
+	addr, err := p.parseAngleAddr()
+	if err != nil {
+		return nil, nil, err
+	}
+	return &Address{Name: name, Address: addr}, nil, nil
+}
+
+// parseGroupList parses the members of a group, after the colon.
+func (p *headerParser) parseGroupList() ([]*Address, error) {
+	var l []*Address
+	for {
+		if !p.skipCFWS() {
+			return nil, errors.New("mail: malformed parenthetical comment")
+		}
+		if p.consume(';') {
+			break
+		}
+		if p.empty() {
+			return nil, errors.New("mail: missing ';' in group")
+		}
+		if p.consume(',') {
+			continue
+		}
+
+		addr, _, err := p.parseAddress(false)
+		if err != nil {
+			return nil, err
+		}
+		l = append(l, addr)
+
+		if !p.skipCFWS() {
+			return nil, errors.New("mail: malformed parenthetical comment")
+		}
+		if !p.empty() && p.peek() != ';' && !p.consume(',') {
+			return nil, errors.New("mail: expected comma in group")
+		}
+	}
+	return l, nil
+}
+
+// parseAngleAddr parses an angle-addr, as defined in RFC 5322 section 3.4.
+func (p *headerParser) parseAngleAddr() (string, error) {
+	if !p.consume('<') {
+		return "", errors.New("mail: missing '<' in angle-addr")
+	}
+
+	// RFC 5322 section 4.4: obs-route
+	if !p.empty() && p.peek() == '@' {
+		i := strings.IndexByte(p.s, ':')
+		if i < 0 {
+			return "", errors.New("mail: missing ':' in obs-route")
+		}
+		p.s = p.s[i+1:]
+	}
+
+	addr, err := p.parseAddrSpec()
+	if err != nil {
+		return "", err
+	}
+
+	if !p.consume('>') {
+		return "", errors.New("mail: missing '>' in angle-addr")
+	}
+	return addr, nil
+}
+
+// parseAddrSpec parses an addr-spec, as defined in RFC 5322 section 3.4.1 and
+// extended by RFC 6532 section 3.2.
+func (p *headerParser) parseAddrSpec() (string, error) {
+	if !p.skipCFWS() {
+		return "", errors.New("mail: malformed parenthetical comment")
+	}
+	if p.empty() {
+		return "", errors.New("mail: no addr-spec")
+	}
+
+	var local string
+	var err error
+	if p.peek() == '"' {
+		local, err = p.parseQuotedString()
+	} else {
+		// Consecutive, leading and trailing dots are accepted because they
+		// are commonly found in the wild
+		local, err = p.parseAtomText(true)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if !p.skipCFWS() {
+		return "", errors.New("mail: malformed parenthetical comment")
+	}
+	if !p.consume('@') {
+		return "", errors.New("mail: missing '@' in addr-spec")
+	}
+	if !p.skipCFWS() {
+		return "", errors.New("mail: malformed parenthetical comment")
+	}
+
+	var domain string
+	if !p.empty() && p.peek() == '[' {
+		domain, err = p.parseNoFoldLiteral()
+	} else {
+		domain, err = p.parseDotAtom()
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return local + "@" + domain, nil
+}
+
+// parseDotAtom parses a dot-atom-text, as defined in RFC 5322 section 3.2.3.
+func (p *headerParser) parseDotAtom() (string, error) {
+	atom, err := p.parseAtomText(true)
+	if err != nil {
+		return "", err
+	}
+	if strings.HasPrefix(atom, ".") || strings.HasSuffix(atom, ".") || strings.Contains(atom, "..") {
+		return "", errors.New("mail: invalid dot-atom")
+	}
+	return atom, nil
+}
+
+// parsePhrase parses a phrase, as defined in RFC 5322 section 3.2.5. RFC 2047
+// encoded-words are decoded, unless p.raw is set.
+func (p *headerParser) parsePhrase() (string, error) {
+	var words []string
+	prevEncoded := false
+	for {
+		if !p.skipCFWS() {
+			return "", errors.New("mail: malformed parenthetical comment")
+		}
+		if p.empty() {
+			break
+		}
+
+		var word string
+		var err error
+		encoded := false
+		if p.peek() == '"' {
+			word, err = p.parseQuotedString()
+		} else {
+			// RFC 5322 section 4.1: obs-phrase allows dots
+			word, err = p.parseAtomText(true)
+			if err == nil && !p.raw && strings.HasPrefix(word, "=?") {
+				if dec, decErr := newWordDecoder().Decode(word); decErr == nil {
+					word, encoded = dec, true
+				}
+			}
+		}
+		if err != nil {
+			if len(words) > 0 {
+				break
+			}
+			return "", err
+		}
+
+		// RFC 2047 section 6.2: whitespace between adjacent encoded-words is
+		// ignored
+		if prevEncoded && encoded {
+			words[len(words)-1] += word
+		} else {
+			words = append(words, word)
+		}
+		prevEncoded = encoded
+	}
+	if len(words) == 0 {
+		return "", errors.New("mail: missing word in phrase")
+	}
+	return strings.Join(words, " "), nil
+}
+
+// parseQuotedString parses a quoted-string, as defined in RFC 5322 section
+// 3.2.4 and extended by RFC 6532 section 3.2. It returns the unescaped
+// content.
+func (p *headerParser) parseQuotedString() (string, error) {
+	if !p.consume('"') {
+		return "", errors.New("mail: missing '\"' in quoted-string")
+	}
+
+	var b strings.Builder
+	for {
+		if p.empty() {
+			return "", errors.New("mail: unclosed quoted-string")
+		}
+
+		c := p.peek()
+		p.s = p.s[1:]
+		switch c {
+		case '"':
+			return b.String(), nil
+		case '\\':
+			if p.empty() {
+				return "", errors.New("mail: unclosed quoted-string")
+			}
+			b.WriteByte(p.peek())
+			p.s = p.s[1:]
+		case '\r', '\n':
+			// Folding whitespace
+		default:
+			if c < ' ' && c != '\t' || c == 0x7F {
+				return "", errors.New("mail: invalid character in quoted-string")
+			}
+			b.WriteByte(c)
+		}
+	}
+}
+
+// parseCFWS is like skipCFWS, but returns the comments.
+func (p *headerParser) parseCFWS() ([]string, bool) {
+	var comments []string
+	p.skipSpace()
+	for p.consume('(') {
+		comment, ok := p.consumeComment()
+		if !ok {
+			return nil, false
+		}
+		comments = append(comments, comment)
+		p.skipSpace()
+	}
+	return comments, true
+}
+
+// decodeWords decodes RFC 2047 encoded-words in s. If it fails, s is returned.
+func decodeWords(s string) string {
+	if dec, err := newWordDecoder().DecodeHeader(s); err == nil {
+		return dec
 	}
-	return parser.ParseList(list)
+	return s
 }
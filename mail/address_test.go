@@ -1,9 +1,10 @@
 package mail_test
 
 import (
-	"net/mail"
 	"reflect"
 	"testing"
+
+	"github.com/emersion/go-message/mail"
 )
 
 func TestParseAddressList(t *testing.T) {
@@ -28,3 +29,84 @@ func TestParseAddress(t *testing.T) {
 		t.Errorf("Expected address to be %v, but got %v", want, got)
 	}
 }
+
+var parseAddressTests = []struct {
+	input string
+	want  *mail.Address
+}{
+	{"bg@example.com", &mail.Address{Address: "bg@example.com"}},
+	{"<bg@example.com>", &mail.Address{Address: "bg@example.com"}},
+	{"Barry Gibbs <bg@example.com>", &mail.Address{Name: "Barry Gibbs", Address: "bg@example.com"}},
+	{`"Gibbs, Barry" <bg@example.com>`, &mail.Address{Name: "Gibbs, Barry", Address: "bg@example.com"}},
+	{`"Barry \"The Bee\" Gibbs" <bg@example.com>`, &mail.Address{Name: `Barry "The Bee" Gibbs`, Address: "bg@example.com"}},
+	{"Barry Q. Gibbs <bg@example.com>", &mail.Address{Name: "Barry Q. Gibbs", Address: "bg@example.com"}},
+	{"bg@example.com (Barry Gibbs)", &mail.Address{Name: "Barry Gibbs", Address: "bg@example.com"}},
+	{"(comment) Barry (another) Gibbs <bg@example.com> (trailing)", &mail.Address{Name: "Barry Gibbs", Address: "bg@example.com"}},
+	{"<@relay.example.org,@other.example.org:bg@example.com>", &mail.Address{Address: "bg@example.com"}},
+	{`"barry gibbs"@example.com`, &mail.Address{Address: "barry gibbs@example.com"}},
+	{"bg@[192.0.2.1]", &mail.Address{Address: "bg@[192.0.2.1]"}},
+	{"=?utf-8?q?J=C3=B6rg?= =?utf-8?q?_Doe?= <joerg@example.com>", &mail.Address{Name: "Jörg Doe", Address: "joerg@example.com"}},
+	{"=?ISO-8859-1?Q?J=F6rg?= Doe <joerg@example.com>", &mail.Address{Name: "Jörg Doe", Address: "joerg@example.com"}},
+	{"用户@例子.广告", &mail.Address{Address: "用户@例子.广告"}},
+	{"Ünsal <ünsal@exämple.org>", &mail.Address{Name: "Ünsal", Address: "ünsal@exämple.org"}},
+	{`"用户" <用户@例子.广告>`, &mail.Address{Name: "用户", Address: "用户@例子.广告"}},
+	{"δοκιμή@παράδειγμα.δοκιμή", &mail.Address{Address: "δοκιμή@παράδειγμα.δοκιμή"}},
+}
+
+func TestParseAddress_rfc5322(t *testing.T) {
+	for _, test := range parseAddressTests {
+		if got, err := mail.ParseAddress(test.input); err != nil {
+			t.Errorf("ParseAddress(%q) = %v", test.input, err)
+		} else if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("ParseAddress(%q) = %v, want %v", test.input, got, test.want)
+		}
+	}
+}
+
+func TestParseAddress_invalid(t *testing.T) {
+	for _, input := range []string{
+		"",
+		"Barry Gibbs",
+		"bg@",
+		"<bg@example.com",
+		"bg@example..com",
+		"bg@example.com, hs@example.org",
+		`"unclosed@example.com`,
+		"bg@example.com (unclosed",
+		"bg@exam\xffple.com",
+	} {
+		if got, err := mail.ParseAddress(input); err == nil {
+			t.Errorf("ParseAddress(%q) = %v, expected an error", input, got)
+		}
+	}
+}
+
+func TestParseAddressList_groups(t *testing.T) {
+	want := []*mail.Address{
+		{Address: "a@example.org"},
+		{Name: "B", Address: "b@example.org"},
+		{Address: "c@example.org"},
+	}
+	input := "Team: a@example.org, B <b@example.org>;, undisclosed-recipients:;, , c@example.org"
+	if got, err := mail.ParseAddressList(input); err != nil {
+		t.Error("Expected no error while parsing address list got:", err)
+	} else if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected address list to be %v, but got %v", want, got)
+	}
+}
+
+func TestParseAddressGroupsWithOptions_raw(t *testing.T) {
+	want := []*mail.Group{
+		{Name: "=?utf-8?q?Team?=", Addresses: []*mail.Address{
+			{Name: "=?utf-8?q?A?= =?utf-8?q?B?=", Address: "a@example.org"},
+			{Name: "=?utf-8?q?C?=", Address: "c@example.org"},
+		}},
+	}
+	input := "=?utf-8?q?Team?=: =?utf-8?q?A?= =?utf-8?q?B?= <a@example.org>, c@example.org (=?utf-8?q?C?=);"
+	opts := &mail.ParseAddressOptions{Raw: true}
+	if got, err := mail.ParseAddressGroupsWithOptions(input, opts); err != nil {
+		t.Error("Expected no error while parsing address groups got:", err)
+	} else if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected address groups to be %v, but got %v", want, got)
+	}
+}
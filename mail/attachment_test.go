@@ -49,3 +49,20 @@ func TestAttachmentHeader_Filename_encoded(t *testing.T) {
 		t.Errorf("Expected filename to be %q but got %q", "", filename)
 	}
 }
+
+func TestAttachmentHeader_SetFilename_encoded(t *testing.T) {
+	filename := "Opis przedmiotu zamówienia.pdf"
+
+	var h mail.AttachmentHeader
+	h.SetFilename(filename)
+
+	expected := "attachment; filename*=utf-8''Opis%20przedmiotu%20zam%C3%B3wienia.pdf"
+	if s := h.Get("Content-Disposition"); s != expected {
+		t.Errorf("Expected Content-Disposition to be %q but got %q", expected, s)
+	}
+	if got, err := h.Filename(); err != nil {
+		t.Error("Expected no error while parsing filename, got:", err)
+	} else if got != filename {
+		t.Errorf("Expected filename to be %q but got %q", filename, got)
+	}
+}
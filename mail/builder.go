@@ -0,0 +1,175 @@
+package mail
+
+import (
+	"io"
+
+	"github.com/emersion/go-message"
+)
+
+// builderPart is a part of the MIME tree built by Builder.
+type builderPart struct {
+	header message.Header
+	body   io.Reader
+	parts  []*builderPart
+}
+
+func newBuilderMultipart(t string, params map[string]string, parts ...*builderPart) *builderPart {
+	p := &builderPart{parts: parts}
+	p.header.SetContentType(t, params)
+	return p
+}
+
+func (p *builderPart) writeTo(w *message.Writer) error {
+	if p.parts == nil {
+		if p.body == nil {
+			return nil
+		}
+		_, err := io.Copy(w, p.body)
+		return err
+	}
+
+	for _, child := range p.parts {
+		cw, err := w.CreatePart(child.header)
+		if err != nil {
+			return err
+		}
+		if err := child.writeTo(cw); err != nil {
+			return err
+		}
+		if err := cw.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// countWriter counts the number of bytes written.
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countWriter) Write(b []byte) (int, error) {
+	n, err := cw.w.Write(b)
+	cw.n += int64(n)
+	return n, err
+}
+
+// A Builder builds a mail message from its text bodies, inline resources and
+// attachments. It picks the simplest MIME structure able to represent them.
+//
+// The text and HTML bodies are written as a single part, or as a
+// multipart/alternative part if both are set. Inline resources, e.g. images
+// referenced by the HTML body, are grouped with the HTML body in a
+// multipart/related part. Attachments are grouped with the message body in a
+// multipart/mixed part.
+//
+// A Builder can only be written once, since bodies are read when written.
+type Builder struct {
+	// The mail header. The MIME header fields are set by the Builder.
+	Header Header
+	// Options used to write the message.
+	Options *message.WriterOptions
+
+	text, html  *builderPart
+	inlines     []*builderPart
+	attachments []*builderPart
+}
+
+func (b *Builder) newTextPart(t string, body io.Reader) *builderPart {
+	h := InlineHeader{}
+	h.SetContentType(t, map[string]string{"charset": "utf-8"})
+	initInlineHeader(&h, b.Options)
+	return &builderPart{header: h.Header, body: body}
+}
+
+// SetText sets the plain text body of the message. The body must be encoded
+// in UTF-8.
+func (b *Builder) SetText(body io.Reader) {
+	b.text = b.newTextPart("text/plain", body)
+}
+
+// SetHTML sets the HTML body of the message. The body must be encoded in
+// UTF-8.
+func (b *Builder) SetHTML(body io.Reader) {
+	b.html = b.newTextPart("text/html", body)
+}
+
+// AddInline adds an inline resource, e.g. an image referenced by the HTML
+// body with a cid: URL. The header should contain the Content-Type and
+// Content-Id header fields.
+func (b *Builder) AddInline(h InlineHeader, body io.Reader) {
+	h = InlineHeader{h.Header.Copy()} // don't modify the caller's view
+	if disp, _, _ := h.ContentDisposition(); disp != "inline" {
+		h.Set("Content-Disposition", "inline")
+	}
+	if !autoEncoding(b.Options) {
+		initInlineContentTransferEncoding(&h.Header)
+	}
+	b.inlines = append(b.inlines, &builderPart{header: h.Header, body: body})
+}
+
+// AddAttachment adds an attachment.
+func (b *Builder) AddAttachment(h AttachmentHeader, body io.Reader) {
+	h = AttachmentHeader{h.Header.Copy()} // don't modify the caller's view
+	initAttachmentHeader(&h, b.Options)
+	b.attachments = append(b.attachments, &builderPart{header: h.Header, body: body})
+}
+
+// build returns the root part of the message.
+func (b *Builder) build() *builderPart {
+	html := b.html
+	var mixed []*builderPart
+	if html != nil && len(b.inlines) > 0 {
+		parts := append([]*builderPart{html}, b.inlines...)
+		html = newBuilderMultipart("multipart/related", map[string]string{"type": "text/html"}, parts...)
+	} else {
+		// Inline resources can't be referenced without an HTML body
+		mixed = append(mixed, b.inlines...)
+	}
+
+	var body *builderPart
+	switch {
+	case b.text != nil && html != nil:
+		body = newBuilderMultipart("multipart/alternative", nil, b.text, html)
+	case html != nil:
+		body = html
+	case b.text != nil:
+		body = b.text
+	default:
+		body = b.newTextPart("text/plain", nil)
+	}
+
+	mixed = append(mixed, b.attachments...)
+	if len(mixed) == 0 {
+		return body
+	}
+	return newBuilderMultipart("multipart/mixed", nil, append([]*builderPart{body}, mixed...)...)
+}
+
+// WriteTo writes the message to w.
+func (b *Builder) WriteTo(w io.Writer) (int64, error) {
+	root := b.build()
+
+	h := b.Header.Copy() // don't modify the caller's view
+	for _, k := range []string{"Content-Type", "Content-Transfer-Encoding", "Content-Disposition"} {
+		h.Del(k)
+	}
+	fields := root.header.Fields()
+	for fields.Next() {
+		if fields.Key() != "Content-Disposition" {
+			h.Set(fields.Key(), fields.Value())
+		}
+	}
+
+	cw := &countWriter{w: w}
+	mw, err := message.CreateWriterWithOptions(cw, h.Header, b.Options)
+	if err != nil {
+		return cw.n, err
+	}
+	if err := root.writeTo(mw); err != nil {
+		return cw.n, err
+	}
+	err = mw.Close()
+	return cw.n, err
+}
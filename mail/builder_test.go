@@ -0,0 +1,256 @@
+package mail_test
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/emersion/go-message"
+	"github.com/emersion/go-message/mail"
+)
+
+func ExampleBuilder() {
+	var b mail.Builder
+
+	from := []*mail.Address{{Name: "Mitsuha Miyamizu", Address: "mitsuha.miyamizu@example.org"}}
+	to := []*mail.Address{{Name: "Taki Tachibana", Address: "taki.tachibana@example.org"}}
+	b.Header.SetAddressList("From", from)
+	b.Header.SetAddressList("To", to)
+	b.Header.SetSubject("Your Name.")
+
+	b.SetText(strings.NewReader("Who are you?"))
+	b.SetHTML(strings.NewReader(`<p>Who are you?</p><img src="cid:comet@example.org">`))
+
+	var ih mail.InlineHeader
+	ih.Set("Content-Type", "image/png")
+	ih.Set("Content-Id", "<comet@example.org>")
+	// TODO: replace with a PNG file
+	b.AddInline(ih, strings.NewReader(""))
+
+	var ah mail.AttachmentHeader
+	ah.Set("Content-Type", "image/jpeg")
+	ah.SetFilename("picture.jpg")
+	// TODO: replace with a JPEG file
+	b.AddAttachment(ah, strings.NewReader(""))
+
+	var buf bytes.Buffer
+	if _, err := b.WriteTo(&buf); err != nil {
+		log.Fatal(err)
+	}
+	log.Println(buf.String())
+}
+
+// builderStructure returns the media types of the parts of a message, indexed
+// by their path, and the bodies of its leaf parts.
+func builderStructure(t *testing.T, b *mail.Builder) (map[string]string, map[string]string) {
+	var buf bytes.Buffer
+	n, err := b.WriteTo(&buf)
+	if err != nil {
+		t.Fatalf("Builder.WriteTo() = %v", err)
+	}
+	if n != int64(buf.Len()) {
+		t.Errorf("Builder.WriteTo() = %v, want %v", n, buf.Len())
+	}
+
+	e, err := message.Read(&buf)
+	if err != nil {
+		t.Fatalf("message.Read() = %v", err)
+	}
+
+	types := make(map[string]string)
+	bodies := make(map[string]string)
+	err = e.Walk(func(path []int, part *message.Entity, err error) error {
+		if err != nil {
+			return err
+		}
+		mediaType, _, _ := part.Header.ContentType()
+		types[fmt.Sprint(path)] = mediaType
+		if part.MultipartReader() == nil {
+			b, err := ioutil.ReadAll(part.Body)
+			if err != nil {
+				return err
+			}
+			bodies[fmt.Sprint(path)] = string(b)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Entity.Walk() = %v", err)
+	}
+	return types, bodies
+}
+
+func TestBuilder(t *testing.T) {
+	tests := []struct {
+		name                 string
+		text, html           bool
+		inlines, attachments int
+		wantTypes            map[string]string
+	}{
+		{
+			name:      "empty",
+			wantTypes: map[string]string{"[]": "text/plain"},
+		},
+		{
+			name:      "text",
+			text:      true,
+			wantTypes: map[string]string{"[]": "text/plain"},
+		},
+		{
+			name:      "html",
+			html:      true,
+			wantTypes: map[string]string{"[]": "text/html"},
+		},
+		{
+			name: "alternative",
+			text: true,
+			html: true,
+			wantTypes: map[string]string{
+				"[]":  "multipart/alternative",
+				"[0]": "text/plain",
+				"[1]": "text/html",
+			},
+		},
+		{
+			name:    "related",
+			html:    true,
+			inlines: 2,
+			wantTypes: map[string]string{
+				"[]":  "multipart/related",
+				"[0]": "text/html",
+				"[1]": "image/png",
+				"[2]": "image/png",
+			},
+		},
+		{
+			name:        "mixed",
+			text:        true,
+			attachments: 1,
+			wantTypes: map[string]string{
+				"[]":  "multipart/mixed",
+				"[0]": "text/plain",
+				"[1]": "image/jpeg",
+			},
+		},
+		{
+			name:        "everything",
+			text:        true,
+			html:        true,
+			inlines:     1,
+			attachments: 2,
+			wantTypes: map[string]string{
+				"[]":      "multipart/mixed",
+				"[0]":     "multipart/alternative",
+				"[0 0]":   "text/plain",
+				"[0 1]":   "multipart/related",
+				"[0 1 0]": "text/html",
+				"[0 1 1]": "image/png",
+				"[1]":     "image/jpeg",
+				"[2]":     "image/jpeg",
+			},
+		},
+		{
+			name:    "inline without html",
+			text:    true,
+			inlines: 1,
+			wantTypes: map[string]string{
+				"[]":  "multipart/mixed",
+				"[0]": "text/plain",
+				"[1]": "image/png",
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var b mail.Builder
+			b.Header.SetSubject("Your Name.")
+			b.Header.Set("Content-Type", "application/octet-stream")
+			if tc.text {
+				b.SetText(strings.NewReader("Who are you?"))
+			}
+			if tc.html {
+				b.SetHTML(strings.NewReader("<p>Who are you?</p>"))
+			}
+			for i := 0; i < tc.inlines; i++ {
+				var h mail.InlineHeader
+				h.Set("Content-Type", "image/png")
+				h.Set("Content-Id", fmt.Sprintf("<%v@example.org>", i))
+				b.AddInline(h, strings.NewReader("PNG"))
+			}
+			for i := 0; i < tc.attachments; i++ {
+				var h mail.AttachmentHeader
+				h.Set("Content-Type", "image/jpeg")
+				h.SetFilename("picture.jpg")
+				b.AddAttachment(h, strings.NewReader("JPEG"))
+			}
+
+			types, bodies := builderStructure(t, &b)
+			if !reflect.DeepEqual(types, tc.wantTypes) {
+				t.Errorf("Builder.WriteTo() wrote parts %v, want %v", types, tc.wantTypes)
+			}
+			for path, body := range bodies {
+				switch tc.wantTypes[path] {
+				case "text/plain":
+					if tc.text && body != "Who are you?" {
+						t.Errorf("text/plain part has body %q", body)
+					}
+				case "text/html":
+					if body != "<p>Who are you?</p>" {
+						t.Errorf("text/html part has body %q", body)
+					}
+				case "image/png":
+					if body != "PNG" {
+						t.Errorf("image/png part has body %q", body)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestBuilder_header(t *testing.T) {
+	var b mail.Builder
+	b.Header.SetSubject("Your Name.")
+	b.SetHTML(strings.NewReader("<p>Who are you?</p>"))
+
+	var h mail.InlineHeader
+	h.Set("Content-Type", "image/png")
+	h.SetContentDisposition("inline", map[string]string{"filename": "comet.png"})
+	b.AddInline(h, strings.NewReader("PNG"))
+
+	var buf bytes.Buffer
+	if _, err := b.WriteTo(&buf); err != nil {
+		t.Fatalf("Builder.WriteTo() = %v", err)
+	}
+
+	r, err := mail.CreateReader(&buf)
+	if err != nil {
+		t.Fatalf("mail.CreateReader() = %v", err)
+	}
+	if s, _ := r.Header.Subject(); s != "Your Name." {
+		t.Errorf("Subject = %q, want %q", s, "Your Name.")
+	}
+	mediaType, params, _ := r.Header.ContentType()
+	if mediaType != "multipart/related" || params["type"] != "text/html" {
+		t.Errorf("Content-Type = %v %v, want multipart/related with type=text/html", mediaType, params)
+	}
+
+	if _, err := r.NextPart(); err != nil {
+		t.Fatalf("NextPart() = %v", err)
+	}
+	p, err := r.NextPart()
+	if err != nil {
+		t.Fatalf("NextPart() = %v", err)
+	}
+	if disp, params, _ := p.Header.(*mail.InlineHeader).ContentDisposition(); disp != "inline" || params["filename"] != "comet.png" {
+		t.Errorf("Content-Disposition = %v %v, want inline with filename=comet.png", disp, params)
+	}
+	if h.Has("Content-Transfer-Encoding") {
+		t.Error("AddInline() modified the caller's header")
+	}
+}
@@ -0,0 +1,144 @@
+package mail
+
+import (
+	"errors"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/emersion/go-message"
+)
+
+// contentID parses the Content-ID header field. Unlike message identifiers,
+// many mail clients generate invalid content identifiers, e.g. without "@" or
+// without angle brackets: these are accepted too.
+func contentID(h *message.Header) (string, error) {
+	v := strings.TrimSpace(h.Get("Content-Id"))
+	if v == "" {
+		return "", nil
+	}
+
+	p := headerParser{s: v}
+	if id, err := p.parseMsgID(); err == nil {
+		return id, nil
+	}
+
+	if strings.HasPrefix(v, "<") {
+		i := strings.IndexByte(v, '>')
+		if i < 0 {
+			return "", errors.New("mail: unterminated Content-ID")
+		}
+		v = strings.TrimSpace(v[1:i])
+	}
+	if v == "" {
+		return "", errors.New("mail: empty Content-ID")
+	}
+	return v, nil
+}
+
+func setContentID(h *message.Header, id string) {
+	if id != "" {
+		h.Set("Content-Id", "<"+id+">")
+	} else {
+		h.Del("Content-Id")
+	}
+}
+
+func generateContentID(h *message.Header) error {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return err
+	}
+	id, err := generateMsgID(hostname)
+	if err != nil {
+		return err
+	}
+	setContentID(h, id)
+	return nil
+}
+
+// ContentID parses the Content-ID header field. It returns the content
+// identifier, without the angle brackets. If the part doesn't have a
+// Content-ID header field, it returns an empty string.
+func (h *InlineHeader) ContentID() (string, error) {
+	return contentID(&h.Header)
+}
+
+// SetContentID sets the Content-ID header field. id is the content
+// identifier, without the angle brackets.
+func (h *InlineHeader) SetContentID(id string) {
+	setContentID(&h.Header, id)
+}
+
+// GenerateContentID generates a new unique Content-ID header field. The
+// content identifier can then be retrieved with ContentID.
+func (h *InlineHeader) GenerateContentID() error {
+	return generateContentID(&h.Header)
+}
+
+// ContentID parses the Content-ID header field. It returns the content
+// identifier, without the angle brackets. If the part doesn't have a
+// Content-ID header field, it returns an empty string.
+func (h *AttachmentHeader) ContentID() (string, error) {
+	return contentID(&h.Header)
+}
+
+// SetContentID sets the Content-ID header field. id is the content
+// identifier, without the angle brackets.
+func (h *AttachmentHeader) SetContentID(id string) {
+	setContentID(&h.Header, id)
+}
+
+// GenerateContentID generates a new unique Content-ID header field. The
+// content identifier can then be retrieved with ContentID.
+func (h *AttachmentHeader) GenerateContentID() error {
+	return generateContentID(&h.Header)
+}
+
+// ContentIDURL formats a cid: URL referencing the part with the specified
+// content identifier, as defined in RFC 2392. It can be used e.g. in an HTML
+// body to reference an inline image.
+func ContentIDURL(id string) string {
+	return "cid:" + url.PathEscape(id)
+}
+
+// ParseContentIDURL parses a cid: URL, as defined in RFC 2392. It returns the
+// content identifier of the referenced part, which can be compared with the
+// result of ContentID.
+func ParseContentIDURL(u string) (string, error) {
+	if len(u) < 4 || !strings.EqualFold(u[:4], "cid:") {
+		return "", errors.New("mail: not a cid: URL")
+	}
+	id, err := url.PathUnescape(u[4:])
+	if err != nil {
+		return "", err
+	}
+	id = strings.TrimSuffix(strings.TrimPrefix(id, "<"), ">")
+	if id == "" {
+		return "", errors.New("mail: empty cid: URL")
+	}
+	return id, nil
+}
+
+// PartsByContentID returns the parts of a buffered message which have a
+// Content-ID header field, indexed by content identifier. Together with
+// ParseContentIDURL, it can be used to resolve the cid: URLs of an HTML part.
+//
+// Parts can only be looked up in a message read with message.ReadBuffered: a
+// message read as a stream needs to be buffered first.
+func PartsByContentID(be *message.BufferedEntity) map[string]*message.BufferedEntity {
+	parts := make(map[string]*message.BufferedEntity)
+	addPartsByContentID(parts, be)
+	return parts
+}
+
+func addPartsByContentID(parts map[string]*message.BufferedEntity, be *message.BufferedEntity) {
+	if id, err := contentID(&be.Header); err == nil && id != "" {
+		if _, ok := parts[id]; !ok {
+			parts[id] = be
+		}
+	}
+	for _, p := range be.Parts {
+		addPartsByContentID(parts, p)
+	}
+}
@@ -0,0 +1,146 @@
+package mail_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/emersion/go-message"
+	"github.com/emersion/go-message/mail"
+)
+
+func TestInlineHeader_ContentID(t *testing.T) {
+	var h mail.InlineHeader
+	if id, err := h.ContentID(); err != nil || id != "" {
+		t.Errorf("InlineHeader.ContentID() = %q, %v, want empty", id, err)
+	}
+
+	h.SetContentID("comet@example.org")
+	if v := h.Get("Content-Id"); v != "<comet@example.org>" {
+		t.Errorf("Content-Id = %q, want %q", v, "<comet@example.org>")
+	}
+	if id, err := h.ContentID(); err != nil || id != "comet@example.org" {
+		t.Errorf("InlineHeader.ContentID() = %q, %v, want %q", id, err, "comet@example.org")
+	}
+
+	h.SetContentID("")
+	if h.Has("Content-Id") {
+		t.Error("SetContentID(\"\") didn't remove the Content-Id header field")
+	}
+}
+
+func TestInlineHeader_ContentID_lenient(t *testing.T) {
+	tests := []struct {
+		v, id string
+	}{
+		{"<comet@example.org> (comment)", "comet@example.org"},
+		{"<part1>", "part1"},
+		{" < part1 > ", "part1"},
+		{"image001", "image001"},
+		{"image001@01D2A1B2.C3D4E5F6", "image001@01D2A1B2.C3D4E5F6"},
+	}
+
+	for _, tc := range tests {
+		var h mail.InlineHeader
+		h.Set("Content-Id", tc.v)
+		if id, err := h.ContentID(); err != nil || id != tc.id {
+			t.Errorf("InlineHeader.ContentID(%q) = %q, %v, want %q", tc.v, id, err, tc.id)
+		}
+	}
+
+	for _, v := range []string{"<>", "<part1"} {
+		var h mail.InlineHeader
+		h.Set("Content-Id", v)
+		if _, err := h.ContentID(); err == nil {
+			t.Errorf("InlineHeader.ContentID(%q) = nil, want an error", v)
+		}
+	}
+}
+
+func TestAttachmentHeader_GenerateContentID(t *testing.T) {
+	var h mail.AttachmentHeader
+	if err := h.GenerateContentID(); err != nil {
+		t.Fatalf("AttachmentHeader.GenerateContentID() = %v", err)
+	}
+	id, err := h.ContentID()
+	if err != nil {
+		t.Fatalf("AttachmentHeader.ContentID() = %v", err)
+	}
+	if !strings.Contains(id, "@") {
+		t.Errorf("AttachmentHeader.ContentID() = %q, want an addr-spec", id)
+	}
+
+	var other mail.AttachmentHeader
+	if err := other.GenerateContentID(); err != nil {
+		t.Fatalf("AttachmentHeader.GenerateContentID() = %v", err)
+	}
+	if otherID, _ := other.ContentID(); otherID == id {
+		t.Errorf("GenerateContentID() generated the same ID twice: %q", id)
+	}
+}
+
+func TestContentIDURL(t *testing.T) {
+	tests := []struct {
+		id, url string
+	}{
+		{"comet@example.org", "cid:comet@example.org"},
+		{"foo4*foo1@bar.net", "cid:foo4%2Afoo1@bar.net"},
+		{"a b/c@example.org", "cid:a%20b%2Fc@example.org"},
+	}
+
+	for _, tc := range tests {
+		if u := mail.ContentIDURL(tc.id); u != tc.url {
+			t.Errorf("ContentIDURL(%q) = %q, want %q", tc.id, u, tc.url)
+		}
+		if id, err := mail.ParseContentIDURL(tc.url); err != nil || id != tc.id {
+			t.Errorf("ParseContentIDURL(%q) = %q, %v, want %q", tc.url, id, err, tc.id)
+		}
+	}
+
+	if id, err := mail.ParseContentIDURL("CID:<comet@example.org>"); err != nil || id != "comet@example.org" {
+		t.Errorf("ParseContentIDURL() = %q, %v, want %q", id, err, "comet@example.org")
+	}
+	for _, u := range []string{"https://example.org", "cid:", "cid:%zz"} {
+		if _, err := mail.ParseContentIDURL(u); err == nil {
+			t.Errorf("ParseContentIDURL(%q) = nil, want an error", u)
+		}
+	}
+}
+
+const relatedMailString = "Subject: Your Name\r\n" +
+	"Content-Type: multipart/related; boundary=message-boundary; type=\"text/html\"\r\n" +
+	"\r\n" +
+	"--message-boundary\r\n" +
+	"Content-Type: text/html\r\n" +
+	"\r\n" +
+	"<img src=\"cid:comet@example.org\">\r\n" +
+	"--message-boundary\r\n" +
+	"Content-Type: image/png\r\n" +
+	"Content-Id: <comet@example.org>\r\n" +
+	"\r\n" +
+	"PNG\r\n" +
+	"--message-boundary--\r\n"
+
+func TestPartsByContentID(t *testing.T) {
+	be, err := message.ReadBuffered(strings.NewReader(relatedMailString), nil)
+	if err != nil {
+		t.Fatalf("message.ReadBuffered() = %v", err)
+	}
+	defer be.Close()
+
+	parts := mail.PartsByContentID(be)
+	if len(parts) != 1 {
+		t.Fatalf("PartsByContentID() = %v, want a single part", parts)
+	}
+
+	id, err := mail.ParseContentIDURL("cid:comet@example.org")
+	if err != nil {
+		t.Fatalf("ParseContentIDURL() = %v", err)
+	}
+	p, ok := parts[id]
+	if !ok {
+		t.Fatalf("PartsByContentID() has no part for %q", id)
+	}
+	if mediaType, _, _ := p.Header.ContentType(); mediaType != "image/png" {
+		t.Errorf("part has Content-Type %q, want %q", mediaType, "image/png")
+	}
+}
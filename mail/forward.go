@@ -0,0 +1,220 @@
+package mail
+
+import (
+	"io"
+	"strings"
+
+	"github.com/emersion/go-message"
+)
+
+// ForwardHeader creates the header of a message forwarding a message with the
+// header h. It sets the Subject header field. The caller is responsible for
+// setting the other header fields, such as From, To and Date.
+func ForwardHeader(h *Header) Header {
+	// Decoding errors are ignored: the raw value is returned in that case
+	subject, _ := h.Subject()
+
+	var fwd Header
+	fwd.SetSubject(forwardSubject(subject))
+	return fwd
+}
+
+// forwardSubject prepends "Fwd: " to the base subject of a subject, as
+// defined by BaseSubject.
+func forwardSubject(subject string) string {
+	base, _ := BaseSubject(subject)
+	return "Fwd: " + base
+}
+
+// ForwardAttachment writes e as a message/rfc822 attachment. The attachment
+// filename is derived from the subject of e. e is consumed.
+func ForwardAttachment(w *Writer, e *message.Entity) error {
+	mh := Header{e.Header}
+	subject, _ := mh.Subject()
+	if subject == "" {
+		subject = "message"
+	}
+
+	var h AttachmentHeader
+	h.Set("Content-Type", "message/rfc822")
+	h.SetFilename(subject + ".eml")
+	return w.AttachMessage(h, e)
+}
+
+// displayAddressList formats a list of addresses for display purposes. Unlike
+// Address.String, display names are not encoded.
+func displayAddressList(l []*Address) string {
+	formatted := make([]string, len(l))
+	for i, addr := range l {
+		if addr.Name != "" {
+			formatted[i] = addr.Name + " <" + addr.Address + ">"
+		} else {
+			formatted[i] = addr.Address
+		}
+	}
+	return strings.Join(formatted, ", ")
+}
+
+// writeForwardedHeader writes a header block describing a forwarded message.
+func writeForwardedHeader(w io.Writer, h *Header) error {
+	lines := []string{"---------- Forwarded message ----------"}
+	for _, k := range []string{"From", "Date", "Subject", "To", "Cc"} {
+		var v string
+		switch k {
+		case "Date":
+			v = h.Get(k)
+		case "Subject":
+			v, _ = h.Subject()
+		default:
+			if l, err := h.AddressList(k); err == nil {
+				v = displayAddressList(l)
+			} else {
+				v = h.Get(k)
+			}
+		}
+		if v != "" {
+			lines = append(lines, k+": "+v)
+		}
+	}
+
+	_, err := io.WriteString(w, strings.Join(lines, "\r\n")+"\r\n\r\n")
+	return err
+}
+
+// isAlternative reports whether p is part of an alternative representation of
+// body, i.e. whether p is in the multipart/alternative entity containing body.
+func isAlternative(p, body *Part) bool {
+	if body.ParentMediaType != "multipart/alternative" {
+		return false
+	}
+	parent := body.Path[:len(body.Path)-1]
+	if len(p.Path) <= len(parent) {
+		return false
+	}
+	for i := range parent {
+		if p.Path[i] != parent[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func isPlainText(p *Part) bool {
+	h, ok := p.Header.(*InlineHeader)
+	if !ok {
+		return false
+	}
+	t, _, _ := h.ContentType()
+	return t == "text/plain"
+}
+
+// nextForwardedPart returns the next part of a forwarded message, or nil if
+// there are no more parts. raw is true if the body of the part couldn't be
+// decoded from its charset.
+func nextForwardedPart(mr *Reader) (p *Part, raw bool, err error) {
+	p, err = mr.NextPart()
+	if err == io.EOF {
+		return nil, false, nil
+	} else if message.IsUnknownCharset(err) {
+		return p, true, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+	return p, false, nil
+}
+
+// ForwardInline writes e as an inline forward. A text part is written,
+// containing text (which may be nil), a "Forwarded message" block with the
+// header fields of e, and the plain text body of e. The other parts of e are
+// written as attachments, except the alternative representations of its plain
+// text body and their inline resources. e is consumed.
+//
+// The plain text body of e is only included if it's the first part of e.
+// Otherwise, it's written as an attachment.
+func ForwardInline(w *Writer, text io.Reader, e *message.Entity) error {
+	mr := NewReader(e)
+	defer mr.Close()
+
+	var th InlineHeader
+	th.SetContentType("text/plain", map[string]string{"charset": "utf-8"})
+	tw, err := w.CreateSingleInline(th)
+	if err != nil {
+		return err
+	}
+	if text != nil {
+		if _, err := io.Copy(tw, text); err != nil {
+			return err
+		}
+		if _, err := io.WriteString(tw, "\r\n\r\n"); err != nil {
+			return err
+		}
+	}
+	if err := writeForwardedHeader(tw, &mr.Header); err != nil {
+		return err
+	}
+
+	p, raw, err := nextForwardedPart(mr)
+	if err != nil {
+		return err
+	}
+	var body *Part
+	if p != nil && isPlainText(p) {
+		if _, err := io.Copy(tw, p.Body); err != nil {
+			return err
+		}
+		body, p = p, nil
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+
+	for {
+		if p == nil {
+			if p, raw, err = nextForwardedPart(mr); err != nil {
+				return err
+			} else if p == nil {
+				return nil
+			}
+		}
+		if body == nil || !isAlternative(p, body) {
+			if err := forwardAttachment(w, p, raw); err != nil {
+				return err
+			}
+		}
+		p = nil
+	}
+}
+
+// forwardAttachment writes a part of a forwarded message as an attachment.
+// The body of p has been decoded to UTF-8, so the charset of text parts is
+// replaced with utf-8. If raw is true, the body couldn't be decoded and is
+// written as is, as application/octet-stream.
+func forwardAttachment(w *Writer, p *Part, raw bool) error {
+	var h AttachmentHeader
+	switch ph := p.Header.(type) {
+	case *InlineHeader:
+		h = AttachmentHeader{ph.Header.Copy()}
+	case *AttachmentHeader:
+		h = AttachmentHeader{ph.Header.Copy()}
+	}
+
+	// RFC 2046 section 4.1.2: charset only applies to text/*
+	if t, params, err := h.ContentType(); err == nil && strings.HasPrefix(t, "text/") {
+		if raw {
+			delete(params, "charset")
+			h.SetContentType("application/octet-stream", params)
+		} else if _, ok := params["charset"]; ok {
+			params["charset"] = "utf-8"
+			h.SetContentType(t, params)
+		}
+	}
+
+	aw, err := w.CreateAttachment(h)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(aw, p.Body); err != nil {
+		return err
+	}
+	return aw.Close()
+}
@@ -0,0 +1,256 @@
+package mail_test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/emersion/go-message"
+	"github.com/emersion/go-message/mail"
+)
+
+func TestForwardHeader(t *testing.T) {
+	tests := []struct {
+		subject, want string
+	}{
+		{"Your Name", "Fwd: Your Name"},
+		{"Fwd: Your Name", "Fwd: Your Name"},
+		{"FW: Your Name", "Fwd: Your Name"},
+		{"Re: Your Name", "Fwd: Your Name"},
+		{"[list] Fwd: Your Name", "Fwd: Your Name"},
+		{"Your Name (fwd)", "Fwd: Your Name"},
+	}
+
+	for _, tc := range tests {
+		var h mail.Header
+		h.SetSubject(tc.subject)
+		fwd := mail.ForwardHeader(&h)
+		if s, _ := fwd.Subject(); s != tc.want {
+			t.Errorf("ForwardHeader(%q) has subject %q, want %q", tc.subject, s, tc.want)
+		}
+	}
+}
+
+type forwardedPart struct {
+	inline   bool
+	filename string
+	body     string
+}
+
+func readForwardedParts(t *testing.T, r io.Reader) []forwardedPart {
+	mr, err := mail.CreateReader(r)
+	if err != nil {
+		t.Fatalf("mail.CreateReader() = %v", err)
+	}
+	defer mr.Close()
+
+	var parts []forwardedPart
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("NextPart() = %v", err)
+		}
+
+		var fp forwardedPart
+		switch h := p.Header.(type) {
+		case *mail.InlineHeader:
+			fp.inline = true
+		case *mail.AttachmentHeader:
+			fp.filename, _ = h.Filename()
+		}
+		b, err := ioutil.ReadAll(p.Body)
+		if err != nil {
+			t.Fatalf("failed to read part body: %v", err)
+		}
+		fp.body = string(b)
+		parts = append(parts, fp)
+	}
+	return parts
+}
+
+func TestForwardAttachment(t *testing.T) {
+	e, err := message.Read(strings.NewReader(mailString))
+	if err != nil {
+		t.Fatalf("message.Read() = %v", err)
+	}
+
+	var b bytes.Buffer
+	h := mail.ForwardHeader(&mail.Header{e.Header})
+	mw, err := mail.CreateWriter(&b, h)
+	if err != nil {
+		t.Fatalf("mail.CreateWriter() = %v", err)
+	}
+	if err := mail.ForwardAttachment(mw, e); err != nil {
+		t.Fatalf("ForwardAttachment() = %v", err)
+	}
+	if err := mw.Close(); err != nil {
+		t.Fatalf("Writer.Close() = %v", err)
+	}
+
+	parts := readForwardedParts(t, &b)
+	if len(parts) != 1 || parts[0].filename != "Your Name.eml" {
+		t.Fatalf("forward has parts %+v, want a single Your Name.eml attachment", parts)
+	}
+	testReader(t, strings.NewReader(parts[0].body))
+}
+
+func TestForwardInline(t *testing.T) {
+	e, err := message.Read(strings.NewReader(pathMailString))
+	if err != nil {
+		t.Fatalf("message.Read() = %v", err)
+	}
+	e.Header.Set("From", "=?utf-8?q?Mitsuha_Miyamizu?= <mitsuha.miyamizu@example.org>")
+
+	var b bytes.Buffer
+	mw, err := mail.CreateWriter(&b, mail.ForwardHeader(&mail.Header{e.Header}))
+	if err != nil {
+		t.Fatalf("mail.CreateWriter() = %v", err)
+	}
+	if err := mail.ForwardInline(mw, strings.NewReader("See below."), e); err != nil {
+		t.Fatalf("ForwardInline() = %v", err)
+	}
+	if err := mw.Close(); err != nil {
+		t.Fatalf("Writer.Close() = %v", err)
+	}
+
+	want := []forwardedPart{
+		{
+			inline: true,
+			body: "See below.\r\n\r\n" +
+				"---------- Forwarded message ----------\r\n" +
+				"From: Mitsuha Miyamizu <mitsuha.miyamizu@example.org>\r\n" +
+				"Subject: Your Name\r\n" +
+				"\r\n" +
+				"Who are you?",
+		},
+		{filename: "note.txt", body: "I'm Mitsuha."},
+	}
+	parts := readForwardedParts(t, &b)
+	if len(parts) != len(want) {
+		t.Fatalf("forward has parts %+v, want %+v", parts, want)
+	}
+	for i := range want {
+		if parts[i] != want[i] {
+			t.Errorf("forward part #%v = %+v, want %+v", i, parts[i], want[i])
+		}
+	}
+}
+
+func TestForwardInline_noText(t *testing.T) {
+	s := "Subject: Your Name\r\n" +
+		"Content-Type: image/png\r\n" +
+		"\r\n" +
+		"PNG"
+	e, err := message.Read(strings.NewReader(s))
+	if err != nil {
+		t.Fatalf("message.Read() = %v", err)
+	}
+
+	var b bytes.Buffer
+	mw, err := mail.CreateWriter(&b, mail.Header{})
+	if err != nil {
+		t.Fatalf("mail.CreateWriter() = %v", err)
+	}
+	if err := mail.ForwardInline(mw, nil, e); err != nil {
+		t.Fatalf("ForwardInline() = %v", err)
+	}
+	if err := mw.Close(); err != nil {
+		t.Fatalf("Writer.Close() = %v", err)
+	}
+
+	parts := readForwardedParts(t, &b)
+	if len(parts) != 2 || !parts[0].inline || parts[1].inline || parts[1].body != "PNG" {
+		t.Errorf("forward has parts %+v, want a text part and a PNG attachment", parts)
+	}
+}
+
+func TestForwardInline_charset(t *testing.T) {
+	defer func(cr func(string, io.Reader) (io.Reader, error)) {
+		message.CharsetReader = cr
+	}(message.CharsetReader)
+	message.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		if charset != "iso-8859-1" {
+			return nil, fmt.Errorf("unhandled charset %q", charset)
+		}
+		b, err := ioutil.ReadAll(input)
+		if err != nil {
+			return nil, err
+		}
+		runes := make([]rune, len(b))
+		for i, c := range b {
+			runes[i] = rune(c)
+		}
+		return strings.NewReader(string(runes)), nil
+	}
+
+	s := "Subject: Your Name\r\n" +
+		"Content-Type: multipart/mixed; boundary=IMTHEBOUNDARY\r\n" +
+		"\r\n" +
+		"--IMTHEBOUNDARY\r\n" +
+		"Content-Type: text/html; charset=iso-8859-1\r\n" +
+		"\r\n" +
+		"Caf\xe9\r\n" +
+		"--IMTHEBOUNDARY\r\n" +
+		"Content-Type: text/plain; charset=x-unknown\r\n" +
+		"Content-Disposition: attachment; filename=note.txt\r\n" +
+		"\r\n" +
+		"Caf\xe9\r\n" +
+		"--IMTHEBOUNDARY--\r\n"
+	e, err := message.Read(strings.NewReader(s))
+	if err != nil {
+		t.Fatalf("message.Read() = %v", err)
+	}
+
+	var b bytes.Buffer
+	mw, err := mail.CreateWriter(&b, mail.Header{})
+	if err != nil {
+		t.Fatalf("mail.CreateWriter() = %v", err)
+	}
+	if err := mail.ForwardInline(mw, nil, e); err != nil {
+		t.Fatalf("ForwardInline() = %v", err)
+	}
+	if err := mw.Close(); err != nil {
+		t.Fatalf("Writer.Close() = %v", err)
+	}
+
+	mr, err := mail.CreateReader(&b)
+	if err != nil {
+		t.Fatalf("mail.CreateReader() = %v", err)
+	}
+	defer mr.Close()
+
+	want := []struct {
+		mediaType, charset, body string
+	}{
+		{"text/plain", "utf-8", "---------- Forwarded message ----------\r\nSubject: Your Name\r\n\r\n"},
+		{"text/html", "utf-8", "Café"},
+		{"application/octet-stream", "", "Caf\xe9"},
+	}
+	for i, w := range want {
+		p, err := mr.NextPart()
+		if err != nil {
+			t.Fatalf("NextPart() = %v", err)
+		}
+		var h message.Header
+		switch ph := p.Header.(type) {
+		case *mail.InlineHeader:
+			h = ph.Header
+		case *mail.AttachmentHeader:
+			h = ph.Header
+		}
+		mediaType, params, _ := h.ContentType()
+		if mediaType != w.mediaType || params["charset"] != w.charset {
+			t.Errorf("part #%v has type %q and charset %q, want %q and %q", i, mediaType, params["charset"], w.mediaType, w.charset)
+		}
+		if b, err := ioutil.ReadAll(p.Body); err != nil {
+			t.Errorf("ReadAll() = %v", err)
+		} else if string(b) != w.body {
+			t.Errorf("part #%v has body %q, want %q", i, string(b), w.body)
+		}
+	}
+}
@@ -19,6 +19,8 @@ const dateLayout = "Mon, 02 Jan 2006 15:04:05 -0700"
 
 type headerParser struct {
 	s string
+	// raw disables RFC 2047 decoding of display names
+	raw bool
 }
 
 func (p *headerParser) len() int {
@@ -250,6 +252,33 @@ func (h *Header) SetAddressList(key string, addrs []*Address) {
 	}
 }
 
+// AddressGroups parses the named header field as a list of addresses and
+// groups, as defined in RFC 5322 section 3.4. Consecutive addresses which
+// don't belong to a group are returned in a Group with an empty Name. If the
+// header field is missing, it returns nil.
+//
+// This can be used on From, Sender, Reply-To, To, Cc and Bcc header fields.
+func (h *Header) AddressGroups(key string) ([]*Group, error) {
+	v := h.Get(key)
+	if v == "" {
+		return nil, nil
+	}
+	return ParseAddressGroups(v)
+}
+
+// SetAddressGroups formats the named header field to the provided list of
+// addresses and groups. Addresses of groups with an empty Name are written
+// without a group.
+//
+// This can be used on From, Sender, Reply-To, To, Cc and Bcc header fields.
+func (h *Header) SetAddressGroups(key string, groups []*Group) {
+	if v := formatGroupList(groups); v != "" {
+		h.Set(key, v)
+	} else {
+		h.Del(key)
+	}
+}
+
 // Date parses the Date header field. If the header field is missing, it
 // returns the zero time.
 func (h *Header) Date() (time.Time, error) {
@@ -289,7 +318,7 @@ func (h *Header) MessageID() (string, error) {
 		return "", nil
 	}
 
-	p := headerParser{v}
+	p := headerParser{s: v}
 	return p.parseMsgID()
 }
 
@@ -304,7 +333,7 @@ func (h *Header) MsgIDList(key string) ([]string, error) {
 		return nil, nil
 	}
 
-	p := headerParser{v}
+	p := headerParser{s: v}
 	var l []string
 	for !p.empty() {
 		msgID, err := p.parseMsgID()
@@ -336,17 +365,24 @@ func (h *Header) GenerateMessageID() error {
 // IDs", it takes an hostname as argument, so that software using this library
 // could use a hostname they know to be unique
 func (h *Header) GenerateMessageIDWithHostname(hostname string) error {
+	msgID, err := generateMsgID(hostname)
+	if err != nil {
+		return err
+	}
+	h.SetMessageID(msgID)
+	return nil
+}
+
+func generateMsgID(hostname string) (string, error) {
 	now := uint64(time.Now().UnixNano())
 
 	nonceByte := make([]byte, 8)
 	if _, err := rand.Read(nonceByte); err != nil {
-		return err
+		return "", err
 	}
 	nonce := binary.BigEndian.Uint64(nonceByte)
 
-	msgID := fmt.Sprintf("%s.%s@%s", base36(now), base36(nonce), hostname)
-	h.SetMessageID(msgID)
-	return nil
+	return fmt.Sprintf("%s.%s@%s", base36(now), base36(nonce), hostname), nil
 }
 
 func base36(input uint64) string {
@@ -224,3 +224,72 @@ func TestHeader_EmptyAddressList(t *testing.T) {
 		}
 	}
 }
+
+func TestHeader_AddressGroups(t *testing.T) {
+	tests := []struct {
+		raw    string
+		groups []*mail.Group
+	}{
+		{
+			raw: `Team: "A" <a@example.org>, "B" <b@example.org>;`,
+			groups: []*mail.Group{{
+				Name: "Team",
+				Addresses: []*mail.Address{
+					{Name: "A", Address: "a@example.org"},
+					{Name: "B", Address: "b@example.org"},
+				},
+			}},
+		},
+		{
+			raw:    `undisclosed-recipients:;`,
+			groups: []*mail.Group{{Name: "undisclosed-recipients"}},
+		},
+		{
+			raw: `<a@example.org>, <b@example.org>, Team: <c@example.org>;, <d@example.org>`,
+			groups: []*mail.Group{
+				{Addresses: []*mail.Address{{Address: "a@example.org"}, {Address: "b@example.org"}}},
+				{Name: "Team", Addresses: []*mail.Address{{Address: "c@example.org"}}},
+				{Addresses: []*mail.Address{{Address: "d@example.org"}}},
+			},
+		},
+		{
+			raw: `"Team, Inc.": <a@example.org>;`,
+			groups: []*mail.Group{{
+				Name:      "Team, Inc.",
+				Addresses: []*mail.Address{{Address: "a@example.org"}},
+			}},
+		},
+		{
+			raw: `=?utf-8?q?=C3=89quipe?=: <a@example.org>;`,
+			groups: []*mail.Group{{
+				Name:      "Équipe",
+				Addresses: []*mail.Address{{Address: "a@example.org"}},
+			}},
+		},
+	}
+
+	for _, test := range tests {
+		var h mail.Header
+		h.Set("To", test.raw)
+		if got, err := h.AddressGroups("To"); err != nil {
+			t.Errorf("AddressGroups(%q) = %v", test.raw, err)
+		} else if !reflect.DeepEqual(got, test.groups) {
+			t.Errorf("AddressGroups(%q) = %v, want %v", test.raw, got, test.groups)
+		}
+
+		h.SetAddressGroups("To", test.groups)
+		if s := h.Get("To"); s != test.raw {
+			t.Errorf("SetAddressGroups() = %q, want %q", s, test.raw)
+		}
+	}
+}
+
+func TestHeader_AddressList_emptyGroup(t *testing.T) {
+	var h mail.Header
+	h.Set("To", "undisclosed-recipients:;")
+	if got, err := h.AddressList("To"); err != nil {
+		t.Errorf("AddressList() = %v", err)
+	} else if len(got) != 0 {
+		t.Errorf("AddressList() = %v, want an empty list", got)
+	}
+}
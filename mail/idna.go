@@ -0,0 +1,71 @@
+package mail
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// checkDomainLabels checks that a domain name doesn't contain empty labels.
+func checkDomainLabels(domain string) error {
+	for _, label := range strings.Split(domain, ".") {
+		if label == "" {
+			return fmt.Errorf("mail: empty label in domain %q", domain)
+		}
+	}
+	return nil
+}
+
+// DomainToASCII converts an internationalized domain name to its ASCII form:
+// labels containing non-ASCII characters (U-labels) are mapped and converted
+// to A-labels, as defined in RFC 5891 section 5. Domain literals are returned
+// unchanged.
+//
+// This can be used to send a message to an internationalized address through
+// a server which doesn't support SMTPUTF8, as long as the local part is ASCII.
+func DomainToASCII(domain string) (string, error) {
+	if strings.HasPrefix(domain, "[") {
+		return domain, nil
+	}
+	if err := checkDomainLabels(domain); err != nil {
+		return "", err
+	}
+
+	ascii, err := idna.Lookup.ToASCII(domain)
+	if err != nil {
+		return "", fmt.Errorf("mail: invalid domain %q: %v", domain, err)
+	}
+	return ascii, nil
+}
+
+// DomainToUnicode converts a domain name to its Unicode form: A-labels are
+// converted to U-labels, as defined in RFC 5891 section 5. Domain literals
+// are returned unchanged.
+func DomainToUnicode(domain string) (string, error) {
+	if strings.HasPrefix(domain, "[") {
+		return domain, nil
+	}
+	if err := checkDomainLabels(domain); err != nil {
+		return "", err
+	}
+
+	unicode, err := idna.Lookup.ToUnicode(domain)
+	if err != nil {
+		return "", fmt.Errorf("mail: invalid domain %q: %v", domain, err)
+	}
+	// RFC 5891 section 5.4: the A-labels must round-trip
+	for _, label := range strings.Split(domain, ".") {
+		if len(label) < 4 || !strings.EqualFold(label[:4], "xn--") {
+			continue
+		}
+		u, err := idna.Lookup.ToUnicode(label)
+		if err != nil {
+			return "", fmt.Errorf("mail: invalid domain %q: %v", domain, err)
+		}
+		if ascii, err := idna.Lookup.ToASCII(u); err != nil || !strings.EqualFold(ascii, label) {
+			return "", fmt.Errorf("mail: invalid A-label in domain %q", domain)
+		}
+	}
+	return unicode, nil
+}
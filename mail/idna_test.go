@@ -0,0 +1,69 @@
+package mail
+
+import (
+	"testing"
+)
+
+var domainTests = []struct {
+	unicode string
+	ascii   string
+}{
+	{"example.org", "example.org"},
+	{"bücher.example", "xn--bcher-kva.example"},
+	{"münchen.de", "xn--mnchen-3ya.de"},
+	{"例子.测试", "xn--fsqu00a.xn--0zwm56d"},
+	{"пример.испытание", "xn--e1afmkfd.xn--80akhbyknj4f"},
+	{"[192.0.2.1]", "[192.0.2.1]"},
+}
+
+func TestDomainToASCII(t *testing.T) {
+	for _, test := range domainTests {
+		if got, err := DomainToASCII(test.unicode); err != nil {
+			t.Errorf("DomainToASCII(%q) = %v", test.unicode, err)
+		} else if got != test.ascii {
+			t.Errorf("DomainToASCII(%q) = %q, want %q", test.unicode, got, test.ascii)
+		}
+	}
+
+	if got, err := DomainToASCII("BÜCHER.example"); err != nil {
+		t.Errorf("DomainToASCII() = %v", err)
+	} else if got != "xn--bcher-kva.example" {
+		t.Errorf("DomainToASCII() = %q, want %q", got, "xn--bcher-kva.example")
+	}
+
+	for _, domain := range []string{"", "example..org", ".example.org"} {
+		if _, err := DomainToASCII(domain); err == nil {
+			t.Errorf("DomainToASCII(%q): expected an error", domain)
+		}
+	}
+}
+
+func TestDomainToUnicode(t *testing.T) {
+	for _, test := range domainTests {
+		if got, err := DomainToUnicode(test.ascii); err != nil {
+			t.Errorf("DomainToUnicode(%q) = %v", test.ascii, err)
+		} else if got != test.unicode {
+			t.Errorf("DomainToUnicode(%q) = %q, want %q", test.ascii, got, test.unicode)
+		}
+	}
+
+	// U-labels and mixed A-labels and U-labels
+	for _, test := range []struct{ domain, want string }{
+		{"bücher.example", "bücher.example"},
+		{"例子.广告", "例子.广告"},
+		{"xn--bcher-kva.例子", "bücher.例子"},
+		{"例子.xn--bcher-kva.example", "例子.bücher.example"},
+	} {
+		if got, err := DomainToUnicode(test.domain); err != nil {
+			t.Errorf("DomainToUnicode(%q) = %v", test.domain, err)
+		} else if got != test.want {
+			t.Errorf("DomainToUnicode(%q) = %q, want %q", test.domain, got, test.want)
+		}
+	}
+
+	for _, domain := range []string{"xn--.example", "xn--abc-.example", "xn--!!!.example"} {
+		if _, err := DomainToUnicode(domain); err == nil {
+			t.Errorf("DomainToUnicode(%q): expected an error", domain)
+		}
+	}
+}
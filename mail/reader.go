@@ -30,6 +30,26 @@ type PartHeader interface {
 type Part struct {
 	Header PartHeader
 	Body   io.Reader
+
+	// The path of the part in the MIME tree, with the same format as the path
+	// passed to message.WalkFunc. It is nil if the message isn't multipart.
+	// IMAP part numbers are the path elements plus one.
+	Path []int
+	// The media type of the multipart entity containing the part, e.g.
+	// "multipart/alternative". It is empty if the message isn't multipart.
+	ParentMediaType string
+	// The content identifier of the part, parsed from the Content-ID header
+	// field. It is empty if the part doesn't have a valid Content-ID.
+	ContentID string
+}
+
+// readerFrame is a multipart entity being read by a Reader.
+type readerFrame struct {
+	mr        message.MultipartReader
+	mediaType string
+	path      []int
+	// index of the last part read, -1 if none
+	index int
 }
 
 // A Reader reads a mail message.
@@ -42,19 +62,21 @@ type Reader struct {
 
 // NewReader creates a new mail reader.
 func NewReader(e *message.Entity) *Reader {
-	mr := e.MultipartReader()
-	if mr == nil {
+	l := list.New()
+
+	if mr := e.MultipartReader(); mr != nil {
+		mediaType, _, _ := e.Header.ContentType()
+		l.PushBack(&readerFrame{mr: mr, mediaType: mediaType, index: -1})
+	} else {
 		// Artificially create a multipart entity
 		// With this header, no error will be returned by message.NewMultipart
 		var h message.Header
 		h.Set("Content-Type", "multipart/mixed")
 		me, _ := message.NewMultipart(h, []*message.Entity{e})
-		mr = me.MultipartReader()
+		// The frame has no media type, so that the part gets a nil path
+		l.PushBack(&readerFrame{mr: me.MultipartReader(), index: -1})
 	}
 
-	l := list.New()
-	l.PushBack(mr)
-
 	return &Reader{Header{e.Header}, e, l}
 }
 
@@ -84,9 +106,9 @@ func CreateReader(r io.Reader) (*Reader, error) {
 func (r *Reader) NextPart() (*Part, error) {
 	for r.readers.Len() > 0 {
 		e := r.readers.Back()
-		mr := e.Value.(message.MultipartReader)
+		frame := e.Value.(*readerFrame)
 
-		p, err := mr.NextPart()
+		p, err := frame.mr.NextPart()
 		if err == io.EOF {
 			// This whole multipart entity has been read, continue with the next one
 			r.readers.Remove(e)
@@ -95,12 +117,26 @@ func (r *Reader) NextPart() (*Part, error) {
 			return nil, err
 		}
 
+		frame.index++
+		var path []int
+		if frame.mediaType != "" {
+			path = make([]int, len(frame.path)+1)
+			copy(path, frame.path)
+			path[len(frame.path)] = frame.index
+		}
+
 		if pmr := p.MultipartReader(); pmr != nil {
 			// This is a multipart part, read it
-			r.readers.PushBack(pmr)
+			mediaType, _, _ := p.Header.ContentType()
+			r.readers.PushBack(&readerFrame{mr: pmr, mediaType: mediaType, path: path, index: -1})
 		} else {
 			// This is a non-multipart part, return a mail part
-			mp := &Part{Body: p.Body}
+			mp := &Part{
+				Body:            p.Body,
+				Path:            path,
+				ParentMediaType: frame.mediaType,
+			}
+			mp.ContentID, _ = contentID(&p.Header)
 			t, _, _ := p.Header.ContentType()
 			disp, _, _ := p.Header.ContentDisposition()
 			if disp == "inline" || (disp != "attachment" && strings.HasPrefix(t, "text/")) {
@@ -119,9 +155,9 @@ func (r *Reader) NextPart() (*Part, error) {
 func (r *Reader) Close() error {
 	for r.readers.Len() > 0 {
 		e := r.readers.Back()
-		mr := e.Value.(message.MultipartReader)
+		frame := e.Value.(*readerFrame)
 
-		if err := mr.Close(); err != nil {
+		if err := frame.mr.Close(); err != nil {
 			return err
 		}
 
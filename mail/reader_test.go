@@ -4,6 +4,7 @@ import (
 	"io"
 	"io/ioutil"
 	"log"
+	"reflect"
 	"strings"
 	"testing"
 
@@ -142,6 +143,92 @@ func TestReader_nonMultipart(t *testing.T) {
 	}
 }
 
+const pathMailString = "Subject: Your Name\r\n" +
+	"Content-Type: multipart/mixed; boundary=message-boundary\r\n" +
+	"\r\n" +
+	"--message-boundary\r\n" +
+	"Content-Type: multipart/alternative; boundary=text-boundary\r\n" +
+	"\r\n" +
+	"--text-boundary\r\n" +
+	"Content-Type: text/plain\r\n" +
+	"\r\n" +
+	"Who are you?\r\n" +
+	"--text-boundary\r\n" +
+	"Content-Type: multipart/related; boundary=related-boundary; type=\"text/html\"\r\n" +
+	"\r\n" +
+	"--related-boundary\r\n" +
+	"Content-Type: text/html\r\n" +
+	"\r\n" +
+	"<p>Who are you?</p><img src=\"cid:comet@example.org\">\r\n" +
+	"--related-boundary\r\n" +
+	"Content-Type: image/png\r\n" +
+	"Content-Id: <comet@example.org>\r\n" +
+	"\r\n" +
+	"PNG\r\n" +
+	"--related-boundary--\r\n" +
+	"--text-boundary--\r\n" +
+	"--message-boundary\r\n" +
+	"Content-Type: text/plain\r\n" +
+	"Content-Disposition: attachment; filename=note.txt\r\n" +
+	"\r\n" +
+	"I'm Mitsuha.\r\n" +
+	"--message-boundary--\r\n"
+
+func TestReader_path(t *testing.T) {
+	mr, err := mail.CreateReader(strings.NewReader(pathMailString))
+	if err != nil {
+		t.Fatalf("mail.CreateReader() = %v", err)
+	}
+	defer mr.Close()
+
+	type partInfo struct {
+		path            []int
+		parentMediaType string
+		contentID       string
+	}
+	want := []partInfo{
+		{[]int{0, 0}, "multipart/alternative", ""},
+		{[]int{0, 1, 0}, "multipart/related", ""},
+		{[]int{0, 1, 1}, "multipart/related", "comet@example.org"},
+		{[]int{1}, "multipart/mixed", ""},
+	}
+
+	var got []partInfo
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("NextPart() = %v", err)
+		}
+		got = append(got, partInfo{p.Path, p.ParentMediaType, p.ContentID})
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("NextPart() returned parts %+v, want %+v", got, want)
+	}
+}
+
+func TestReader_pathNonMultipart(t *testing.T) {
+	s := "Subject: Your Name\r\n" +
+		"\r\n" +
+		"Who are you?"
+
+	mr, err := mail.CreateReader(strings.NewReader(s))
+	if err != nil {
+		t.Fatalf("mail.CreateReader() = %v", err)
+	}
+	defer mr.Close()
+
+	p, err := mr.NextPart()
+	if err != nil {
+		t.Fatalf("NextPart() = %v", err)
+	}
+	if p.Path != nil || p.ParentMediaType != "" {
+		t.Errorf("NextPart() = part with path %v and parent %q, want none", p.Path, p.ParentMediaType)
+	}
+}
+
 func TestReader_closeImmediately(t *testing.T) {
 	s := "Content-Type: text/plain\r\n" +
 		"\r\n" +
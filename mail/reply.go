@@ -0,0 +1,172 @@
+package mail
+
+import (
+	"strings"
+)
+
+// maxReferencesLen is the maximum length of the References header field
+// generated by ReplyHeader, to stay below the line length limit of RFC 5322.
+const maxReferencesLen = 998 - len("References: ")
+
+// ReplyOptions are options for ReplyHeader.
+type ReplyOptions struct {
+	// All enables replying to all recipients of the original message, instead
+	// of only to its author.
+	All bool
+
+	// Addresses are the addresses of the user replying. They are removed from
+	// the recipients of the reply.
+	Addresses []*Address
+}
+
+// ReplyHeader creates the header of a reply to a message with the header h.
+// It sets the Subject, In-Reply-To, References, To and Cc header fields, as
+// described in RFC 5322 section 3.6.4. The caller is responsible for setting
+// the other header fields, such as From, Date and Message-ID.
+//
+// The reply is sent to the addresses in the Reply-To header field if present,
+// or to the author of the message otherwise. When replying to all recipients,
+// the Mail-Followup-To header field is used if present. Otherwise, the
+// recipients of the message are added to the Cc header field.
+//
+// Malformed Message-ID, In-Reply-To and References header fields don't cause
+// an error: the message identifiers which can be parsed are kept, the others
+// are skipped.
+func ReplyHeader(h *Header, opts *ReplyOptions) (Header, error) {
+	if opts == nil {
+		opts = &ReplyOptions{}
+	}
+
+	var reply Header
+
+	// Decoding errors are ignored: the raw value is returned in that case
+	subject, _ := h.Subject()
+	reply.SetSubject(replySubject(subject))
+
+	refs := replyReferences(h)
+	if msgID, err := h.MessageID(); err == nil && msgID != "" {
+		reply.SetMsgIDList("In-Reply-To", []string{msgID})
+		refs = append(refs, msgID)
+	}
+	reply.SetMsgIDList("References", trimReferences(refs))
+
+	to, cc, err := replyRecipients(h, opts)
+	if err != nil {
+		return reply, err
+	}
+	if len(to) > 0 {
+		reply.SetAddressList("To", to)
+	}
+	if len(cc) > 0 {
+		reply.SetAddressList("Cc", cc)
+	}
+
+	return reply, nil
+}
+
+// replySubject prepends "Re: " to the base subject of a subject, as defined
+// by BaseSubject.
+func replySubject(subject string) string {
+	base, _ := BaseSubject(subject)
+	return "Re: " + base
+}
+
+// replyReferences returns the identifiers of the parent messages of the
+// message with the header h. Parsing errors are ignored: the identifiers
+// parsed before an error are kept.
+func replyReferences(h *Header) []string {
+	if refs, _ := h.MsgIDList("References"); len(refs) > 0 {
+		return refs
+	}
+
+	// RFC 5322 section 3.6.4: if there is no References field, use the
+	// In-Reply-To field if it contains a single identifier
+	if inReplyTo, err := h.MsgIDList("In-Reply-To"); err == nil && len(inReplyTo) == 1 {
+		return inReplyTo
+	}
+	return nil
+}
+
+// trimReferences removes message identifiers from a References list until it
+// fits in a header line. The first identifier, which is the root of the
+// thread, and the last three are kept, as recommended by RFC 5537 section
+// 3.4.4.
+func trimReferences(refs []string) []string {
+	n := 0
+	for _, ref := range refs {
+		n += len(ref) + len(" <>")
+	}
+
+	for n > maxReferencesLen && len(refs) > 4 {
+		n -= len(refs[1]) + len(" <>")
+		refs = append(refs[:1], refs[2:]...)
+	}
+	return refs
+}
+
+func containsAddress(l []*Address, addr *Address) bool {
+	for _, a := range l {
+		if strings.EqualFold(a.Address, addr.Address) {
+			return true
+		}
+	}
+	return false
+}
+
+// appendAddresses appends the addresses of src to dst, skipping the addresses
+// already in dst or in exclude.
+func appendAddresses(dst, src, exclude []*Address) []*Address {
+	for _, addr := range src {
+		if !containsAddress(dst, addr) && !containsAddress(exclude, addr) {
+			dst = append(dst, addr)
+		}
+	}
+	return dst
+}
+
+func replyRecipients(h *Header, opts *ReplyOptions) (to, cc []*Address, err error) {
+	if opts.All {
+		followupTo, err := h.AddressList("Mail-Followup-To")
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(followupTo) > 0 {
+			return appendAddresses(nil, followupTo, opts.Addresses), nil, nil
+		}
+	}
+
+	author, err := h.AddressList("Reply-To")
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(author) == 0 {
+		author, err = h.AddressList("From")
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	origTo, err := h.AddressList("To")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	to = appendAddresses(nil, author, opts.Addresses)
+	if len(to) == 0 {
+		// The message was sent by the user: reply to its recipients instead
+		to = appendAddresses(nil, origTo, opts.Addresses)
+		origTo = nil
+	}
+
+	if opts.All {
+		origCc, err := h.AddressList("Cc")
+		if err != nil {
+			return nil, nil, err
+		}
+		exclude := append(append([]*Address(nil), opts.Addresses...), to...)
+		cc = appendAddresses(nil, origTo, exclude)
+		cc = appendAddresses(cc, origCc, exclude)
+	}
+
+	return to, cc, nil
+}
@@ -0,0 +1,255 @@
+package mail_test
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/emersion/go-message/mail"
+)
+
+func addressStrings(t *testing.T, h *mail.Header, key string) []string {
+	l, err := h.AddressList(key)
+	if err != nil {
+		t.Fatalf("Header.AddressList(%q) = %v", key, err)
+	}
+	var s []string
+	for _, addr := range l {
+		s = append(s, addr.Address)
+	}
+	return s
+}
+
+func TestReplyHeader(t *testing.T) {
+	self := []*mail.Address{{Address: "taki.tachibana@example.org"}}
+
+	tests := []struct {
+		name   string
+		header map[string][]string
+		all    bool
+		to, cc []string
+	}{
+		{
+			name: "reply",
+			header: map[string][]string{
+				"From": {"Mitsuha Miyamizu <mitsuha.miyamizu@example.org>"},
+				"To":   {"taki.tachibana@example.org, tessie@example.org"},
+				"Cc":   {"sayaka@example.org"},
+			},
+			to: []string{"mitsuha.miyamizu@example.org"},
+		},
+		{
+			name: "reply-all",
+			header: map[string][]string{
+				"From": {"Mitsuha Miyamizu <mitsuha.miyamizu@example.org>"},
+				"To":   {"Taki.Tachibana@example.org, tessie@example.org"},
+				"Cc":   {"sayaka@example.org, mitsuha.miyamizu@example.org"},
+			},
+			all: true,
+			to:  []string{"mitsuha.miyamizu@example.org"},
+			cc:  []string{"tessie@example.org", "sayaka@example.org"},
+		},
+		{
+			name: "reply-to",
+			header: map[string][]string{
+				"From":     {"mitsuha.miyamizu@example.org"},
+				"Reply-To": {"list@example.org"},
+				"To":       {"list@example.org"},
+			},
+			all: true,
+			to:  []string{"list@example.org"},
+		},
+		{
+			name: "mail-followup-to",
+			header: map[string][]string{
+				"From":             {"mitsuha.miyamizu@example.org"},
+				"To":               {"list@example.org"},
+				"Cc":               {"taki.tachibana@example.org"},
+				"Mail-Followup-To": {"list@example.org, taki.tachibana@example.org"},
+			},
+			all: true,
+			to:  []string{"list@example.org"},
+		},
+		{
+			name: "mail-followup-to ignored",
+			header: map[string][]string{
+				"From":             {"mitsuha.miyamizu@example.org"},
+				"To":               {"list@example.org"},
+				"Mail-Followup-To": {"list@example.org"},
+			},
+			to: []string{"mitsuha.miyamizu@example.org"},
+		},
+		{
+			name: "own message",
+			header: map[string][]string{
+				"From": {"taki.tachibana@example.org"},
+				"To":   {"mitsuha.miyamizu@example.org"},
+				"Cc":   {"tessie@example.org"},
+			},
+			all: true,
+			to:  []string{"mitsuha.miyamizu@example.org"},
+			cc:  []string{"tessie@example.org"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			h := mail.HeaderFromMap(tc.header)
+			reply, err := mail.ReplyHeader(&h, &mail.ReplyOptions{All: tc.all, Addresses: self})
+			if err != nil {
+				t.Fatalf("ReplyHeader() = %v", err)
+			}
+			if to := addressStrings(t, &reply, "To"); !reflect.DeepEqual(to, tc.to) {
+				t.Errorf("To = %v, want %v", to, tc.to)
+			}
+			if cc := addressStrings(t, &reply, "Cc"); !reflect.DeepEqual(cc, tc.cc) {
+				t.Errorf("Cc = %v, want %v", cc, tc.cc)
+			}
+		})
+	}
+}
+
+func TestReplyHeader_subject(t *testing.T) {
+	tests := []struct {
+		subject, want string
+	}{
+		{"", "Re: "},
+		{"Your Name", "Re: Your Name"},
+		{"Re: Your Name", "Re: Your Name"},
+		{"RE:Your Name", "Re: Your Name"},
+		{"Fwd: Your Name", "Re: Your Name"},
+		{"RE : hello", "Re: hello"},
+		{"Re[2]: hello", "Re: hello"},
+		{"[list] Re: hello", "Re: hello"},
+		{"Re: Re: hello", "Re: hello"},
+	}
+
+	for _, tc := range tests {
+		var h mail.Header
+		h.SetSubject(tc.subject)
+		reply, err := mail.ReplyHeader(&h, nil)
+		if err != nil {
+			t.Fatalf("ReplyHeader() = %v", err)
+		}
+		if s, _ := reply.Subject(); s != tc.want {
+			t.Errorf("ReplyHeader(%q) has subject %q, want %q", tc.subject, s, tc.want)
+		}
+	}
+}
+
+func TestReplyHeader_references(t *testing.T) {
+	tests := []struct {
+		name       string
+		header     map[string][]string
+		inReplyTo  []string
+		references []string
+	}{
+		{
+			name:   "no message id",
+			header: map[string][]string{},
+		},
+		{
+			name:       "first reply",
+			header:     map[string][]string{"Message-Id": {"<b@example.org>"}},
+			inReplyTo:  []string{"b@example.org"},
+			references: []string{"b@example.org"},
+		},
+		{
+			name: "references",
+			header: map[string][]string{
+				"Message-Id":  {"<c@example.org>"},
+				"In-Reply-To": {"<b@example.org>"},
+				"References":  {"<a@example.org> <b@example.org>"},
+			},
+			inReplyTo:  []string{"c@example.org"},
+			references: []string{"a@example.org", "b@example.org", "c@example.org"},
+		},
+		{
+			name: "in-reply-to",
+			header: map[string][]string{
+				"Message-Id":  {"<c@example.org>"},
+				"In-Reply-To": {"<b@example.org>"},
+			},
+			inReplyTo:  []string{"c@example.org"},
+			references: []string{"b@example.org", "c@example.org"},
+		},
+		{
+			name: "ambiguous in-reply-to",
+			header: map[string][]string{
+				"Message-Id":  {"<c@example.org>"},
+				"In-Reply-To": {"<a@example.org> <b@example.org>"},
+			},
+			inReplyTo:  []string{"c@example.org"},
+			references: []string{"c@example.org"},
+		},
+		{
+			name: "malformed message id",
+			header: map[string][]string{
+				"Message-Id":  {"garbage"},
+				"In-Reply-To": {"<b@example.org>"},
+			},
+			references: []string{"b@example.org"},
+		},
+		{
+			name: "malformed references",
+			header: map[string][]string{
+				"Message-Id":  {"<c@example.org>"},
+				"In-Reply-To": {"<b@example.org>"},
+				"References":  {"<a@example.org> garbage"},
+			},
+			inReplyTo:  []string{"c@example.org"},
+			references: []string{"a@example.org", "c@example.org"},
+		},
+		{
+			name: "malformed in-reply-to",
+			header: map[string][]string{
+				"Message-Id":  {"<c@example.org>"},
+				"In-Reply-To": {"<b@example.org"},
+			},
+			inReplyTo:  []string{"c@example.org"},
+			references: []string{"c@example.org"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			h := mail.HeaderFromMap(tc.header)
+			reply, err := mail.ReplyHeader(&h, nil)
+			if err != nil {
+				t.Fatalf("ReplyHeader() = %v", err)
+			}
+			if l, _ := reply.MsgIDList("In-Reply-To"); !reflect.DeepEqual(l, tc.inReplyTo) {
+				t.Errorf("In-Reply-To = %v, want %v", l, tc.inReplyTo)
+			}
+			if l, _ := reply.MsgIDList("References"); !reflect.DeepEqual(l, tc.references) {
+				t.Errorf("References = %v, want %v", l, tc.references)
+			}
+		})
+	}
+}
+
+func TestReplyHeader_trimReferences(t *testing.T) {
+	var refs []string
+	for i := 0; i < 100; i++ {
+		refs = append(refs, fmt.Sprintf("%v@example.org", i))
+	}
+
+	var h mail.Header
+	h.SetMsgIDList("References", refs[:99])
+	h.SetMessageID(refs[99])
+
+	reply, err := mail.ReplyHeader(&h, nil)
+	if err != nil {
+		t.Fatalf("ReplyHeader() = %v", err)
+	}
+	if v := reply.Get("References"); len(v) > 998 {
+		t.Errorf("References is %v bytes long, want at most 998", len(v))
+	}
+	l, err := reply.MsgIDList("References")
+	if err != nil {
+		t.Fatalf("Header.MsgIDList() = %v", err)
+	}
+	if l[0] != refs[0] || !reflect.DeepEqual(l[len(l)-3:], refs[97:]) {
+		t.Errorf("References = %v, want the first and last references to be kept", l)
+	}
+}
@@ -0,0 +1,217 @@
+package mail
+
+import (
+	"strings"
+)
+
+// LocalizedPrefixes is a list of localized reply and forward subject prefixes
+// commonly used by mail clients. It can be used in BaseSubjectOptions.
+var LocalizedPrefixes = []string{
+	"AW", "WG", // German
+	"SV", "VS", "VB", // Danish, Norwegian and Swedish
+	"Antw", "Doorst", // Dutch
+	"RIF",       // Italian
+	"TR",        // French
+	"RV",        // Spanish
+	"ENC",       // Portuguese
+	"Odp", "PD", // Polish
+	"YNT",               // Turkish
+	"ΑΠ", "ΣΧΕΤ", "ΠΡΘ", // Greek
+	"回复", "回覆", "答复", "转发", "轉寄", // Chinese
+}
+
+// BaseSubjectOptions are options for BaseSubjectWithOptions.
+type BaseSubjectOptions struct {
+	// Prefixes are additional reply and forward subject prefixes, matched
+	// case-insensitively, e.g. localized versions of "Re" and "Fwd". Prefixes
+	// don't include the colon. They can be followed by an ASCII or a
+	// full-width colon.
+	Prefixes []string
+}
+
+// BaseSubject parses the Subject header field and extracts its base subject,
+// as defined in RFC 5256 section 2.1. The base subject is used to sort and
+// thread messages: it doesn't contain reply and forward indicators, nor
+// subject blobs such as mailing list tags.
+//
+// If there is an error, the base subject of the raw field value is returned
+// alongside the error.
+func (h *Header) BaseSubject() (string, error) {
+	return h.BaseSubjectWithOptions(nil)
+}
+
+// BaseSubjectWithOptions see BaseSubject, but allows overriding some
+// parameters with BaseSubjectOptions.
+func (h *Header) BaseSubjectWithOptions(opts *BaseSubjectOptions) (string, error) {
+	subject, err := h.Subject()
+	base, _ := BaseSubjectWithOptions(subject, opts)
+	return base, err
+}
+
+// BaseSubject extracts the base subject of a decoded subject, as defined in
+// RFC 5256 section 2.1. isReply is true if the subject indicates a reply or a
+// forward.
+func BaseSubject(subject string) (base string, isReply bool) {
+	return BaseSubjectWithOptions(subject, nil)
+}
+
+// BaseSubjectWithOptions see BaseSubject, but allows overriding some
+// parameters with BaseSubjectOptions.
+func BaseSubjectWithOptions(subject string, opts *BaseSubjectOptions) (base string, isReply bool) {
+	if opts == nil {
+		opts = &BaseSubjectOptions{}
+	}
+	p := subjectParser{opts}
+	return p.baseSubject(subject)
+}
+
+// hasPrefixFold reports whether s begins with prefix, ignoring case.
+func hasPrefixFold(s, prefix string) bool {
+	return len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix)
+}
+
+// hasSuffixFold reports whether s ends with suffix, ignoring case.
+func hasSuffixFold(s, suffix string) bool {
+	return len(s) >= len(suffix) && strings.EqualFold(s[len(s)-len(suffix):], suffix)
+}
+
+// collapseSpace converts tabs and line breaks to spaces, and multiple spaces
+// to a single space.
+func collapseSpace(s string) string {
+	var sb strings.Builder
+	space := false
+	for _, r := range s {
+		switch r {
+		case ' ', '\t', '\r', '\n':
+			if !space {
+				sb.WriteByte(' ')
+			}
+			space = true
+		default:
+			sb.WriteRune(r)
+			space = false
+		}
+	}
+	return sb.String()
+}
+
+// blobLen returns the length of the subj-blob at the start of s, or 0 if s
+// doesn't start with a subj-blob.
+func blobLen(s string) int {
+	if !strings.HasPrefix(s, "[") {
+		return 0
+	}
+	i := strings.IndexAny(s[1:], "[]")
+	if i < 0 || s[1+i] != ']' {
+		return 0
+	}
+	n := i + 2
+	for n < len(s) && s[n] == ' ' {
+		n++
+	}
+	return n
+}
+
+type subjectParser struct {
+	opts *BaseSubjectOptions
+}
+
+// refwdLen returns the length of the subj-refwd at the start of s, or 0 if s
+// doesn't start with a subj-refwd.
+func (p *subjectParser) refwdLen(s string) int {
+	var n int
+	localized := false
+	switch {
+	case hasPrefixFold(s, "re"):
+		n = 2
+	case hasPrefixFold(s, "fwd"):
+		n = 3
+	case hasPrefixFold(s, "fw"):
+		n = 2
+	default:
+		for _, prefix := range p.opts.Prefixes {
+			if prefix != "" && hasPrefixFold(s, prefix) {
+				n = len(prefix)
+				localized = true
+				break
+			}
+		}
+		if n == 0 {
+			return 0
+		}
+	}
+
+	for n < len(s) && s[n] == ' ' {
+		n++
+	}
+	n += blobLen(s[n:])
+	if strings.HasPrefix(s[n:], ":") {
+		return n + 1
+	} else if localized && strings.HasPrefix(s[n:], "：") {
+		return n + len("：")
+	}
+	return 0
+}
+
+// leaderLen returns the length of the subj-leader at the start of s, or 0 if
+// s doesn't start with a subj-leader. isRefwd is true if the leader contains a
+// subj-refwd.
+func (p *subjectParser) leaderLen(s string) (n int, isRefwd bool) {
+	if strings.HasPrefix(s, " ") {
+		return 1, false
+	}
+	for {
+		l := blobLen(s[n:])
+		if l == 0 {
+			break
+		}
+		n += l
+	}
+	if l := p.refwdLen(s[n:]); l > 0 {
+		return n + l, true
+	}
+	return 0, false
+}
+
+func (p *subjectParser) baseSubject(s string) (base string, isReply bool) {
+	// Step 1: the subject is already decoded, normalize whitespace
+	s = collapseSpace(s)
+	for {
+		// Step 2: remove subj-trailer
+		for {
+			s = strings.TrimRight(s, " ")
+			if !hasSuffixFold(s, "(fwd)") {
+				break
+			}
+			s = s[:len(s)-len("(fwd)")]
+			isReply = true
+		}
+
+		// Steps 3 to 5: remove subj-leader and subj-blob
+		for {
+			prev := s
+			for {
+				n, isRefwd := p.leaderLen(s)
+				if n == 0 {
+					break
+				}
+				s = s[n:]
+				isReply = isReply || isRefwd
+			}
+			if n := blobLen(s); n > 0 && n < len(s) {
+				s = s[n:]
+			}
+			if s == prev {
+				break
+			}
+		}
+
+		// Step 6: remove subj-fwd-hdr and subj-fwd-trl
+		if hasPrefixFold(s, "[fwd:") && strings.HasSuffix(s, "]") {
+			s = s[len("[fwd:") : len(s)-1]
+			isReply = true
+			continue
+		}
+		return s, isReply
+	}
+}
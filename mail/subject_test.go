@@ -0,0 +1,80 @@
+package mail_test
+
+import (
+	"testing"
+
+	"github.com/emersion/go-message/mail"
+)
+
+var baseSubjectTests = []struct {
+	subject string
+	base    string
+	isReply bool
+}{
+	{"", "", false},
+	{"Your Name", "Your Name", false},
+	{"  Your \t Name  ", "Your Name", false},
+	{"Re: Your Name", "Your Name", true},
+	{"RE:Re: re : Your Name", "Your Name", true},
+	{"Fw: Your Name", "Your Name", true},
+	{"FWD: Your Name", "Your Name", true},
+	{"Re[2]: Your Name", "Your Name", true},
+	{"[taki] Re: Your Name", "Your Name", true},
+	{"[taki] [mitsuha] Your Name", "Your Name", false},
+	{"[taki]", "[taki]", false},
+	{"Your Name (fwd)", "Your Name", true},
+	{"Your Name (FWD) (fwd)", "Your Name", true},
+	{"[Fwd: Re: Your Name]", "Your Name", true},
+	{"Reply: Your Name", "Reply: Your Name", false},
+	{"Re: [taki] Your Name", "Your Name", true},
+}
+
+func TestBaseSubject(t *testing.T) {
+	for _, tc := range baseSubjectTests {
+		base, isReply := mail.BaseSubject(tc.subject)
+		if base != tc.base || isReply != tc.isReply {
+			t.Errorf("BaseSubject(%q) = %q, %v, want %q, %v", tc.subject, base, isReply, tc.base, tc.isReply)
+		}
+	}
+}
+
+func TestBaseSubjectWithOptions(t *testing.T) {
+	opts := &mail.BaseSubjectOptions{Prefixes: mail.LocalizedPrefixes}
+	tests := []struct {
+		subject string
+		base    string
+		isReply bool
+	}{
+		{"AW: Your Name", "Your Name", true},
+		{"Sv: WG: Re: Your Name", "Your Name", true},
+		{"回复：Your Name", "Your Name", true},
+		{"[taki] 转发: Your Name", "Your Name", true},
+		{"Re：Your Name", "Re：Your Name", false},
+		{"Awesome: Your Name", "Awesome: Your Name", false},
+	}
+
+	for _, tc := range tests {
+		base, isReply := mail.BaseSubjectWithOptions(tc.subject, opts)
+		if base != tc.base || isReply != tc.isReply {
+			t.Errorf("BaseSubjectWithOptions(%q) = %q, %v, want %q, %v", tc.subject, base, isReply, tc.base, tc.isReply)
+		}
+	}
+
+	if base, _ := mail.BaseSubject("AW: Your Name"); base != "AW: Your Name" {
+		t.Errorf("BaseSubject() = %q, want localized prefixes to be ignored by default", base)
+	}
+}
+
+func TestHeader_BaseSubject(t *testing.T) {
+	var h mail.Header
+	h.Set("Subject", "=?utf-8?q?Re:_[taki]_Kimi_no_Na_wa?=\r\n (fwd)")
+	if base, err := h.BaseSubject(); err != nil || base != "Kimi no Na wa" {
+		t.Errorf("Header.BaseSubject() = %q, %v, want %q", base, err, "Kimi no Na wa")
+	}
+
+	h.SetSubject("AW: Kimi no Na wa")
+	opts := &mail.BaseSubjectOptions{Prefixes: mail.LocalizedPrefixes}
+	if base, err := h.BaseSubjectWithOptions(opts); err != nil || base != "Kimi no Na wa" {
+		t.Errorf("Header.BaseSubjectWithOptions() = %q, %v, want %q", base, err, "Kimi no Na wa")
+	}
+}
@@ -18,38 +18,59 @@ func initInlineContentTransferEncoding(h *message.Header) {
 	}
 }
 
-func initInlineHeader(h *InlineHeader) {
+func initInlineHeader(h *InlineHeader, opts *message.WriterOptions) {
 	h.Set("Content-Disposition", "inline")
-	initInlineContentTransferEncoding(&h.Header)
+	if !autoEncoding(opts) {
+		initInlineContentTransferEncoding(&h.Header)
+	}
 }
 
-func initAttachmentHeader(h *AttachmentHeader) {
+func initAttachmentHeader(h *AttachmentHeader, opts *message.WriterOptions) {
 	disp, _, _ := h.ContentDisposition()
 	if disp != "attachment" {
 		h.Set("Content-Disposition", "attachment")
 	}
-	if !h.Has("Content-Transfer-Encoding") {
+	if !h.Has("Content-Transfer-Encoding") && !autoEncoding(opts) {
 		h.Set("Content-Transfer-Encoding", "base64")
 	}
 }
 
+// autoEncoding reports whether the Content-Transfer-Encoding of parts is
+// automatically selected by message.Writer.
+func autoEncoding(opts *message.WriterOptions) bool {
+	return opts != nil && opts.AutoEncoding
+}
+
 // A Writer writes a mail message. A mail message contains one or more text
 // parts and zero or more attachments.
 type Writer struct {
-	mw *message.Writer
+	mw   *message.Writer
+	opts *message.WriterOptions
+	// part containing the message, if it's encapsulated in another one
+	pw io.Closer
 }
 
 // CreateWriter writes a mail header to w and creates a new Writer.
 func CreateWriter(w io.Writer, header Header) (*Writer, error) {
+	return CreateWriterWithOptions(w, header, nil)
+}
+
+// CreateWriterWithOptions see CreateWriter, but allows overriding some
+// parameters with message.WriterOptions. The options also apply to the parts
+// of the message.
+//
+// For instance, setting UTF8Header writes the Subject header field and the
+// display names of addresses as raw UTF-8, as defined in RFC 6532.
+func CreateWriterWithOptions(w io.Writer, header Header, opts *message.WriterOptions) (*Writer, error) {
 	header = header.Copy() // don't modify the caller's view
 	header.Set("Content-Type", "multipart/mixed")
 
-	mw, err := message.CreateWriter(w, header.Header)
+	mw, err := message.CreateWriterWithOptions(w, header.Header, opts)
 	if err != nil {
 		return nil, err
 	}
 
-	return &Writer{mw}, nil
+	return &Writer{mw: mw, opts: opts}, nil
 }
 
 // CreateInlineWriter writes a mail header to w. The mail will contain an
@@ -64,7 +85,7 @@ func CreateInlineWriter(w io.Writer, header Header) (*InlineWriter, error) {
 		return nil, err
 	}
 
-	return &InlineWriter{mw}, nil
+	return &InlineWriter{mw, nil}, nil
 }
 
 // CreateSingleInlineWriter writes a mail header to w. The mail will contain a
@@ -87,7 +108,13 @@ func (w *Writer) CreateInline() (*InlineWriter, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &InlineWriter{mw}, nil
+	return &InlineWriter{mw, w.opts}, nil
+}
+
+// CreateRelated creates a RelatedWriter. An HTML part and the inline resources
+// it references, e.g. images, can be written to a RelatedWriter.
+func (w *Writer) CreateRelated() (*RelatedWriter, error) {
+	return createRelated(w.mw, w.opts)
 }
 
 // CreateSingleInline creates a new single text part with the provided header.
@@ -96,7 +123,7 @@ func (w *Writer) CreateInline() (*InlineWriter, error) {
 // text parts.
 func (w *Writer) CreateSingleInline(h InlineHeader) (io.WriteCloser, error) {
 	h = InlineHeader{h.Header.Copy()} // don't modify the caller's view
-	initInlineHeader(&h)
+	initInlineHeader(&h, w.opts)
 	return w.mw.CreatePart(h.Header)
 }
 
@@ -104,25 +131,81 @@ func (w *Writer) CreateSingleInline(h InlineHeader) (io.WriteCloser, error) {
 // of the part should be written to the returned io.WriteCloser.
 func (w *Writer) CreateAttachment(h AttachmentHeader) (io.WriteCloser, error) {
 	h = AttachmentHeader{h.Header.Copy()} // don't modify the caller's view
-	initAttachmentHeader(&h)
+	initAttachmentHeader(&h, w.opts)
+	return w.mw.CreatePart(h.Header)
+}
+
+// CreateMessage creates a new message/rfc822 attachment with the provided
+// header, containing a new message with the provided mail header. The parts of
+// the encapsulated message should be written to the returned Writer, which
+// must be closed before writing other parts.
+func (w *Writer) CreateMessage(h AttachmentHeader, header Header) (*Writer, error) {
+	pw, err := w.createMessagePart(h)
+	if err != nil {
+		return nil, err
+	}
+
+	mw, err := CreateWriterWithOptions(pw, header, w.opts)
+	if err != nil {
+		return nil, err
+	}
+	mw.pw = pw
+	return mw, nil
+}
+
+// AttachMessage writes e as a message/rfc822 attachment with the provided
+// header. e is consumed.
+func (w *Writer) AttachMessage(h AttachmentHeader, e *message.Entity) error {
+	pw, err := w.createMessagePart(h)
+	if err != nil {
+		return err
+	}
+	if err := e.WriteTo(pw); err != nil {
+		return err
+	}
+	return pw.Close()
+}
+
+func (w *Writer) createMessagePart(h AttachmentHeader) (*message.Writer, error) {
+	h = AttachmentHeader{h.Header.Copy()} // don't modify the caller's view
+	if t, _, _ := h.ContentType(); t != "message/rfc822" && t != "message/global" {
+		h.Set("Content-Type", "message/rfc822")
+	}
+	disp, _, _ := h.ContentDisposition()
+	if disp != "attachment" {
+		h.Set("Content-Disposition", "attachment")
+	}
+	// RFC 2046 section 5.2.1: message/rfc822 can't be encoded with
+	// quoted-printable or base64, so prevent message.Writer from picking one
+	if !h.Has("Content-Transfer-Encoding") && autoEncoding(w.opts) {
+		h.Set("Content-Transfer-Encoding", "8bit")
+	}
 	return w.mw.CreatePart(h.Header)
 }
 
-// Close finishes the Writer.
+// Close finishes the Writer. If the Writer has been created with
+// CreateMessage, it also finishes the message/rfc822 attachment.
 func (w *Writer) Close() error {
-	return w.mw.Close()
+	if err := w.mw.Close(); err != nil {
+		return err
+	}
+	if w.pw != nil {
+		return w.pw.Close()
+	}
+	return nil
 }
 
 // InlineWriter writes a mail message's text.
 type InlineWriter struct {
-	mw *message.Writer
+	mw   *message.Writer
+	opts *message.WriterOptions
 }
 
 // CreatePart creates a new text part with the provided header. The body of the
 // part should be written to the returned io.WriteCloser.
 func (w *InlineWriter) CreatePart(h InlineHeader) (io.WriteCloser, error) {
 	h = InlineHeader{h.Header.Copy()} // don't modify the caller's view
-	initInlineHeader(&h)
+	initInlineHeader(&h, w.opts)
 	return w.mw.CreatePart(h.Header)
 }
 
@@ -130,3 +213,44 @@ func (w *InlineWriter) CreatePart(h InlineHeader) (io.WriteCloser, error) {
 func (w *InlineWriter) Close() error {
 	return w.mw.Close()
 }
+
+// CreateRelated creates a RelatedWriter, which can be used as the HTML
+// alternative of the text.
+func (w *InlineWriter) CreateRelated() (*RelatedWriter, error) {
+	return createRelated(w.mw, w.opts)
+}
+
+// RelatedWriter writes a multipart/related part, as defined in RFC 2387. It
+// contains an HTML part and the inline resources it references with cid:
+// URLs.
+//
+// The first part written is the HTML part. The following parts are inline
+// resources, which should have a Content-ID header field.
+type RelatedWriter struct {
+	mw   *message.Writer
+	opts *message.WriterOptions
+}
+
+func createRelated(mw *message.Writer, opts *message.WriterOptions) (*RelatedWriter, error) {
+	var h message.Header
+	h.SetContentType("multipart/related", map[string]string{"type": "text/html"})
+
+	rmw, err := mw.CreatePart(h)
+	if err != nil {
+		return nil, err
+	}
+	return &RelatedWriter{rmw, opts}, nil
+}
+
+// CreatePart creates a new part with the provided header. The body of the part
+// should be written to the returned io.WriteCloser.
+func (w *RelatedWriter) CreatePart(h InlineHeader) (io.WriteCloser, error) {
+	h = InlineHeader{h.Header.Copy()} // don't modify the caller's view
+	initInlineHeader(&h, w.opts)
+	return w.mw.CreatePart(h.Header)
+}
+
+// Close finishes the RelatedWriter.
+func (w *RelatedWriter) Close() error {
+	return w.mw.Close()
+}
@@ -4,9 +4,11 @@ import (
 	"bytes"
 	"io"
 	"log"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/emersion/go-message"
 	"github.com/emersion/go-message/mail"
 )
 
@@ -137,3 +139,224 @@ func TestWriter_singleInline(t *testing.T) {
 
 	testReader(t, &b)
 }
+
+func TestWriter_utf8Header(t *testing.T) {
+	var h mail.Header
+	h.SetAddressList("From", []*mail.Address{{Name: "Jöhn Döe", Address: "jöhn@exämple.org"}})
+	h.SetSubject("Café")
+
+	var b bytes.Buffer
+	mw, err := mail.CreateWriterWithOptions(&b, h, &message.WriterOptions{UTF8Header: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ah mail.AttachmentHeader
+	ah.Set("Content-Type", "text/plain")
+	ah.SetFilename("note.txt")
+	w, err := mw.CreateAttachment(ah)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(w, "Hello")
+	w.Close()
+	mw.Close()
+
+	s := b.String()
+	if !strings.Contains(s, "\r\nFrom: Jöhn Döe <jöhn@exämple.org>\r\n") {
+		t.Errorf("Expected From header field to be UTF-8, got:\n%v", s)
+	}
+	if !strings.Contains(s, "\r\nSubject: Café\r\n") {
+		t.Errorf("Expected Subject header field to be UTF-8, got:\n%v", s)
+	}
+
+	r, err := mail.CreateReader(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if subject, err := r.Header.Subject(); err != nil {
+		t.Errorf("Subject() = %v", err)
+	} else if subject != "Café" {
+		t.Errorf("Expected subject %q, got %q", "Café", subject)
+	}
+	if from, err := r.Header.AddressList("From"); err != nil {
+		t.Errorf("AddressList(From) = %v", err)
+	} else if len(from) != 1 || from[0].Name != "Jöhn Döe" || from[0].Address != "jöhn@exämple.org" {
+		t.Errorf("Unexpected From address list: %v", from)
+	}
+}
+
+func TestWriter_autoEncoding(t *testing.T) {
+	var b bytes.Buffer
+	mw, err := mail.CreateWriterWithOptions(&b, mail.Header{}, &message.WriterOptions{AutoEncoding: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ih mail.InlineHeader
+	ih.Set("Content-Type", "text/plain")
+	w, err := mw.CreateSingleInline(ih)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(w, "Hello world!")
+	w.Close()
+	mw.Close()
+
+	r, err := mail.CreateReader(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := r.NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if enc := p.Header.Get("Content-Transfer-Encoding"); enc != "7bit" {
+		t.Errorf("Expected encoding %q, got %q", "7bit", enc)
+	}
+}
+
+func TestWriter_related(t *testing.T) {
+	var b bytes.Buffer
+
+	var h mail.Header
+	h.SetSubject("Your Name")
+	mw, err := mail.CreateWriter(&b, h)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tw, err := mw.CreateInline()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var th mail.InlineHeader
+	th.Set("Content-Type", "text/plain")
+	w, err := tw.CreatePart(th)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(w, "Who are you?")
+	w.Close()
+
+	rw, err := tw.CreateRelated()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var hh mail.InlineHeader
+	hh.Set("Content-Type", "text/html")
+	w, err = rw.CreatePart(hh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(w, `<img src="`+mail.ContentIDURL("comet@example.org")+`">`)
+	w.Close()
+
+	var ih mail.InlineHeader
+	ih.Set("Content-Type", "image/png")
+	ih.SetContentID("comet@example.org")
+	w, err = rw.CreatePart(ih)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(w, "PNG")
+	w.Close()
+
+	rw.Close()
+	tw.Close()
+	mw.Close()
+
+	e, err := message.Read(&b)
+	if err != nil {
+		t.Fatalf("message.Read() = %v", err)
+	}
+	var types []string
+	var relatedParams map[string]string
+	err = e.Walk(func(path []int, part *message.Entity, err error) error {
+		if err != nil {
+			return err
+		}
+		mediaType, params, _ := part.Header.ContentType()
+		types = append(types, mediaType)
+		if mediaType == "multipart/related" {
+			relatedParams = params
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Entity.Walk() = %v", err)
+	}
+
+	want := []string{"multipart/mixed", "multipart/alternative", "text/plain", "multipart/related", "text/html", "image/png"}
+	if strings.Join(types, " ") != strings.Join(want, " ") {
+		t.Errorf("message has parts %v, want %v", types, want)
+	}
+	if relatedParams["type"] != "text/html" {
+		t.Errorf("multipart/related has type %q, want %q", relatedParams["type"], "text/html")
+	}
+}
+
+func TestWriter_CreateMessage(t *testing.T) {
+	var b bytes.Buffer
+
+	var h mail.Header
+	h.SetSubject("Fwd: Your Name")
+	mw, err := mail.CreateWriter(&b, h)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ah mail.AttachmentHeader
+	ah.SetFilename("attached-message.eml")
+	var eh mail.Header
+	eh.SetSubject("Your Name")
+	ew, err := mw.CreateMessage(ah, eh)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tw, err := ew.CreateInline()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var th mail.InlineHeader
+	th.Set("Content-Type", "text/plain")
+	w, err := tw.CreatePart(th)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(w, "Who are you?")
+	w.Close()
+	tw.Close()
+
+	var nh mail.AttachmentHeader
+	nh.Set("Content-Type", "text/plain")
+	nh.SetFilename("note.txt")
+	w, err = ew.CreateAttachment(nh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(w, "I'm Mitsuha.")
+	w.Close()
+
+	if err := ew.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := mw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	mr, err := mail.CreateReader(&b)
+	if err != nil {
+		t.Fatalf("mail.CreateReader() = %v", err)
+	}
+	p, err := mr.NextPart()
+	if err != nil {
+		t.Fatalf("NextPart() = %v", err)
+	}
+	if mediaType, _, _ := p.Header.(*mail.AttachmentHeader).ContentType(); mediaType != "message/rfc822" {
+		t.Errorf("attachment has Content-Type %q, want %q", mediaType, "message/rfc822")
+	}
+	testReader(t, p.Body)
+	if _, err := mr.NextPart(); err != io.EOF {
+		t.Errorf("NextPart() = %v, want io.EOF", err)
+	}
+}
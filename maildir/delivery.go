@@ -0,0 +1,146 @@
+package maildir
+
+import (
+	"errors"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/emersion/go-message"
+)
+
+// Delivery writes a new message to a Maildir. The message is written to the
+// tmp subdirectory, and linked to new when the delivery is closed.
+type Delivery struct {
+	dir  Dir
+	name string
+	f    *os.File
+	size int64
+	msg  *Message
+}
+
+// NewDelivery starts delivering a new message. The raw message should be
+// written to the Delivery, which must then be either closed or aborted.
+func (d Dir) NewDelivery() (*Delivery, error) {
+	name, err := newUniqueName()
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(d.path("tmp", name), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &Delivery{dir: d, name: name, f: f}, nil
+}
+
+// Write implements io.Writer.
+func (d *Delivery) Write(b []byte) (int, error) {
+	if d.f == nil {
+		return 0, errors.New("maildir: delivery already closed")
+	}
+	n, err := d.f.Write(b)
+	d.size += int64(n)
+	return n, err
+}
+
+// syncDir syncs a directory to disk, so that the entries created in it are
+// persisted.
+func syncDir(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Close completes the delivery. The message file is synced to disk, and linked
+// to the new subdirectory as recommended by the Maildir specification, so that
+// an existing message is never overwritten. Its key includes a Maildir++ size
+// hint.
+func (d *Delivery) Close() error {
+	if d.f == nil {
+		return errors.New("maildir: delivery already closed")
+	}
+	f := d.f
+	d.f = nil
+
+	tmpPath := d.dir.path("tmp", d.name)
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	key := d.name + ",S=" + strconv.FormatInt(d.size, 10)
+	err := os.Link(tmpPath, d.dir.path("new", key))
+	os.Remove(tmpPath)
+	if err != nil {
+		return err
+	}
+	if err := syncDir(d.dir.path("new")); err != nil {
+		return err
+	}
+
+	d.msg = newMessage(d.dir, key, true)
+	return nil
+}
+
+// Abort cancels the delivery, and removes the partially written message.
+func (d *Delivery) Abort() error {
+	if d.f == nil {
+		return errors.New("maildir: delivery already closed")
+	}
+	f := d.f
+	d.f = nil
+
+	f.Close()
+	return os.Remove(d.dir.path("tmp", d.name))
+}
+
+// Message returns the delivered message. It returns nil if the delivery
+// hasn't been closed successfully.
+func (d *Delivery) Message() *Message {
+	return d.msg
+}
+
+// Deliver delivers a new message read from r.
+func (d Dir) Deliver(r io.Reader) (*Message, error) {
+	delivery, err := d.NewDelivery()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(delivery, r); err != nil {
+		delivery.Abort()
+		return nil, err
+	}
+	if err := delivery.Close(); err != nil {
+		return nil, err
+	}
+	return delivery.Message(), nil
+}
+
+// DeliverEntity delivers a new message. The entity is written with
+// message.Entity.WriteTo.
+func (d Dir) DeliverEntity(e *message.Entity) (*Message, error) {
+	delivery, err := d.NewDelivery()
+	if err != nil {
+		return nil, err
+	}
+	if err := e.WriteTo(delivery); err != nil {
+		delivery.Abort()
+		return nil, err
+	}
+	if err := delivery.Close(); err != nil {
+		return nil, err
+	}
+	return delivery.Message(), nil
+}
@@ -0,0 +1,269 @@
+// Package maildir implements the Maildir and Maildir++ mailbox formats.
+//
+// A Maildir is a directory containing three subdirectories: tmp, new and cur.
+// Messages are written to tmp and atomically moved to new once complete. Mail
+// readers then move messages to cur, and store flags in their filename.
+//
+// Maildir++ extends the format with subfolders, stored as subdirectories whose
+// name starts with ".", and with a size hint in message filenames.
+//
+// Maildir is specified in https://cr.yp.to/proto/maildir.html. Maildir++ is
+// specified in https://www.courier-mta.org/imap/README.maildirquota.html.
+package maildir
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// infoSeparator separates the unique name of a message from its info in
+// filenames.
+const infoSeparator = ':'
+
+// Flag is a message flag, stored in the info part of its filename.
+type Flag rune
+
+// Standard flags.
+const (
+	FlagPassed  Flag = 'P' // the message has been resent/forwarded/bounced
+	FlagReplied Flag = 'R' // the message has been replied to
+	FlagSeen    Flag = 'S' // the message has been viewed
+	FlagTrashed Flag = 'T' // the message has been marked for deletion
+	FlagDraft   Flag = 'D' // the message is a draft
+	FlagFlagged Flag = 'F' // the message has been flagged
+)
+
+func (f Flag) isValid() bool {
+	return f > ' ' && f < 0x7F && f != ',' && f != '/' && f != infoSeparator
+}
+
+// parseFilename splits a message filename into its key and its flags. Flags
+// are only parsed for the "2," info format.
+func parseFilename(name string) (key string, flags []Flag) {
+	i := strings.IndexByte(name, infoSeparator)
+	if i < 0 {
+		return name, nil
+	}
+	key, info := name[:i], name[i+1:]
+	if !strings.HasPrefix(info, "2,") {
+		return key, nil
+	}
+	for _, r := range strings.TrimPrefix(info, "2,") {
+		flags = append(flags, Flag(r))
+	}
+	return key, flags
+}
+
+// formatFilename formats a message filename from its key and its flags.
+func formatFilename(key string, flags []Flag) (string, error) {
+	l := make([]Flag, 0, len(flags))
+	for _, f := range flags {
+		if !f.isValid() {
+			return "", fmt.Errorf("maildir: invalid flag %q", rune(f))
+		}
+		l = append(l, f)
+	}
+	// Flags must be stored in ASCII order
+	sort.Slice(l, func(i, j int) bool {
+		return l[i] < l[j]
+	})
+
+	var sb strings.Builder
+	sb.WriteString(key)
+	sb.WriteRune(infoSeparator)
+	sb.WriteString("2,")
+	for i, f := range l {
+		if i > 0 && l[i-1] == f {
+			continue
+		}
+		sb.WriteRune(rune(f))
+	}
+	return sb.String(), nil
+}
+
+// parseSize parses the Maildir++ size hint of a message key.
+func parseSize(key string) (int64, bool) {
+	fields := strings.Split(key, ",")
+	for _, field := range fields[1:] {
+		if !strings.HasPrefix(field, "S=") {
+			continue
+		}
+		size, err := strconv.ParseInt(strings.TrimPrefix(field, "S="), 10, 64)
+		return size, err == nil && size >= 0
+	}
+	return 0, false
+}
+
+var deliveryCounter uint32
+
+var hostnameReplacer = strings.NewReplacer("/", `\057`, string(infoSeparator), `\072`)
+
+// newUniqueName generates a new unique name for a message, as recommended by
+// the Maildir specification.
+func newUniqueName() (string, error) {
+	host, err := os.Hostname()
+	if err != nil {
+		return "", err
+	}
+	host = hostnameReplacer.Replace(host)
+
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	n := atomic.AddUint32(&deliveryCounter, 1)
+	return fmt.Sprintf("%v.M%vP%vQ%vR%v.%v", now.Unix(), now.Nanosecond()/1000, os.Getpid(), n, hex.EncodeToString(b[:]), host), nil
+}
+
+// Dir is a Maildir directory.
+type Dir string
+
+func (d Dir) path(elem ...string) string {
+	return filepath.Join(append([]string{string(d)}, elem...)...)
+}
+
+// Init creates the tmp, new and cur subdirectories, if they don't exist yet.
+func (d Dir) Init() error {
+	for _, name := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(d.path(name), 0700); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkFolderName(name string) error {
+	if name == "" || strings.ContainsAny(name, "/"+string(filepath.Separator)) {
+		return fmt.Errorf("maildir: invalid folder name %q", name)
+	}
+	for _, elem := range strings.Split(name, ".") {
+		if elem == "" {
+			return fmt.Errorf("maildir: invalid folder name %q", name)
+		}
+	}
+	return nil
+}
+
+// Folder returns the Maildir++ subfolder with the specified name. Nested
+// folder names are delimited by ".", e.g. "Lists.golang".
+//
+// The subfolder may not exist: see CreateFolder.
+func (d Dir) Folder(name string) (Dir, error) {
+	if err := checkFolderName(name); err != nil {
+		return "", err
+	}
+	return Dir(d.path("." + name)), nil
+}
+
+// CreateFolder creates a Maildir++ subfolder. It's not an error if the
+// subfolder already exists.
+func (d Dir) CreateFolder(name string) (Dir, error) {
+	folder, err := d.Folder(name)
+	if err != nil {
+		return "", err
+	}
+	if err := folder.Init(); err != nil {
+		return "", err
+	}
+	f, err := os.OpenFile(folder.path("maildirfolder"), os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return "", err
+	}
+	return folder, f.Close()
+}
+
+// Folders lists the names of Maildir++ subfolders, sorted by name.
+func (d Dir) Folders() ([]string, error) {
+	infos, err := ioutil.ReadDir(string(d))
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, fi := range infos {
+		name := fi.Name()
+		if !fi.IsDir() || !strings.HasPrefix(name, ".") {
+			continue
+		}
+		name = strings.TrimPrefix(name, ".")
+		if checkFolderName(name) != nil {
+			continue
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// Messages lists messages in the new and cur subdirectories. Messages in new
+// are listed first.
+func (d Dir) Messages() ([]*Message, error) {
+	var msgs []*Message
+	for _, isNew := range []bool{true, false} {
+		sub := "cur"
+		if isNew {
+			sub = "new"
+		}
+		infos, err := ioutil.ReadDir(d.path(sub))
+		if err != nil {
+			return nil, err
+		}
+		for _, fi := range infos {
+			if fi.IsDir() || strings.HasPrefix(fi.Name(), ".") {
+				continue
+			}
+			msgs = append(msgs, newMessage(d, fi.Name(), isNew))
+		}
+	}
+	return msgs, nil
+}
+
+// ErrNotFound is returned by Dir.MessageByKey if no message has the requested
+// key.
+var ErrNotFound = errors.New("maildir: message not found")
+
+// MessageByKey looks up a message by its key.
+func (d Dir) MessageByKey(key string) (*Message, error) {
+	// Keys can't refer to a file outside of the Maildir
+	if key == "" || strings.HasPrefix(key, ".") || strings.Contains(key, "..") || strings.ContainsAny(key, "/"+string(filepath.Separator)+string(infoSeparator)) {
+		return nil, fmt.Errorf("maildir: invalid message key %q", key)
+	}
+
+	if _, err := os.Stat(d.path("new", key)); err == nil {
+		return newMessage(d, key, true), nil
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	f, err := os.Open(d.path("cur"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	for {
+		names, err := f.Readdirnames(128)
+		if err == io.EOF {
+			return nil, ErrNotFound
+		} else if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			if k, _ := parseFilename(name); k == key {
+				return newMessage(d, name, false), nil
+			}
+		}
+	}
+}
@@ -0,0 +1,374 @@
+package maildir
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/emersion/go-message"
+)
+
+const testMessage = "From: Mitsuha Miyamizu <mitsuha@example.org>\r\n" +
+	"Subject: Your Name.\r\n" +
+	"Content-Type: text/plain; charset=utf-8\r\n" +
+	"\r\n" +
+	"Who are you?\r\n"
+
+func newTestDir(t *testing.T) Dir {
+	dir, err := ioutil.TempDir("", "go-message-maildir-")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir() = %v", err)
+	}
+	t.Cleanup(func() {
+		os.RemoveAll(dir)
+	})
+
+	d := Dir(dir)
+	if err := d.Init(); err != nil {
+		t.Fatalf("Init() = %v", err)
+	}
+	return d
+}
+
+var filenameTests = []struct {
+	name  string
+	key   string
+	flags []Flag
+}{
+	{
+		name: "1477000000.M1P2Q3.example.org",
+		key:  "1477000000.M1P2Q3.example.org",
+	},
+	{
+		name:  "1477000000.M1P2Q3.example.org,S=1234:2,FRS",
+		key:   "1477000000.M1P2Q3.example.org,S=1234",
+		flags: []Flag{FlagFlagged, FlagReplied, FlagSeen},
+	},
+	{
+		name: "1477000000.M1P2Q3.example.org:2,",
+		key:  "1477000000.M1P2Q3.example.org",
+	},
+	{
+		name: "1477000000.M1P2Q3.example.org:1,experimental",
+		key:  "1477000000.M1P2Q3.example.org",
+	},
+}
+
+func TestParseFilename(t *testing.T) {
+	for _, tc := range filenameTests {
+		key, flags := parseFilename(tc.name)
+		if key != tc.key {
+			t.Errorf("parseFilename(%q): key = %q, want %q", tc.name, key, tc.key)
+		}
+		if !reflect.DeepEqual(flags, tc.flags) {
+			t.Errorf("parseFilename(%q): flags = %q, want %q", tc.name, flags, tc.flags)
+		}
+	}
+}
+
+func TestFormatFilename(t *testing.T) {
+	name, err := formatFilename("1477000000.M1P2Q3.example.org", []Flag{FlagSeen, FlagFlagged, FlagSeen, 'a'})
+	if err != nil {
+		t.Fatalf("formatFilename() = %v", err)
+	}
+	if want := "1477000000.M1P2Q3.example.org:2,FSa"; name != want {
+		t.Errorf("formatFilename() = %q, want %q", name, want)
+	}
+
+	if _, err := formatFilename("1477000000.M1P2Q3.example.org", []Flag{'/'}); err == nil {
+		t.Error("formatFilename() with an invalid flag = nil, want an error")
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := map[string]int64{
+		"1477000000.M1P2Q3.example.org,S=1234":        1234,
+		"1477000000.M1P2Q3.example.org,W=1260,S=1234": 1234,
+		"1477000000.M1P2Q3.example.org":               -1,
+		"1477000000.M1P2Q3.example.org,S=abc":         -1,
+	}
+	for key, want := range tests {
+		size, ok := parseSize(key)
+		if !ok {
+			size = -1
+		}
+		if size != want {
+			t.Errorf("parseSize(%q) = %v, want %v", key, size, want)
+		}
+	}
+}
+
+func TestDir_Deliver(t *testing.T) {
+	d := newTestDir(t)
+
+	msg, err := d.Deliver(strings.NewReader(testMessage))
+	if err != nil {
+		t.Fatalf("Deliver() = %v", err)
+	}
+	if !msg.IsNew() {
+		t.Error("IsNew() = false, want true")
+	}
+	if size, err := msg.Size(); err != nil || size != int64(len(testMessage)) {
+		t.Errorf("Size() = %v, %v, want %v", size, err, len(testMessage))
+	}
+	if want := d.path("new", msg.Key()); msg.Filename() != want {
+		t.Errorf("Filename() = %q, want %q", msg.Filename(), want)
+	}
+
+	if infos, err := ioutil.ReadDir(d.path("tmp")); err != nil || len(infos) != 0 {
+		t.Errorf("tmp contains %v files (%v), want none", len(infos), err)
+	}
+
+	b, err := ioutil.ReadFile(msg.Filename())
+	if err != nil {
+		t.Fatalf("ReadFile() = %v", err)
+	}
+	if string(b) != testMessage {
+		t.Errorf("message file = %q, want %q", string(b), testMessage)
+	}
+}
+
+func TestDir_DeliverEntity(t *testing.T) {
+	d := newTestDir(t)
+
+	var h message.Header
+	h.Set("Subject", "Your Name.")
+	e, err := message.New(h, strings.NewReader("Who are you?"))
+	if err != nil {
+		t.Fatalf("message.New() = %v", err)
+	}
+
+	msg, err := d.DeliverEntity(e)
+	if err != nil {
+		t.Fatalf("DeliverEntity() = %v", err)
+	}
+
+	e, closer, err := msg.OpenEntity()
+	if err != nil {
+		t.Fatalf("OpenEntity() = %v", err)
+	}
+	defer closer.Close()
+
+	if s := e.Header.Get("Subject"); s != "Your Name." {
+		t.Errorf("Subject = %q, want %q", s, "Your Name.")
+	}
+	if b, err := ioutil.ReadAll(e.Body); err != nil || string(b) != "Who are you?" {
+		t.Errorf("body = %q (%v), want %q", string(b), err, "Who are you?")
+	}
+}
+
+func TestDelivery_Abort(t *testing.T) {
+	d := newTestDir(t)
+
+	delivery, err := d.NewDelivery()
+	if err != nil {
+		t.Fatalf("NewDelivery() = %v", err)
+	}
+	if _, err := delivery.Write([]byte("From: mitsuha@example.org\r\n")); err != nil {
+		t.Fatalf("Write() = %v", err)
+	}
+	if err := delivery.Abort(); err != nil {
+		t.Fatalf("Abort() = %v", err)
+	}
+	if delivery.Message() != nil {
+		t.Error("Message() != nil after Abort()")
+	}
+
+	for _, sub := range []string{"tmp", "new"} {
+		if infos, err := ioutil.ReadDir(d.path(sub)); err != nil || len(infos) != 0 {
+			t.Errorf("%v contains %v files (%v), want none", sub, len(infos), err)
+		}
+	}
+}
+
+func TestMessage_SetFlags(t *testing.T) {
+	d := newTestDir(t)
+
+	msg, err := d.Deliver(strings.NewReader(testMessage))
+	if err != nil {
+		t.Fatalf("Deliver() = %v", err)
+	}
+	key := msg.Key()
+
+	if err := msg.SetFlags([]Flag{FlagSeen, FlagReplied}); err != nil {
+		t.Fatalf("SetFlags() = %v", err)
+	}
+	if msg.IsNew() {
+		t.Error("IsNew() = true after SetFlags(), want false")
+	}
+	if msg.Key() != key {
+		t.Errorf("Key() = %q after SetFlags(), want %q", msg.Key(), key)
+	}
+	if want := d.path("cur", key+":2,RS"); msg.Filename() != want {
+		t.Errorf("Filename() = %q, want %q", msg.Filename(), want)
+	}
+
+	if err := msg.AddFlags(FlagFlagged); err != nil {
+		t.Fatalf("AddFlags() = %v", err)
+	}
+	if err := msg.RemoveFlags(FlagReplied); err != nil {
+		t.Fatalf("RemoveFlags() = %v", err)
+	}
+	if want := []Flag{FlagFlagged, FlagSeen}; !reflect.DeepEqual(msg.Flags(), want) {
+		t.Errorf("Flags() = %q, want %q", msg.Flags(), want)
+	}
+	if !msg.HasFlag(FlagSeen) || msg.HasFlag(FlagReplied) {
+		t.Errorf("HasFlag() doesn't match Flags() = %q", msg.Flags())
+	}
+
+	found, err := d.MessageByKey(key)
+	if err != nil {
+		t.Fatalf("MessageByKey() = %v", err)
+	}
+	if found.Filename() != msg.Filename() || !reflect.DeepEqual(found.Flags(), msg.Flags()) {
+		t.Errorf("MessageByKey() = %q, want %q", found.Filename(), msg.Filename())
+	}
+
+	if err := msg.Remove(); err != nil {
+		t.Fatalf("Remove() = %v", err)
+	}
+	if _, err := d.MessageByKey(key); err != ErrNotFound {
+		t.Errorf("MessageByKey() after Remove() = %v, want ErrNotFound", err)
+	}
+}
+
+func TestMessage_SetFlags_unknownInfo(t *testing.T) {
+	d := newTestDir(t)
+
+	name := "1234.test:1,experimental"
+	if err := ioutil.WriteFile(d.path("cur", name), []byte(testMessage), 0600); err != nil {
+		t.Fatalf("WriteFile() = %v", err)
+	}
+	msg, err := d.MessageByKey("1234.test")
+	if err != nil {
+		t.Fatalf("MessageByKey() = %v", err)
+	}
+
+	if err := msg.SetFlags([]Flag{FlagSeen}); err == nil {
+		t.Error("SetFlags() = nil, want an error")
+	}
+	if want := d.path("cur", name); msg.Filename() != want {
+		t.Errorf("Filename() = %q, want %q", msg.Filename(), want)
+	}
+	if _, err := os.Stat(d.path("cur", name)); err != nil {
+		t.Errorf("message file was renamed: %v", err)
+	}
+}
+
+func TestDir_Messages(t *testing.T) {
+	d := newTestDir(t)
+
+	var keys []string
+	for i := 0; i < 3; i++ {
+		msg, err := d.Deliver(strings.NewReader(testMessage))
+		if err != nil {
+			t.Fatalf("Deliver() = %v", err)
+		}
+		keys = append(keys, msg.Key())
+	}
+
+	msg, err := d.MessageByKey(keys[1])
+	if err != nil {
+		t.Fatalf("MessageByKey() = %v", err)
+	}
+	if err := msg.AddFlags(FlagSeen); err != nil {
+		t.Fatalf("AddFlags() = %v", err)
+	}
+
+	msgs, err := d.Messages()
+	if err != nil {
+		t.Fatalf("Messages() = %v", err)
+	}
+	if len(msgs) != len(keys) {
+		t.Fatalf("Messages() returned %v messages, want %v", len(msgs), len(keys))
+	}
+	for i, msg := range msgs {
+		// The message in cur is listed last
+		isNew := i < len(msgs)-1
+		if msg.IsNew() != isNew {
+			t.Errorf("Messages()[%v].IsNew() = %v, want %v", i, msg.IsNew(), isNew)
+		}
+		if !isNew && msg.Key() != keys[1] {
+			t.Errorf("Messages()[%v].Key() = %q, want %q", i, msg.Key(), keys[1])
+		}
+	}
+}
+
+func TestDir_Folders(t *testing.T) {
+	d := newTestDir(t)
+
+	for _, name := range []string{"Sent", "Lists.golang"} {
+		folder, err := d.CreateFolder(name)
+		if err != nil {
+			t.Fatalf("CreateFolder(%q) = %v", name, err)
+		}
+		if want, err := d.Folder(name); err != nil {
+			t.Errorf("Folder(%q) = %v", name, err)
+		} else if folder != want {
+			t.Errorf("CreateFolder(%q) = %q, want %q", name, folder, want)
+		}
+		if _, err := os.Stat(filepath.Join(string(folder), "maildirfolder")); err != nil {
+			t.Errorf("CreateFolder(%q): missing maildirfolder file: %v", name, err)
+		}
+		if _, err := folder.Deliver(strings.NewReader(testMessage)); err != nil {
+			t.Errorf("Deliver() = %v", err)
+		}
+	}
+
+	if _, err := d.CreateFolder("Lists..golang"); err == nil {
+		t.Error("CreateFolder() with an invalid name = nil, want an error")
+	}
+	for _, name := range []string{"", "Lists/golang", "..", ".Sent", "../Sent"} {
+		if _, err := d.Folder(name); err == nil {
+			t.Errorf("Folder(%q) = nil, want an error", name)
+		}
+	}
+
+	names, err := d.Folders()
+	if err != nil {
+		t.Fatalf("Folders() = %v", err)
+	}
+	if want := []string{"Lists.golang", "Sent"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("Folders() = %q, want %q", names, want)
+	}
+}
+
+func TestDelivery_Close_exists(t *testing.T) {
+	d := newTestDir(t)
+
+	delivery, err := d.NewDelivery()
+	if err != nil {
+		t.Fatalf("NewDelivery() = %v", err)
+	}
+	delivery.Write([]byte(testMessage))
+
+	existing := d.path("new", delivery.name+",S="+strconv.Itoa(len(testMessage)))
+	if err := ioutil.WriteFile(existing, []byte("existing"), 0600); err != nil {
+		t.Fatalf("WriteFile() = %v", err)
+	}
+
+	if err := delivery.Close(); err == nil {
+		t.Error("Close() = nil, want an error")
+	}
+	if b, err := ioutil.ReadFile(existing); err != nil {
+		t.Errorf("ReadFile() = %v", err)
+	} else if string(b) != "existing" {
+		t.Errorf("existing message was overwritten: %q", string(b))
+	}
+	if _, err := os.Stat(d.path("tmp", delivery.name)); !os.IsNotExist(err) {
+		t.Errorf("temporary file wasn't removed: %v", err)
+	}
+}
+
+func TestDir_MessageByKey_invalid(t *testing.T) {
+	d := newTestDir(t)
+	for _, key := range []string{"", ".", "..", "../cur", "a/b", "1234.test:2,S", "..hidden"} {
+		if _, err := d.MessageByKey(key); err == nil || err == ErrNotFound {
+			t.Errorf("MessageByKey(%q) = %v, want an invalid key error", key, err)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package maildir
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/emersion/go-message"
+)
+
+// A Message is a message stored in a Maildir.
+type Message struct {
+	dir   Dir
+	name  string
+	isNew bool
+	key   string
+	flags []Flag
+}
+
+func newMessage(d Dir, name string, isNew bool) *Message {
+	key, flags := parseFilename(name)
+	return &Message{dir: d, name: name, isNew: isNew, key: key, flags: flags}
+}
+
+// Key returns the message's unique key. It doesn't change when the message's
+// flags are updated.
+func (m *Message) Key() string {
+	return m.key
+}
+
+// IsNew returns true if the message is in the new subdirectory, i.e. hasn't
+// been seen by a mail reader yet.
+func (m *Message) IsNew() bool {
+	return m.isNew
+}
+
+// Filename returns the path to the message file.
+func (m *Message) Filename() string {
+	sub := "cur"
+	if m.isNew {
+		sub = "new"
+	}
+	return m.dir.path(sub, m.name)
+}
+
+// Flags returns the message's flags.
+func (m *Message) Flags() []Flag {
+	return append([]Flag(nil), m.flags...)
+}
+
+// HasFlag checks whether the message has the specified flag.
+func (m *Message) HasFlag(flag Flag) bool {
+	for _, f := range m.flags {
+		if f == flag {
+			return true
+		}
+	}
+	return false
+}
+
+// Size returns the size of the message file. The Maildir++ size hint is used
+// if present.
+func (m *Message) Size() (int64, error) {
+	if size, ok := parseSize(m.key); ok {
+		return size, nil
+	}
+	fi, err := os.Stat(m.Filename())
+	if err != nil {
+		return 0, err
+	}
+	return fi.Size(), nil
+}
+
+// Open opens the raw message file for reading.
+func (m *Message) Open() (io.ReadCloser, error) {
+	return os.Open(m.Filename())
+}
+
+// OpenEntity opens the message file and reads it with message.Read. The
+// returned io.Closer must be closed once the entity's body is no longer used.
+//
+// If the message uses an unknown transfer encoding or charset, OpenEntity
+// returns an error that verifies message.IsUnknownCharset or
+// message.IsUnknownEncoding, but also returns an Entity that can be read.
+func (m *Message) OpenEntity() (*message.Entity, io.Closer, error) {
+	f, err := os.Open(m.Filename())
+	if err != nil {
+		return nil, nil, err
+	}
+	e, err := message.Read(f)
+	if e == nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return e, f, err
+}
+
+// SetFlags replaces the message's flags. If the message is in the new
+// subdirectory, it's moved to cur.
+//
+// Flags can't be set if the message filename has an info part in a format
+// other than "2,": an error is returned and the message is left unchanged.
+func (m *Message) SetFlags(flags []Flag) error {
+	if i := strings.IndexByte(m.name, infoSeparator); i >= 0 && !strings.HasPrefix(m.name[i+1:], "2,") {
+		return fmt.Errorf("maildir: unsupported info in message filename %q", m.name)
+	}
+
+	name, err := formatFilename(m.key, flags)
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(m.Filename(), m.dir.path("cur", name)); err != nil {
+		return err
+	}
+	m.name = name
+	m.isNew = false
+	_, m.flags = parseFilename(name)
+	return nil
+}
+
+// AddFlags adds flags to the message. See SetFlags.
+func (m *Message) AddFlags(flags ...Flag) error {
+	return m.SetFlags(append(m.Flags(), flags...))
+}
+
+// RemoveFlags removes flags from the message. See SetFlags.
+func (m *Message) RemoveFlags(flags ...Flag) error {
+	var l []Flag
+	for _, f := range m.flags {
+		remove := false
+		for _, other := range flags {
+			if f == other {
+				remove = true
+				break
+			}
+		}
+		if !remove {
+			l = append(l, f)
+		}
+	}
+	return m.SetFlags(l)
+}
+
+// Remove deletes the message file.
+func (m *Message) Remove() error {
+	return os.Remove(m.Filename())
+}
@@ -0,0 +1,101 @@
+// Package mbox implements reading and writing mbox files.
+//
+// An mbox file is a sequence of messages, each preceded by a "From " line
+// containing the envelope sender and the delivery date. Several incompatible
+// variants exist, described in the qmail mbox(5) man page: they differ in how
+// lines starting with "From " in message bodies are handled.
+package mbox
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+// Format is an mbox variant.
+type Format int
+
+const (
+	// Mboxo quotes lines starting with "From " by prepending ">". Quoted lines
+	// can't be distinguished from lines which originally started with
+	// ">From ", so they are left as-is when reading.
+	Mboxo Format = iota
+	// Mboxrd quotes lines starting with "From " preceded by any number of
+	// ">", so that quoting is reversible.
+	Mboxrd
+	// Mboxcl quotes lines like Mboxo, and adds a Content-Length header field
+	// containing the length of the quoted body.
+	Mboxcl
+	// Mboxcl2 doesn't quote lines, and relies on a Content-Length header field
+	// containing the length of the body.
+	Mboxcl2
+)
+
+func (f Format) hasContentLength() bool {
+	return f == Mboxcl || f == Mboxcl2
+}
+
+// fromLineDateLayout is the layout of dates in "From " lines, as generated by
+// asctime(3).
+const fromLineDateLayout = "Mon Jan _2 15:04:05 2006"
+
+// fromLineDateLayouts are accepted layouts for dates in "From " lines.
+var fromLineDateLayouts = []string{
+	fromLineDateLayout,
+	"Mon Jan _2 15:04:05 MST 2006",
+	"Mon Jan _2 15:04:05 -0700 2006",
+	"Mon Jan _2 15:04 2006",
+}
+
+// defaultSender is the envelope sender written if none is specified.
+const defaultSender = "MAILER-DAEMON"
+
+// parseFromLine parses a "From " line, without the line ending. The date is
+// zero if it can't be parsed.
+func parseFromLine(l string) (string, time.Time, error) {
+	if !strings.HasPrefix(l, "From ") {
+		return "", time.Time{}, errors.New("mbox: missing From line")
+	}
+	l = strings.TrimSpace(strings.TrimPrefix(l, "From "))
+
+	from := l
+	var date string
+	if i := strings.IndexAny(l, " \t"); i >= 0 {
+		from = l[:i]
+		date = strings.TrimSpace(l[i+1:])
+	}
+	// The day of month may be padded with a space
+	date = strings.Join(strings.Fields(date), " ")
+
+	for _, layout := range fromLineDateLayouts {
+		if t, err := time.Parse(layout, date); err == nil {
+			return from, t, nil
+		}
+	}
+	return from, time.Time{}, nil
+}
+
+// formatFromLine formats a "From " line, including the line ending.
+func formatFromLine(from string, date time.Time) (string, error) {
+	if from == "" {
+		from = defaultSender
+	}
+	if strings.ContainsAny(from, " \t\r\n") {
+		return "", errors.New("mbox: envelope sender contains whitespace")
+	}
+	if date.IsZero() {
+		date = time.Now()
+	}
+	return "From " + from + " " + date.UTC().Format(fromLineDateLayout) + "\n", nil
+}
+
+// isFromLine checks whether a line starts with "From ", optionally preceded
+// by ">" characters if quoted is true.
+func isFromLine(l []byte, quoted bool) bool {
+	if quoted {
+		for len(l) > 0 && l[0] == '>' {
+			l = l[1:]
+		}
+	}
+	return len(l) >= 5 && string(l[:5]) == "From "
+}
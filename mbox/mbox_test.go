@@ -0,0 +1,380 @@
+package mbox
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-message"
+)
+
+var fromLineTests = []struct {
+	line string
+	from string
+	date time.Time
+}{
+	{
+		line: "From mitsuha@example.org Thu Jan  5 10:04:05 2006",
+		from: "mitsuha@example.org",
+		date: time.Date(2006, time.January, 5, 10, 4, 5, 0, time.UTC),
+	},
+	{
+		line: "From taki@example.org Wed Nov 23 09:41:02 2016",
+		from: "taki@example.org",
+		date: time.Date(2016, time.November, 23, 9, 41, 2, 0, time.UTC),
+	},
+	{
+		line: "From taki@example.org Wed Nov 23 09:41:02 +0900 2016",
+		from: "taki@example.org",
+		date: time.Date(2016, time.November, 23, 9, 41, 2, 0, time.FixedZone("", 9*60*60)),
+	},
+	{
+		line: "From MAILER-DAEMON someday",
+		from: "MAILER-DAEMON",
+	},
+}
+
+func TestParseFromLine(t *testing.T) {
+	for _, tc := range fromLineTests {
+		from, date, err := parseFromLine(tc.line)
+		if err != nil {
+			t.Errorf("parseFromLine(%q) = %v", tc.line, err)
+			continue
+		}
+		if from != tc.from {
+			t.Errorf("parseFromLine(%q): from = %q, want %q", tc.line, from, tc.from)
+		}
+		if !date.Equal(tc.date) {
+			t.Errorf("parseFromLine(%q): date = %v, want %v", tc.line, date, tc.date)
+		}
+	}
+
+	if _, _, err := parseFromLine("Subject: hi"); err == nil {
+		t.Error("parseFromLine() on a header field = nil, want an error")
+	}
+}
+
+const testMboxrd = "From mitsuha@example.org Thu Jan  5 10:04:05 2006\n" +
+	"From: Mitsuha Miyamizu <mitsuha@example.org>\n" +
+	"Subject: Your Name.\n" +
+	"\n" +
+	"Who are you?\n" +
+	">From the countryside.\n" +
+	">>From Itomori.\n" +
+	"\n" +
+	"From taki@example.org Wed Nov 23 09:41:02 2016\n" +
+	"From: Taki Tachibana <taki@example.org>\n" +
+	"Subject: Re: Your Name.\n" +
+	"\n" +
+	"\n" +
+	"I don't know.\n" +
+	"\n" +
+	"\n"
+
+func readBody(t *testing.T, e *message.Entity) string {
+	b, err := ioutil.ReadAll(e.Body)
+	if err != nil {
+		t.Fatalf("ReadAll() = %v", err)
+	}
+	return string(b)
+}
+
+// readAll reads all messages and their bodies.
+func readAll(t *testing.T, r *Reader) ([]*Message, []string) {
+	var msgs []*Message
+	var bodies []string
+	for {
+		msg, err := r.Next()
+		if err == io.EOF {
+			return msgs, bodies
+		} else if err != nil {
+			t.Fatalf("Next() = %v", err)
+		}
+		msgs = append(msgs, msg)
+		bodies = append(bodies, readBody(t, msg.Entity))
+	}
+}
+
+func TestReader(t *testing.T) {
+	r := NewReader(strings.NewReader(testMboxrd), Mboxrd)
+
+	msg, err := r.Next()
+	if err != nil {
+		t.Fatalf("Next() = %v", err)
+	}
+	if msg.From != "mitsuha@example.org" {
+		t.Errorf("From = %q, want %q", msg.From, "mitsuha@example.org")
+	}
+	if want := time.Date(2006, time.January, 5, 10, 4, 5, 0, time.UTC); !msg.Date.Equal(want) {
+		t.Errorf("Date = %v, want %v", msg.Date, want)
+	}
+	if s := msg.Entity.Header.Get("Subject"); s != "Your Name." {
+		t.Errorf("Subject = %q, want %q", s, "Your Name.")
+	}
+	want := "Who are you?\nFrom the countryside.\n>From Itomori.\n"
+	if s := readBody(t, msg.Entity); s != want {
+		t.Errorf("body = %q, want %q", s, want)
+	}
+
+	// The body of this message isn't read
+	msg, err = r.Next()
+	if err != nil {
+		t.Fatalf("Next() = %v", err)
+	}
+	if msg.From != "taki@example.org" {
+		t.Errorf("From = %q, want %q", msg.From, "taki@example.org")
+	}
+
+	if _, err := r.Next(); err != io.EOF {
+		t.Errorf("Next() = %v, want io.EOF", err)
+	}
+}
+
+func TestReader_mboxo(t *testing.T) {
+	_, bodies := readAll(t, NewReader(strings.NewReader(testMboxrd), Mboxo))
+	if len(bodies) != 2 {
+		t.Fatalf("read %v messages, want 2", len(bodies))
+	}
+
+	want := "Who are you?\n>From the countryside.\n>>From Itomori.\n"
+	if bodies[0] != want {
+		t.Errorf("body = %q, want %q", bodies[0], want)
+	}
+
+	// Only the blank line preceding the separator is removed
+	want = "\nI don't know.\n\n"
+	if bodies[1] != want {
+		t.Errorf("body = %q, want %q", bodies[1], want)
+	}
+}
+
+func TestReader_crlf(t *testing.T) {
+	mbox := strings.Replace(testMboxrd, "\n", "\r\n", -1)
+	msgs, bodies := readAll(t, NewReader(strings.NewReader(mbox), Mboxrd))
+	if len(msgs) != 2 {
+		t.Fatalf("read %v messages, want 2", len(msgs))
+	}
+
+	if msgs[1].From != "taki@example.org" {
+		t.Errorf("From = %q, want %q", msgs[1].From, "taki@example.org")
+	}
+	want := "Who are you?\r\nFrom the countryside.\r\n>From Itomori.\r\n"
+	if bodies[0] != want {
+		t.Errorf("body = %q, want %q", bodies[0], want)
+	}
+}
+
+func TestReader_contentLength(t *testing.T) {
+	msg := func(contentLength int, body string) string {
+		return "From taki@example.org Wed Nov 23 09:41:02 2016\n" +
+			"Content-Length: " + strconv.Itoa(contentLength) + "\n" +
+			"\n" + body + "\n"
+	}
+	tests := []struct {
+		name   string
+		mbox   string
+		bodies []string
+	}{
+		{
+			name:   "valid",
+			mbox:   msg(17, "Hi\nFrom Itomori.\n") + msg(4, "Bye\n"),
+			bodies: []string{"Hi\nFrom Itomori.\n", "Bye\n"},
+		},
+		{
+			name:   "too short",
+			mbox:   msg(3, "Hi\nthere\n") + msg(4, "Bye\n"),
+			bodies: []string{"Hi\nthere\n", "Bye\n"},
+		},
+		{
+			name:   "too long",
+			mbox:   msg(20, "Hi\n") + msg(4, "Bye\n"),
+			bodies: []string{"Hi\n", "Bye\n"},
+		},
+		{
+			name:   "past EOF",
+			mbox:   msg(1000, "Hi\n"),
+			bodies: []string{"Hi\n"},
+		},
+	}
+
+	for _, tc := range tests {
+		for name, r := range map[string]io.Reader{
+			"seeker":     strings.NewReader(tc.mbox),
+			"not seeker": struct{ io.Reader }{strings.NewReader(tc.mbox)},
+		} {
+			_, bodies := readAll(t, NewReader(r, Mboxcl2))
+			if !reflect.DeepEqual(bodies, tc.bodies) {
+				t.Errorf("%v (%v): bodies = %q, want %q", tc.name, name, bodies, tc.bodies)
+			}
+		}
+	}
+}
+
+var testBodies = []string{
+	"Who are you?\r\nFrom the countryside.\r\n",
+	">From Itomori.\r\n>>From Tokyo.\r\nFrom\r\n",
+	"No trailing line break",
+	"",
+}
+
+func TestWriter(t *testing.T) {
+	for _, format := range []Format{Mboxo, Mboxrd, Mboxcl, Mboxcl2} {
+		var b bytes.Buffer
+		w := NewWriter(&b, format)
+		date := time.Date(2016, time.November, 23, 9, 41, 2, 0, time.UTC)
+		for _, body := range testBodies {
+			mw, err := w.CreateMessage("taki@example.org", date)
+			if err != nil {
+				t.Fatalf("CreateMessage() = %v", err)
+			}
+			io.WriteString(mw, "Subject: Your Name.\r\n\r\n"+body)
+			if err := mw.Close(); err != nil {
+				t.Fatalf("Close() = %v", err)
+			}
+		}
+
+		msgs, bodies := readAll(t, NewReader(&b, format))
+		if len(msgs) != len(testBodies) {
+			t.Fatalf("format %v: read %v messages, want %v", format, len(msgs), len(testBodies))
+		}
+		for i, msg := range msgs {
+			if msg.From != "taki@example.org" || !msg.Date.Equal(date) {
+				t.Errorf("format %v: From line = %q %v, want %q %v", format, msg.From, msg.Date, "taki@example.org", date)
+			}
+
+			// Line endings are converted to LF
+			want := strings.ReplaceAll(testBodies[i], "\r\n", "\n")
+			if want != "" && !strings.HasSuffix(want, "\n") {
+				want += "\n"
+			}
+			if format == Mboxo || format == Mboxcl {
+				// Quoting isn't reversible
+				want = strings.Replace(want, "\nFrom the", "\n>From the", 1)
+			}
+			if bodies[i] != want {
+				t.Errorf("format %v: body = %q, want %q", format, bodies[i], want)
+			}
+
+			if format.hasContentLength() && !msg.Entity.Header.Has("Content-Length") {
+				t.Errorf("format %v: missing Content-Length header field", format)
+			}
+		}
+	}
+}
+
+func TestWriter_mboxcl2(t *testing.T) {
+	var b bytes.Buffer
+	w := NewWriter(&b, Mboxcl2)
+	mw, err := w.CreateMessage("", time.Time{})
+	if err != nil {
+		t.Fatalf("CreateMessage() = %v", err)
+	}
+	io.WriteString(mw, "Content-Length: 1\r\n\r\nHi\nFrom Itomori.\n")
+	mw.Close()
+
+	s := b.String()
+	if !strings.HasPrefix(s, "From MAILER-DAEMON ") {
+		t.Errorf("mbox doesn't start with the default From line: %q", s)
+	}
+	if !strings.Contains(s, "Content-Length: 17\n") || strings.Contains(s, "Content-Length: 1\n") {
+		t.Errorf("mbox doesn't contain the updated Content-Length: %q", s)
+	}
+	if strings.Contains(s, "\r") {
+		t.Errorf("mbox header doesn't use LF line endings: %q", s)
+	}
+	if !strings.Contains(s, "\nFrom Itomori.\n") {
+		t.Errorf("mboxcl2 body was quoted: %q", s)
+	}
+}
+
+func TestWriter_WriteMessage(t *testing.T) {
+	var h message.Header
+	h.Set("Subject", "Your Name.")
+	h.Set("Content-Transfer-Encoding", "quoted-printable")
+	e, err := message.New(h, strings.NewReader("From the countryside=3D\r\n"))
+	if err != nil {
+		t.Fatalf("message.New() = %v", err)
+	}
+
+	var b bytes.Buffer
+	w := NewWriter(&b, Mboxrd)
+	if err := w.WriteMessage(&Message{From: "mitsuha@example.org", Entity: e}); err != nil {
+		t.Fatalf("WriteMessage() = %v", err)
+	}
+
+	msgs, bodies := readAll(t, NewReader(&b, Mboxrd))
+	if len(msgs) != 1 {
+		t.Fatalf("read %v messages, want 1", len(msgs))
+	}
+	if msgs[0].From != "mitsuha@example.org" {
+		t.Errorf("From = %q, want %q", msgs[0].From, "mitsuha@example.org")
+	}
+	if bodies[0] != "From the countryside=\n" {
+		t.Errorf("body = %q, want %q", bodies[0], "From the countryside=\n")
+	}
+}
+
+func TestWriter_lineEndings(t *testing.T) {
+	for _, format := range []Format{Mboxo, Mboxrd, Mboxcl, Mboxcl2} {
+		var b bytes.Buffer
+		w := NewWriter(&b, format)
+		mw, err := w.CreateMessage("taki@example.org", time.Time{})
+		if err != nil {
+			t.Fatalf("CreateMessage() = %v", err)
+		}
+		// The CRLF is split across writes, the bare CR is kept
+		io.WriteString(mw, "Subject: hi\r")
+		io.WriteString(mw, "\n\r\nFrom me\r\nline\r2\r\n")
+		if err := mw.Close(); err != nil {
+			t.Fatalf("Close() = %v", err)
+		}
+
+		s := b.String()
+		s = s[strings.IndexByte(s, '\n')+1:]
+		if format.hasContentLength() && strings.HasPrefix(s, "Content-Length: ") {
+			s = s[strings.IndexByte(s, '\n')+1:]
+		}
+		want := "Subject: hi\n\n>From me\nline\r2\n\n"
+		if format == Mboxcl2 {
+			want = "Subject: hi\n\nFrom me\nline\r2\n\n"
+		}
+		if s != want {
+			t.Errorf("format %v: message = %q, want %q", format, s, want)
+		}
+	}
+}
+
+// limitedWriter fails after n bytes have been written.
+type limitedWriter struct {
+	bytes.Buffer
+	n int
+}
+
+func (w *limitedWriter) Write(b []byte) (int, error) {
+	if len(b) > w.n {
+		n, _ := w.Buffer.Write(b[:w.n])
+		w.n = 0
+		return n, io.ErrShortWrite
+	}
+	w.n -= len(b)
+	return w.Buffer.Write(b)
+}
+
+func TestQuoteWriter_partialWrite(t *testing.T) {
+	lw := &limitedWriter{n: 12}
+	qw := &quoteWriter{w: lw}
+
+	// "Hi\n" is written, then "Hello world!\n" is written partially
+	n, err := qw.Write([]byte("Hi\nHello world!\n"))
+	if err != io.ErrShortWrite {
+		t.Fatalf("Write() = %v, want %v", err, io.ErrShortWrite)
+	}
+	if n != 12 {
+		t.Errorf("Write() = %v bytes, want %v (wrote %q)", n, 12, lw.String())
+	}
+}
@@ -0,0 +1,275 @@
+package mbox
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-message"
+	"github.com/emersion/go-message/textproto"
+)
+
+// A Message is a message read from an mbox file.
+type Message struct {
+	// From is the envelope sender, as specified in the "From " line.
+	From string
+	// Date is the delivery date, as specified in the "From " line. It's zero
+	// if the date can't be parsed.
+	Date time.Time
+	// Entity is the message itself. Its body is read from the mbox file: it
+	// becomes invalid when the next message is read.
+	Entity *message.Entity
+}
+
+func isBlankLine(l []byte) bool {
+	return len(l) == 1 && l[0] == '\n' || len(l) == 2 && l[0] == '\r' && l[1] == '\n'
+}
+
+// bodyReader reads a message body, up to the next "From " line. The blank
+// line preceding the "From " line is part of the separator, and isn't
+// returned.
+type bodyReader struct {
+	br      *bufio.Reader
+	unquote bool
+
+	buf   []byte
+	blank []byte // pending blank line
+	bol   bool   // at the beginning of a line
+	eof   bool
+	err   error
+}
+
+func (r *bodyReader) fill() {
+	if r.bol {
+		if b, _ := r.br.Peek(5); isFromLine(b, false) {
+			r.eof = true
+			return
+		}
+	}
+
+	l, err := r.br.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		err = nil
+	} else if err == io.EOF {
+		r.eof = true
+		err = nil
+	}
+	if err != nil {
+		r.err = err
+		return
+	}
+	if len(l) == 0 {
+		return
+	}
+
+	bol := r.bol
+	r.bol = l[len(l)-1] == '\n'
+
+	if bol && isBlankLine(l) {
+		r.buf = append(r.buf, r.blank...)
+		r.blank = append(r.blank[:0], l...)
+		return
+	}
+
+	r.buf = append(r.buf, r.blank...)
+	r.blank = r.blank[:0]
+	if bol && r.unquote && len(l) > 0 && l[0] == '>' && isFromLine(l, true) {
+		l = l[1:]
+	}
+	r.buf = append(r.buf, l...)
+}
+
+func (r *bodyReader) Read(b []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		if r.eof {
+			return 0, io.EOF
+		}
+		r.fill()
+	}
+
+	n := copy(b, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// isMessageEnd checks whether b, the data following a message, contains
+// blank lines followed by a "From " line or EOF. eof is true if b is all the
+// remaining data.
+func isMessageEnd(b []byte, eof bool) bool {
+	for {
+		switch {
+		case len(b) > 0 && b[0] == '\n':
+			b = b[1:]
+		case len(b) > 1 && b[0] == '\r' && b[1] == '\n':
+			b = b[2:]
+		case len(b) == 0:
+			return eof
+		default:
+			return isFromLine(b, false)
+		}
+	}
+}
+
+// prefixReader reads data pushed back by Reader.unread, then from r.
+type prefixReader struct {
+	prefix []byte
+	r      io.Reader
+}
+
+func (pr *prefixReader) Read(b []byte) (int, error) {
+	if len(pr.prefix) > 0 {
+		n := copy(b, pr.prefix)
+		pr.prefix = pr.prefix[n:]
+		return n, nil
+	}
+	return pr.r.Read(b)
+}
+
+// Reader reads messages from an mbox file.
+type Reader struct {
+	r      io.Reader
+	src    *prefixReader
+	br     *bufio.Reader
+	format Format
+	body   io.Reader
+}
+
+// NewReader creates a new mbox reader for the given format.
+//
+// With the Mboxcl and Mboxcl2 formats, the Content-Length header field of
+// each message is checked: it must be followed by the next "From " line or
+// by the end of the file. Otherwise, the message ends at the next "From "
+// line. If r isn't an io.Seeker, message bodies are buffered in memory to
+// perform this check.
+func NewReader(r io.Reader, format Format) *Reader {
+	src := &prefixReader{r: r}
+	return &Reader{r: r, src: src, br: bufio.NewReader(src), format: format}
+}
+
+// unread pushes back data read from the mbox file.
+func (r *Reader) unread(b []byte) {
+	buffered, _ := r.br.Peek(r.br.Buffered())
+	r.src.prefix = append(append(b, buffered...), r.src.prefix...)
+	r.br.Reset(r.src)
+}
+
+// checkContentLength checks whether the message body at the current position
+// is n bytes long. The current position is unchanged.
+func (r *Reader) checkContentLength(n int64) (bool, error) {
+	if seeker, ok := r.r.(io.Seeker); ok {
+		cur, err := seeker.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return false, err
+		}
+		end, err := seeker.Seek(0, io.SeekEnd)
+		if err != nil {
+			return false, err
+		}
+		pos := cur - int64(r.br.Buffered())
+		valid := false
+		if pos+n <= end {
+			if _, err := seeker.Seek(pos+n, io.SeekStart); err != nil {
+				return false, err
+			}
+			b, err := bufio.NewReader(r.r).Peek(512)
+			if err != nil && err != io.EOF {
+				return false, err
+			}
+			valid = isMessageEnd(b, err == io.EOF)
+		}
+		// The buffered data is still valid once the position is restored
+		_, err = seeker.Seek(cur, io.SeekStart)
+		return valid, err
+	}
+
+	var body bytes.Buffer
+	if _, err := io.CopyN(&body, r.br, n); err == io.EOF {
+		r.unread(body.Bytes())
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	b, err := r.br.Peek(512)
+	if err != nil && err != io.EOF {
+		return false, err
+	}
+	valid := isMessageEnd(b, err == io.EOF)
+	r.unread(body.Bytes())
+	return valid, nil
+}
+
+// readLine reads a line, without its line ending.
+func (r *Reader) readLine() (string, error) {
+	l, err := r.br.ReadString('\n')
+	if err == io.EOF && l != "" {
+		err = nil
+	}
+	return strings.TrimRight(l, "\r\n"), err
+}
+
+// Next reads the next message. The body of the previous message is
+// discarded. At the end of the mbox file, io.EOF is returned.
+//
+// If the message uses an unknown transfer encoding or charset, Next returns
+// an error that verifies message.IsUnknownCharset or
+// message.IsUnknownEncoding, but also returns a Message that can be read.
+func (r *Reader) Next() (*Message, error) {
+	if r.body != nil {
+		if _, err := io.Copy(ioutil.Discard, r.body); err != nil {
+			return nil, err
+		}
+		r.body = nil
+	}
+
+	// Blank lines may separate messages whose length is specified by a
+	// Content-Length header field
+	var l string
+	for {
+		var err error
+		l, err = r.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if l != "" {
+			break
+		}
+	}
+
+	from, date, err := parseFromLine(l)
+	if err != nil {
+		return nil, err
+	}
+
+	h, err := textproto.ReadHeader(r.br)
+	if err != nil {
+		return nil, err
+	}
+
+	var body io.Reader
+	if r.format.hasContentLength() {
+		if n, err := strconv.ParseInt(strings.TrimSpace(h.Get("Content-Length")), 10, 64); err == nil && n >= 0 {
+			if ok, err := r.checkContentLength(n); err != nil {
+				return nil, err
+			} else if ok {
+				body = io.LimitReader(r.br, n)
+			}
+		}
+	}
+	if body == nil {
+		body = &bodyReader{br: r.br, unquote: r.format == Mboxrd, bol: true}
+	}
+	r.body = body
+
+	e, err := message.New(message.Header{Header: h}, body)
+	if e == nil {
+		return nil, err
+	}
+	return &Message{From: from, Date: date, Entity: e}, err
+}
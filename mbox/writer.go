@@ -0,0 +1,304 @@
+package mbox
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"time"
+
+	"github.com/emersion/go-message/textproto"
+)
+
+// quoteWriter quotes lines starting with "From ", by prepending ">".
+type quoteWriter struct {
+	w io.Writer
+	// quoteAll is true if lines starting with "From " preceded by any
+	// number of ">" are quoted too
+	quoteAll bool
+
+	line    []byte // beginning of the current line, not written yet
+	decided bool   // whether the current line has been written
+}
+
+// decide checks whether the buffered beginning of a line needs to be quoted.
+// ok is false if more data is needed.
+func (qw *quoteWriter) decide(l []byte) (quote, ok bool) {
+	if qw.quoteAll {
+		for len(l) > 0 && l[0] == '>' {
+			l = l[1:]
+		}
+	}
+	if len(l) >= 5 {
+		return string(l[:5]) == "From ", true
+	}
+	if len(l) > 0 && l[len(l)-1] == '\n' {
+		return false, true
+	}
+	return false, string(l) != "From "[:len(l)]
+}
+
+func (qw *quoteWriter) flushLine(quote bool) error {
+	if quote {
+		if _, err := io.WriteString(qw.w, ">"); err != nil {
+			return err
+		}
+	}
+	_, err := qw.w.Write(qw.line)
+	qw.decided = qw.line[len(qw.line)-1] != '\n'
+	qw.line = qw.line[:0]
+	return err
+}
+
+func (qw *quoteWriter) Write(b []byte) (int, error) {
+	n := 0
+	for n < len(b) {
+		if qw.decided {
+			chunk := b[n:]
+			if i := bytes.IndexByte(chunk, '\n'); i >= 0 {
+				chunk = chunk[:i+1]
+			}
+			written, err := qw.w.Write(chunk)
+			n += written
+			if err != nil {
+				return n, err
+			}
+			qw.decided = chunk[len(chunk)-1] != '\n'
+			continue
+		}
+
+		// At the beginning of a line: buffer data until we know whether it
+		// needs to be quoted
+		qw.line = append(qw.line, b[n])
+		n++
+		if quote, ok := qw.decide(qw.line); ok {
+			if err := qw.flushLine(quote); err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// Flush writes any buffered data.
+func (qw *quoteWriter) Flush() error {
+	if len(qw.line) == 0 {
+		return nil
+	}
+	return qw.flushLine(false)
+}
+
+// lfWriter converts CRLF line endings to LF.
+type lfWriter struct {
+	w  io.Writer
+	cr bool // a CR has been held back
+}
+
+func (lw *lfWriter) Write(b []byte) (int, error) {
+	if lw.cr && len(b) > 0 {
+		lw.cr = false
+		if b[0] != '\n' {
+			if _, err := lw.w.Write([]byte{'\r'}); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	n := 0
+	for n < len(b) {
+		chunk := b[n:]
+		i := bytes.IndexByte(chunk, '\r')
+		if i < 0 {
+			written, err := lw.w.Write(chunk)
+			return n + written, err
+		}
+		written, err := lw.w.Write(chunk[:i])
+		n += written
+		if err != nil {
+			return n, err
+		}
+		n++
+		if n == len(b) {
+			// Wait for the next byte to know whether it's a line ending
+			lw.cr = true
+		} else if b[n] != '\n' {
+			if _, err := lw.w.Write([]byte{'\r'}); err != nil {
+				return n - 1, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// Flush writes any held back CR.
+func (lw *lfWriter) Flush() error {
+	if !lw.cr {
+		return nil
+	}
+	lw.cr = false
+	_, err := lw.w.Write([]byte{'\r'})
+	return err
+}
+
+// lastByteWriter keeps track of the last byte written.
+type lastByteWriter struct {
+	w    io.Writer
+	last byte
+}
+
+func (w *lastByteWriter) Write(b []byte) (int, error) {
+	if len(b) > 0 {
+		w.last = b[len(b)-1]
+	}
+	return w.w.Write(b)
+}
+
+type messageWriter struct {
+	w    *Writer
+	from string
+	lf   *lfWriter
+
+	// Used by formats without Content-Length
+	lw *lastByteWriter
+	qw *quoteWriter
+
+	// Used by formats with Content-Length
+	buf bytes.Buffer
+}
+
+func (mw *messageWriter) Write(b []byte) (int, error) {
+	if mw.w.cur != mw {
+		return 0, errors.New("mbox: message already closed")
+	}
+	return mw.lf.Write(b)
+}
+
+func (mw *messageWriter) Close() error {
+	if mw.w.cur != mw {
+		return nil
+	}
+	mw.w.cur = nil
+
+	if err := mw.lf.Flush(); err != nil {
+		return err
+	}
+	if mw.qw != nil {
+		if err := mw.qw.Flush(); err != nil {
+			return err
+		}
+		// The message must end with a line ending, followed by a blank line
+		sep := "\n"
+		if mw.lw.last != '\n' {
+			sep = "\n\n"
+		}
+		_, err := io.WriteString(mw.w.w, sep)
+		return err
+	}
+
+	br := bufio.NewReader(&mw.buf)
+	h, err := textproto.ReadHeader(br)
+	if err != nil {
+		return err
+	}
+	body, err := ioutil.ReadAll(br)
+	if err != nil {
+		return err
+	}
+	if len(body) > 0 && body[len(body)-1] != '\n' {
+		body = append(body, '\n')
+	}
+	if mw.w.format == Mboxcl {
+		var quoted bytes.Buffer
+		qw := &quoteWriter{w: &quoted}
+		qw.Write(body)
+		qw.Flush()
+		body = quoted.Bytes()
+	}
+
+	h.Del("Content-Length")
+	h.Add("Content-Length", strconv.Itoa(len(body)))
+
+	// Use LF line endings in the header, like in the body
+	var hb bytes.Buffer
+	if err := textproto.WriteHeader(&hb, h); err != nil {
+		return err
+	}
+	header := bytes.ReplaceAll(hb.Bytes(), []byte("\r\n"), []byte("\n"))
+
+	if _, err := io.WriteString(mw.w.w, mw.from); err != nil {
+		return err
+	}
+	if _, err := mw.w.w.Write(header); err != nil {
+		return err
+	}
+	if _, err := mw.w.w.Write(body); err != nil {
+		return err
+	}
+	_, err = io.WriteString(mw.w.w, "\n")
+	return err
+}
+
+// Writer writes messages to an mbox file.
+type Writer struct {
+	w      io.Writer
+	format Format
+	cur    *messageWriter
+}
+
+// NewWriter creates a new mbox writer for the given format. Messages are
+// appended to w.
+func NewWriter(w io.Writer, format Format) *Writer {
+	return &Writer{w: w, format: format}
+}
+
+// CreateMessage starts a new message. The raw message, header and body,
+// should be written to the returned io.WriteCloser. Close must be called
+// before the next message is created.
+//
+// from is the envelope sender: if empty, MAILER-DAEMON is used. date is the
+// delivery date: if zero, the current time is used.
+//
+// CRLF line endings are converted to LF, like in the rest of the mbox file.
+//
+// With the Mboxcl and Mboxcl2 formats, the message is buffered in memory to
+// compute its length, and any existing Content-Length header field is
+// replaced.
+func (w *Writer) CreateMessage(from string, date time.Time) (io.WriteCloser, error) {
+	if w.cur != nil {
+		return nil, errors.New("mbox: previous message not closed")
+	}
+
+	l, err := formatFromLine(from, date)
+	if err != nil {
+		return nil, err
+	}
+
+	mw := &messageWriter{w: w, from: l}
+	if !w.format.hasContentLength() {
+		if _, err := io.WriteString(w.w, l); err != nil {
+			return nil, err
+		}
+		mw.lw = &lastByteWriter{w: w.w, last: '\n'}
+		mw.qw = &quoteWriter{w: mw.lw, quoteAll: w.format == Mboxrd}
+		mw.lf = &lfWriter{w: mw.qw}
+	} else {
+		mw.lf = &lfWriter{w: &mw.buf}
+	}
+	w.cur = mw
+	return mw, nil
+}
+
+// WriteMessage writes a message.
+func (w *Writer) WriteMessage(msg *Message) error {
+	mw, err := w.CreateMessage(msg.From, msg.Date)
+	if err != nil {
+		return err
+	}
+	if err := msg.Entity.WriteTo(mw); err != nil {
+		mw.Close()
+		return err
+	}
+	return mw.Close()
+}
@@ -10,6 +10,7 @@
 //		_ "github.com/emersion/go-message/charset"
 //	)
 //
-// Note, non-UTF-8 charsets are only supported when reading messages. Only
-// UTF-8 is supported when writing messages.
+// The charset package also handles non-UTF-8 charsets when writing messages:
+// text written to a Writer is converted from UTF-8 to the charset specified in
+// the Content-Type header field.
 package message
@@ -0,0 +1,61 @@
+// Package pgpmime implements PGP/MIME messages.
+//
+// PGP/MIME is defined in RFC 3156. This package only handles the MIME
+// structure of messages: OpenPGP operations are delegated to a Signer,
+// Verifier, Encryptor or Decryptor, so that any OpenPGP implementation can be
+// used.
+//
+// To sign and encrypt a message (RFC 3156 section 6.1), write the output of
+// Sign to the writer returned by Encrypt.
+package pgpmime
+
+import (
+	"errors"
+	"io"
+)
+
+// ErrNotSigned is returned by Verify when the message isn't a PGP/MIME
+// signed message.
+var ErrNotSigned = errors.New("pgpmime: message is not signed")
+
+// ErrNotEncrypted is returned by Decrypt when the message isn't a PGP/MIME
+// encrypted message.
+var ErrNotEncrypted = errors.New("pgpmime: message is not encrypted")
+
+// A Signer creates detached OpenPGP signatures.
+type Signer interface {
+	// MICAlg returns the name of the hash algorithm used to sign, as defined
+	// in RFC 3156 section 5, for instance "pgp-sha256".
+	MICAlg() string
+	// Sign returns a writer for the data to sign. When the writer is closed,
+	// an ASCII-armored detached signature of the data must be written to
+	// signature.
+	Sign(signature io.Writer) (io.WriteCloser, error)
+}
+
+// A Verifier checks detached OpenPGP signatures.
+type Verifier interface {
+	// Verify checks that signature is a valid signature of signed. signed
+	// contains the exact bytes of the signed part, including its header, with
+	// CRLF line endings. signature is ASCII-armored.
+	//
+	// Implementations can keep track of the signer's identity if needed.
+	Verify(signed, signature io.Reader) error
+}
+
+// An Encryptor encrypts OpenPGP messages.
+type Encryptor interface {
+	// Encrypt returns a writer for the data to encrypt. When the writer is
+	// closed, the ASCII-armored encrypted data must have been written to
+	// ciphertext.
+	Encrypt(ciphertext io.Writer) (io.WriteCloser, error)
+}
+
+// A Decryptor decrypts OpenPGP messages.
+type Decryptor interface {
+	// Decrypt returns a reader for the decrypted data. ciphertext is
+	// ASCII-armored. If the encrypted data is authenticated, the returned
+	// reader should return an error at the end of the data if
+	// authentication fails.
+	Decrypt(ciphertext io.Reader) (io.Reader, error)
+}
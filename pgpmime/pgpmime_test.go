@@ -0,0 +1,353 @@
+package pgpmime
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/emersion/go-message"
+)
+
+const (
+	testSignatureHeader = "-----BEGIN PGP SIGNATURE-----\r\n\r\n"
+	testSignatureFooter = "\r\n-----END PGP SIGNATURE-----\r\n"
+	testMessageHeader   = "-----BEGIN PGP MESSAGE-----\r\n\r\n"
+	testMessageFooter   = "\r\n-----END PGP MESSAGE-----\r\n"
+)
+
+var testKey = []byte("test key")
+
+// testCrypto implements Signer, Verifier, Encryptor and Decryptor with a
+// HMAC and hex encoding, as a stand-in for an OpenPGP implementation.
+type testCrypto struct {
+	signed []byte
+}
+
+type testSignWriter struct {
+	w   io.Writer
+	buf bytes.Buffer
+	c   *testCrypto
+}
+
+func (w *testSignWriter) Write(b []byte) (int, error) {
+	return w.buf.Write(b)
+}
+
+func (w *testSignWriter) Close() error {
+	w.c.signed = append([]byte(nil), w.buf.Bytes()...)
+	mac := hmac.New(sha256.New, testKey)
+	mac.Write(w.buf.Bytes())
+	_, err := io.WriteString(w.w, testSignatureHeader+hex.EncodeToString(mac.Sum(nil))+testSignatureFooter)
+	return err
+}
+
+func (c *testCrypto) MICAlg() string {
+	return "pgp-sha256"
+}
+
+func (c *testCrypto) Sign(signature io.Writer) (io.WriteCloser, error) {
+	return &testSignWriter{w: signature, c: c}, nil
+}
+
+func (c *testCrypto) Verify(signed, signature io.Reader) error {
+	b, err := ioutil.ReadAll(signed)
+	if err != nil {
+		return err
+	}
+	c.signed = b
+
+	sig, err := ioutil.ReadAll(signature)
+	if err != nil {
+		return err
+	}
+	s := strings.TrimSpace(string(sig))
+	s = strings.TrimPrefix(s, strings.TrimSpace(testSignatureHeader))
+	s = strings.TrimSuffix(s, strings.TrimSpace(testSignatureFooter))
+	got, err := hex.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return err
+	}
+
+	mac := hmac.New(sha256.New, testKey)
+	mac.Write(b)
+	if !hmac.Equal(got, mac.Sum(nil)) {
+		return errors.New("bad signature")
+	}
+	return nil
+}
+
+type testEncryptWriter struct {
+	w   io.Writer
+	buf bytes.Buffer
+}
+
+func (w *testEncryptWriter) Write(b []byte) (int, error) {
+	return w.buf.Write(b)
+}
+
+func (w *testEncryptWriter) Close() error {
+	_, err := io.WriteString(w.w, testMessageHeader+hex.EncodeToString(w.buf.Bytes())+testMessageFooter)
+	return err
+}
+
+func (c *testCrypto) Encrypt(ciphertext io.Writer) (io.WriteCloser, error) {
+	return &testEncryptWriter{w: ciphertext}, nil
+}
+
+func (c *testCrypto) Decrypt(ciphertext io.Reader) (io.Reader, error) {
+	b, err := ioutil.ReadAll(ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	s := strings.TrimSpace(string(b))
+	if !strings.HasPrefix(s, strings.TrimSpace(testMessageHeader)) {
+		return nil, errors.New("not an OpenPGP message")
+	}
+	s = strings.TrimPrefix(s, strings.TrimSpace(testMessageHeader))
+	s = strings.TrimSuffix(s, strings.TrimSpace(testMessageFooter))
+	plaintext, err := hex.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(plaintext), nil
+}
+
+func testHeader() message.Header {
+	var h message.Header
+	h.Set("From", "Mitsuha Miyamizu <mitsuha.miyamizu@example.org>")
+	h.Set("Subject", "Your Name.")
+	return h
+}
+
+// Bare LF line endings must be canonicalized
+const testInnerEntity = "Content-Type: text/plain\n" +
+	"\n" +
+	"Who are you?\n"
+
+const testCanonicalInnerEntity = "Content-Type: text/plain\r\n" +
+	"\r\n" +
+	"Who are you?\r\n"
+
+func signMessage(t *testing.T, c *testCrypto) []byte {
+	var b bytes.Buffer
+	w, err := Sign(&b, testHeader(), c)
+	if err != nil {
+		t.Fatalf("Sign() = %v", err)
+	}
+	if _, err := io.WriteString(w, testInnerEntity); err != nil {
+		t.Fatalf("Write() = %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close() = %v", err)
+	}
+	return b.Bytes()
+}
+
+func checkInnerEntity(t *testing.T, e *message.Entity) {
+	if mediaType, _, _ := e.Header.ContentType(); mediaType != "text/plain" {
+		t.Errorf("inner media type = %q, want %q", mediaType, "text/plain")
+	}
+	b, err := ioutil.ReadAll(e.Body)
+	if err != nil {
+		t.Fatalf("ReadAll() = %v", err)
+	}
+	if s := string(b); s != "Who are you?\r\n" {
+		t.Errorf("inner body = %q, want %q", s, "Who are you?\r\n")
+	}
+}
+
+func TestSign(t *testing.T) {
+	signer := new(testCrypto)
+	b := signMessage(t, signer)
+
+	if s := string(signer.signed); s != testCanonicalInnerEntity {
+		t.Errorf("signed data = %q, want %q", s, testCanonicalInnerEntity)
+	}
+
+	e, err := message.Read(bytes.NewReader(b))
+	if err != nil {
+		t.Fatalf("message.Read() = %v", err)
+	}
+	mediaType, params, err := e.Header.ContentType()
+	if err != nil {
+		t.Fatalf("ContentType() = %v", err)
+	}
+	if mediaType != "multipart/signed" {
+		t.Errorf("media type = %q, want %q", mediaType, "multipart/signed")
+	}
+	if params["protocol"] != "application/pgp-signature" {
+		t.Errorf("protocol = %q, want %q", params["protocol"], "application/pgp-signature")
+	}
+	if params["micalg"] != "pgp-sha256" {
+		t.Errorf("micalg = %q, want %q", params["micalg"], "pgp-sha256")
+	}
+	if s := e.Header.Get("Subject"); s != "Your Name." {
+		t.Errorf("Subject = %q, want %q", s, "Your Name.")
+	}
+
+	verifier := new(testCrypto)
+	inner, err := Verify(e, verifier)
+	if err != nil {
+		t.Fatalf("Verify() = %v", err)
+	}
+	if s := string(verifier.signed); s != testCanonicalInnerEntity {
+		t.Errorf("verified data = %q, want %q", s, testCanonicalInnerEntity)
+	}
+	checkInnerEntity(t, inner)
+}
+
+// This message is in the format of the example in RFC 3156 section 5, with
+// bare LF line endings and a signed part without trailing line break.
+const testSignedMessage = `Content-Type: multipart/signed; boundary=bar; micalg=pgp-sha256;
+  protocol="application/pgp-signature"
+
+--bar
+Content-Type: text/plain
+
+Who are you?
+--bar
+Content-Type: application/pgp-signature
+
+-----BEGIN PGP SIGNATURE-----
+
+%s
+-----END PGP SIGNATURE-----
+
+--bar--
+`
+
+func TestVerify_lf(t *testing.T) {
+	signed := "Content-Type: text/plain\r\n\r\nWho are you?"
+	mac := hmac.New(sha256.New, testKey)
+	mac.Write([]byte(signed))
+	raw := strings.Replace(testSignedMessage, "%s", hex.EncodeToString(mac.Sum(nil)), 1)
+
+	e, err := message.Read(strings.NewReader(raw))
+	if err != nil {
+		t.Fatalf("message.Read() = %v", err)
+	}
+	verifier := new(testCrypto)
+	if _, err := Verify(e, verifier); err != nil {
+		t.Fatalf("Verify() = %v", err)
+	}
+	if s := string(verifier.signed); s != signed {
+		t.Errorf("verified data = %q, want %q", s, signed)
+	}
+}
+
+func TestVerify_tampered(t *testing.T) {
+	b := signMessage(t, new(testCrypto))
+	b = bytes.Replace(b, []byte("Who are you?"), []byte("Who am I?"), 1)
+
+	e, err := message.Read(bytes.NewReader(b))
+	if err != nil {
+		t.Fatalf("message.Read() = %v", err)
+	}
+	if _, err := Verify(e, new(testCrypto)); err == nil {
+		t.Error("Verify() = nil, want an error")
+	}
+}
+
+func TestVerify_notSigned(t *testing.T) {
+	e, err := message.Read(strings.NewReader(testInnerEntity))
+	if err != nil {
+		t.Fatalf("message.Read() = %v", err)
+	}
+	if _, err := Verify(e, new(testCrypto)); err != ErrNotSigned {
+		t.Errorf("Verify() = %v, want %v", err, ErrNotSigned)
+	}
+}
+
+func TestEncrypt(t *testing.T) {
+	c := new(testCrypto)
+
+	var b bytes.Buffer
+	w, err := Encrypt(&b, testHeader(), c)
+	if err != nil {
+		t.Fatalf("Encrypt() = %v", err)
+	}
+	if _, err := io.WriteString(w, testInnerEntity); err != nil {
+		t.Fatalf("Write() = %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close() = %v", err)
+	}
+
+	if strings.Contains(b.String(), "Who are you?") {
+		t.Error("encrypted message contains plaintext")
+	}
+
+	e, err := message.Read(&b)
+	if err != nil {
+		t.Fatalf("message.Read() = %v", err)
+	}
+	mediaType, params, err := e.Header.ContentType()
+	if err != nil {
+		t.Fatalf("ContentType() = %v", err)
+	}
+	if mediaType != "multipart/encrypted" {
+		t.Errorf("media type = %q, want %q", mediaType, "multipart/encrypted")
+	}
+	if params["protocol"] != "application/pgp-encrypted" {
+		t.Errorf("protocol = %q, want %q", params["protocol"], "application/pgp-encrypted")
+	}
+
+	inner, err := Decrypt(e, c)
+	if err != nil {
+		t.Fatalf("Decrypt() = %v", err)
+	}
+	checkInnerEntity(t, inner)
+}
+
+func TestEncrypt_signed(t *testing.T) {
+	c := new(testCrypto)
+
+	var b bytes.Buffer
+	ew, err := Encrypt(&b, testHeader(), c)
+	if err != nil {
+		t.Fatalf("Encrypt() = %v", err)
+	}
+	sw, err := Sign(ew, message.Header{}, c)
+	if err != nil {
+		t.Fatalf("Sign() = %v", err)
+	}
+	if _, err := io.WriteString(sw, testInnerEntity); err != nil {
+		t.Fatalf("Write() = %v", err)
+	}
+	if err := sw.Close(); err != nil {
+		t.Fatalf("Close() = %v", err)
+	}
+	if err := ew.Close(); err != nil {
+		t.Fatalf("Close() = %v", err)
+	}
+
+	e, err := message.Read(&b)
+	if err != nil {
+		t.Fatalf("message.Read() = %v", err)
+	}
+	signed, err := Decrypt(e, c)
+	if err != nil {
+		t.Fatalf("Decrypt() = %v", err)
+	}
+	inner, err := Verify(signed, c)
+	if err != nil {
+		t.Fatalf("Verify() = %v", err)
+	}
+	checkInnerEntity(t, inner)
+}
+
+func TestDecrypt_notEncrypted(t *testing.T) {
+	e, err := message.Read(strings.NewReader(testInnerEntity))
+	if err != nil {
+		t.Fatalf("message.Read() = %v", err)
+	}
+	if _, err := Decrypt(e, new(testCrypto)); err != ErrNotEncrypted {
+		t.Errorf("Decrypt() = %v, want %v", err, ErrNotEncrypted)
+	}
+}
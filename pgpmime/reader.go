@@ -0,0 +1,106 @@
+package pgpmime
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/emersion/go-message"
+	"github.com/emersion/go-message/internal/canonical"
+)
+
+// Verify checks the PGP/MIME signature of a multipart/signed message, and
+// returns the signed entity. The body of e must not have been read.
+//
+// verifier is given the exact bytes of the signed part, canonicalized to CRLF
+// line endings as required by RFC 3156 section 5.
+//
+// If the message isn't signed, ErrNotSigned is returned.
+func Verify(e *message.Entity, verifier Verifier) (*message.Entity, error) {
+	mediaType, params, err := e.Header.ContentType()
+	if err != nil || mediaType != "multipart/signed" {
+		return nil, ErrNotSigned
+	}
+	if !strings.EqualFold(params["protocol"], "application/pgp-signature") {
+		return nil, ErrNotSigned
+	}
+	boundary := params["boundary"]
+	if boundary == "" {
+		return nil, errors.New("pgpmime: missing multipart boundary")
+	}
+
+	body, err := ioutil.ReadAll(e.Body)
+	if err != nil {
+		return nil, err
+	}
+	parts, err := canonical.SplitMultipart(canonical.Bytes(body), boundary)
+	if err != nil {
+		return nil, fmt.Errorf("pgpmime: failed to split multipart/signed body: %v", err)
+	}
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("pgpmime: multipart/signed message has %v parts, want 2", len(parts))
+	}
+
+	sig, err := message.Read(bytes.NewReader(parts[1]))
+	if err != nil {
+		return nil, fmt.Errorf("pgpmime: failed to read signature part: %v", err)
+	}
+	if mediaType, _, _ := sig.Header.ContentType(); mediaType != "application/pgp-signature" {
+		return nil, fmt.Errorf("pgpmime: unexpected signature part media type %q", mediaType)
+	}
+
+	if err := verifier.Verify(bytes.NewReader(parts[0]), sig.Body); err != nil {
+		return nil, err
+	}
+
+	return message.Read(bytes.NewReader(parts[0]))
+}
+
+// Decrypt decrypts a multipart/encrypted PGP/MIME message, and returns the
+// decrypted entity. The body of the returned entity is read from the reader
+// returned by decryptor: errors reported at the end of the encrypted data,
+// for instance integrity check failures, are returned when reading it.
+//
+// If the message isn't encrypted, ErrNotEncrypted is returned.
+func Decrypt(e *message.Entity, decryptor Decryptor) (*message.Entity, error) {
+	mediaType, params, err := e.Header.ContentType()
+	if err != nil || mediaType != "multipart/encrypted" {
+		return nil, ErrNotEncrypted
+	}
+	if !strings.EqualFold(params["protocol"], "application/pgp-encrypted") {
+		return nil, ErrNotEncrypted
+	}
+
+	mr := e.MultipartReader()
+	if mr == nil {
+		return nil, errors.New("pgpmime: multipart/encrypted message is not multipart")
+	}
+
+	control, err := mr.NextPart()
+	if err != nil {
+		return nil, fmt.Errorf("pgpmime: failed to read control part: %v", err)
+	}
+	if mediaType, _, _ := control.Header.ContentType(); mediaType != "application/pgp-encrypted" {
+		return nil, fmt.Errorf("pgpmime: unexpected control part media type %q", mediaType)
+	}
+	if _, err := io.Copy(ioutil.Discard, control.Body); err != nil {
+		return nil, err
+	}
+
+	data, err := mr.NextPart()
+	if err != nil {
+		return nil, fmt.Errorf("pgpmime: failed to read encrypted part: %v", err)
+	}
+	if mediaType, _, _ := data.Header.ContentType(); mediaType != "application/octet-stream" {
+		return nil, fmt.Errorf("pgpmime: unexpected encrypted part media type %q", mediaType)
+	}
+
+	r, err := decryptor.Decrypt(data.Body)
+	if err != nil {
+		return nil, err
+	}
+	return message.Read(r)
+}
@@ -0,0 +1,160 @@
+package pgpmime
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/emersion/go-message"
+	"github.com/emersion/go-message/internal/canonical"
+)
+
+type detachedSigner struct {
+	w   *message.Writer
+	sw  io.WriteCloser
+	cw  *canonical.Writer
+	sig bytes.Buffer
+}
+
+func (s *detachedSigner) Write(b []byte) (int, error) {
+	return s.cw.Write(b)
+}
+
+func (s *detachedSigner) Close() error {
+	if err := s.sw.Close(); err != nil {
+		return err
+	}
+
+	// The CRLF preceding the boundary delimiter belongs to the delimiter
+	if _, err := io.WriteString(s.w, "\r\n"); err != nil {
+		return err
+	}
+
+	var h message.Header
+	h.SetContentType("application/pgp-signature", map[string]string{"name": "signature.asc"})
+	h.Set("Content-Description", "OpenPGP digital signature")
+	pw, err := s.w.CreatePart(h)
+	if err != nil {
+		return err
+	}
+	if _, err := s.sig.WriteTo(pw); err != nil {
+		return err
+	}
+	if err := pw.Close(); err != nil {
+		return err
+	}
+
+	return s.w.Close()
+}
+
+// Sign creates a writer to sign a message with PGP/MIME, using the
+// multipart/signed format defined in RFC 3156 section 5. header is the
+// header of the resulting message. The signed entity, including its header,
+// should be written to the returned io.WriteCloser. Close must be called to
+// write the signature.
+//
+// The signed entity is canonicalized to CRLF line endings before being
+// signed. It should only contain 7-bit data, otherwise the signature may be
+// broken in transit.
+func Sign(w io.Writer, header message.Header, signer Signer) (io.WriteCloser, error) {
+	boundary, err := canonical.RandomBoundary()
+	if err != nil {
+		return nil, err
+	}
+
+	header = header.Copy()
+	header.SetContentType("multipart/signed", map[string]string{
+		"boundary": boundary,
+		"protocol": "application/pgp-signature",
+		"micalg":   signer.MICAlg(),
+	})
+
+	mw, err := message.CreateWriter(w, header)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := fmt.Fprintf(mw, "--%s\r\n", boundary); err != nil {
+		return nil, err
+	}
+
+	s := &detachedSigner{w: mw}
+	s.sw, err = signer.Sign(&s.sig)
+	if err != nil {
+		return nil, err
+	}
+	s.cw = canonical.NewWriter(io.MultiWriter(mw, s.sw))
+	return s, nil
+}
+
+type encrypter struct {
+	w  *message.Writer
+	pw *message.Writer
+	ew io.WriteCloser
+	cw *canonical.Writer
+}
+
+func (e *encrypter) Write(b []byte) (int, error) {
+	return e.cw.Write(b)
+}
+
+func (e *encrypter) Close() error {
+	if err := e.ew.Close(); err != nil {
+		return err
+	}
+	if err := e.pw.Close(); err != nil {
+		return err
+	}
+	return e.w.Close()
+}
+
+// Encrypt creates a writer to encrypt a message with PGP/MIME, using the
+// multipart/encrypted format defined in RFC 3156 section 4. header is the
+// header of the resulting message. The entity to encrypt, including its
+// header, should be written to the returned io.WriteCloser. It's
+// canonicalized to CRLF line endings. Close must be called to finish the
+// message.
+func Encrypt(w io.Writer, header message.Header, encryptor Encryptor) (io.WriteCloser, error) {
+	header = header.Copy()
+	header.SetContentType("multipart/encrypted", map[string]string{
+		"protocol": "application/pgp-encrypted",
+	})
+
+	mw, err := message.CreateWriter(w, header)
+	if err != nil {
+		return nil, err
+	}
+
+	var controlHeader message.Header
+	controlHeader.SetContentType("application/pgp-encrypted", nil)
+	controlHeader.Set("Content-Description", "PGP/MIME version identification")
+	cw, err := mw.CreatePart(controlHeader)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.WriteString(cw, "Version: 1\r\n"); err != nil {
+		return nil, err
+	}
+	if err := cw.Close(); err != nil {
+		return nil, err
+	}
+
+	var dataHeader message.Header
+	dataHeader.SetContentType("application/octet-stream", map[string]string{"name": "encrypted.asc"})
+	dataHeader.Set("Content-Description", "OpenPGP encrypted message")
+	pw, err := mw.CreatePart(dataHeader)
+	if err != nil {
+		return nil, err
+	}
+
+	ew, err := encryptor.Encrypt(pw)
+	if err != nil {
+		return nil, err
+	}
+
+	return &encrypter{
+		w:  mw,
+		pw: pw,
+		ew: ew,
+		cw: canonical.NewWriter(ew),
+	}, nil
+}
@@ -0,0 +1,455 @@
+package smime
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+)
+
+// maxBERDepth limits the nesting of BER-encoded values.
+const maxBERDepth = 64
+
+// berToDER converts BER-encoded data to the subset of DER understood by
+// encoding/asn1: indefinite lengths are replaced with definite lengths and
+// constructed OCTET STRINGs are flattened. Other BER constructs are left
+// as-is.
+func berToDER(b []byte) ([]byte, error) {
+	der, rest, err := convertBER(b, 0)
+	if err != nil {
+		return nil, err
+	}
+	return append(der, rest...), nil
+}
+
+// convertBER converts the first BER-encoded value in b, and returns the rest.
+func convertBER(b []byte, depth int) (der, rest []byte, err error) {
+	if depth > maxBERDepth {
+		return nil, nil, errors.New("smime: BER value nested too deeply")
+	}
+
+	tag, content, indefinite, rest, err := parseBERHeader(b)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if tag[0]&0x20 == 0 {
+		if indefinite {
+			return nil, nil, errors.New("smime: primitive BER value with indefinite length")
+		}
+		return b[:len(b)-len(rest)], rest, nil
+	}
+
+	var children [][]byte
+	for {
+		if indefinite {
+			if len(content) < 2 {
+				return nil, nil, errors.New("smime: truncated BER value")
+			}
+			if content[0] == 0 && content[1] == 0 {
+				content = content[2:]
+				break
+			}
+		} else if len(content) == 0 {
+			break
+		}
+
+		var child []byte
+		child, content, err = convertBER(content, depth+1)
+		if err != nil {
+			return nil, nil, err
+		}
+		children = append(children, child)
+	}
+	if indefinite {
+		rest = content
+	}
+
+	if len(tag) == 1 && tag[0] == 0x24 {
+		// Constructed OCTET STRING: concatenate the segments
+		var s []byte
+		for _, child := range children {
+			if child[0] != 0x04 {
+				return nil, nil, errors.New("smime: invalid constructed OCTET STRING segment")
+			}
+			_, segment, _, _, err := parseBERHeader(child)
+			if err != nil {
+				return nil, nil, err
+			}
+			s = append(s, segment...)
+		}
+		return appendBER([]byte{0x04}, s), rest, nil
+	}
+
+	return appendBER(append([]byte(nil), tag...), bytes.Join(children, nil)), rest, nil
+}
+
+// parseBERHeader parses the identifier and length octets of a BER value.
+func parseBERHeader(b []byte) (tag, content []byte, indefinite bool, rest []byte, err error) {
+	if len(b) < 2 {
+		return nil, nil, false, nil, errors.New("smime: truncated BER value")
+	}
+
+	i := 1
+	if b[0]&0x1F == 0x1F {
+		// High tag number form
+		for {
+			if i >= len(b) {
+				return nil, nil, false, nil, errors.New("smime: truncated BER tag")
+			}
+			i++
+			if b[i-1]&0x80 == 0 {
+				break
+			}
+		}
+	}
+	tag = b[:i]
+
+	if i >= len(b) {
+		return nil, nil, false, nil, errors.New("smime: truncated BER length")
+	}
+	l := int(b[i])
+	i++
+	switch {
+	case l == 0x80:
+		return tag, b[i:], true, nil, nil
+	case l > 0x80:
+		n := l & 0x7F
+		if n > 4 || i+n > len(b) {
+			return nil, nil, false, nil, errors.New("smime: invalid BER length")
+		}
+		l = 0
+		for _, c := range b[i : i+n] {
+			l = l<<8 | int(c)
+		}
+		i += n
+		if l < 0 {
+			return nil, nil, false, nil, errors.New("smime: invalid BER length")
+		}
+	}
+	if l > len(b)-i {
+		return nil, nil, false, nil, errors.New("smime: truncated BER value")
+	}
+	return tag, b[i : i+l], false, b[i+l:], nil
+}
+
+// appendBER appends the DER length octets and content to tag.
+func appendBER(tag, content []byte) []byte {
+	b := tag
+	l := len(content)
+	switch {
+	case l < 0x80:
+		b = append(b, byte(l))
+	default:
+		var lb []byte
+		for ; l > 0; l >>= 8 {
+			lb = append([]byte{byte(l)}, lb...)
+		}
+		b = append(b, 0x80|byte(len(lb)))
+		b = append(b, lb...)
+	}
+	return append(b, content...)
+}
+
+// maxBERElementSize limits the size of BER values read in memory by
+// berReader.readElement.
+const maxBERElementSize = 16 << 20 // 16 MB
+
+// berReader reads BER-encoded values from a stream.
+type berReader struct {
+	r   *bufio.Reader
+	off int64
+}
+
+func newBERReader(r io.Reader) *berReader {
+	return &berReader{r: bufio.NewReader(r)}
+}
+
+// berHeader holds the identifier and length octets of a BER value.
+type berHeader struct {
+	tag    []byte
+	length int64 // -1 if indefinite
+}
+
+func (h *berHeader) is(tag byte) bool {
+	return len(h.tag) == 1 && h.tag[0] == tag
+}
+
+func (r *berReader) Read(b []byte) (int, error) {
+	n, err := r.r.Read(b)
+	r.off += int64(n)
+	return n, err
+}
+
+func (r *berReader) readByte() (byte, error) {
+	c, err := r.r.ReadByte()
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	} else if err == nil {
+		r.off++
+	}
+	return c, err
+}
+
+// readHeader reads the identifier and length octets of a value.
+func (r *berReader) readHeader() (*berHeader, error) {
+	c, err := r.readByte()
+	if err != nil {
+		return nil, err
+	}
+	tag := []byte{c}
+	if c&0x1F == 0x1F {
+		// High tag number form
+		for {
+			if len(tag) > 5 {
+				return nil, errors.New("smime: BER tag too long")
+			}
+			c, err := r.readByte()
+			if err != nil {
+				return nil, err
+			}
+			tag = append(tag, c)
+			if c&0x80 == 0 {
+				break
+			}
+		}
+	}
+
+	c, err = r.readByte()
+	if err != nil {
+		return nil, err
+	}
+	if c == 0x80 {
+		if tag[0]&0x20 == 0 {
+			return nil, errors.New("smime: primitive BER value with indefinite length")
+		}
+		return &berHeader{tag: tag, length: -1}, nil
+	} else if c < 0x80 {
+		return &berHeader{tag: tag, length: int64(c)}, nil
+	}
+
+	n := int(c & 0x7F)
+	if n > 7 {
+		return nil, errors.New("smime: invalid BER length")
+	}
+	var l int64
+	for i := 0; i < n; i++ {
+		c, err := r.readByte()
+		if err != nil {
+			return nil, err
+		}
+		l = l<<8 | int64(c)
+	}
+	return &berHeader{tag: tag, length: l}, nil
+}
+
+// readEndOfContents reads the end-of-contents octets terminating a value with
+// an indefinite length. It returns false if the next value isn't
+// end-of-contents.
+func (r *berReader) readEndOfContents() (bool, error) {
+	b, err := r.r.Peek(2)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return false, err
+	}
+	if b[0] != 0 || b[1] != 0 {
+		return false, nil
+	}
+	r.r.Discard(2)
+	r.off += 2
+	return true, nil
+}
+
+// peekTag returns the first identifier octet of the next value.
+func (r *berReader) peekTag() (byte, error) {
+	b, err := r.r.Peek(1)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+// readElement reads a whole value, and converts it to DER as berToDER does.
+func (r *berReader) readElement() ([]byte, error) {
+	b, err := r.readRawElement(nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	return berToDER(b)
+}
+
+func (r *berReader) readRawElement(b []byte, depth int) ([]byte, error) {
+	if depth > maxBERDepth {
+		return nil, errors.New("smime: BER value nested too deeply")
+	}
+
+	h, err := r.readHeader()
+	if err != nil {
+		return nil, err
+	}
+
+	if h.length < 0 {
+		b = append(b, h.tag...)
+		b = append(b, 0x80)
+		for {
+			if ok, err := r.readEndOfContents(); err != nil {
+				return nil, err
+			} else if ok {
+				return append(b, 0, 0), nil
+			}
+			if b, err = r.readRawElement(b, depth+1); err != nil {
+				return nil, err
+			}
+			if len(b) > maxBERElementSize {
+				return nil, errors.New("smime: BER value too large")
+			}
+		}
+	}
+
+	if h.length > maxBERElementSize-int64(len(b)) {
+		return nil, errors.New("smime: BER value too large")
+	}
+	b = appendBER(append(b, h.tag...), make([]byte, h.length))
+	if _, err := io.ReadFull(r, b[len(b)-int(h.length):]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return b, nil
+}
+
+// berOctetStringReader reads the contents of a BER-encoded OCTET STRING,
+// which may be split into segments.
+type berOctetStringReader struct {
+	r *berReader
+	// ends holds the end offsets of the enclosing constructed strings, -1 if
+	// they have an indefinite length
+	ends []int64
+	// n is the number of bytes left in the current segment
+	n int64
+}
+
+// newBEROctetStringReader creates a reader for the contents of a string
+// whose header h has just been read.
+func newBEROctetStringReader(r *berReader, h *berHeader) *berOctetStringReader {
+	osr := &berOctetStringReader{r: r}
+	if h.tag[0]&0x20 == 0 {
+		osr.n = h.length
+	} else {
+		osr.push(h)
+	}
+	return osr
+}
+
+func (osr *berOctetStringReader) push(h *berHeader) {
+	end := int64(-1)
+	if h.length >= 0 {
+		end = osr.r.off + h.length
+	}
+	osr.ends = append(osr.ends, end)
+}
+
+func (osr *berOctetStringReader) Read(b []byte) (int, error) {
+	for osr.n == 0 {
+		if len(osr.ends) == 0 {
+			return 0, io.EOF
+		}
+
+		end := osr.ends[len(osr.ends)-1]
+		if end >= 0 && osr.r.off >= end {
+			if osr.r.off > end {
+				return 0, errors.New("smime: invalid constructed OCTET STRING length")
+			}
+			osr.ends = osr.ends[:len(osr.ends)-1]
+			continue
+		} else if end < 0 {
+			if ok, err := osr.r.readEndOfContents(); err != nil {
+				return 0, err
+			} else if ok {
+				osr.ends = osr.ends[:len(osr.ends)-1]
+				continue
+			}
+		}
+
+		h, err := osr.r.readHeader()
+		if err != nil {
+			return 0, err
+		}
+		switch {
+		case h.is(0x04):
+			osr.n = h.length
+		case h.is(0x24):
+			if len(osr.ends) > maxBERDepth {
+				return 0, errors.New("smime: BER value nested too deeply")
+			}
+			osr.push(h)
+		default:
+			return 0, errors.New("smime: invalid constructed OCTET STRING segment")
+		}
+	}
+
+	if int64(len(b)) > osr.n {
+		b = b[:osr.n]
+	}
+	n, err := osr.r.Read(b)
+	osr.n -= int64(n)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// berIndefiniteHeader returns the identifier and length octets of a
+// constructed value with an indefinite length.
+func berIndefiniteHeader(tag byte) []byte {
+	return []byte{tag | 0x20, 0x80}
+}
+
+// berEndOfContents terminates a value with an indefinite length.
+var berEndOfContents = []byte{0, 0}
+
+// berSegmentWriter writes data as segments of a constructed OCTET STRING.
+type berSegmentWriter struct {
+	w   io.Writer
+	buf []byte
+}
+
+const berSegmentSize = 4096
+
+func (w *berSegmentWriter) Write(b []byte) (int, error) {
+	n := len(b)
+	for len(b) > 0 {
+		if w.buf == nil {
+			w.buf = make([]byte, 0, berSegmentSize)
+		}
+		l := cap(w.buf) - len(w.buf)
+		if l > len(b) {
+			l = len(b)
+		}
+		w.buf = append(w.buf, b[:l]...)
+		b = b[l:]
+
+		if len(w.buf) == cap(w.buf) {
+			if err := w.Flush(); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// Flush writes buffered data as a segment.
+func (w *berSegmentWriter) Flush() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+	_, err := w.w.Write(appendBER([]byte{0x04}, w.buf))
+	w.buf = w.buf[:0]
+	return err
+}
@@ -0,0 +1,499 @@
+package smime
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"time"
+)
+
+// Object identifiers, as defined in RFC 5652, RFC 5754, RFC 3279, RFC 8419,
+// RFC 5083, RFC 3565, RFC 5084, RFC 8017 and RFC 5753.
+var (
+	oidData              = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidSignedData        = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidEnvelopedData     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 3}
+	oidAuthEnvelopedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 1, 23}
+
+	oidAttributeContentType   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidAttributeMessageDigest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidAttributeSigningTime   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 5}
+
+	oidSHA1   = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidSHA256 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidSHA384 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 2}
+	oidSHA512 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 3}
+
+	oidRSAEncryption   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	oidSHA1WithRSA     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 5}
+	oidSHA256WithRSA   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 11}
+	oidSHA384WithRSA   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 12}
+	oidSHA512WithRSA   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 13}
+	oidECPublicKey     = asn1.ObjectIdentifier{1, 2, 840, 10045, 2, 1}
+	oidECDSAWithSHA1   = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 1}
+	oidECDSAWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+	oidECDSAWithSHA384 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 3}
+	oidECDSAWithSHA512 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 4}
+	oidEd25519         = asn1.ObjectIdentifier{1, 3, 101, 112}
+
+	oidAES128CBC  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	oidAES128Wrap = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 5}
+	oidAES128GCM  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 6}
+	oidAES192CBC  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+	oidAES192Wrap = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 25}
+	oidAES192GCM  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 26}
+	oidAES256CBC  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+	oidAES256Wrap = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 45}
+	oidAES256GCM  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 46}
+
+	oidRSAESOAEP = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 7}
+	oidMGF1      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 8}
+
+	oidDHSinglePassStdDHSHA1KDF   = asn1.ObjectIdentifier{1, 3, 133, 16, 840, 63, 0, 2}
+	oidDHSinglePassStdDHSHA256KDF = asn1.ObjectIdentifier{1, 3, 132, 1, 11, 1}
+	oidDHSinglePassStdDHSHA384KDF = asn1.ObjectIdentifier{1, 3, 132, 1, 11, 2}
+	oidDHSinglePassStdDHSHA512KDF = asn1.ObjectIdentifier{1, 3, 132, 1, 11, 3}
+)
+
+var digestAlgorithms = []struct {
+	oid  asn1.ObjectIdentifier
+	hash crypto.Hash
+}{
+	{oidSHA1, crypto.SHA1},
+	{oidSHA256, crypto.SHA256},
+	{oidSHA384, crypto.SHA384},
+	{oidSHA512, crypto.SHA512},
+}
+
+func hashByOID(oid asn1.ObjectIdentifier) (crypto.Hash, error) {
+	for _, alg := range digestAlgorithms {
+		if alg.oid.Equal(oid) {
+			return alg.hash, nil
+		}
+	}
+	return 0, fmt.Errorf("smime: unsupported digest algorithm %v", oid)
+}
+
+func oidByHash(hash crypto.Hash) asn1.ObjectIdentifier {
+	for _, alg := range digestAlgorithms {
+		if alg.hash == hash {
+			return alg.oid
+		}
+	}
+	return nil
+}
+
+var signatureKeyAlgorithms = []struct {
+	oid  asn1.ObjectIdentifier
+	algo x509.PublicKeyAlgorithm
+}{
+	{oidRSAEncryption, x509.RSA},
+	{oidSHA1WithRSA, x509.RSA},
+	{oidSHA256WithRSA, x509.RSA},
+	{oidSHA384WithRSA, x509.RSA},
+	{oidSHA512WithRSA, x509.RSA},
+	{oidECPublicKey, x509.ECDSA},
+	{oidECDSAWithSHA1, x509.ECDSA},
+	{oidECDSAWithSHA256, x509.ECDSA},
+	{oidECDSAWithSHA384, x509.ECDSA},
+	{oidECDSAWithSHA512, x509.ECDSA},
+	{oidEd25519, x509.Ed25519},
+}
+
+var signatureAlgorithms = []struct {
+	pubKeyAlgo x509.PublicKeyAlgorithm
+	hash       crypto.Hash
+	sigAlgo    x509.SignatureAlgorithm
+}{
+	{x509.RSA, crypto.SHA1, x509.SHA1WithRSA},
+	{x509.RSA, crypto.SHA256, x509.SHA256WithRSA},
+	{x509.RSA, crypto.SHA384, x509.SHA384WithRSA},
+	{x509.RSA, crypto.SHA512, x509.SHA512WithRSA},
+	{x509.ECDSA, crypto.SHA1, x509.ECDSAWithSHA1},
+	{x509.ECDSA, crypto.SHA256, x509.ECDSAWithSHA256},
+	{x509.ECDSA, crypto.SHA384, x509.ECDSAWithSHA384},
+	{x509.ECDSA, crypto.SHA512, x509.ECDSAWithSHA512},
+	// RFC 8419 section 3.1: SHA-512 must be used with Ed25519
+	{x509.Ed25519, crypto.SHA512, x509.PureEd25519},
+}
+
+// signatureAlgorithm returns the x509 signature algorithm matching a CMS
+// signature algorithm identifier and digest algorithm, for a signer
+// certificate.
+func signatureAlgorithm(oid asn1.ObjectIdentifier, hash crypto.Hash, cert *x509.Certificate) (x509.SignatureAlgorithm, error) {
+	pubKeyAlgo := x509.UnknownPublicKeyAlgorithm
+	for _, alg := range signatureKeyAlgorithms {
+		if alg.oid.Equal(oid) {
+			pubKeyAlgo = alg.algo
+			break
+		}
+	}
+	if pubKeyAlgo == x509.UnknownPublicKeyAlgorithm {
+		return x509.UnknownSignatureAlgorithm, fmt.Errorf("smime: unsupported signature algorithm %v", oid)
+	}
+	if pubKeyAlgo != cert.PublicKeyAlgorithm {
+		return x509.UnknownSignatureAlgorithm, errors.New("smime: signature algorithm doesn't match signer public key")
+	}
+
+	for _, alg := range signatureAlgorithms {
+		if alg.pubKeyAlgo == pubKeyAlgo && alg.hash == hash {
+			return alg.sigAlgo, nil
+		}
+	}
+	return x509.UnknownSignatureAlgorithm, fmt.Errorf("smime: unsupported digest algorithm %v for %v", hash, pubKeyAlgo)
+}
+
+// contentInfo is a CMS ContentInfo, as defined in RFC 5652 section 3.
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,optional,tag:0"`
+}
+
+// signedData is a CMS SignedData, as defined in RFC 5652 section 5.1.
+type signedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	EncapContentInfo encapsulatedContentInfo
+	Certificates     rawCertificates `asn1:"optional,tag:0"`
+	CRLs             asn1.RawValue   `asn1:"optional,tag:1"`
+	SignerInfos      []signerInfo    `asn1:"set"`
+}
+
+// encapsulatedContentInfo is a CMS EncapsulatedContentInfo, as defined in
+// RFC 5652 section 5.2. EContent is nil for detached signatures.
+type encapsulatedContentInfo struct {
+	EContentType asn1.ObjectIdentifier
+	EContent     []byte `asn1:"explicit,optional,tag:0"`
+}
+
+// rawCertificates holds a [0] IMPLICIT CertificateSet, including its tag.
+type rawCertificates struct {
+	Raw asn1.RawContent
+}
+
+func marshalCertificates(certs []*x509.Certificate) (rawCertificates, error) {
+	var b []byte
+	for _, cert := range certs {
+		b = append(b, cert.Raw...)
+	}
+	raw, err := asn1.Marshal(asn1.RawValue{
+		Class:      asn1.ClassContextSpecific,
+		Tag:        0,
+		IsCompound: true,
+		Bytes:      b,
+	})
+	return rawCertificates{Raw: raw}, err
+}
+
+func (raw rawCertificates) parse() ([]*x509.Certificate, error) {
+	if len(raw.Raw) == 0 {
+		return nil, nil
+	}
+
+	var val asn1.RawValue
+	if _, err := asn1.Unmarshal(raw.Raw, &val); err != nil {
+		return nil, err
+	}
+	// Other certificate formats (attribute certificates and so on) aren't
+	// supported
+	return x509.ParseCertificates(val.Bytes)
+}
+
+// signerInfo is a CMS SignerInfo, as defined in RFC 5652 section 5.3.
+type signerInfo struct {
+	Version            int
+	SID                asn1.RawValue
+	DigestAlgorithm    pkix.AlgorithmIdentifier
+	SignedAttrs        asn1.RawValue `asn1:"optional,tag:0"`
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          []byte
+	UnsignedAttrs      asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+// issuerAndSerialNumber identifies a certificate, as defined in RFC 5652
+// section 10.2.4.
+type issuerAndSerialNumber struct {
+	Issuer       asn1.RawValue
+	SerialNumber *big.Int
+}
+
+// attribute is a CMS Attribute, as defined in RFC 5652 section 5.3.
+type attribute struct {
+	Type   asn1.ObjectIdentifier
+	Values []asn1.RawValue `asn1:"set"`
+}
+
+// marshalAttributes encodes a SET OF Attribute. DER requires the elements to
+// be sorted.
+func marshalAttributes(attrs []attribute) ([]byte, error) {
+	encoded := make([][]byte, len(attrs))
+	for i, attr := range attrs {
+		b, err := asn1.Marshal(attr)
+		if err != nil {
+			return nil, err
+		}
+		encoded[i] = b
+	}
+	sort.Slice(encoded, func(i, j int) bool {
+		return bytes.Compare(encoded[i], encoded[j]) < 0
+	})
+
+	return asn1.Marshal(asn1.RawValue{
+		Class:      asn1.ClassUniversal,
+		Tag:        asn1.TagSet,
+		IsCompound: true,
+		Bytes:      bytes.Join(encoded, nil),
+	})
+}
+
+func newAttribute(oid asn1.ObjectIdentifier, v interface{}) (attribute, error) {
+	b, err := asn1.Marshal(v)
+	if err != nil {
+		return attribute{}, err
+	}
+	return attribute{Type: oid, Values: []asn1.RawValue{{FullBytes: b}}}, nil
+}
+
+// attributeValue unmarshals the single value of the attribute with the
+// specified type into v. It returns false if the attribute is missing.
+func attributeValue(attrs []attribute, oid asn1.ObjectIdentifier, v interface{}) (bool, error) {
+	for _, attr := range attrs {
+		if !attr.Type.Equal(oid) {
+			continue
+		}
+		if len(attr.Values) != 1 {
+			return false, fmt.Errorf("smime: attribute %v must have a single value", oid)
+		}
+		if _, err := asn1.Unmarshal(attr.Values[0].FullBytes, v); err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+// newSignerInfo creates a SignerInfo with signed attributes for content with
+// the provided digest.
+func newSignerInfo(signer *Signer, digest []byte, now time.Time) (*signerInfo, error) {
+	hash, sigAlg, err := signer.algorithms()
+	if err != nil {
+		return nil, err
+	}
+
+	var attrs []attribute
+	for _, kv := range []struct {
+		oid asn1.ObjectIdentifier
+		v   interface{}
+	}{
+		{oidAttributeContentType, oidData},
+		{oidAttributeSigningTime, now.UTC()},
+		{oidAttributeMessageDigest, digest},
+	} {
+		attr, err := newAttribute(kv.oid, kv.v)
+		if err != nil {
+			return nil, err
+		}
+		attrs = append(attrs, attr)
+	}
+	signedAttrs, err := marshalAttributes(attrs)
+	if err != nil {
+		return nil, err
+	}
+
+	sig, err := signer.sign(hash, signedAttrs)
+	if err != nil {
+		return nil, err
+	}
+
+	sid, err := marshalIssuerAndSerialNumber(signer.Certificate)
+	if err != nil {
+		return nil, err
+	}
+
+	// The signature is computed over the SET OF encoding, but the field is
+	// [0] IMPLICIT
+	signedAttrs[0] = 0xA0
+
+	return &signerInfo{
+		Version:            1,
+		SID:                sid,
+		DigestAlgorithm:    pkix.AlgorithmIdentifier{Algorithm: oidByHash(hash)},
+		SignedAttrs:        asn1.RawValue{FullBytes: signedAttrs},
+		SignatureAlgorithm: sigAlg,
+		Signature:          sig,
+	}, nil
+}
+
+// marshalSignedData creates a ContentInfo containing a SignedData. content is
+// nil for detached signatures.
+func marshalSignedData(signer *Signer, digest, content []byte, now time.Time) ([]byte, error) {
+	si, err := newSignerInfo(signer, digest, now)
+	if err != nil {
+		return nil, err
+	}
+
+	certs, err := marshalCertificates(append([]*x509.Certificate{signer.Certificate}, signer.Intermediates...))
+	if err != nil {
+		return nil, err
+	}
+
+	sd, err := asn1.Marshal(signedData{
+		Version:          1,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{si.DigestAlgorithm},
+		EncapContentInfo: encapsulatedContentInfo{
+			EContentType: oidData,
+			EContent:     content,
+		},
+		Certificates: certs,
+		SignerInfos:  []signerInfo{*si},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(contentInfo{
+		ContentType: oidSignedData,
+		Content: asn1.RawValue{
+			Class:      asn1.ClassContextSpecific,
+			Tag:        0,
+			IsCompound: true,
+			Bytes:      sd,
+		},
+	})
+}
+
+// parseSignedData parses a DER or BER-encoded ContentInfo containing a
+// SignedData.
+func parseSignedData(b []byte) (*signedData, error) {
+	b, err := berToDER(b)
+	if err != nil {
+		return nil, err
+	}
+
+	var ci contentInfo
+	if rest, err := asn1.Unmarshal(b, &ci); err != nil {
+		return nil, fmt.Errorf("smime: failed to parse ContentInfo: %v", err)
+	} else if len(rest) > 0 {
+		return nil, errors.New("smime: trailing data after ContentInfo")
+	}
+	if !ci.ContentType.Equal(oidSignedData) {
+		return nil, fmt.Errorf("smime: unexpected content type %v", ci.ContentType)
+	}
+
+	var sd signedData
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, &sd); err != nil {
+		return nil, fmt.Errorf("smime: failed to parse SignedData: %v", err)
+	}
+	return &sd, nil
+}
+
+// recipientKeyIdentifier is a CMS RecipientKeyIdentifier, as defined in
+// RFC 5652 section 6.2.2.
+type recipientKeyIdentifier struct {
+	SubjectKeyIdentifier []byte
+	Date                 asn1.RawValue `asn1:"optional"`
+	Other                asn1.RawValue `asn1:"optional"`
+}
+
+// matchesIdentifier checks whether cert is identified by id. id can be an
+// IssuerAndSerialNumber, a [0] IMPLICIT SubjectKeyIdentifier (as in a
+// SignerIdentifier or RecipientIdentifier) or a [0] IMPLICIT
+// RecipientKeyIdentifier (as in a KeyAgreeRecipientIdentifier).
+func matchesIdentifier(cert *x509.Certificate, id asn1.RawValue) bool {
+	if id.Class == asn1.ClassContextSpecific && id.Tag == 0 {
+		ski := id.Bytes
+		if id.IsCompound {
+			var rki recipientKeyIdentifier
+			if _, err := asn1.UnmarshalWithParams(id.FullBytes, &rki, "tag:0"); err != nil {
+				return false
+			}
+			ski = rki.SubjectKeyIdentifier
+		}
+		return len(cert.SubjectKeyId) > 0 && bytes.Equal(cert.SubjectKeyId, ski)
+	}
+
+	var ias issuerAndSerialNumber
+	if _, err := asn1.Unmarshal(id.FullBytes, &ias); err != nil {
+		return false
+	}
+	return bytes.Equal(cert.RawIssuer, ias.Issuer.FullBytes) && cert.SerialNumber.Cmp(ias.SerialNumber) == 0
+}
+
+// marshalIssuerAndSerialNumber returns the IssuerAndSerialNumber identifying
+// cert.
+func marshalIssuerAndSerialNumber(cert *x509.Certificate) (asn1.RawValue, error) {
+	b, err := asn1.Marshal(issuerAndSerialNumber{
+		Issuer:       asn1.RawValue{FullBytes: cert.RawIssuer},
+		SerialNumber: cert.SerialNumber,
+	})
+	return asn1.RawValue{FullBytes: b}, err
+}
+
+// findCertificate returns the certificate identified by a SignerIdentifier.
+func findCertificate(certs []*x509.Certificate, sid asn1.RawValue) (*x509.Certificate, error) {
+	for _, cert := range certs {
+		if matchesIdentifier(cert, sid) {
+			return cert, nil
+		}
+	}
+	return nil, errors.New("smime: signer certificate not found")
+}
+
+// verify checks the signature of content by cert. contentType is the
+// encapsulated content type.
+func (si *signerInfo) verify(cert *x509.Certificate, content []byte, contentType asn1.ObjectIdentifier) error {
+	hash, err := hashByOID(si.DigestAlgorithm.Algorithm)
+	if err != nil {
+		return err
+	}
+	sigAlgo, err := signatureAlgorithm(si.SignatureAlgorithm.Algorithm, hash, cert)
+	if err != nil {
+		return err
+	}
+
+	signed := content
+	if len(si.SignedAttrs.FullBytes) > 0 {
+		// The signature is computed over the SET OF encoding
+		signed = append([]byte(nil), si.SignedAttrs.FullBytes...)
+		signed[0] = 0x31
+
+		var attrs []attribute
+		if _, err := asn1.UnmarshalWithParams(signed, &attrs, "set"); err != nil {
+			return fmt.Errorf("smime: failed to parse signed attributes: %v", err)
+		}
+
+		// RFC 5652 section 5.3: the content-type and message-digest
+		// attributes are mandatory
+		var ct asn1.ObjectIdentifier
+		if ok, err := attributeValue(attrs, oidAttributeContentType, &ct); err != nil {
+			return err
+		} else if !ok {
+			return errors.New("smime: missing content-type attribute")
+		} else if !ct.Equal(contentType) {
+			return errors.New("smime: content-type attribute mismatch")
+		}
+
+		var digest []byte
+		if ok, err := attributeValue(attrs, oidAttributeMessageDigest, &digest); err != nil {
+			return err
+		} else if !ok {
+			return errors.New("smime: missing message-digest attribute")
+		}
+		h := hash.New()
+		h.Write(content)
+		if !bytes.Equal(h.Sum(nil), digest) {
+			return errors.New("smime: message digest mismatch")
+		}
+	}
+
+	if err := cert.CheckSignature(sigAlgo, signed, si.Signature); err != nil {
+		return fmt.Errorf("smime: invalid signature: %v", err)
+	}
+	return nil
+}
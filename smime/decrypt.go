@@ -0,0 +1,413 @@
+package smime
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/emersion/go-message"
+)
+
+// ErrNotEncrypted is returned by Decrypt when the message isn't encrypted
+// with S/MIME.
+var ErrNotEncrypted = errors.New("smime: message is not encrypted")
+
+var errNoRecipient = errors.New("smime: message is not encrypted for this recipient")
+
+// A Recipient holds a certificate and its private key, used to decrypt
+// messages.
+type Recipient struct {
+	// Certificate is the recipient's certificate.
+	Certificate *x509.Certificate
+	// PrivateKey is the private key matching Certificate. It must be a
+	// crypto.Decrypter for RSA keys, or an *ecdsa.PrivateKey for elliptic
+	// curve keys.
+	PrivateKey crypto.PrivateKey
+}
+
+// rsaOAEPParameters holds the parameters of RSAES-OAEP, as defined in
+// RFC 8017 appendix A.2.1.
+type rsaOAEPParameters struct {
+	HashFunc    pkix.AlgorithmIdentifier `asn1:"optional,explicit,tag:0"`
+	MaskGenFunc pkix.AlgorithmIdentifier `asn1:"optional,explicit,tag:1"`
+	PSourceFunc pkix.AlgorithmIdentifier `asn1:"optional,explicit,tag:2"`
+}
+
+func (params *rsaOAEPParameters) options() (*rsa.OAEPOptions, error) {
+	// The default hash and mask generation functions use SHA-1
+	opts := &rsa.OAEPOptions{Hash: crypto.SHA1}
+	if params.HashFunc.Algorithm != nil {
+		hash, err := hashByOID(params.HashFunc.Algorithm)
+		if err != nil {
+			return nil, err
+		}
+		opts.Hash = hash
+	}
+
+	mgfHash := crypto.SHA1
+	if params.MaskGenFunc.Algorithm != nil {
+		if !params.MaskGenFunc.Algorithm.Equal(oidMGF1) {
+			return nil, fmt.Errorf("smime: unsupported mask generation function %v", params.MaskGenFunc.Algorithm)
+		}
+		var alg pkix.AlgorithmIdentifier
+		if _, err := asn1.Unmarshal(params.MaskGenFunc.Parameters.FullBytes, &alg); err != nil {
+			return nil, fmt.Errorf("smime: failed to parse mask generation function: %v", err)
+		}
+		hash, err := hashByOID(alg.Algorithm)
+		if err != nil {
+			return nil, err
+		}
+		mgfHash = hash
+	}
+	if mgfHash != opts.Hash {
+		return nil, errors.New("smime: RSAES-OAEP with different hash and mask generation functions is not supported")
+	}
+
+	if len(params.PSourceFunc.Parameters.FullBytes) > 0 {
+		if _, err := asn1.Unmarshal(params.PSourceFunc.Parameters.FullBytes, &opts.Label); err != nil {
+			return nil, fmt.Errorf("smime: failed to parse RSAES-OAEP label: %v", err)
+		}
+	}
+
+	return opts, nil
+}
+
+// decryptKey decrypts the content-encryption key, which has keySize bytes.
+func (ktri *keyTransRecipientInfo) decryptKey(priv crypto.PrivateKey, keySize int) ([]byte, error) {
+	dec, ok := priv.(crypto.Decrypter)
+	if !ok {
+		return nil, errors.New("smime: recipient private key doesn't support decryption")
+	}
+	if _, ok := dec.Public().(*rsa.PublicKey); !ok {
+		return nil, errors.New("smime: recipient private key is not an RSA key")
+	}
+
+	alg := ktri.KeyEncryptionAlgorithm
+	switch {
+	case alg.Algorithm.Equal(oidRSAEncryption):
+		// If decryption fails, a random key is returned: this prevents
+		// Bleichenbacher attacks, decrypting the content will fail
+		return dec.Decrypt(rand.Reader, ktri.EncryptedKey, &rsa.PKCS1v15DecryptOptions{
+			SessionKeyLen: keySize,
+		})
+	case alg.Algorithm.Equal(oidRSAESOAEP):
+		var params rsaOAEPParameters
+		if len(alg.Parameters.FullBytes) > 0 {
+			if _, err := asn1.Unmarshal(alg.Parameters.FullBytes, &params); err != nil {
+				return nil, fmt.Errorf("smime: failed to parse RSAES-OAEP parameters: %v", err)
+			}
+		}
+		opts, err := params.options()
+		if err != nil {
+			return nil, err
+		}
+		return dec.Decrypt(rand.Reader, ktri.EncryptedKey, opts)
+	default:
+		return nil, fmt.Errorf("smime: unsupported key encryption algorithm %v", alg.Algorithm)
+	}
+}
+
+// decryptContentEncryptionKey finds the RecipientInfo for recipient and
+// decrypts the content-encryption key.
+func decryptContentEncryptionKey(recipientInfos []asn1.RawValue, recipient *Recipient, keySize int) ([]byte, error) {
+	for _, ri := range recipientInfos {
+		var cek []byte
+		var err error
+		switch {
+		case ri.Class == asn1.ClassUniversal && ri.Tag == asn1.TagSequence:
+			var ktri keyTransRecipientInfo
+			if _, err := asn1.Unmarshal(ri.FullBytes, &ktri); err != nil {
+				return nil, fmt.Errorf("smime: failed to parse KeyTransRecipientInfo: %v", err)
+			}
+			if !matchesIdentifier(recipient.Certificate, ktri.RID) {
+				continue
+			}
+			cek, err = ktri.decryptKey(recipient.PrivateKey, keySize)
+		case ri.Class == asn1.ClassContextSpecific && ri.Tag == 1:
+			var kari keyAgreeRecipientInfo
+			if _, err := asn1.UnmarshalWithParams(ri.FullBytes, &kari, "tag:1"); err != nil {
+				return nil, fmt.Errorf("smime: failed to parse KeyAgreeRecipientInfo: %v", err)
+			}
+			priv, ok := recipient.PrivateKey.(*ecdsa.PrivateKey)
+			if !ok {
+				continue
+			}
+			cek, err = kari.decryptKey(recipient.Certificate, priv)
+			if err == errNoRecipient {
+				continue
+			}
+		default:
+			// Other kinds of recipients aren't supported
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(cek) != keySize {
+			return nil, errors.New("smime: invalid content-encryption key size")
+		}
+		return cek, nil
+	}
+	return nil, errNoRecipient
+}
+
+// cbcDecrypter decrypts data encrypted with CBC and PKCS #7 padding.
+type cbcDecrypter struct {
+	r    io.Reader
+	mode cipher.BlockMode
+	buf  []byte // ciphertext not decrypted yet
+	out  []byte // plaintext not returned yet
+	eof  bool
+}
+
+func (d *cbcDecrypter) Read(b []byte) (int, error) {
+	bs := d.mode.BlockSize()
+	for len(d.out) == 0 {
+		if d.eof {
+			return 0, io.EOF
+		}
+
+		var chunk [4096]byte
+		n, err := d.r.Read(chunk[:])
+		d.buf = append(d.buf, chunk[:n]...)
+		if err == io.EOF {
+			if len(d.buf) == 0 || len(d.buf)%bs != 0 {
+				return 0, errors.New("smime: invalid encrypted content length")
+			}
+			d.mode.CryptBlocks(d.buf, d.buf)
+			pad := int(d.buf[len(d.buf)-1])
+			if pad == 0 || pad > bs {
+				return 0, errors.New("smime: invalid padding")
+			}
+			for _, c := range d.buf[len(d.buf)-pad:] {
+				if int(c) != pad {
+					return 0, errors.New("smime: invalid padding")
+				}
+			}
+			d.out = d.buf[:len(d.buf)-pad]
+			d.buf = nil
+			d.eof = true
+		} else if err != nil {
+			return 0, err
+		} else if len(d.buf) > bs {
+			// The last block may contain padding: keep it until the end
+			l := (len(d.buf) - 1) / bs * bs
+			d.out = make([]byte, l)
+			d.mode.CryptBlocks(d.out, d.buf[:l])
+			d.buf = append(d.buf[:0], d.buf[l:]...)
+		}
+	}
+
+	n := copy(b, d.out)
+	d.out = d.out[n:]
+	return n, nil
+}
+
+// decryptEnvelope reads an EnvelopedData or AuthEnvelopedData from r, and
+// returns a reader for the decrypted content.
+func decryptEnvelope(r *berReader, recipient *Recipient) (io.Reader, error) {
+	errInvalid := errors.New("smime: invalid enveloped data")
+
+	h, err := r.readHeader()
+	if err != nil {
+		return nil, err
+	} else if !h.is(0x30) {
+		return nil, errInvalid
+	}
+
+	var contentType asn1.ObjectIdentifier
+	if b, err := r.readElement(); err != nil {
+		return nil, err
+	} else if _, err := asn1.Unmarshal(b, &contentType); err != nil {
+		return nil, errInvalid
+	}
+	auth := false
+	switch {
+	case contentType.Equal(oidEnvelopedData):
+	case contentType.Equal(oidAuthEnvelopedData):
+		auth = true
+	default:
+		return nil, ErrNotEncrypted
+	}
+
+	for _, tag := range []byte{0xA0, 0x30} {
+		if h, err := r.readHeader(); err != nil {
+			return nil, err
+		} else if !h.is(tag) {
+			return nil, errInvalid
+		}
+	}
+
+	// Version
+	if _, err := r.readElement(); err != nil {
+		return nil, err
+	}
+
+	// OriginatorInfo isn't needed to decrypt the message
+	if tag, err := r.peekTag(); err != nil {
+		return nil, err
+	} else if tag == 0xA0 {
+		if _, err := r.readElement(); err != nil {
+			return nil, err
+		}
+	}
+
+	var recipientInfos []asn1.RawValue
+	if b, err := r.readElement(); err != nil {
+		return nil, err
+	} else if _, err := asn1.UnmarshalWithParams(b, &recipientInfos, "set"); err != nil {
+		return nil, fmt.Errorf("smime: failed to parse RecipientInfos: %v", err)
+	}
+
+	// EncryptedContentInfo
+	eciHeader, err := r.readHeader()
+	if err != nil {
+		return nil, err
+	} else if !eciHeader.is(0x30) {
+		return nil, errInvalid
+	}
+	if _, err := r.readElement(); err != nil {
+		return nil, err
+	}
+	var alg pkix.AlgorithmIdentifier
+	if b, err := r.readElement(); err != nil {
+		return nil, err
+	} else if _, err := asn1.Unmarshal(b, &alg); err != nil {
+		return nil, fmt.Errorf("smime: failed to parse content encryption algorithm: %v", err)
+	}
+
+	contentEncryption, ok := contentEncryptionAlgorithmByOID(alg.Algorithm)
+	if !ok {
+		return nil, fmt.Errorf("smime: unsupported content encryption algorithm %v", alg.Algorithm)
+	}
+	keySize := contentEncryptionAlgorithms[contentEncryption].keySize
+	gcmMode := contentEncryptionAlgorithms[contentEncryption].gcm
+	if gcmMode != auth {
+		return nil, errors.New("smime: content encryption algorithm doesn't match content type")
+	}
+
+	cek, err := decryptContentEncryptionKey(recipientInfos, recipient, keySize)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, err
+	}
+
+	h, err = r.readHeader()
+	if err != nil {
+		return nil, err
+	} else if !h.is(0x80) && !h.is(0xA0) {
+		return nil, errors.New("smime: missing encrypted content")
+	}
+	content := newBEROctetStringReader(r, h)
+
+	if !gcmMode {
+		var iv []byte
+		if _, err := asn1.Unmarshal(alg.Parameters.FullBytes, &iv); err != nil {
+			return nil, fmt.Errorf("smime: failed to parse AES-CBC parameters: %v", err)
+		} else if len(iv) != block.BlockSize() {
+			return nil, errors.New("smime: invalid AES-CBC IV size")
+		}
+		return &cbcDecrypter{r: content, mode: cipher.NewCBCDecrypter(block, iv)}, nil
+	}
+
+	var params gcmParameters
+	if _, err := asn1.Unmarshal(alg.Parameters.FullBytes, &params); err != nil {
+		return nil, fmt.Errorf("smime: failed to parse AES-GCM parameters: %v", err)
+	}
+	// RFC 5084 section 3.2: the tag is 12 to 16 bytes long
+	if params.ICVLen < 12 || params.ICVLen > gcmTagSize {
+		return nil, errors.New("smime: invalid AES-GCM tag size")
+	}
+	if len(params.Nonce) != gcmNonceSize {
+		return nil, errors.New("smime: unsupported AES-GCM nonce size")
+	}
+	aead, err := cipher.NewGCMWithTagSize(block, params.ICVLen)
+	if err != nil {
+		return nil, err
+	}
+
+	// cipher.AEAD can't process a stream: buffer the ciphertext, and don't
+	// return any data until it's been authenticated
+	ciphertext, err := ioutil.ReadAll(content)
+	if err != nil {
+		return nil, err
+	}
+
+	if eciHeader.length < 0 {
+		if ok, err := r.readEndOfContents(); err != nil {
+			return nil, err
+		} else if !ok {
+			return nil, errInvalid
+		}
+	}
+	if tag, err := r.peekTag(); err != nil {
+		return nil, err
+	} else if tag == 0xA1 {
+		return nil, errors.New("smime: authenticated attributes are not supported")
+	}
+
+	var mac []byte
+	if b, err := r.readElement(); err != nil {
+		return nil, err
+	} else if _, err := asn1.Unmarshal(b, &mac); err != nil {
+		return nil, fmt.Errorf("smime: failed to parse message authentication code: %v", err)
+	}
+	if len(mac) != params.ICVLen {
+		return nil, errors.New("smime: invalid message authentication code size")
+	}
+
+	plaintext, err := aead.Open(ciphertext[:0], params.Nonce, append(ciphertext, mac...), nil)
+	if err != nil {
+		return nil, errors.New("smime: message authentication failed")
+	}
+	return bytes.NewReader(plaintext), nil
+}
+
+// Decrypt decrypts an S/MIME application/pkcs7-mime message, and returns the
+// encrypted entity. Both enveloped-data and authEnveloped-data are supported.
+//
+// With enveloped-data, the message is decrypted while the returned entity is
+// read. With authEnveloped-data, the message is kept in memory and its
+// integrity is checked before the entity is returned: an error is returned if
+// the message has been tampered with.
+//
+// If the message isn't encrypted, ErrNotEncrypted is returned.
+func Decrypt(e *message.Entity, recipient *Recipient) (*message.Entity, error) {
+	mediaType, params, err := e.Header.ContentType()
+	if err != nil {
+		return nil, ErrNotEncrypted
+	}
+	if mediaType != "application/pkcs7-mime" && mediaType != "application/x-pkcs7-mime" {
+		return nil, ErrNotEncrypted
+	}
+	switch strings.ToLower(params["smime-type"]) {
+	case "", "enveloped-data", "authenveloped-data":
+	default:
+		return nil, ErrNotEncrypted
+	}
+
+	if recipient == nil || recipient.Certificate == nil || recipient.PrivateKey == nil {
+		return nil, errors.New("smime: missing recipient certificate or private key")
+	}
+
+	r, err := decryptEnvelope(newBERReader(e.Body), recipient)
+	if err != nil {
+		return nil, err
+	}
+	return message.Read(r)
+}
@@ -0,0 +1,312 @@
+package smime
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/subtle"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// keyAgreeRecipientInfo is a CMS KeyAgreeRecipientInfo, as defined in
+// RFC 5652 section 6.2.2. Originator and UKM include their explicit tags.
+type keyAgreeRecipientInfo struct {
+	Version                int
+	Originator             asn1.RawValue `asn1:"explicit,tag:0"`
+	UKM                    asn1.RawValue `asn1:"explicit,optional,tag:1"`
+	KeyEncryptionAlgorithm pkix.AlgorithmIdentifier
+	RecipientEncryptedKeys []recipientEncryptedKey
+}
+
+// recipientEncryptedKey is a CMS RecipientEncryptedKey, as defined in
+// RFC 5652 section 6.2.2.
+type recipientEncryptedKey struct {
+	RID          asn1.RawValue
+	EncryptedKey []byte
+}
+
+// originatorPublicKey is a CMS OriginatorPublicKey, as defined in RFC 5652
+// section 6.2.2.
+type originatorPublicKey struct {
+	Algorithm pkix.AlgorithmIdentifier
+	PublicKey asn1.BitString
+}
+
+// eccCMSSharedInfo is the input of the key derivation function, as defined in
+// RFC 5753 section 7.2.
+type eccCMSSharedInfo struct {
+	KeyInfo     pkix.AlgorithmIdentifier
+	EntityUInfo []byte `asn1:"explicit,optional,tag:0"`
+	SuppPubInfo []byte `asn1:"explicit,tag:2"`
+}
+
+var keyAgreementAlgorithms = []struct {
+	oid  asn1.ObjectIdentifier
+	hash crypto.Hash
+}{
+	// OpenSSL uses SHA-1 by default
+	{oidDHSinglePassStdDHSHA1KDF, crypto.SHA1},
+	{oidDHSinglePassStdDHSHA256KDF, crypto.SHA256},
+	{oidDHSinglePassStdDHSHA384KDF, crypto.SHA384},
+	{oidDHSinglePassStdDHSHA512KDF, crypto.SHA512},
+}
+
+var keyWrapAlgorithms = []struct {
+	oid     asn1.ObjectIdentifier
+	keySize int
+}{
+	{oidAES128Wrap, 16},
+	{oidAES192Wrap, 24},
+	{oidAES256Wrap, 32},
+}
+
+func keyWrapAlgorithm(keySize int) asn1.ObjectIdentifier {
+	for _, alg := range keyWrapAlgorithms {
+		if alg.keySize == keySize {
+			return alg.oid
+		}
+	}
+	return nil
+}
+
+// x963KDF derives a key of the specified size from a shared secret, as
+// defined in ANSI X9.63 section 3.6.1 (see RFC 5753 section 7.2).
+func x963KDF(hash crypto.Hash, z, sharedInfo []byte, size int) []byte {
+	var k []byte
+	var counter [4]byte
+	for i := uint32(1); len(k) < size; i++ {
+		binary.BigEndian.PutUint32(counter[:], i)
+		h := hash.New()
+		h.Write(z)
+		h.Write(counter[:])
+		h.Write(sharedInfo)
+		k = h.Sum(k)
+	}
+	return k[:size]
+}
+
+// deriveKEK derives a key-encryption key from an ECDH shared secret, as
+// defined in RFC 5753 section 3.1.
+func deriveKEK(hash crypto.Hash, z []byte, wrapAlg asn1.ObjectIdentifier, kekSize int, ukm []byte) ([]byte, error) {
+	var suppPubInfo [4]byte
+	binary.BigEndian.PutUint32(suppPubInfo[:], uint32(kekSize*8))
+	sharedInfo, err := asn1.Marshal(eccCMSSharedInfo{
+		KeyInfo:     pkix.AlgorithmIdentifier{Algorithm: wrapAlg},
+		EntityUInfo: ukm,
+		SuppPubInfo: suppPubInfo[:],
+	})
+	if err != nil {
+		return nil, err
+	}
+	return x963KDF(hash, z, sharedInfo, kekSize), nil
+}
+
+// newKeyAgreeRecipientInfo encrypts cek for an elliptic curve public key,
+// with ephemeral-static ECDH as defined in RFC 5753 section 3.1.
+func newKeyAgreeRecipientInfo(cert *x509.Certificate, pub *ecdsa.PublicKey, cek []byte) ([]byte, error) {
+	ecdhPub, err := pub.ECDH()
+	if err != nil {
+		return nil, fmt.Errorf("smime: unsupported elliptic curve public key: %v", err)
+	}
+	eph, err := ecdhPub.Curve().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	wrapAlg := keyWrapAlgorithm(len(cek))
+	if wrapAlg == nil {
+		return nil, fmt.Errorf("smime: unsupported content-encryption key size %v", len(cek))
+	}
+
+	// The shared secret is the x-coordinate of the shared point
+	z, err := eph.ECDH(ecdhPub)
+	if err != nil {
+		return nil, err
+	}
+	kek, err := deriveKEK(crypto.SHA256, z, wrapAlg, len(cek), nil)
+	if err != nil {
+		return nil, err
+	}
+	wrapped, err := aesKeyWrap(kek, cek)
+	if err != nil {
+		return nil, err
+	}
+
+	point := eph.PublicKey().Bytes()
+	originator, err := asn1.MarshalWithParams(originatorPublicKey{
+		Algorithm: pkix.AlgorithmIdentifier{Algorithm: oidECPublicKey},
+		PublicKey: asn1.BitString{Bytes: point, BitLength: 8 * len(point)},
+	}, "tag:1")
+	if err != nil {
+		return nil, err
+	}
+
+	wrapParams, err := asn1.Marshal(pkix.AlgorithmIdentifier{Algorithm: wrapAlg})
+	if err != nil {
+		return nil, err
+	}
+
+	rid, err := marshalIssuerAndSerialNumber(cert)
+	if err != nil {
+		return nil, err
+	}
+
+	return asn1.MarshalWithParams(keyAgreeRecipientInfo{
+		Version: 3,
+		Originator: asn1.RawValue{
+			Class:      asn1.ClassContextSpecific,
+			Tag:        0,
+			IsCompound: true,
+			Bytes:      originator,
+		},
+		KeyEncryptionAlgorithm: pkix.AlgorithmIdentifier{
+			Algorithm:  oidDHSinglePassStdDHSHA256KDF,
+			Parameters: asn1.RawValue{FullBytes: wrapParams},
+		},
+		RecipientEncryptedKeys: []recipientEncryptedKey{{
+			RID:          rid,
+			EncryptedKey: wrapped,
+		}},
+	}, "tag:1")
+}
+
+// decryptKey decrypts the content-encryption key for a recipient.
+func (kari *keyAgreeRecipientInfo) decryptKey(cert *x509.Certificate, priv *ecdsa.PrivateKey) ([]byte, error) {
+	var encryptedKey []byte
+	for _, rek := range kari.RecipientEncryptedKeys {
+		if matchesIdentifier(cert, rek.RID) {
+			encryptedKey = rek.EncryptedKey
+			break
+		}
+	}
+	if encryptedKey == nil {
+		return nil, errNoRecipient
+	}
+
+	var hash crypto.Hash
+	for _, alg := range keyAgreementAlgorithms {
+		if alg.oid.Equal(kari.KeyEncryptionAlgorithm.Algorithm) {
+			hash = alg.hash
+			break
+		}
+	}
+	if hash == 0 {
+		return nil, fmt.Errorf("smime: unsupported key agreement algorithm %v", kari.KeyEncryptionAlgorithm.Algorithm)
+	}
+
+	var wrapAlg pkix.AlgorithmIdentifier
+	if _, err := asn1.Unmarshal(kari.KeyEncryptionAlgorithm.Parameters.FullBytes, &wrapAlg); err != nil {
+		return nil, fmt.Errorf("smime: failed to parse key wrap algorithm: %v", err)
+	}
+	kekSize := 0
+	for _, alg := range keyWrapAlgorithms {
+		if alg.oid.Equal(wrapAlg.Algorithm) {
+			kekSize = alg.keySize
+			break
+		}
+	}
+	if kekSize == 0 {
+		return nil, fmt.Errorf("smime: unsupported key wrap algorithm %v", wrapAlg.Algorithm)
+	}
+
+	var opk originatorPublicKey
+	if _, err := asn1.UnmarshalWithParams(kari.Originator.Bytes, &opk, "tag:1"); err != nil {
+		return nil, errors.New("smime: unsupported key agreement originator")
+	}
+	ecdhPriv, err := priv.ECDH()
+	if err != nil {
+		return nil, fmt.Errorf("smime: unsupported elliptic curve private key: %v", err)
+	}
+	originatorPub, err := ecdhPriv.Curve().NewPublicKey(opk.PublicKey.RightAlign())
+	if err != nil {
+		return nil, errors.New("smime: invalid key agreement originator public key")
+	}
+
+	var ukm []byte
+	if len(kari.UKM.Bytes) > 0 {
+		if _, err := asn1.Unmarshal(kari.UKM.Bytes, &ukm); err != nil {
+			return nil, fmt.Errorf("smime: failed to parse user keying material: %v", err)
+		}
+	}
+
+	z, err := ecdhPriv.ECDH(originatorPub)
+	if err != nil {
+		return nil, err
+	}
+	kek, err := deriveKEK(hash, z, wrapAlg.Algorithm, kekSize, ukm)
+	if err != nil {
+		return nil, err
+	}
+	return aesKeyUnwrap(kek, encryptedKey)
+}
+
+// aesKeyWrapIV is the default initial value, as defined in RFC 3394 section
+// 2.2.3.1.
+var aesKeyWrapIV = []byte{0xA6, 0xA6, 0xA6, 0xA6, 0xA6, 0xA6, 0xA6, 0xA6}
+
+// aesKeyWrap wraps a key, as defined in RFC 3394 section 2.2.1.
+func aesKeyWrap(kek, key []byte) ([]byte, error) {
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+	if len(key)%8 != 0 || len(key) < 16 {
+		return nil, errors.New("smime: invalid key size for AES key wrap")
+	}
+
+	n := len(key) / 8
+	out := make([]byte, 8+len(key))
+	copy(out, aesKeyWrapIV)
+	copy(out[8:], key)
+
+	var b [16]byte
+	for j := 0; j < 6; j++ {
+		for i := 1; i <= n; i++ {
+			copy(b[:8], out[:8])
+			copy(b[8:], out[8*i:8*i+8])
+			block.Encrypt(b[:], b[:])
+			t := uint64(n*j + i)
+			binary.BigEndian.PutUint64(out[:8], binary.BigEndian.Uint64(b[:8])^t)
+			copy(out[8*i:8*i+8], b[8:])
+		}
+	}
+	return out, nil
+}
+
+// aesKeyUnwrap unwraps a key, as defined in RFC 3394 section 2.2.2.
+func aesKeyUnwrap(kek, wrapped []byte) ([]byte, error) {
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrapped)%8 != 0 || len(wrapped) < 24 {
+		return nil, errors.New("smime: invalid wrapped key size")
+	}
+
+	n := len(wrapped)/8 - 1
+	out := make([]byte, len(wrapped))
+	copy(out, wrapped)
+
+	var b [16]byte
+	for j := 5; j >= 0; j-- {
+		for i := n; i >= 1; i-- {
+			t := uint64(n*j + i)
+			binary.BigEndian.PutUint64(b[:8], binary.BigEndian.Uint64(out[:8])^t)
+			copy(b[8:], out[8*i:8*i+8])
+			block.Decrypt(b[:], b[:])
+			copy(out[:8], b[:8])
+			copy(out[8*i:8*i+8], b[8:])
+		}
+	}
+
+	if subtle.ConstantTimeCompare(out[:8], aesKeyWrapIV) != 1 {
+		return nil, errors.New("smime: failed to unwrap key")
+	}
+	return out[8:], nil
+}
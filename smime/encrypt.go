@@ -0,0 +1,395 @@
+package smime
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/emersion/go-message"
+	"github.com/emersion/go-message/internal/canonical"
+)
+
+// ContentEncryptionAlgorithm is an algorithm used to encrypt the content of
+// a message.
+type ContentEncryptionAlgorithm int
+
+const (
+	// AES256CBC is AES-256 in Cipher Block Chaining mode, using the
+	// enveloped-data format. The message is streamed, but it isn't protected
+	// against modifications in transit: it should be signed before being
+	// encrypted.
+	AES256CBC ContentEncryptionAlgorithm = iota
+	// AES128CBC is AES-128 in Cipher Block Chaining mode, using the
+	// enveloped-data format.
+	AES128CBC
+	// AES256GCM is AES-256 in Galois/Counter Mode, using the
+	// authEnveloped-data format defined in RFC 5083. The message is
+	// authenticated, but it's kept in memory both when encrypting and when
+	// decrypting it.
+	AES256GCM
+	// AES128GCM is AES-128 in Galois/Counter Mode, using the
+	// authEnveloped-data format defined in RFC 5083.
+	AES128GCM
+
+	// AES-192 is only supported for decryption
+	aes192CBC
+	aes192GCM
+)
+
+var contentEncryptionAlgorithms = []struct {
+	oid     asn1.ObjectIdentifier
+	keySize int
+	gcm     bool
+}{
+	AES256CBC: {oidAES256CBC, 32, false},
+	AES128CBC: {oidAES128CBC, 16, false},
+	AES256GCM: {oidAES256GCM, 32, true},
+	AES128GCM: {oidAES128GCM, 16, true},
+	aes192CBC: {oidAES192CBC, 24, false},
+	aes192GCM: {oidAES192GCM, 24, true},
+}
+
+// contentEncryptionAlgorithmByOID returns the content encryption algorithm
+// with the specified object identifier.
+func contentEncryptionAlgorithmByOID(oid asn1.ObjectIdentifier) (ContentEncryptionAlgorithm, bool) {
+	for alg, a := range contentEncryptionAlgorithms {
+		if a.oid.Equal(oid) {
+			return ContentEncryptionAlgorithm(alg), true
+		}
+	}
+	return 0, false
+}
+
+// EncryptOptions are options for EncryptWithOptions.
+type EncryptOptions struct {
+	// ContentEncryption is the algorithm used to encrypt the content. The
+	// default is AES256CBC.
+	ContentEncryption ContentEncryptionAlgorithm
+}
+
+const (
+	gcmNonceSize = 12
+	gcmTagSize   = 16
+)
+
+// gcmParameters holds the parameters of AES-GCM, as defined in RFC 5084
+// section 3.2.
+type gcmParameters struct {
+	Nonce  []byte
+	ICVLen int `asn1:"default:12"`
+}
+
+// keyTransRecipientInfo is a CMS KeyTransRecipientInfo, as defined in
+// RFC 5652 section 6.2.1.
+type keyTransRecipientInfo struct {
+	Version                int
+	RID                    asn1.RawValue
+	KeyEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedKey           []byte
+}
+
+// newKeyTransRecipientInfo encrypts cek for an RSA public key, with
+// RSAES-PKCS1-v1_5 as defined in RFC 3370 section 4.2.1.
+func newKeyTransRecipientInfo(cert *x509.Certificate, pub *rsa.PublicKey, cek []byte) ([]byte, error) {
+	encryptedKey, err := rsa.EncryptPKCS1v15(rand.Reader, pub, cek)
+	if err != nil {
+		return nil, err
+	}
+
+	rid, err := marshalIssuerAndSerialNumber(cert)
+	if err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(keyTransRecipientInfo{
+		Version: 0,
+		RID:     rid,
+		KeyEncryptionAlgorithm: pkix.AlgorithmIdentifier{
+			Algorithm:  oidRSAEncryption,
+			Parameters: asn1.NullRawValue,
+		},
+		EncryptedKey: encryptedKey,
+	})
+}
+
+// marshalRecipientInfos encrypts cek for each recipient, and returns a SET OF
+// RecipientInfo.
+func marshalRecipientInfos(to []*x509.Certificate, cek []byte) ([]byte, bool, error) {
+	if len(to) == 0 {
+		return nil, false, errors.New("smime: no recipient")
+	}
+
+	var b []byte
+	keyAgree := false
+	for _, cert := range to {
+		var ri []byte
+		var err error
+		switch pub := cert.PublicKey.(type) {
+		case *rsa.PublicKey:
+			ri, err = newKeyTransRecipientInfo(cert, pub, cek)
+		case *ecdsa.PublicKey:
+			ri, err = newKeyAgreeRecipientInfo(cert, pub, cek)
+			keyAgree = true
+		default:
+			err = fmt.Errorf("smime: unsupported recipient public key type %T", cert.PublicKey)
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		b = append(b, ri...)
+	}
+
+	// DER requires SET OF elements to be sorted, but RecipientInfos isn't
+	// covered by any signature so BER is fine
+	set, err := asn1.Marshal(asn1.RawValue{
+		Class:      asn1.ClassUniversal,
+		Tag:        asn1.TagSet,
+		IsCompound: true,
+		Bytes:      b,
+	})
+	return set, keyAgree, err
+}
+
+// cbcEncrypter encrypts data with CBC and PKCS #7 padding, as defined in
+// RFC 5652 section 6.3.
+type cbcEncrypter struct {
+	w    io.Writer
+	mode cipher.BlockMode
+	buf  []byte
+}
+
+func (e *cbcEncrypter) Write(b []byte) (int, error) {
+	n := len(b)
+	bs := e.mode.BlockSize()
+	e.buf = append(e.buf, b...)
+	if l := len(e.buf) - len(e.buf)%bs; l > 0 {
+		e.mode.CryptBlocks(e.buf[:l], e.buf[:l])
+		if _, err := e.w.Write(e.buf[:l]); err != nil {
+			return 0, err
+		}
+		e.buf = append(e.buf[:0], e.buf[l:]...)
+	}
+	return n, nil
+}
+
+func (e *cbcEncrypter) Close() error {
+	bs := e.mode.BlockSize()
+	pad := bs - len(e.buf)
+	for i := 0; i < pad; i++ {
+		e.buf = append(e.buf, byte(pad))
+	}
+	e.mode.CryptBlocks(e.buf, e.buf)
+	_, err := e.w.Write(e.buf)
+	return err
+}
+
+// gcmEncrypter encrypts data with AES-GCM. The data is kept in memory until
+// Close is called, since cipher.AEAD can't process a stream.
+type gcmEncrypter struct {
+	w     io.Writer
+	aead  cipher.AEAD
+	nonce []byte
+	buf   []byte
+	tag   []byte
+}
+
+func (e *gcmEncrypter) Write(b []byte) (int, error) {
+	e.buf = append(e.buf, b...)
+	return len(b), nil
+}
+
+func (e *gcmEncrypter) Close() error {
+	sealed := e.aead.Seal(e.buf[:0], e.nonce, e.buf, nil)
+	n := len(sealed) - e.aead.Overhead()
+	e.tag = sealed[n:]
+	_, err := e.w.Write(sealed[:n])
+	return err
+}
+
+type encrypter struct {
+	w   *message.Writer
+	sw  *berSegmentWriter
+	ew  io.WriteCloser
+	cw  *canonical.Writer
+	gcm *gcmEncrypter
+}
+
+func (e *encrypter) Write(b []byte) (int, error) {
+	return e.cw.Write(b)
+}
+
+func (e *encrypter) Close() error {
+	if err := e.ew.Close(); err != nil {
+		return err
+	}
+	if err := e.sw.Flush(); err != nil {
+		return err
+	}
+
+	// End of encryptedContent and EncryptedContentInfo
+	trailer := append(append([]byte(nil), berEndOfContents...), berEndOfContents...)
+	if e.gcm != nil {
+		trailer = appendBER(append(trailer, 0x04), e.gcm.tag)
+	}
+	// End of EnvelopedData, explicit tag and ContentInfo
+	for i := 0; i < 3; i++ {
+		trailer = append(trailer, berEndOfContents...)
+	}
+	if _, err := e.w.Write(trailer); err != nil {
+		return err
+	}
+
+	return e.w.Close()
+}
+
+// Encrypt creates a writer to encrypt a message with S/MIME, using the
+// application/pkcs7-mime format defined in RFC 8551 section 3.3. to contains
+// the certificates of the recipients: RSA and elliptic curve (ECDH) public
+// keys are supported. header is the header of the resulting message.
+//
+// The entity to encrypt, including its header, should be written to the
+// returned io.WriteCloser. It's canonicalized to CRLF line endings. Close
+// must be called to finish the encrypted message. By default, the message is
+// encrypted with AES256CBC and streamed.
+func Encrypt(w io.Writer, header message.Header, to []*x509.Certificate) (io.WriteCloser, error) {
+	return EncryptWithOptions(w, header, to, nil)
+}
+
+// EncryptWithOptions see Encrypt, but allows overriding some parameters with
+// EncryptOptions.
+func EncryptWithOptions(w io.Writer, header message.Header, to []*x509.Certificate, opts *EncryptOptions) (io.WriteCloser, error) {
+	if opts == nil {
+		opts = new(EncryptOptions)
+	}
+	if opts.ContentEncryption < 0 || opts.ContentEncryption > AES128GCM {
+		return nil, errors.New("smime: unknown content encryption algorithm")
+	}
+	return encrypt(w, header, to, opts.ContentEncryption)
+}
+
+func encrypt(w io.Writer, header message.Header, to []*x509.Certificate, contentEncryption ContentEncryptionAlgorithm) (io.WriteCloser, error) {
+	alg := contentEncryptionAlgorithms[contentEncryption]
+
+	cek := make([]byte, alg.keySize)
+	if _, err := io.ReadFull(rand.Reader, cek); err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, err
+	}
+
+	recipientInfos, keyAgree, err := marshalRecipientInfos(to, cek)
+	if err != nil {
+		return nil, err
+	}
+
+	var params []byte
+	var aead cipher.AEAD
+	var iv []byte
+	if alg.gcm {
+		iv = make([]byte, gcmNonceSize)
+		if _, err := io.ReadFull(rand.Reader, iv); err != nil {
+			return nil, err
+		}
+		if aead, err = cipher.NewGCMWithTagSize(block, gcmTagSize); err != nil {
+			return nil, err
+		}
+		params, err = asn1.Marshal(gcmParameters{Nonce: iv, ICVLen: gcmTagSize})
+	} else {
+		iv = make([]byte, block.BlockSize())
+		if _, err := io.ReadFull(rand.Reader, iv); err != nil {
+			return nil, err
+		}
+		params, err = asn1.Marshal(iv)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	contentType := oidEnvelopedData
+	smimeType := "enveloped-data"
+	// RFC 5652 section 6.1 and RFC 5083 section 2.1
+	version := 0
+	if alg.gcm {
+		contentType = oidAuthEnvelopedData
+		smimeType = "authEnveloped-data"
+	} else if keyAgree {
+		version = 2
+	}
+
+	// The length of the encrypted content isn't known in advance: use BER
+	// indefinite lengths
+	var b []byte
+	b = append(b, berIndefiniteHeader(asn1.TagSequence)...)
+	oid, err := asn1.Marshal(contentType)
+	if err != nil {
+		return nil, err
+	}
+	b = append(b, oid...)
+	b = append(b, berIndefiniteHeader(0x80)...) // [0] EXPLICIT
+	b = append(b, berIndefiniteHeader(asn1.TagSequence)...)
+	versionBytes, err := asn1.Marshal(version)
+	if err != nil {
+		return nil, err
+	}
+	b = append(b, versionBytes...)
+	b = append(b, recipientInfos...)
+	b = append(b, berIndefiniteHeader(asn1.TagSequence)...) // EncryptedContentInfo
+	oid, err = asn1.Marshal(oidData)
+	if err != nil {
+		return nil, err
+	}
+	b = append(b, oid...)
+	algID, err := asn1.Marshal(pkix.AlgorithmIdentifier{
+		Algorithm:  alg.oid,
+		Parameters: asn1.RawValue{FullBytes: params},
+	})
+	if err != nil {
+		return nil, err
+	}
+	b = append(b, algID...)
+	b = append(b, berIndefiniteHeader(0x80)...) // [0] IMPLICIT OCTET STRING
+
+	header = header.Copy()
+	header.SetContentType("application/pkcs7-mime", map[string]string{
+		"smime-type": smimeType,
+		"name":       "smime.p7m",
+	})
+	header.SetContentDisposition("attachment", map[string]string{"filename": "smime.p7m"})
+	header.Set("Content-Transfer-Encoding", "base64")
+
+	mw, err := message.CreateWriter(w, header)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := mw.Write(b); err != nil {
+		return nil, err
+	}
+
+	sw := &berSegmentWriter{w: mw}
+	var ew io.WriteCloser
+	var gcm *gcmEncrypter
+	if aead != nil {
+		gcm = &gcmEncrypter{w: sw, aead: aead, nonce: iv}
+		ew = gcm
+	} else {
+		ew = &cbcEncrypter{w: sw, mode: cipher.NewCBCEncrypter(block, iv)}
+	}
+
+	return &encrypter{
+		w:   mw,
+		sw:  sw,
+		ew:  ew,
+		cw:  canonical.NewWriter(ew),
+		gcm: gcm,
+	}, nil
+}
@@ -0,0 +1,315 @@
+package smime
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/emersion/go-message"
+)
+
+func encryptMessage(t *testing.T, to []*x509.Certificate, opts *EncryptOptions, entity string) []byte {
+	var b bytes.Buffer
+	w, err := EncryptWithOptions(&b, testHeader(), to, opts)
+	if err != nil {
+		t.Fatalf("EncryptWithOptions() = %v", err)
+	}
+	// Write in small chunks to exercise streaming
+	for s := entity; len(s) > 0; {
+		n := 7
+		if n > len(s) {
+			n = len(s)
+		}
+		if _, err := io.WriteString(w, s[:n]); err != nil {
+			t.Fatalf("Write() = %v", err)
+		}
+		s = s[n:]
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close() = %v", err)
+	}
+	return b.Bytes()
+}
+
+func decryptMessage(b []byte, recipient *Recipient) (*message.Entity, error) {
+	e, err := message.Read(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	return Decrypt(e, recipient)
+}
+
+func newTestRecipient(t *testing.T, key crypto.Signer) *Recipient {
+	pki := newTestPKI(t, key)
+	return &Recipient{Certificate: pki.signer.Certificate, PrivateKey: key}
+}
+
+func TestEncrypt(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("rsa.GenerateKey() = %v", err)
+	}
+	p256Key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("ecdsa.GenerateKey() = %v", err)
+	}
+	p384Key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatalf("ecdsa.GenerateKey() = %v", err)
+	}
+	recipients := map[string]*Recipient{
+		"rsa":  newTestRecipient(t, rsaKey),
+		"p256": newTestRecipient(t, p256Key),
+		"p384": newTestRecipient(t, p384Key),
+	}
+	var to []*x509.Certificate
+	for _, recipient := range recipients {
+		to = append(to, recipient.Certificate)
+	}
+
+	algs := map[string]ContentEncryptionAlgorithm{
+		"aes256gcm": AES256GCM,
+		"aes128gcm": AES128GCM,
+		"aes256cbc": AES256CBC,
+		"aes128cbc": AES128CBC,
+	}
+	wantSmimeType := map[ContentEncryptionAlgorithm]string{
+		AES256GCM: "authEnveloped-data",
+		AES128GCM: "authEnveloped-data",
+		AES256CBC: "enveloped-data",
+		AES128CBC: "enveloped-data",
+	}
+	for algName, alg := range algs {
+		b := encryptMessage(t, to, &EncryptOptions{ContentEncryption: alg}, testSignedEntity)
+		if bytes.Contains(b, []byte("Who are you?")) {
+			t.Errorf("%v: encrypted message contains the plaintext", algName)
+		}
+
+		e, err := message.Read(bytes.NewReader(b))
+		if err != nil {
+			t.Fatalf("message.Read() = %v", err)
+		}
+		if _, params, _ := e.Header.ContentType(); params["smime-type"] != wantSmimeType[alg] {
+			t.Errorf("%v: smime-type = %q, want %q", algName, params["smime-type"], wantSmimeType[alg])
+		}
+
+		for name, recipient := range recipients {
+			e, err := decryptMessage(b, recipient)
+			if err != nil {
+				t.Fatalf("%v/%v: Decrypt() = %v", algName, name, err)
+			}
+			if mediaType, _, _ := e.Header.ContentType(); mediaType != "text/plain" {
+				t.Errorf("%v/%v: decrypted entity has media type %q, want text/plain", algName, name, mediaType)
+			}
+			body, err := ioutil.ReadAll(e.Body)
+			if err != nil {
+				t.Fatalf("%v/%v: ioutil.ReadAll() = %v", algName, name, err)
+			}
+			want := "Who are you?\r\n\r\n-- \r\nMitsuha\r\n"
+			if string(body) != want {
+				t.Errorf("%v/%v: decrypted body = %q, want %q", algName, name, string(body), want)
+			}
+		}
+	}
+}
+
+func TestEncrypt_large(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("ecdsa.GenerateKey() = %v", err)
+	}
+	recipient := newTestRecipient(t, key)
+
+	body := strings.Repeat("All work and no play makes Jack a dull boy.\r\n", 30000)
+	entity := "Content-Type: text/plain\r\n\r\n" + body
+	for _, alg := range []ContentEncryptionAlgorithm{AES256GCM, AES128CBC} {
+		b := encryptMessage(t, []*x509.Certificate{recipient.Certificate}, &EncryptOptions{ContentEncryption: alg}, entity)
+
+		e, err := decryptMessage(b, recipient)
+		if err != nil {
+			t.Fatalf("Decrypt() = %v", err)
+		}
+		got, err := ioutil.ReadAll(e.Body)
+		if err != nil {
+			t.Fatalf("ioutil.ReadAll() = %v", err)
+		}
+		if string(got) != body {
+			t.Errorf("decrypted body doesn't match (got %v bytes, want %v)", len(got), len(body))
+		}
+	}
+}
+
+func TestEncrypt_streaming(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("ecdsa.GenerateKey() = %v", err)
+	}
+	recipient := newTestRecipient(t, key)
+
+	var b bytes.Buffer
+	w, err := Encrypt(&b, testHeader(), []*x509.Certificate{recipient.Certificate})
+	if err != nil {
+		t.Fatalf("Encrypt() = %v", err)
+	}
+	io.WriteString(w, "Content-Type: text/plain\r\n\r\n")
+	line := strings.Repeat("a", 76) + "\r\n"
+	for i := 0; i < 1<<20/len(line); i++ {
+		if _, err := io.WriteString(w, line); err != nil {
+			t.Fatalf("Write() = %v", err)
+		}
+	}
+	// The encrypted message is written before Close is called
+	if b.Len() < 1<<20 {
+		t.Errorf("Encrypt() buffered the entity: %v bytes written before Close", b.Len())
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close() = %v", err)
+	}
+
+	e, err := decryptMessage(b.Bytes(), recipient)
+	if err != nil {
+		t.Fatalf("Decrypt() = %v", err)
+	}
+	if _, err := io.Copy(ioutil.Discard, e.Body); err != nil {
+		t.Fatalf("io.Copy() = %v", err)
+	}
+}
+
+func TestDecrypt_aes192(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("ecdsa.GenerateKey() = %v", err)
+	}
+	recipient := newTestRecipient(t, key)
+
+	for _, alg := range []ContentEncryptionAlgorithm{aes192CBC, aes192GCM} {
+		var b bytes.Buffer
+		w, err := encrypt(&b, testHeader(), []*x509.Certificate{recipient.Certificate}, alg)
+		if err != nil {
+			t.Fatalf("encrypt() = %v", err)
+		}
+		io.WriteString(w, testSignedEntity)
+		if err := w.Close(); err != nil {
+			t.Fatalf("Close() = %v", err)
+		}
+
+		e, err := decryptMessage(b.Bytes(), recipient)
+		if err != nil {
+			t.Fatalf("Decrypt() = %v", err)
+		}
+		if _, err := io.Copy(ioutil.Discard, e.Body); err != nil {
+			t.Fatalf("io.Copy() = %v", err)
+		}
+	}
+
+	opts := &EncryptOptions{ContentEncryption: aes192CBC}
+	if _, err := EncryptWithOptions(ioutil.Discard, testHeader(), []*x509.Certificate{recipient.Certificate}, opts); err == nil {
+		t.Errorf("EncryptWithOptions(aes192CBC): expected an error")
+	}
+}
+
+func TestDecrypt_tampered(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("ecdsa.GenerateKey() = %v", err)
+	}
+	recipient := newTestRecipient(t, key)
+
+	opts := &EncryptOptions{ContentEncryption: AES256GCM}
+	b := encryptMessage(t, []*x509.Certificate{recipient.Certificate}, opts, testSignedEntity)
+	e, err := message.Read(bytes.NewReader(b))
+	if err != nil {
+		t.Fatalf("message.Read() = %v", err)
+	}
+	der, err := ioutil.ReadAll(e.Body)
+	if err != nil {
+		t.Fatalf("ioutil.ReadAll() = %v", err)
+	}
+
+	// Flip a bit in the last byte of the encrypted content, right before
+	// the end-of-contents octets and the tag
+	i := len(der) - 2*2 - (2 + gcmTagSize) - 3*2 - 1
+	der[i] ^= 1
+
+	tampered, err := message.New(e.Header, bytes.NewReader(der))
+	if err != nil {
+		t.Fatalf("message.New() = %v", err)
+	}
+	// The Content-Transfer-Encoding has already been decoded
+	tampered.Header.Del("Content-Transfer-Encoding")
+
+	// No data must be returned before the message has been authenticated
+	if _, err := Decrypt(tampered, recipient); err == nil {
+		t.Errorf("Decrypt() = nil, want an authentication error")
+	}
+}
+
+func TestDecrypt_wrongRecipient(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("ecdsa.GenerateKey() = %v", err)
+	}
+	recipient := newTestRecipient(t, key)
+	other := newTestRecipient(t, key)
+	other.Certificate.SerialNumber.SetInt64(42)
+
+	b := encryptMessage(t, []*x509.Certificate{recipient.Certificate}, nil, testSignedEntity)
+	if _, err := decryptMessage(b, other); err != errNoRecipient {
+		t.Errorf("Decrypt() = %v, want %v", err, errNoRecipient)
+	}
+}
+
+func TestDecrypt_notEncrypted(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("ecdsa.GenerateKey() = %v", err)
+	}
+	pki := newTestPKI(t, key)
+	recipient := &Recipient{Certificate: pki.signer.Certificate, PrivateKey: key}
+
+	for name, b := range map[string][]byte{
+		"plain":  []byte(testSignedEntity),
+		"opaque": signMessage(t, SignOpaque, pki.signer),
+	} {
+		if _, err := decryptMessage(b, recipient); err != ErrNotEncrypted {
+			t.Errorf("%v: Decrypt() = %v, want %v", name, err, ErrNotEncrypted)
+		}
+	}
+}
+
+func TestAESKeyWrap(t *testing.T) {
+	// RFC 3394 section 4.1
+	kek, _ := hex.DecodeString("000102030405060708090A0B0C0D0E0F")
+	key, _ := hex.DecodeString("00112233445566778899AABBCCDDEEFF")
+	want, _ := hex.DecodeString("1FA68B0A8112B447AEF34BD8FB5A7B829D3E862371D2CFE5")
+
+	wrapped, err := aesKeyWrap(kek, key)
+	if err != nil {
+		t.Fatalf("aesKeyWrap() = %v", err)
+	}
+	if !bytes.Equal(wrapped, want) {
+		t.Errorf("aesKeyWrap() = %X, want %X", wrapped, want)
+	}
+
+	unwrapped, err := aesKeyUnwrap(kek, wrapped)
+	if err != nil {
+		t.Fatalf("aesKeyUnwrap() = %v", err)
+	}
+	if !bytes.Equal(unwrapped, key) {
+		t.Errorf("aesKeyUnwrap() = %X, want %X", unwrapped, key)
+	}
+
+	wrapped[0] ^= 1
+	if _, err := aesKeyUnwrap(kek, wrapped); err == nil {
+		t.Errorf("aesKeyUnwrap() = nil, want an error for a corrupted key")
+	}
+}
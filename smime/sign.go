@@ -0,0 +1,223 @@
+package smime
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"time"
+
+	"github.com/emersion/go-message"
+	"github.com/emersion/go-message/internal/canonical"
+)
+
+// A Signer holds a certificate and its private key, used to sign messages.
+type Signer struct {
+	// Certificate is the signer's certificate. It's included in the signature.
+	Certificate *x509.Certificate
+	// PrivateKey is the private key matching Certificate. RSA, ECDSA and
+	// Ed25519 keys are supported.
+	PrivateKey crypto.Signer
+	// Intermediates are included in the signature, so that recipients can
+	// build a chain from Certificate to a trusted root.
+	Intermediates []*x509.Certificate
+}
+
+// algorithms returns the digest and signature algorithms used by the signer.
+func (s *Signer) algorithms() (crypto.Hash, pkix.AlgorithmIdentifier, error) {
+	if s == nil || s.Certificate == nil || s.PrivateKey == nil {
+		return 0, pkix.AlgorithmIdentifier{}, errors.New("smime: missing signer certificate or private key")
+	}
+
+	switch s.PrivateKey.Public().(type) {
+	case *rsa.PublicKey:
+		return crypto.SHA256, pkix.AlgorithmIdentifier{
+			Algorithm:  oidRSAEncryption,
+			Parameters: asn1.NullRawValue,
+		}, nil
+	case *ecdsa.PublicKey:
+		return crypto.SHA256, pkix.AlgorithmIdentifier{Algorithm: oidECDSAWithSHA256}, nil
+	case ed25519.PublicKey:
+		return crypto.SHA512, pkix.AlgorithmIdentifier{Algorithm: oidEd25519}, nil
+	default:
+		return 0, pkix.AlgorithmIdentifier{}, fmt.Errorf("smime: unsupported private key type %T", s.PrivateKey)
+	}
+}
+
+func (s *Signer) sign(hash crypto.Hash, b []byte) ([]byte, error) {
+	if _, ok := s.PrivateKey.Public().(ed25519.PublicKey); ok {
+		return s.PrivateKey.Sign(rand.Reader, b, crypto.Hash(0))
+	}
+
+	h := hash.New()
+	h.Write(b)
+	return s.PrivateKey.Sign(rand.Reader, h.Sum(nil), hash)
+}
+
+// micalg returns the value of the micalg parameter, as defined in RFC 8551
+// section 3.5.3.2.
+func micalg(hash crypto.Hash) string {
+	switch hash {
+	case crypto.SHA256:
+		return "sha-256"
+	case crypto.SHA384:
+		return "sha-384"
+	case crypto.SHA512:
+		return "sha-512"
+	default:
+		return "unknown"
+	}
+}
+
+type detachedSigner struct {
+	w      *message.Writer
+	cw     *canonical.Writer
+	h      hash.Hash
+	signer *Signer
+}
+
+func (s *detachedSigner) Write(b []byte) (int, error) {
+	return s.cw.Write(b)
+}
+
+func (s *detachedSigner) Close() error {
+	der, err := marshalSignedData(s.signer, s.h.Sum(nil), nil, time.Now())
+	if err != nil {
+		return err
+	}
+
+	// The CRLF preceding the boundary delimiter belongs to the delimiter
+	if _, err := io.WriteString(s.w, "\r\n"); err != nil {
+		return err
+	}
+
+	var h message.Header
+	h.SetContentType("application/pkcs7-signature", map[string]string{"name": "smime.p7s"})
+	h.SetContentDisposition("attachment", map[string]string{"filename": "smime.p7s"})
+	h.Set("Content-Transfer-Encoding", "base64")
+	pw, err := s.w.CreatePart(h)
+	if err != nil {
+		return err
+	}
+	if _, err := pw.Write(der); err != nil {
+		return err
+	}
+	if err := pw.Close(); err != nil {
+		return err
+	}
+
+	return s.w.Close()
+}
+
+// Sign creates a writer to sign a message with S/MIME, using the
+// multipart/signed format defined in RFC 8551 section 3.5.3. header is the
+// header of the resulting message. The signed entity, including its header,
+// should be written to the returned io.WriteCloser. Close must be called to
+// write the signature.
+//
+// The signed entity is canonicalized to CRLF line endings. It should only
+// contain 7-bit data, otherwise the signature may be broken in transit.
+func Sign(w io.Writer, header message.Header, signer *Signer) (io.WriteCloser, error) {
+	hash, _, err := signer.algorithms()
+	if err != nil {
+		return nil, err
+	}
+
+	boundary, err := canonical.RandomBoundary()
+	if err != nil {
+		return nil, err
+	}
+
+	header = header.Copy()
+	header.SetContentType("multipart/signed", map[string]string{
+		"boundary": boundary,
+		"protocol": "application/pkcs7-signature",
+		"micalg":   micalg(hash),
+	})
+
+	mw, err := message.CreateWriter(w, header)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := fmt.Fprintf(mw, "--%s\r\n", boundary); err != nil {
+		return nil, err
+	}
+
+	h := hash.New()
+	return &detachedSigner{
+		w:      mw,
+		cw:     canonical.NewWriter(io.MultiWriter(mw, h)),
+		h:      h,
+		signer: signer,
+	}, nil
+}
+
+type opaqueSigner struct {
+	w      io.Writer
+	header message.Header
+	buf    bytes.Buffer
+	cw     *canonical.Writer
+	signer *Signer
+}
+
+func (s *opaqueSigner) Write(b []byte) (int, error) {
+	return s.cw.Write(b)
+}
+
+func (s *opaqueSigner) Close() error {
+	hash, _, err := s.signer.algorithms()
+	if err != nil {
+		return err
+	}
+	h := hash.New()
+	h.Write(s.buf.Bytes())
+
+	der, err := marshalSignedData(s.signer, h.Sum(nil), s.buf.Bytes(), time.Now())
+	if err != nil {
+		return err
+	}
+
+	mw, err := message.CreateWriter(s.w, s.header)
+	if err != nil {
+		return err
+	}
+	if _, err := mw.Write(der); err != nil {
+		return err
+	}
+	return mw.Close()
+}
+
+// SignOpaque creates a writer to sign a message with S/MIME, using the
+// application/pkcs7-mime format defined in RFC 8551 section 3.5.2. The signed
+// entity is embedded in the signature, so it can only be read by S/MIME-aware
+// clients, but it's protected from modifications in transit.
+//
+// header is the header of the resulting message. The signed entity,
+// including its header, should be written to the returned io.WriteCloser.
+// The signed entity is buffered in memory until Close is called.
+func SignOpaque(w io.Writer, header message.Header, signer *Signer) (io.WriteCloser, error) {
+	if _, _, err := signer.algorithms(); err != nil {
+		return nil, err
+	}
+
+	header = header.Copy()
+	header.SetContentType("application/pkcs7-mime", map[string]string{
+		"smime-type": "signed-data",
+		"name":       "smime.p7m",
+	})
+	header.SetContentDisposition("attachment", map[string]string{"filename": "smime.p7m"})
+	header.Set("Content-Transfer-Encoding", "base64")
+
+	s := &opaqueSigner{w: w, header: header, signer: signer}
+	s.cw = canonical.NewWriter(&s.buf)
+	return s, nil
+}
@@ -0,0 +1,15 @@
+// Package smime implements S/MIME signed and encrypted messages.
+//
+// S/MIME is defined in RFC 8551. Signatures and encryption use the
+// Cryptographic Message Syntax (CMS), defined in RFC 5652.
+//
+// Messages can be signed in two formats: multipart/signed (detached
+// signatures, see Sign), readable by clients which don't support S/MIME, and
+// application/pkcs7-mime (opaque signatures, see SignOpaque). Both formats
+// are checked by Verify.
+//
+// Messages are encrypted with Encrypt and decrypted with Decrypt. Both are
+// streaming: messages are never fully buffered in memory. To sign and
+// encrypt a message, write the output of Sign to the writer returned by
+// Encrypt.
+package smime
@@ -0,0 +1,290 @@
+package smime
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-message"
+)
+
+func newTestCertificate(t *testing.T, template, parent *x509.Certificate, pub crypto.PublicKey, priv crypto.Signer) *x509.Certificate {
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, pub, priv)
+	if err != nil {
+		t.Fatalf("x509.CreateCertificate() = %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("x509.ParseCertificate() = %v", err)
+	}
+	return cert
+}
+
+type testPKI struct {
+	roots  *x509.CertPool
+	signer *Signer
+}
+
+func newTestPKI(t *testing.T, leafKey crypto.Signer) *testPKI {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("ecdsa.GenerateKey() = %v", err)
+	}
+
+	now := time.Now()
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	ca := newTestCertificate(t, caTemplate, caTemplate, caKey.Public(), caKey)
+
+	// Certificates are identified by their issuer and serial number
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64))
+	if err != nil {
+		t.Fatalf("rand.Int() = %v", err)
+	}
+	leafTemplate := &x509.Certificate{
+		SerialNumber:   serial,
+		Subject:        pkix.Name{CommonName: "Mitsuha Miyamizu"},
+		EmailAddresses: []string{"mitsuha.miyamizu@example.org"},
+		NotBefore:      now.Add(-time.Hour),
+		NotAfter:       now.Add(time.Hour),
+		KeyUsage:       x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageEmailProtection},
+	}
+	leaf := newTestCertificate(t, leafTemplate, ca, leafKey.Public(), caKey)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	return &testPKI{
+		roots:  roots,
+		signer: &Signer{Certificate: leaf, PrivateKey: leafKey},
+	}
+}
+
+func testKeys(t *testing.T) map[string]crypto.Signer {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("rsa.GenerateKey() = %v", err)
+	}
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("ecdsa.GenerateKey() = %v", err)
+	}
+	_, ed25519Key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("ed25519.GenerateKey() = %v", err)
+	}
+	return map[string]crypto.Signer{
+		"rsa":     rsaKey,
+		"ecdsa":   ecdsaKey,
+		"ed25519": ed25519Key,
+	}
+}
+
+const testSignedEntity = "Content-Type: text/plain\n" +
+	"\n" +
+	"Who are you?\n" +
+	"\n" +
+	"-- \n" +
+	"Mitsuha\n"
+
+func testHeader() message.Header {
+	var h message.Header
+	h.Set("From", "Mitsuha Miyamizu <mitsuha.miyamizu@example.org>")
+	h.Set("Subject", "Your Name.")
+	return h
+}
+
+func signMessage(t *testing.T, sign func(io.Writer, message.Header, *Signer) (io.WriteCloser, error), signer *Signer) []byte {
+	var b bytes.Buffer
+	w, err := sign(&b, testHeader(), signer)
+	if err != nil {
+		t.Fatalf("sign() = %v", err)
+	}
+	if _, err := io.WriteString(w, testSignedEntity); err != nil {
+		t.Fatalf("Write() = %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close() = %v", err)
+	}
+	return b.Bytes()
+}
+
+func verifyMessage(b []byte, roots *x509.CertPool) (*SignedEntity, error) {
+	e, err := message.Read(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	return Verify(e, roots)
+}
+
+func checkSignedEntity(t *testing.T, signed *SignedEntity, signer *Signer) {
+	if len(signed.Signers) != 1 || !signed.Signers[0].Equal(signer.Certificate) {
+		t.Errorf("Verify().Signers = %v, want the signer certificate", signed.Signers)
+	}
+	if mediaType, _, _ := signed.Entity.Header.ContentType(); mediaType != "text/plain" {
+		t.Errorf("signed entity has media type %q, want text/plain", mediaType)
+	}
+	body, err := ioutil.ReadAll(signed.Entity.Body)
+	if err != nil {
+		t.Fatalf("ioutil.ReadAll() = %v", err)
+	}
+	want := "Who are you?\r\n\r\n-- \r\nMitsuha\r\n"
+	if string(body) != want {
+		t.Errorf("signed entity body = %q, want %q", string(body), want)
+	}
+}
+
+func TestSign(t *testing.T) {
+	for name, key := range testKeys(t) {
+		t.Run(name, func(t *testing.T) {
+			pki := newTestPKI(t, key)
+			b := signMessage(t, Sign, pki.signer)
+
+			e, err := message.Read(bytes.NewReader(b))
+			if err != nil {
+				t.Fatalf("message.Read() = %v", err)
+			}
+			mediaType, params, _ := e.Header.ContentType()
+			if mediaType != "multipart/signed" {
+				t.Errorf("media type = %q, want multipart/signed", mediaType)
+			}
+			if params["protocol"] != "application/pkcs7-signature" {
+				t.Errorf("protocol = %q, want application/pkcs7-signature", params["protocol"])
+			}
+			if s := e.Header.Get("Subject"); s != "Your Name." {
+				t.Errorf("Subject = %q, want %q", s, "Your Name.")
+			}
+
+			signed, err := Verify(e, pki.roots)
+			if err != nil {
+				t.Fatalf("Verify() = %v", err)
+			}
+			checkSignedEntity(t, signed, pki.signer)
+		})
+	}
+}
+
+func TestSignOpaque(t *testing.T) {
+	for name, key := range testKeys(t) {
+		t.Run(name, func(t *testing.T) {
+			pki := newTestPKI(t, key)
+			b := signMessage(t, SignOpaque, pki.signer)
+
+			if bytes.Contains(b, []byte("Who are you?")) {
+				t.Errorf("opaque signed message contains the signed entity in clear text")
+			}
+
+			signed, err := verifyMessage(b, pki.roots)
+			if err != nil {
+				t.Fatalf("Verify() = %v", err)
+			}
+			checkSignedEntity(t, signed, pki.signer)
+		})
+	}
+}
+
+func TestVerify_tampered(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("ecdsa.GenerateKey() = %v", err)
+	}
+	pki := newTestPKI(t, key)
+	b := signMessage(t, Sign, pki.signer)
+
+	b = bytes.Replace(b, []byte("Who are you?"), []byte("Who am I?"), 1)
+	if _, err := verifyMessage(b, pki.roots); err == nil {
+		t.Errorf("Verify() = nil, want an error for a tampered message")
+	}
+}
+
+func TestVerify_untrusted(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("ecdsa.GenerateKey() = %v", err)
+	}
+	pki := newTestPKI(t, key)
+	other := newTestPKI(t, key)
+
+	for name, sign := range map[string]func(io.Writer, message.Header, *Signer) (io.WriteCloser, error){
+		"detached": Sign,
+		"opaque":   SignOpaque,
+	} {
+		b := signMessage(t, sign, pki.signer)
+		if _, err := verifyMessage(b, other.roots); err == nil {
+			t.Errorf("%v: Verify() = nil, want an error for an untrusted signer", name)
+		}
+	}
+}
+
+func TestVerify_notSigned(t *testing.T) {
+	if _, err := verifyMessage([]byte(testSignedEntity), x509.NewCertPool()); err != ErrNotSigned {
+		t.Errorf("Verify() = %v, want %v", err, ErrNotSigned)
+	}
+}
+
+func TestVerify_lf(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("ecdsa.GenerateKey() = %v", err)
+	}
+	pki := newTestPKI(t, key)
+	b := signMessage(t, Sign, pki.signer)
+
+	// Messages stored on disk often use LF line endings
+	b = bytes.Replace(b, []byte("\r\n"), []byte("\n"), -1)
+	signed, err := verifyMessage(b, pki.roots)
+	if err != nil {
+		t.Fatalf("Verify() = %v", err)
+	}
+	checkSignedEntity(t, signed, pki.signer)
+}
+
+func TestBERToDER(t *testing.T) {
+	// SEQUENCE (indefinite) { [0] (indefinite) { OCTET STRING (constructed,
+	// indefinite) { "ab", "c" } } }
+	ber := []byte{
+		0x30, 0x80,
+		0xA0, 0x80,
+		0x24, 0x80,
+		0x04, 0x02, 'a', 'b',
+		0x04, 0x01, 'c',
+		0x00, 0x00,
+		0x00, 0x00,
+		0x00, 0x00,
+	}
+	want := []byte{
+		0x30, 0x07,
+		0xA0, 0x05,
+		0x04, 0x03, 'a', 'b', 'c',
+	}
+
+	der, err := berToDER(ber)
+	if err != nil {
+		t.Fatalf("berToDER() = %v", err)
+	}
+	if !bytes.Equal(der, want) {
+		t.Errorf("berToDER() = %x, want %x", der, want)
+	}
+
+	if _, err := berToDER(ber[:len(ber)-2]); err == nil {
+		t.Errorf("berToDER() = nil, want an error for truncated input")
+	}
+}
@@ -0,0 +1,179 @@
+package smime
+
+import (
+	"bytes"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/emersion/go-message"
+	"github.com/emersion/go-message/internal/canonical"
+)
+
+// ErrNotSigned is returned by Verify when the message isn't signed with
+// S/MIME.
+var ErrNotSigned = errors.New("smime: message is not signed")
+
+// A SignedEntity is an entity whose S/MIME signature has been verified.
+type SignedEntity struct {
+	// Entity is the signed entity.
+	Entity *message.Entity
+	// Signers contains the certificates of the signers. Each of them has been
+	// verified up to a trusted root, for email protection.
+	Signers []*x509.Certificate
+}
+
+// Verify checks the S/MIME signature of a message, and returns the signed
+// entity. Both multipart/signed and application/pkcs7-mime signed-data
+// messages are supported. The body of e must not have been read.
+//
+// Signer certificates are verified against roots, using the certificates
+// embedded in the signature as intermediates. If roots is nil, the system
+// roots are used. No network access is performed: revocation isn't checked.
+//
+// If the message isn't signed, ErrNotSigned is returned.
+func Verify(e *message.Entity, roots *x509.CertPool) (*SignedEntity, error) {
+	mediaType, params, err := e.Header.ContentType()
+	if err != nil {
+		return nil, ErrNotSigned
+	}
+
+	switch mediaType {
+	case "multipart/signed":
+		return verifyDetached(e, params, roots)
+	case "application/pkcs7-mime", "application/x-pkcs7-mime":
+		// RFC 8551 section 3.2.2: smime-type is optional
+		if t := params["smime-type"]; t != "" && !strings.EqualFold(t, "signed-data") {
+			return nil, ErrNotSigned
+		}
+		return verifyOpaque(e, roots)
+	default:
+		return nil, ErrNotSigned
+	}
+}
+
+func isSignatureMediaType(mediaType string) bool {
+	mediaType = strings.ToLower(mediaType)
+	return mediaType == "application/pkcs7-signature" || mediaType == "application/x-pkcs7-signature"
+}
+
+func verifyDetached(e *message.Entity, params map[string]string, roots *x509.CertPool) (*SignedEntity, error) {
+	if !isSignatureMediaType(params["protocol"]) {
+		return nil, ErrNotSigned
+	}
+	boundary := params["boundary"]
+	if boundary == "" {
+		return nil, errors.New("smime: missing multipart boundary")
+	}
+
+	body, err := ioutil.ReadAll(e.Body)
+	if err != nil {
+		return nil, err
+	}
+	parts, err := canonical.SplitMultipart(canonical.Bytes(body), boundary)
+	if err != nil {
+		return nil, fmt.Errorf("smime: failed to split multipart/signed body: %v", err)
+	}
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("smime: multipart/signed message has %v parts, want 2", len(parts))
+	}
+
+	sig, err := message.Read(bytes.NewReader(parts[1]))
+	if err != nil {
+		return nil, fmt.Errorf("smime: failed to read signature part: %v", err)
+	}
+	if mediaType, _, _ := sig.Header.ContentType(); !isSignatureMediaType(mediaType) {
+		return nil, fmt.Errorf("smime: unexpected signature part media type %q", mediaType)
+	}
+	der, err := ioutil.ReadAll(sig.Body)
+	if err != nil {
+		return nil, fmt.Errorf("smime: failed to read signature part: %v", err)
+	}
+
+	sd, err := parseSignedData(der)
+	if err != nil {
+		return nil, err
+	}
+	signers, err := verifySignedData(sd, parts[0], roots)
+	if err != nil {
+		return nil, err
+	}
+
+	inner, err := message.Read(bytes.NewReader(parts[0]))
+	if err != nil {
+		return nil, err
+	}
+	return &SignedEntity{Entity: inner, Signers: signers}, nil
+}
+
+func verifyOpaque(e *message.Entity, roots *x509.CertPool) (*SignedEntity, error) {
+	der, err := ioutil.ReadAll(e.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	sd, err := parseSignedData(der)
+	if err != nil {
+		return nil, err
+	}
+	content := sd.EncapContentInfo.EContent
+	if content == nil {
+		return nil, errors.New("smime: missing encapsulated content")
+	}
+	signers, err := verifySignedData(sd, content, roots)
+	if err != nil {
+		return nil, err
+	}
+
+	inner, err := message.Read(bytes.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+	return &SignedEntity{Entity: inner, Signers: signers}, nil
+}
+
+// verifySignedData checks all signatures of content in sd, and returns the
+// signer certificates.
+func verifySignedData(sd *signedData, content []byte, roots *x509.CertPool) ([]*x509.Certificate, error) {
+	certs, err := sd.Certificates.parse()
+	if err != nil {
+		return nil, fmt.Errorf("smime: failed to parse certificates: %v", err)
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs {
+		intermediates.AddCert(cert)
+	}
+
+	if len(sd.SignerInfos) == 0 {
+		return nil, errors.New("smime: no signer")
+	}
+
+	var signers []*x509.Certificate
+	for i := range sd.SignerInfos {
+		si := &sd.SignerInfos[i]
+
+		cert, err := findCertificate(certs, si.SID)
+		if err != nil {
+			return nil, err
+		}
+		if err := si.verify(cert, content, sd.EncapContentInfo.EContentType); err != nil {
+			return nil, err
+		}
+
+		_, err = cert.Verify(x509.VerifyOptions{
+			Roots:         roots,
+			Intermediates: intermediates,
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageEmailProtection},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("smime: failed to verify signer certificate: %v", err)
+		}
+
+		signers = append(signers, cert)
+	}
+
+	return signers, nil
+}
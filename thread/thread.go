@@ -0,0 +1,370 @@
+// Package thread groups messages into conversation threads.
+//
+// The REFERENCES and ORDEREDSUBJECT algorithms are defined in RFC 5256. The
+// REFERENCES algorithm is based on Jamie Zawinski's algorithm described at
+// https://www.jwz.org/doc/threading.html.
+package thread
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-message/mail"
+)
+
+// A Thread is a message and its replies.
+type Thread struct {
+	// The index of the message in the slice of headers passed to the threading
+	// function. It is -1 if the message is missing, e.g. if it's referenced by
+	// replies but hasn't been passed to the threading function.
+	Index int
+	// The replies to the message, sorted by date.
+	Children []*Thread
+}
+
+// IsDummy reports whether the message of the thread is missing.
+func (t *Thread) IsDummy() bool {
+	return t.Index < 0
+}
+
+// Options are options for ReferencesWithOptions and OrderedSubjectWithOptions.
+type Options struct {
+	// BaseSubject contains the options used to extract the base subject of
+	// messages.
+	BaseSubject *mail.BaseSubjectOptions
+}
+
+// messageInfo contains data extracted from a message header.
+type messageInfo struct {
+	date        time.Time
+	baseSubject string
+	isReply     bool
+}
+
+func newMessageInfos(headers []mail.Header, opts *Options) []messageInfo {
+	infos := make([]messageInfo, len(headers))
+	for i := range headers {
+		h := &headers[i]
+		// Invalid dates are replaced with the zero time
+		infos[i].date, _ = h.Date()
+		// Decoding errors are ignored: the raw value is returned in that case
+		subject, _ := h.Subject()
+		infos[i].baseSubject, infos[i].isReply = mail.BaseSubjectWithOptions(subject, opts.BaseSubject)
+	}
+	return infos
+}
+
+// subjectKey returns the key used to compare base subjects.
+func subjectKey(base string) string {
+	return strings.ToLower(base)
+}
+
+// container is a node of the thread tree built by References.
+type container struct {
+	index    int // -1 for dummy containers
+	parent   *container
+	children []*container
+}
+
+// hasAncestor reports whether anc is c or one of its ancestors.
+func (c *container) hasAncestor(anc *container) bool {
+	for ; c != nil; c = c.parent {
+		if c == anc {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *container) setParent(parent *container) {
+	if c.parent != nil {
+		siblings := c.parent.children
+		for i, sibling := range siblings {
+			if sibling == c {
+				c.parent.children = append(siblings[:i:i], siblings[i+1:]...)
+				break
+			}
+		}
+	}
+	c.parent = parent
+	if parent != nil {
+		parent.children = append(parent.children, c)
+	}
+}
+
+// first returns the index of the message representing the container: the
+// container's message, or the message of its first child for dummies.
+func (c *container) first() int {
+	for c.index < 0 && len(c.children) > 0 {
+		c = c.children[0]
+	}
+	return c.index
+}
+
+// messageReferences returns the identifiers of the parents of a message, from
+// the root of the thread to the direct parent.
+func messageReferences(h *mail.Header) []string {
+	if refs, err := h.MsgIDList("References"); len(refs) > 0 {
+		// Keep the identifiers parsed before an error
+		return refs
+	} else if err != nil {
+		return nil
+	}
+
+	// RFC 5256 section 3: use the first identifier of In-Reply-To if there are
+	// no references
+	inReplyTo, _ := h.MsgIDList("In-Reply-To")
+	if len(inReplyTo) > 0 {
+		return inReplyTo[:1]
+	}
+	return nil
+}
+
+// pruneContainers removes dummy containers without children, and promotes the
+// children of dummy containers. At the root level, dummy containers with
+// multiple children are kept.
+func pruneContainers(list []*container, root bool) []*container {
+	var out []*container
+	for _, c := range list {
+		c.children = pruneContainers(c.children, false)
+		if c.index < 0 {
+			if len(c.children) == 0 {
+				continue
+			}
+			if !root || len(c.children) == 1 {
+				for _, child := range c.children {
+					child.parent = c.parent
+				}
+				out = append(out, c.children...)
+				continue
+			}
+		}
+		out = append(out, c)
+	}
+	return out
+}
+
+// sortContainers sorts a list of sibling containers and their descendants by
+// date. Dummy containers are sorted with the date of their first child.
+func sortContainers(list []*container, infos []messageInfo) {
+	for _, c := range list {
+		sortContainers(c.children, infos)
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		a, b := list[i].first(), list[j].first()
+		if !infos[a].date.Equal(infos[b].date) {
+			return infos[a].date.Before(infos[b].date)
+		}
+		return a < b
+	})
+}
+
+// groupBySubject merges root containers with the same base subject, as
+// described in RFC 5256 section 3 step 5.
+func groupBySubject(roots []*container, infos []messageInfo) []*container {
+	subjects := make(map[string]*container)
+	for _, c := range roots {
+		info := &infos[c.first()]
+		if info.baseSubject == "" {
+			continue
+		}
+		k := subjectKey(info.baseSubject)
+		old, ok := subjects[k]
+		if !ok || (c.index < 0 && old.index >= 0) ||
+			(old.index >= 0 && c.index >= 0 && infos[old.index].isReply && !info.isReply) {
+			subjects[k] = c
+		}
+	}
+
+	for _, c := range roots {
+		info := &infos[c.first()]
+		if info.baseSubject == "" || c.parent != nil {
+			continue
+		}
+		k := subjectKey(info.baseSubject)
+		old := subjects[k]
+		if old == c {
+			continue
+		}
+
+		switch {
+		case old.index < 0 && c.index < 0:
+			for len(c.children) > 0 {
+				c.children[0].setParent(old)
+			}
+		case old.index < 0:
+			c.setParent(old)
+		case !infos[old.index].isReply && info.isReply:
+			c.setParent(old)
+		default:
+			dummy := &container{index: -1}
+			old.setParent(dummy)
+			c.setParent(dummy)
+			subjects[k] = dummy
+		}
+	}
+
+	// Dummy containers created above take the place of their first child
+	var out []*container
+	seen := make(map[*container]bool)
+	for _, c := range roots {
+		if c.parent == nil && c.index < 0 && len(c.children) == 0 {
+			// Dummy container whose children have been moved
+			continue
+		}
+		for c.parent != nil {
+			c = c.parent
+		}
+		if !seen[c] {
+			seen[c] = true
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+func newThreads(list []*container) []*Thread {
+	threads := make([]*Thread, len(list))
+	for i, c := range list {
+		threads[i] = &Thread{
+			Index:    c.index,
+			Children: newThreads(c.children),
+		}
+	}
+	return threads
+}
+
+// References groups messages into threads with the REFERENCES algorithm, as
+// defined in RFC 5256 section 3. Messages are linked with their parents using
+// the Message-ID, References and In-Reply-To header fields. Threads whose root
+// messages have the same base subject are then grouped together.
+//
+// Messages referenced by replies but missing from headers are represented by
+// dummy threads. Threads and replies are sorted by the Date header field.
+func References(headers []mail.Header) []*Thread {
+	return ReferencesWithOptions(headers, nil)
+}
+
+// ReferencesWithOptions see References, but allows overriding some parameters
+// with Options.
+func ReferencesWithOptions(headers []mail.Header, opts *Options) []*Thread {
+	if opts == nil {
+		opts = &Options{}
+	}
+	infos := newMessageInfos(headers, opts)
+
+	ids := make(map[string]*container)
+	var all []*container
+	newContainer := func() *container {
+		c := &container{index: -1}
+		all = append(all, c)
+		return c
+	}
+	containerByID := func(id string) *container {
+		c, ok := ids[id]
+		if !ok {
+			c = newContainer()
+			ids[id] = c
+		}
+		return c
+	}
+
+	for i := range headers {
+		h := &headers[i]
+
+		var c *container
+		if id, err := h.MessageID(); err == nil && id != "" {
+			c = containerByID(id)
+			if c.index >= 0 {
+				// Duplicate Message-ID, treat the message as unique
+				c = nil
+			}
+		}
+		if c == nil {
+			c = newContainer()
+		}
+		c.index = i
+
+		// Link the references together, without changing existing links
+		var parent *container
+		for _, ref := range messageReferences(h) {
+			rc := containerByID(ref)
+			if parent != nil && rc.parent == nil && !parent.hasAncestor(rc) {
+				rc.setParent(parent)
+			}
+			parent = rc
+		}
+
+		// Link the message to its last reference
+		if parent != nil && parent.hasAncestor(c) {
+			parent = nil
+		}
+		c.setParent(parent)
+	}
+
+	var roots []*container
+	for _, c := range all {
+		if c.parent == nil {
+			roots = append(roots, c)
+		}
+	}
+
+	roots = pruneContainers(roots, true)
+	sortContainers(roots, infos)
+	roots = groupBySubject(roots, infos)
+	sortContainers(roots, infos)
+
+	return newThreads(roots)
+}
+
+// OrderedSubject groups messages into threads with the ORDEREDSUBJECT
+// algorithm, as defined in RFC 5256 section 3. Messages with the same base
+// subject are grouped in a thread: the earliest message is the root, and the
+// other messages are its children.
+//
+// Threads and replies are sorted by the Date header field.
+func OrderedSubject(headers []mail.Header) []*Thread {
+	return OrderedSubjectWithOptions(headers, nil)
+}
+
+// OrderedSubjectWithOptions see OrderedSubject, but allows overriding some
+// parameters with Options.
+func OrderedSubjectWithOptions(headers []mail.Header, opts *Options) []*Thread {
+	if opts == nil {
+		opts = &Options{}
+	}
+	infos := newMessageInfos(headers, opts)
+
+	indices := make([]int, len(headers))
+	for i := range indices {
+		indices[i] = i
+	}
+	sort.SliceStable(indices, func(i, j int) bool {
+		a, b := &infos[indices[i]], &infos[indices[j]]
+		if ka, kb := subjectKey(a.baseSubject), subjectKey(b.baseSubject); ka != kb {
+			return ka < kb
+		}
+		return a.date.Before(b.date)
+	})
+
+	var threads []*Thread
+	var root *Thread
+	for i, index := range indices {
+		if i > 0 && subjectKey(infos[index].baseSubject) == subjectKey(infos[indices[i-1]].baseSubject) {
+			root.Children = append(root.Children, &Thread{Index: index})
+		} else {
+			root = &Thread{Index: index}
+			threads = append(threads, root)
+		}
+	}
+
+	sort.SliceStable(threads, func(i, j int) bool {
+		a, b := threads[i].Index, threads[j].Index
+		if !infos[a].date.Equal(infos[b].date) {
+			return infos[a].date.Before(infos[b].date)
+		}
+		return a < b
+	})
+	return threads
+}
@@ -0,0 +1,148 @@
+package thread
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-message/mail"
+)
+
+// formatThreads formats threads like an IMAP THREAD response. Message numbers
+// are indices plus one.
+func formatThreads(threads []*Thread) string {
+	var sb strings.Builder
+	for _, t := range threads {
+		sb.WriteString("(")
+		formatThread(&sb, t)
+		sb.WriteString(")")
+	}
+	return sb.String()
+}
+
+func formatThread(sb *strings.Builder, t *Thread) {
+	var nums []string
+	if !t.IsDummy() {
+		nums = append(nums, fmt.Sprint(t.Index+1))
+	}
+	for len(t.Children) == 1 {
+		t = t.Children[0]
+		nums = append(nums, fmt.Sprint(t.Index+1))
+	}
+	sb.WriteString(strings.Join(nums, " "))
+	if len(t.Children) > 1 {
+		if len(nums) > 0 {
+			sb.WriteString(" ")
+		}
+		sb.WriteString(formatThreads(t.Children))
+	}
+}
+
+type testMessage struct {
+	id, refs, inReplyTo, subject string
+	date                         int
+}
+
+func testHeaders(messages []testMessage) []mail.Header {
+	base := time.Date(2016, time.August, 26, 0, 0, 0, 0, time.UTC)
+	headers := make([]mail.Header, len(messages))
+	for i, msg := range messages {
+		h := &headers[i]
+		if msg.id != "" {
+			h.SetMessageID(msg.id + "@example.org")
+		}
+		if msg.refs != "" {
+			var refs []string
+			for _, ref := range strings.Fields(msg.refs) {
+				refs = append(refs, ref+"@example.org")
+			}
+			h.SetMsgIDList("References", refs)
+		}
+		if msg.inReplyTo != "" {
+			h.SetMsgIDList("In-Reply-To", []string{msg.inReplyTo + "@example.org"})
+		}
+		h.SetSubject(msg.subject)
+		h.SetDate(base.Add(time.Duration(msg.date) * time.Hour))
+	}
+	return headers
+}
+
+var testThreadMessages = []testMessage{
+	{id: "a", subject: "Your Name", date: 1},
+	{id: "b", refs: "a", subject: "Re: Your Name", date: 2},
+	{id: "c", refs: "a b", subject: "Re: Your Name", date: 3},
+	{id: "d", inReplyTo: "a", subject: "Re: Your Name", date: 4},
+	{id: "e", refs: "x", subject: "Re: Comet", date: 5},
+	{id: "f", refs: "x", subject: "Re: Comet", date: 6},
+	{id: "g", subject: "Kumihimo", date: 0},
+	{id: "h", subject: "Re: Kumihimo", date: 7},
+	{id: "i", subject: "Itomori", date: 8},
+	{id: "j", subject: "itomori", date: 9},
+}
+
+func TestReferences(t *testing.T) {
+	threads := References(testHeaders(testThreadMessages))
+	want := "(7 8)(1 (2 3)(4))((5)(6))((9)(10))"
+	if got := formatThreads(threads); got != want {
+		t.Errorf("References() = %v, want %v", got, want)
+	}
+}
+
+func TestReferences_loop(t *testing.T) {
+	messages := []testMessage{
+		{id: "a", refs: "b", subject: "Your Name", date: 0},
+		{id: "b", refs: "a", subject: "Comet", date: 1},
+		{id: "c", refs: "c", subject: "Kumihimo", date: 2},
+	}
+	threads := References(testHeaders(messages))
+	want := "(2 1)(3)"
+	if got := formatThreads(threads); got != want {
+		t.Errorf("References() = %v, want %v", got, want)
+	}
+}
+
+func TestReferences_duplicate(t *testing.T) {
+	messages := []testMessage{
+		{id: "a", subject: "Your Name", date: 0},
+		{id: "a", subject: "Comet", date: 1},
+		{id: "b", refs: "a", subject: "Re: Your Name", date: 2},
+		{subject: "Kumihimo", date: 3},
+	}
+	threads := References(testHeaders(messages))
+	want := "(1 3)(2)(4)"
+	if got := formatThreads(threads); got != want {
+		t.Errorf("References() = %v, want %v", got, want)
+	}
+}
+
+func TestOrderedSubject(t *testing.T) {
+	threads := OrderedSubject(testHeaders(testThreadMessages))
+	want := "(7 8)(1 (2)(3)(4))(5 6)(9 10)"
+	if got := formatThreads(threads); got != want {
+		t.Errorf("OrderedSubject() = %v, want %v", got, want)
+	}
+}
+
+func TestOrderedSubjectWithOptions(t *testing.T) {
+	messages := []testMessage{
+		{id: "a", subject: "Your Name", date: 0},
+		{id: "b", subject: "AW: Your Name", date: 1},
+		{id: "c", subject: "SV: Your Name", date: 2},
+	}
+
+	threads := OrderedSubject(testHeaders(messages))
+	if got, want := formatThreads(threads), "(1)(2)(3)"; got != want {
+		t.Errorf("OrderedSubject() = %v, want %v", got, want)
+	}
+
+	opts := &Options{BaseSubject: &mail.BaseSubjectOptions{Prefixes: mail.LocalizedPrefixes}}
+	threads = OrderedSubjectWithOptions(testHeaders(messages), opts)
+	if got, want := formatThreads(threads), "(1 (2)(3))"; got != want {
+		t.Errorf("OrderedSubjectWithOptions() = %v, want %v", got, want)
+	}
+	threads = ReferencesWithOptions(testHeaders(messages), opts)
+	if got, want := formatThreads(threads), "(1 (2)(3))"; got != want {
+		t.Errorf("ReferencesWithOptions() = %v, want %v", got, want)
+	}
+}
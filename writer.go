@@ -2,8 +2,8 @@ package message
 
 import (
 	"errors"
-	"fmt"
 	"io"
+	"math"
 	"strings"
 
 	"github.com/emersion/go-message/textproto"
@@ -18,15 +18,88 @@ import (
 // parts or Write to directly pipe a multipart message. In any case, Close must
 // be called at the end.
 type Writer struct {
-	w  io.Writer
-	c  io.Closer
-	mw *textproto.MultipartWriter
+	w    io.Writer
+	c    io.Closer
+	mw   *textproto.MultipartWriter
+	opts *WriterOptions
+}
+
+const defaultMaxAutoEncodingBytes = 1 << 20 // 1 MB
+
+// WriterOptions are options for CreateWriterWithOptions.
+type WriterOptions struct {
+	// AutoEncoding enables automatic selection of the
+	// Content-Transfer-Encoding of non-multipart entities whose header doesn't
+	// specify one. The body is inspected to pick 7bit, quoted-printable or
+	// base64. If the body of a text entity contains non-ASCII characters and
+	// no charset is specified, charset=utf-8 is added to the Content-Type.
+	//
+	// The header is only written once the encoding has been picked, so each
+	// part must be closed before creating the next one.
+	AutoEncoding bool
+	// MaxAutoEncodingBytes limits the number of body bytes buffered to pick
+	// the Content-Transfer-Encoding. If exceeded, the encoding is picked from
+	// the buffered data only, quoted-printable is used for text and base64 for
+	// everything else, and the rest of the body is streamed. If
+	// quoted-printable has been picked but the rest of the body contains a
+	// bare CR, which can't be represented in quoted-printable text, writing
+	// the body fails instead of altering it.
+	//
+	// Set to -1 for no limit, set to 0 for the default value (1MB).
+	MaxAutoEncodingBytes int64
+	// UTF8Header enables RFC 6532 internationalized header fields, for
+	// messages sent through SMTPUTF8-capable servers. RFC 2047 encoded-words
+	// in header fields, including display names in address lists, are
+	// written as raw UTF-8. Content-Type and Content-Disposition parameters
+	// are left as-is. Parts with a message/rfc822 media type are written as
+	// message/global.
+	UTF8Header bool
+}
+
+// withDefaults returns a sanitised version of the options with defaults/special
+// values accounted for.
+func (o *WriterOptions) withDefaults() *WriterOptions {
+	var out WriterOptions
+	if o != nil {
+		out = *o
+	}
+	if out.MaxAutoEncodingBytes == 0 {
+		out.MaxAutoEncodingBytes = defaultMaxAutoEncodingBytes
+	} else if out.MaxAutoEncodingBytes < 0 {
+		out.MaxAutoEncodingBytes = math.MaxInt64
+	}
+	return &out
+}
+
+// chainCloser closes a writer, then the writer it wraps.
+type chainCloser struct {
+	outer, inner io.Closer
+}
+
+func (c *chainCloser) Close() error {
+	if err := c.outer.Close(); err != nil {
+		return err
+	}
+	return c.inner.Close()
 }
 
 // createWriter creates a new Writer writing to w with the provided header.
-// Nothing is written to w when it is called. header is modified in-place.
-func createWriter(w io.Writer, header *Header) (*Writer, error) {
-	ww := &Writer{w: w}
+// header is modified in-place. writeHeader is called to write the header
+// before anything is written to w: either before createWriter returns, or
+// later if the Content-Transfer-Encoding is automatically selected.
+// writeHeader may be nil.
+func createWriter(w io.Writer, header *Header, opts *WriterOptions, writeHeader func() error) (*Writer, error) {
+	opts = opts.withDefaults()
+	if writeHeader == nil {
+		writeHeader = func() error { return nil }
+	}
+
+	ww := &Writer{w: w, opts: opts}
+	deferHeader := false
+
+	if opts.UTF8Header {
+		decodeHeaderUTF8(header)
+	}
 
 	mediaType, mediaParams, _ := header.ContentType()
 	if strings.HasPrefix(mediaType, "multipart/") {
@@ -45,6 +118,16 @@ func createWriter(w io.Writer, header *Header) (*Writer, error) {
 		}
 
 		header.Del("Content-Transfer-Encoding")
+	} else if opts.AutoEncoding && !header.Has("Content-Transfer-Encoding") {
+		aw := &autoEncodingWriter{
+			w:           ww.w,
+			header:      header,
+			writeHeader: writeHeader,
+			max:         opts.MaxAutoEncodingBytes,
+		}
+		ww.w = aw
+		ww.c = aw
+		deferHeader = true
 	} else {
 		wc, err := encodingWriter(header.Get("Content-Transfer-Encoding"), ww.w)
 		if err != nil {
@@ -54,12 +137,23 @@ func createWriter(w io.Writer, header *Header) (*Writer, error) {
 		ww.c = wc
 	}
 
-	switch strings.ToLower(mediaParams["charset"]) {
-	case "", "us-ascii", "utf-8":
-		// This is OK
-	default:
-		// Anything else is invalid
-		return nil, fmt.Errorf("unhandled charset %q", mediaParams["charset"])
+	// RFC 2046 section 4.1.2: charset only applies to text/*. An empty
+	// charset is handled like a missing one.
+	if strings.HasPrefix(mediaType, "text/") {
+		if ch := mediaParams["charset"]; ch != "" {
+			cw, err := charsetWriter(ch, ww.w)
+			if err != nil {
+				return nil, err
+			}
+			ww.w = cw
+			ww.c = &chainCloser{cw, ww.c}
+		}
+	}
+
+	if !deferHeader {
+		if err := writeHeader(); err != nil {
+			return nil, err
+		}
 	}
 
 	return ww, nil
@@ -67,8 +161,17 @@ func createWriter(w io.Writer, header *Header) (*Writer, error) {
 
 // CreateWriter creates a new message writer to w. If header contains an
 // encoding, data written to the Writer will automatically be encoded with it.
-// The charset needs to be utf-8 or us-ascii.
+// If header contains a text media type with a charset, data written to the
+// Writer is expected to be UTF-8 and will automatically be converted to this
+// charset. Charsets other than utf-8 and us-ascii require CharsetWriter to be
+// set, otherwise an error that verifies IsUnknownCharset is returned.
 func CreateWriter(w io.Writer, header Header) (*Writer, error) {
+	return CreateWriterWithOptions(w, header, nil)
+}
+
+// CreateWriterWithOptions see CreateWriter, but allows overriding some
+// parameters with WriterOptions.
+func CreateWriterWithOptions(w io.Writer, header Header, opts *WriterOptions) (*Writer, error) {
 	// Ensure that modifications are invisible to the caller
 	header = header.Copy()
 
@@ -77,14 +180,9 @@ func CreateWriter(w io.Writer, header Header) (*Writer, error) {
 		header.Set("MIME-Version", "1.0")
 	}
 
-	ww, err := createWriter(w, &header)
-	if err != nil {
-		return nil, err
-	}
-	if err := textproto.WriteHeader(w, header.Header); err != nil {
-		return nil, err
-	}
-	return ww, nil
+	return createWriter(w, &header, opts, func() error {
+		return textproto.WriteHeader(w, header.Header)
+	})
 }
 
 // Write implements io.Writer.
@@ -102,7 +200,8 @@ func (w *Writer) Close() error {
 
 // CreatePart returns a Writer to a new part in this multipart entity. If this
 // entity is not multipart, it fails. The body of the part should be written to
-// the returned io.WriteCloser.
+// the returned io.WriteCloser. The part inherits the WriterOptions of this
+// entity.
 func (w *Writer) CreatePart(header Header) (*Writer, error) {
 	if w.mw == nil {
 		return nil, errors.New("cannot create a part in a non-multipart message")
@@ -120,15 +219,21 @@ func (w *Writer) CreatePart(header Header) (*Writer, error) {
 
 	// ensure that modifications are invisible to the caller
 	header = header.Copy()
-	cw, err := createWriter(ww, &header)
-	if err != nil {
-		return nil, err
-	}
-	pw, err := w.mw.CreatePart(header.Header)
-	if err != nil {
-		return nil, err
+
+	// RFC 6532 section 3.7: encapsulated messages with internationalized
+	// header fields are labelled message/global
+	if w.opts.UTF8Header {
+		if mediaType, mediaParams, err := header.ContentType(); err == nil && mediaType == "message/rfc822" {
+			header.SetContentType("message/global", mediaParams)
+		}
 	}
 
-	ww.Writer = pw
-	return cw, nil
+	return createWriter(ww, &header, w.opts, func() error {
+		pw, err := w.mw.CreatePart(header.Header)
+		if err != nil {
+			return err
+		}
+		ww.Writer = pw
+		return nil
+	})
 }
@@ -3,6 +3,7 @@ package message
 import (
 	"bytes"
 	"io"
+	"io/ioutil"
 	"strings"
 	"testing"
 )
@@ -149,3 +150,201 @@ index 7a6a423c3ea3..e8a672479245 100644
 			original, buf.String())
 	}
 }
+
+func TestWriter_unknownCharset(t *testing.T) {
+	var h Header
+	h.Set("Content-Type", "text/plain; charset=I-DONT-EXIST")
+
+	_, err := CreateWriter(ioutil.Discard, h)
+	if err == nil {
+		t.Fatal("CreateWriter(unknown charset): expected an error")
+	} else if !IsUnknownCharset(err) {
+		t.Fatal("CreateWriter(unknown charset): expected an error that verifies IsUnknownCharset")
+	}
+}
+
+func TestWriter_emptyCharset(t *testing.T) {
+	var h Header
+	h.Set("Content-Type", `text/plain; charset=""`)
+
+	w, err := CreateWriter(ioutil.Discard, h)
+	if err != nil {
+		t.Fatalf("CreateWriter(empty charset) = %v", err)
+	}
+	if _, err := io.WriteString(w, "Hello world!"); err != nil {
+		t.Fatalf("Write() = %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close() = %v", err)
+	}
+}
+
+func TestWriter_autoEncoding(t *testing.T) {
+	testCases := []struct {
+		name        string
+		contentType string
+		body        string
+		encoding    string
+		charset     string
+	}{
+		{"ascii", "text/plain", "Hello world!\r\n", "7bit", ""},
+		{"utf8", "text/plain", "Bonjour à tous\r\n", "quoted-printable", "utf-8"},
+		{"utf8Mostly", "text/plain", "こんにちは", "base64", "utf-8"},
+		{"longLine", "text/plain", strings.Repeat("a", 1000), "quoted-printable", ""},
+		{"bareCR", "text/plain", "a\rb", "base64", ""},
+		{"binary", "application/octet-stream", "\x00\x01\x02", "base64", ""},
+		{"asciiAttachment", "application/json", "{}", "7bit", ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var h Header
+			h.SetContentType(tc.contentType, nil)
+
+			var b bytes.Buffer
+			w, err := CreateWriterWithOptions(&b, h, &WriterOptions{AutoEncoding: true})
+			if err != nil {
+				t.Fatalf("CreateWriterWithOptions() = %v", err)
+			}
+			io.WriteString(w, tc.body)
+			if err := w.Close(); err != nil {
+				t.Fatalf("Writer.Close() = %v", err)
+			}
+
+			e, err := Read(&b)
+			if err != nil {
+				t.Fatalf("Read() = %v", err)
+			}
+			if enc := e.Header.Get("Content-Transfer-Encoding"); enc != tc.encoding {
+				t.Errorf("Expected encoding %q, got %q", tc.encoding, enc)
+			}
+			if _, params, _ := e.Header.ContentType(); params["charset"] != tc.charset {
+				t.Errorf("Expected charset %q, got %q", tc.charset, params["charset"])
+			}
+			if body, err := ioutil.ReadAll(e.Body); err != nil {
+				t.Errorf("ReadAll() = %v", err)
+			} else if string(body) != tc.body {
+				t.Errorf("Expected body %q, got %q", tc.body, string(body))
+			}
+		})
+	}
+}
+
+func TestWriter_autoEncodingLarge(t *testing.T) {
+	var h Header
+	h.SetContentType("multipart/mixed", nil)
+
+	var b bytes.Buffer
+	w, err := CreateWriterWithOptions(&b, h, &WriterOptions{
+		AutoEncoding:         true,
+		MaxAutoEncodingBytes: 16,
+	})
+	if err != nil {
+		t.Fatalf("CreateWriterWithOptions() = %v", err)
+	}
+
+	body := strings.Repeat("Hello world!\r\n", 10)
+
+	var ph Header
+	ph.SetContentType("text/plain", nil)
+	pw, err := w.CreatePart(ph)
+	if err != nil {
+		t.Fatalf("CreatePart() = %v", err)
+	}
+	for _, l := range strings.SplitAfter(body, "\n") {
+		io.WriteString(pw, l)
+	}
+	if err := pw.Close(); err != nil {
+		t.Fatalf("Writer.Close() = %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Writer.Close() = %v", err)
+	}
+
+	e, err := Read(&b)
+	if err != nil {
+		t.Fatalf("Read() = %v", err)
+	}
+	p, err := e.MultipartReader().NextPart()
+	if err != nil {
+		t.Fatalf("NextPart() = %v", err)
+	}
+	if enc := p.Header.Get("Content-Transfer-Encoding"); enc != "quoted-printable" {
+		t.Errorf("Expected encoding %q, got %q", "quoted-printable", enc)
+	}
+	if _, params, _ := p.Header.ContentType(); params["charset"] != "utf-8" {
+		t.Errorf("Expected charset %q, got %q", "utf-8", params["charset"])
+	}
+	if b, err := ioutil.ReadAll(p.Body); err != nil {
+		t.Errorf("ReadAll() = %v", err)
+	} else if string(b) != body {
+		t.Errorf("Expected body %q, got %q", body, string(b))
+	}
+}
+
+func TestWriter_autoEncodingLargeBareCR(t *testing.T) {
+	var h Header
+	h.SetContentType("text/plain", nil)
+
+	var b bytes.Buffer
+	w, err := CreateWriterWithOptions(&b, h, &WriterOptions{
+		AutoEncoding:         true,
+		MaxAutoEncodingBytes: 16,
+	})
+	if err != nil {
+		t.Fatalf("CreateWriterWithOptions() = %v", err)
+	}
+
+	// The bare CR is past the buffered data, quoted-printable has already
+	// been picked
+	io.WriteString(w, strings.Repeat("Hello world!\r\n", 2))
+	_, err = io.WriteString(w, "Hello\rworld!")
+	if err == nil {
+		err = w.Close()
+	}
+	if err == nil {
+		t.Errorf("Expected an error when writing a bare CR in a quoted-printable body")
+	}
+}
+
+func TestWriter_utf8Header(t *testing.T) {
+	var h Header
+	h.Set("From", "=?utf-8?q?Doe=2C_J=C3=B6hn?= <jöhn@exämple.org>")
+	h.Set("To", "=?utf-8?q?Mitsuha?= <mitsuha@example.org>, \"=?utf-8?q?quoted?=\" <q@example.org>")
+	h.SetText("Subject", "Café crème")
+	h.Set("Content-Type", "multipart/mixed; boundary=IMTHEBOUNDARY")
+
+	var b bytes.Buffer
+	w, err := CreateWriterWithOptions(&b, h, &WriterOptions{UTF8Header: true})
+	if err != nil {
+		t.Fatalf("CreateWriterWithOptions() = %v", err)
+	}
+
+	var ph Header
+	ph.SetContentType("message/rfc822", nil)
+	ph.SetText("Content-Description", "Message transféré")
+	pw, err := w.CreatePart(ph)
+	if err != nil {
+		t.Fatalf("CreatePart() = %v", err)
+	}
+	io.WriteString(pw, "Subject: Café\r\n\r\nCoucou\r\n")
+	pw.Close()
+	w.Close()
+
+	expected := "Mime-Version: 1.0\r\n" +
+		"Content-Type: multipart/mixed; boundary=IMTHEBOUNDARY\r\n" +
+		"Subject: Café crème\r\n" +
+		"To: Mitsuha <mitsuha@example.org>, \"=?utf-8?q?quoted?=\" <q@example.org>\r\n" +
+		"From: \"Doe, Jöhn\" <jöhn@exämple.org>\r\n" +
+		"\r\n" +
+		"--IMTHEBOUNDARY\r\n" +
+		"Content-Type: message/global\r\n" +
+		"Content-Description: Message transféré\r\n" +
+		"\r\n" +
+		"Subject: Café\r\n\r\nCoucou\r\n" +
+		"\r\n" +
+		"--IMTHEBOUNDARY--\r\n"
+	if s := b.String(); s != expected {
+		t.Errorf("Expected output to be \n%s\n but got \n%s", expected, s)
+	}
+}